@@ -16,11 +16,14 @@ require (
 	github.com/pelletier/go-toml v1.9.5
 	github.com/stretchr/testify v1.11.1
 	go.uber.org/mock v0.5.2
+	golang.org/x/sync v0.20.0
+	golang.org/x/sys v0.43.0
 	google.golang.org/grpc v1.80.0
 	google.golang.org/protobuf v1.36.11
 	k8s.io/api v0.32.11
 	k8s.io/apimachinery v0.32.11
 	k8s.io/client-go v1.5.2
+	k8s.io/component-base v0.32.11
 	k8s.io/klog/v2 v2.130.1
 	k8s.io/kubernetes v1.32.11
 	k8s.io/mount-utils v0.32.11
@@ -111,8 +114,6 @@ require (
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394 // indirect
 	golang.org/x/net v0.52.0 // indirect
 	golang.org/x/oauth2 v0.34.0 // indirect
-	golang.org/x/sync v0.20.0 // indirect
-	golang.org/x/sys v0.43.0 // indirect
 	golang.org/x/term v0.41.0 // indirect
 	golang.org/x/text v0.36.0 // indirect
 	golang.org/x/time v0.11.0 // indirect
@@ -125,7 +126,6 @@ require (
 	k8s.io/apiextensions-apiserver v0.31.1 // indirect
 	k8s.io/apiserver v0.32.11 // indirect
 	k8s.io/cloud-provider v0.32.4 // indirect
-	k8s.io/component-base v0.32.11 // indirect
 	k8s.io/component-helpers v0.32.11 // indirect
 	k8s.io/controller-manager v0.32.11 // indirect
 	k8s.io/kube-openapi v0.0.0-20241105132330-32ad38e42d3f // indirect
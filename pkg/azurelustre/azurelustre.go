@@ -21,20 +21,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 	utilexec "k8s.io/utils/exec"
@@ -81,7 +83,14 @@ const (
 var (
 	controllerServiceCapabilities = []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
 		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
 	}
 
 	volumeCapabilities = []csi.VolumeCapability_AccessMode_Mode{
@@ -96,6 +105,7 @@ var (
 
 	nodeServiceCapabilities = []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 	}
 )
@@ -108,6 +118,12 @@ type lustreVolume struct {
 	subDir                       string
 	createdByDynamicProvisioning bool
 	resourceGroupName            string
+	archiveOnDeletePath          string
+	subDirOnDelete               string
+	projectID                    uint32
+	// subscriptionID is the subscription of a cluster created with
+	// provisioner secrets, it is empty for the subscription of the driver
+	subscriptionID string
 }
 
 // DriverOptions defines driver parameters specified in driver deployment
@@ -118,6 +134,27 @@ type DriverOptions struct {
 	EnableAzureLustreMockDynProv bool
 	WorkingMountDir              string
 	RemoveNotReadyTaint          bool
+	OperationNamespace           string
+	// CreateOperationResumeInterval is how often to resume the persisted
+	// AMLFS creations that are not polled by the controller, 0 disables it
+	CreateOperationResumeInterval time.Duration
+	// OrphanedAmlFilesystemCheckInterval is how often to check for orphaned
+	// AMLFS clusters, 0 disables the check
+	OrphanedAmlFilesystemCheckInterval time.Duration
+	DeleteOrphanedAmlFilesystems       bool
+	OrphanedAmlFilesystemGracePeriod   time.Duration
+	// BlobImportPopulatorInterval is how often to populate the PVCs with a
+	// LustreBlobImport data source, 0 disables the populator
+	BlobImportPopulatorInterval time.Duration
+	// SkuCacheTTL is how long the SKU values of a location are cached, 0
+	// disables the cache
+	SkuCacheTTL time.Duration
+	// MaxConcurrentAmlFilesystemOperations is the maximum number of AMLFS
+	// creations and deletions in progress, 0 is unlimited
+	MaxConcurrentAmlFilesystemOperations int
+	// SubDirCloneParallelism is the number of files and directories copied
+	// in parallel when a sub-dir volume is cloned
+	SubDirCloneParallelism int
 }
 
 // LustreSkuValue describes the increment and maximum size of a given Lustre sku
@@ -146,14 +183,62 @@ type Driver struct {
 	// for that same volume (as defined by VolumeID) return an Aborted error
 	volumeLocks      *volumeLocks
 	kernelModuleLock sync.Mutex
+	// projectQuotaLock keeps two sub-dirs from being assigned the same free
+	// project ID
+	projectQuotaLock sync.Mutex
+	// AMLFS creations polled in the background, persisted in ConfigMaps in
+	// operationNamespace so that they are resumed after a restart
+	createOperations              *createOperations
+	operationNamespace            string
+	createAmlFilesystemWaitTime   time.Duration
+	createOperationResumeInterval time.Duration
+	// amlFilesystemOperations limits the AMLFS creations and deletions in
+	// progress, the ones over the limit are queued
+	amlFilesystemOperations *amlFilesystemOperationQueue
+	// Snapshots archived in the background, recorded in ConfigMaps in
+	// operationNamespace
+	snapshotArchives *volumeLocks
+	// Sub-dirs of cloned volumes copied in the background
+	subDirClones           *subDirClones
+	cloneSubDirWaitTime    time.Duration
+	subDirCloneParallelism int
+	// AMLFS clusters whose PV no longer exists, keyed by resource group and
+	// name, with the time they were first found orphaned
+	orphanedAmlFilesystems             map[string]time.Time
+	orphanedAmlFilesystemCheckInterval time.Duration
+	deleteOrphanedAmlFilesystems       bool
+	orphanedAmlFilesystemGracePeriod   time.Duration
+	// PVCs with a LustreBlobImport data source are populated through prime
+	// PVCs in operationNamespace
+	blobImportPopulatorInterval time.Duration
 
 	cloud              *azure.Cloud
 	resourceGroup      string
 	location           string
 	dynamicProvisioner DynamicProvisionerInterface
+	// Provisioners of the credentials in the provisioner secrets of
+	// CreateVolume and DeleteVolume, keyed by the hash of the credential
+	credentialProvisioners map[string]DynamicProvisionerInterface
+	// subscriptionProvisioners are the provisioners of the credentials last
+	// used in each subscription, for the requests without secrets on the
+	// clusters of that subscription
+	subscriptionProvisioners  map[string]DynamicProvisionerInterface
+	credentialProvisionersMux sync.Mutex
+	// newCredentialProvisioner creates the provisioner of a credential, it is
+	// newCredentialDynamicProvisioner if nil
+	newCredentialProvisioner func(credential *provisionerCredential) (DynamicProvisionerInterface, error)
+	skuCacheTTL              time.Duration
+	// lookupHost resolves the host names of MGS addresses, it is
+	// net.DefaultResolver.LookupHost if nil
+	lookupHost func(ctx context.Context, host string) ([]string, error)
 
 	removeNotReadyTaint bool
 	kubeClient          kubernetes.Interface
+	// dynamicClient reads the LustreBlobImport data sources
+	dynamicClient dynamic.Interface
+	// eventRecorder records the progress of AMLFS creations and deletions on
+	// the PVCs of the volumes, it is nil without a kubernetes client
+	eventRecorder record.EventRecorder
 	// taintRemovalInitialDelay is the initial delay for node taint removal
 	taintRemovalInitialDelay time.Duration
 	// taintRemovalBackoff is the exponential backoff configuration for node taint removal
@@ -164,12 +249,36 @@ type Driver struct {
 // does not support optional driver plugin info manifest field. Refer to CSI spec for more details.
 func NewDriver(options *DriverOptions) *Driver {
 	d := Driver{
-		volLockMap:                   util.NewLockMap(),
-		volumeLocks:                  newVolumeLocks(),
-		enableAzureLustreMockMount:   options.EnableAzureLustreMockMount,
-		enableAzureLustreMockDynProv: options.EnableAzureLustreMockDynProv,
-		workingMountDir:              options.WorkingMountDir,
-		removeNotReadyTaint:          options.RemoveNotReadyTaint,
+		volLockMap:                         util.NewLockMap(),
+		volumeLocks:                        newVolumeLocks(),
+		enableAzureLustreMockMount:         options.EnableAzureLustreMockMount,
+		enableAzureLustreMockDynProv:       options.EnableAzureLustreMockDynProv,
+		workingMountDir:                    options.WorkingMountDir,
+		removeNotReadyTaint:                options.RemoveNotReadyTaint,
+		createOperations:                   newCreateOperations(),
+		operationNamespace:                 options.OperationNamespace,
+		createAmlFilesystemWaitTime:        defaultCreateAmlFilesystemWaitTime,
+		createOperationResumeInterval:      options.CreateOperationResumeInterval,
+		amlFilesystemOperations:            newAmlFilesystemOperationQueue(options.MaxConcurrentAmlFilesystemOperations),
+		snapshotArchives:                   newVolumeLocks(),
+		subDirClones:                       newSubDirClones(),
+		cloneSubDirWaitTime:                defaultCloneSubDirWaitTime,
+		subDirCloneParallelism:             options.SubDirCloneParallelism,
+		orphanedAmlFilesystems:             make(map[string]time.Time),
+		orphanedAmlFilesystemCheckInterval: options.OrphanedAmlFilesystemCheckInterval,
+		deleteOrphanedAmlFilesystems:       options.DeleteOrphanedAmlFilesystems,
+		orphanedAmlFilesystemGracePeriod:   options.OrphanedAmlFilesystemGracePeriod,
+		blobImportPopulatorInterval:        options.BlobImportPopulatorInterval,
+		skuCacheTTL:                        options.SkuCacheTTL,
+	}
+	if d.operationNamespace == "" {
+		d.operationNamespace = DefaultOperationNamespace
+	}
+	if d.subDirCloneParallelism <= 0 {
+		d.subDirCloneParallelism = DefaultSubDirCloneParallelism
+	}
+	if d.orphanedAmlFilesystemGracePeriod <= 0 {
+		d.orphanedAmlFilesystemGracePeriod = DefaultOrphanedAmlFilesystemGracePeriod
 	}
 	d.Name = options.DriverName
 	d.Version = driverVersion
@@ -201,7 +310,7 @@ func NewDriver(options *DriverOptions) *Driver {
 	if config == nil {
 		if d.enableAzureLustreMockDynProv {
 			klog.V(2).Infof("no cloud config provided, driver running with mock dynamic provisioning")
-			d.dynamicProvisioner = &DynamicProvisioner{}
+			d.dynamicProvisioner = &mockDynamicProvisioner{}
 			d.cloud = az
 		} else {
 			klog.Fatalf("no cloud config provided, error")
@@ -224,11 +333,15 @@ func NewDriver(options *DriverOptions) *Driver {
 		d.resourceGroup = config.ResourceGroup
 		d.location = config.Location
 		// Get kubernetes client for taint removal functionality
-		kubeClient, err := getKubeClient()
+		kubeClient, dynamicClient, err := getKubeClient()
 		if err != nil {
 			klog.Warningf("failed to get kubernetes client: %v", err)
 		}
 		d.kubeClient = kubeClient
+		d.dynamicClient = dynamicClient
+		if kubeClient != nil {
+			d.eventRecorder = newEventRecorder(kubeClient, d.Name)
+		}
 		d.taintRemovalInitialDelay = 1 * time.Second
 		d.taintRemovalBackoff = wait.Backoff{
 			Duration: 500 * time.Millisecond,
@@ -239,39 +352,41 @@ func NewDriver(options *DriverOptions) *Driver {
 		if err != nil {
 			klog.Warningf("failed to obtain a credential: %v", err)
 		}
-		storageClientFactory, err := armstoragecache.NewClientFactory(config.SubscriptionID, cred, nil)
-		if err != nil {
-			klog.Warningf("failed to create storage client factory: %v", err)
-		}
 		subsID := d.cloud.SubscriptionID
 		if len(d.cloud.NetworkResourceSubscriptionID) > 0 {
 			subsID = d.cloud.NetworkResourceSubscriptionID
 		}
-		networkClientFactory, err := armnetwork.NewClientFactory(subsID, cred, nil)
-		if err != nil {
-			klog.Warningf("failed to create network client factory: %v", err)
+		var credential azcore.TokenCredential
+		if cred != nil {
+			credential = cred
 		}
-		vnetClient := networkClientFactory.NewVirtualNetworksClient()
-		skusClient := storageClientFactory.NewSKUsClient()
-		mgmtClient := storageClientFactory.NewManagementClient()
-		amlFilesystemsClient := storageClientFactory.NewAmlFilesystemsClient()
-		d.dynamicProvisioner = &DynamicProvisioner{
-			amlFilesystemsClient: amlFilesystemsClient,
-			mgmtClient:           mgmtClient,
-			vnetClient:           vnetClient,
-			skusClient:           skusClient,
+		dynamicProvisioner, err := newDynamicProvisioner(config.SubscriptionID, subsID, credential, options.SkuCacheTTL)
+		if err != nil {
+			klog.Warningf("failed to create dynamic provisioner: %v", err)
+			dynamicProvisioner = &DynamicProvisioner{subscriptionID: config.SubscriptionID}
 		}
+		d.dynamicProvisioner = dynamicProvisioner
 	}
 
 	return &d
 }
 
 func (d *Driver) populateSubnetPropertiesFromCloudConfig(subnetInfo SubnetProperties) SubnetProperties {
+	return d.populateSubnetProperties(subnetInfo, nil)
+}
+
+// populateSubnetProperties sets the subnet properties missing from the
+// parameters from the cloud config. The subnet is in the network subscription
+// of the credential, if the volume is created with provisioner secrets
+func (d *Driver) populateSubnetProperties(subnetInfo SubnetProperties, credential *provisionerCredential) SubnetProperties {
 	subnetProperties := subnetInfo
 	subsID := d.cloud.SubscriptionID
 	if len(d.cloud.NetworkResourceSubscriptionID) > 0 {
 		subsID = d.cloud.NetworkResourceSubscriptionID
 	}
+	if credential != nil {
+		subsID = credential.networkSubscriptionID
+	}
 
 	if len(subnetInfo.VnetResourceGroup) == 0 {
 		subnetProperties.VnetResourceGroup = d.cloud.ResourceGroup
@@ -322,6 +437,7 @@ func (d *Driver) Run(endpoint string, testBool bool) {
 	d.AddNodeServiceCapabilities(nodeServiceCapabilities)
 
 	d.removeNotReadyTaintIfNeeded()
+	d.startLeaderLoops()
 
 	s := csicommon.NewNonBlockingGRPCServer()
 	// Driver d act as IdentityServer, ControllerServer and NodeServer
@@ -344,7 +460,7 @@ func getLustreVolFromID(id string) (*lustreVolume, error) {
 	vol := &lustreVolume{
 		name:            name,
 		id:              id,
-		azureLustreName: DefaultLustreFsName,
+		azureLustreName: getFsName(segments[1]),
 		mgsIPAddress:    segments[2],
 	}
 
@@ -360,17 +476,45 @@ func getLustreVolFromID(id string) (*lustreVolume, error) {
 		vol.resourceGroupName = segments[5]
 	}
 
+	if len(segments) >= 7 {
+		vol.archiveOnDeletePath = segments[6]
+	}
+
+	if len(segments) >= 8 {
+		vol.subDirOnDelete = segments[7]
+	}
+
+	if len(segments) >= 9 && segments[8] != "" {
+		projectID, err := strconv.ParseUint(segments[8], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse project ID %q of volume ID %q: %v", segments[8], id, err)
+		}
+		vol.projectID = uint32(projectID)
+	}
+
+	if len(segments) >= 10 {
+		vol.subscriptionID = segments[9]
+	}
+
 	return vol, nil
 }
 
 // getKubeClient creates a kubernetes client from the in-cluster config
-func getKubeClient() (kubernetes.Interface, error) {
+func getKubeClient() (kubernetes.Interface, dynamic.Interface, error) {
 	// Use in-cluster config since this driver is designed for AKS environments
 	config, err := rest.InClusterConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
+		return nil, nil, fmt.Errorf("failed to get in-cluster config: %w", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
 	}
-	return kubernetes.NewForConfig(config)
+	return kubeClient, dynamicClient, nil
 }
 
 // JSONPatch represents a JSON patch operation
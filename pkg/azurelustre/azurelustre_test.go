@@ -20,15 +20,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"math"
 	"os"
 	"reflect"
 	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"testing/synctest"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
@@ -37,8 +40,10 @@ import (
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 )
 
@@ -54,11 +59,47 @@ const (
 	fakeDriverName            = "fake"
 	vendorVersion             = "0.4.0"
 	clusterRequestFailureName = "testShouldFail"
+	clusterPollFailureName    = "testPollShouldFail"
+	clusterPollRetryName      = "testPollShouldRetry"
+	fakeResumeTokenPrefix     = "fake-resume-token-"
+	archiveRequestFailureName = "testArchiveShouldFail"
+	otherArchiveRunningName   = "testOtherArchiveRunning"
+	degradedClusterName       = "testDegraded"
 	driverDefaultLocation     = "defaultFakeLocation"
 	emptyZonesLocation        = "emptyZonesLocation"
+	unknownSubscriptionID     = "unknownSubscriptionID"
+	fullSubnetName            = "fullSubnet"
+	importInProgressName      = "testImportInProgress"
+	importFailureName         = "testImportShouldFail"
+	importPartialName         = "testImportPartial"
 )
 
-func NewFakeDriver() *Driver {
+// fakeDriverOption replaces a dependency of the driver returned by
+// NewFakeDriver with a fake
+type fakeDriverOption func(*Driver)
+
+// withFakeDynamicProvisioner sets the dynamic provisioner of the driver
+func withFakeDynamicProvisioner(fakeDynamicProvisioner *FakeDynamicProvisioner) fakeDriverOption {
+	return func(d *Driver) {
+		d.dynamicProvisioner = fakeDynamicProvisioner
+	}
+}
+
+// withFakeKubeClient sets a fake kube client holding the objects
+func withFakeKubeClient(objects ...runtime.Object) fakeDriverOption {
+	return func(d *Driver) {
+		d.kubeClient = kubefake.NewSimpleClientset(objects...)
+	}
+}
+
+// withFakeEventRecorder records the events of the driver in recorder
+func withFakeEventRecorder(recorder *record.FakeRecorder) fakeDriverOption {
+	return func(d *Driver) {
+		d.eventRecorder = recorder
+	}
+}
+
+func NewFakeDriver(options ...fakeDriverOption) *Driver {
 	driverOptions := DriverOptions{
 		NodeID:                       fakeNodeID,
 		DriverName:                   fakeDriverName,
@@ -72,6 +113,12 @@ func NewFakeDriver() *Driver {
 	driver.location = driverDefaultLocation
 	driver.resourceGroup = "defaultFakeResourceGroup"
 	driver.dynamicProvisioner = &FakeDynamicProvisioner{}
+	driver.newCredentialProvisioner = func(_ *provisionerCredential) (DynamicProvisionerInterface, error) {
+		return &FakeDynamicProvisioner{}, nil
+	}
+	for _, option := range options {
+		option(driver)
+	}
 
 	return driver
 }
@@ -80,24 +127,83 @@ type FakeDynamicProvisioner struct {
 	DynamicProvisionerInterface
 	Filesystems   []*AmlFilesystemProperties
 	fakeCallCount map[string]int
+	// PollCreateAmlFilesystem blocks until pollCreateRelease is closed, if set
+	pollCreateRelease chan struct{}
+	// ImportJobs are the created import jobs, keyed by cluster and job name
+	ImportJobs map[string]*ImportJobProperties
+	// ArchiveStartTimes are the start times of the archives, keyed by
+	// cluster and path
+	ArchiveStartTimes map[string]map[string]time.Time
+	mux               sync.Mutex
 }
 
 func (f *FakeDynamicProvisioner) recordFakeCall(name string) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
 	if f.fakeCallCount == nil {
 		f.fakeCallCount = make(map[string]int)
 	}
 	f.fakeCallCount[name]++
 }
 
-func (f *FakeDynamicProvisioner) CreateAmlFilesystem(_ context.Context, amlFilesystemProperties *AmlFilesystemProperties) (string, error) {
-	f.recordFakeCall("CreateAmlFilesystem")
+func (f *FakeDynamicProvisioner) BeginCreateAmlFilesystem(_ context.Context, amlFilesystemProperties *AmlFilesystemProperties) (string, error) {
+	f.recordFakeCall("BeginCreateAmlFilesystem")
 	if strings.HasSuffix(amlFilesystemProperties.AmlFilesystemName, clusterRequestFailureName) {
 		return "", status.Errorf(codes.InvalidArgument, "error occurred calling API: %s", clusterRequestFailureName)
 	}
+	return fakeResumeTokenPrefix + amlFilesystemProperties.AmlFilesystemName, nil
+}
+
+func (f *FakeDynamicProvisioner) PollCreateAmlFilesystem(_ context.Context, amlFilesystemProperties *AmlFilesystemProperties, resumeToken string) (string, error) {
+	f.recordFakeCall("PollCreateAmlFilesystem")
+	if f.pollCreateRelease != nil {
+		<-f.pollCreateRelease
+	}
+	if resumeToken != fakeResumeTokenPrefix+amlFilesystemProperties.AmlFilesystemName {
+		return "", status.Errorf(codes.Internal, "failed to resume creation of AMLFS cluster %s", amlFilesystemProperties.AmlFilesystemName)
+	}
+	if strings.HasSuffix(amlFilesystemProperties.AmlFilesystemName, clusterPollFailureName) {
+		return "", status.Errorf(codes.DeadlineExceeded, "error occurred calling API: %s", clusterPollFailureName)
+	}
+	if strings.HasSuffix(amlFilesystemProperties.AmlFilesystemName, clusterPollRetryName) {
+		return "", status.Errorf(codes.Aborted, amlFilesystemCreationRetryFmt, amlFilesystemProperties.AmlFilesystemName)
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
 	f.Filesystems = append(f.Filesystems, amlFilesystemProperties)
 	return "127.0.0.2", nil
 }
 
+func (f *FakeDynamicProvisioner) SelectSubnet(_ context.Context, amlFilesystemProperties *AmlFilesystemProperties) (SubnetProperties, error) {
+	f.recordFakeCall("SelectSubnet")
+	subnetInfo := amlFilesystemProperties.SubnetInfo
+	candidates := amlFilesystemProperties.SubnetCandidates
+	if amlFilesystemProperties.SubnetNamePrefix != "" {
+		candidates = []string{amlFilesystemProperties.SubnetNamePrefix + fullSubnetName, amlFilesystemProperties.SubnetNamePrefix + "1"}
+	}
+	if len(candidates) == 0 {
+		return subnetInfo, nil
+	}
+	for _, candidate := range candidates {
+		if strings.HasSuffix(candidate, fullSubnetName) {
+			continue
+		}
+		subnetInfo.SubnetName = candidate
+		subnetInfo.SubnetID = subnetInfo.SubnetID[:strings.LastIndex(subnetInfo.SubnetID, "/")+1] + candidate
+		return subnetInfo, nil
+	}
+	return SubnetProperties{}, status.Errorf(codes.ResourceExhausted, "cannot create AMLFS cluster %s in any of the subnets %v, not enough IP addresses available",
+		amlFilesystemProperties.AmlFilesystemName, candidates)
+}
+
+func (f *FakeDynamicProvisioner) CheckSubnetCapacity(_ context.Context, subnetInfo SubnetProperties, _ string, _ float32) (bool, error) {
+	f.recordFakeCall("CheckSubnetCapacity")
+	if subnetInfo.SubnetName == clusterRequestFailureName {
+		return false, status.Errorf(codes.Unavailable, "error occurred calling API: %s", clusterRequestFailureName)
+	}
+	return !strings.HasSuffix(subnetInfo.SubnetName, fullSubnetName), nil
+}
+
 func (f *FakeDynamicProvisioner) DeleteAmlFilesystem(_ context.Context, _, amlFilesystemName string) error {
 	f.recordFakeCall("DeleteAmlFilesystem")
 	if amlFilesystemName == clusterRequestFailureName {
@@ -109,6 +215,110 @@ func (f *FakeDynamicProvisioner) DeleteAmlFilesystem(_ context.Context, _, amlFi
 	return nil
 }
 
+func (f *FakeDynamicProvisioner) ArchiveAmlFilesystem(_ context.Context, _, amlFilesystemName, filesystemPath string) error {
+	f.recordFakeCall("ArchiveAmlFilesystem")
+	switch amlFilesystemName {
+	case archiveRequestFailureName:
+		return status.Errorf(codes.Aborted, "archive of AMLFS cluster %s ended in state Failed", archiveRequestFailureName)
+	case otherArchiveRunningName:
+		return status.Errorf(codes.Aborted, otherArchiveInProgressFmt, "/", otherArchiveRunningName, filesystemPath)
+	}
+	f.setArchiveStartTime(amlFilesystemName, filesystemPath, time.Now())
+	return nil
+}
+
+func (f *FakeDynamicProvisioner) setArchiveStartTime(amlFilesystemName, filesystemPath string, startTime time.Time) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.ArchiveStartTimes == nil {
+		f.ArchiveStartTimes = make(map[string]map[string]time.Time)
+	}
+	if f.ArchiveStartTimes[amlFilesystemName] == nil {
+		f.ArchiveStartTimes[amlFilesystemName] = make(map[string]time.Time)
+	}
+	f.ArchiveStartTimes[amlFilesystemName][filesystemPath] = startTime
+}
+
+func (f *FakeDynamicProvisioner) CreateImportJob(_ context.Context, importJobProperties *ImportJobProperties) error {
+	f.recordFakeCall("CreateImportJob")
+	if f.ImportJobs == nil {
+		f.ImportJobs = make(map[string]*ImportJobProperties)
+	}
+	f.ImportJobs[importJobProperties.AmlFilesystemName+"/"+importJobProperties.ImportJobName] = importJobProperties
+	return nil
+}
+
+func (f *FakeDynamicProvisioner) GetImportJobStatus(_ context.Context, _, amlFilesystemName, importJobName string) (*ImportJobStatus, error) {
+	f.recordFakeCall("GetImportJobStatus")
+	if _, ok := f.ImportJobs[amlFilesystemName+"/"+importJobName]; !ok {
+		return nil, status.Errorf(codes.NotFound, "import job %s not found", importJobName)
+	}
+	switch amlFilesystemName {
+	case importInProgressName:
+		return &ImportJobStatus{State: armstoragecache.ImportStatusTypeInProgress, TotalBlobsWalked: 10, TotalBlobsImported: 4}, nil
+	case importFailureName:
+		return &ImportJobStatus{State: armstoragecache.ImportStatusTypeFailed, StatusMessage: "container not found"}, nil
+	case importPartialName:
+		return &ImportJobStatus{State: armstoragecache.ImportStatusTypeCompletedPartial, TotalBlobsWalked: 10, TotalBlobsImported: 8, TotalErrors: 2}, nil
+	}
+	return &ImportJobStatus{State: armstoragecache.ImportStatusTypeCompleted, TotalBlobsWalked: 10, TotalBlobsImported: 10}, nil
+}
+
+func (f *FakeDynamicProvisioner) ListAmlFilesystems(_ context.Context) ([]*AmlFilesystemInfo, error) {
+	f.recordFakeCall("ListAmlFilesystems")
+	amlFilesystems := make([]*AmlFilesystemInfo, 0, len(f.Filesystems))
+	for _, filesystem := range f.Filesystems {
+		if filesystem.AmlFilesystemName == clusterRequestFailureName {
+			return nil, status.Errorf(codes.Unavailable, "error occurred calling API: %s", clusterRequestFailureName)
+		}
+		amlFilesystems = append(amlFilesystems, newFakeAmlFilesystemInfo(filesystem))
+	}
+	return amlFilesystems, nil
+}
+
+func (f *FakeDynamicProvisioner) GetAmlFilesystem(_ context.Context, resourceGroupName, amlFilesystemName string) (*AmlFilesystemInfo, error) {
+	f.recordFakeCall("GetAmlFilesystem")
+	if amlFilesystemName == clusterRequestFailureName {
+		return nil, status.Errorf(codes.Unavailable, "error occurred calling API: %s", clusterRequestFailureName)
+	}
+	for _, filesystem := range f.Filesystems {
+		if filesystem.AmlFilesystemName == amlFilesystemName && filesystem.ResourceGroupName == resourceGroupName {
+			amlFilesystemInfo := newFakeAmlFilesystemInfo(filesystem)
+			f.mux.Lock()
+			amlFilesystemInfo.ArchiveStartTimes = maps.Clone(f.ArchiveStartTimes[amlFilesystemName])
+			f.mux.Unlock()
+			return amlFilesystemInfo, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "AMLFS cluster %s not found in resource group %s", amlFilesystemName, resourceGroupName)
+}
+
+func (f *FakeDynamicProvisioner) GetAmlFilesystemInSubscription(ctx context.Context, subscriptionID, resourceGroupName, amlFilesystemName string) (*AmlFilesystemInfo, error) {
+	f.recordFakeCall("GetAmlFilesystemInSubscription")
+	if subscriptionID == unknownSubscriptionID {
+		return nil, status.Errorf(codes.PermissionDenied, "no access to subscription %s", subscriptionID)
+	}
+	return f.GetAmlFilesystem(ctx, resourceGroupName, amlFilesystemName)
+}
+
+func newFakeAmlFilesystemInfo(filesystem *AmlFilesystemProperties) *AmlFilesystemInfo {
+	amlFilesystemInfo := &AmlFilesystemInfo{
+		Name:               filesystem.AmlFilesystemName,
+		ResourceGroupName:  filesystem.ResourceGroupName,
+		MgsAddress:         "127.0.0.2",
+		StorageCapacityTiB: filesystem.StorageCapacityTiB,
+		Tags:               filesystem.Tags,
+		ProvisioningState:  armstoragecache.AmlFilesystemProvisioningStateTypeSucceeded,
+		HealthState:        armstoragecache.AmlFilesystemHealthStateTypeAvailable,
+		HsmContainer:       filesystem.HsmContainer,
+	}
+	if filesystem.AmlFilesystemName == degradedClusterName {
+		amlFilesystemInfo.HealthState = armstoragecache.AmlFilesystemHealthStateTypeDegraded
+		amlFilesystemInfo.HealthStatusDescription = "OSS is unreachable"
+	}
+	return amlFilesystemInfo
+}
+
 func (f *FakeDynamicProvisioner) GetSkuValuesForLocation(_ context.Context, location string) (map[string]*LustreSkuValue, error) {
 	f.recordFakeCall("GetSkuValuesForLocation")
 	if location == errorLocation {
@@ -133,6 +343,14 @@ func (f *FakeDynamicProvisioner) GetSkuValuesForLocation(_ context.Context, loca
 	}, nil
 }
 
+func (f *FakeDynamicProvisioner) GetAvailableCapacity(_ context.Context, amlFilesystemProperties *AmlFilesystemProperties, lustreSkuValue *LustreSkuValue) (int64, error) {
+	f.recordFakeCall("GetAvailableCapacity")
+	if amlFilesystemProperties.SubnetInfo.SubnetName == clusterRequestFailureName {
+		return 0, status.Errorf(codes.Unavailable, "error occurred calling API: %s", clusterRequestFailureName)
+	}
+	return lustreSkuValue.MaximumInTib, nil
+}
+
 func TestNewDriver(t *testing.T) {
 	fakeConfigFile := "fake-cred-file.json"
 	fakeConfigContent := `{
@@ -204,7 +422,7 @@ func TestNewDriverInvalidConfigFileLocation(t *testing.T) {
 	d := NewDriver(&driverOptions)
 	assert.NotNil(t, d)
 	assert.Equal(t, &azure.Cloud{}, d.cloud)
-	assert.Equal(t, &DynamicProvisioner{}, d.dynamicProvisioner)
+	assert.Equal(t, &mockDynamicProvisioner{}, d.dynamicProvisioner)
 }
 
 func TestNewDriverInvalidConfigFileContents(t *testing.T) {
@@ -234,7 +452,7 @@ func TestNewDriverInvalidConfigFileContents(t *testing.T) {
 	d := NewDriver(&driverOptions)
 	assert.NotNil(t, d)
 	assert.Equal(t, &azure.Cloud{}, d.cloud)
-	assert.Equal(t, &DynamicProvisioner{}, d.dynamicProvisioner)
+	assert.Equal(t, &mockDynamicProvisioner{}, d.dynamicProvisioner)
 }
 
 func TestIsCorruptedDir(t *testing.T) {
@@ -289,6 +507,17 @@ func TestGetLustreVolFromID(t *testing.T) {
 				subDir:          "",
 			},
 		},
+		{
+			desc:     "correct volume id with fs name and failover NIDs",
+			volumeID: "vol_1#scratch#10.0.0.4@tcp1:10.0.0.5@tcp1#testSubDir",
+			expectedLustreVolume: &lustreVolume{
+				id:              "vol_1#scratch#10.0.0.4@tcp1:10.0.0.5@tcp1#testSubDir",
+				name:            "vol_1",
+				azureLustreName: "scratch",
+				mgsIPAddress:    "10.0.0.4@tcp1:10.0.0.5@tcp1",
+				subDir:          "testSubDir",
+			},
+		},
 		{
 			desc:     "correct simple volume id",
 			volumeID: "vol_1#lustrefs#1.1.1.1##",
@@ -313,6 +542,51 @@ func TestGetLustreVolFromID(t *testing.T) {
 				resourceGroupName:            "testAmlfsRg",
 			},
 		},
+		{
+			desc:     "correct volume id with archive on delete path",
+			volumeID: "vol_1#lustrefs#1.1.1.1#testSubDir#t#testAmlfsRg#/archive",
+			expectedLustreVolume: &lustreVolume{
+				id:                           "vol_1#lustrefs#1.1.1.1#testSubDir#t#testAmlfsRg#/archive",
+				name:                         "vol_1",
+				azureLustreName:              "lustrefs",
+				mgsIPAddress:                 "1.1.1.1",
+				subDir:                       "testSubDir",
+				createdByDynamicProvisioning: true,
+				resourceGroupName:            "testAmlfsRg",
+				archiveOnDeletePath:          "/archive",
+			},
+		},
+		{
+			desc:     "correct volume id with sub-dir delete policy",
+			volumeID: "vol_1#lustrefs#1.1.1.1#testSubDir#f###retain",
+			expectedLustreVolume: &lustreVolume{
+				id:              "vol_1#lustrefs#1.1.1.1#testSubDir#f###retain",
+				name:            "vol_1",
+				azureLustreName: "lustrefs",
+				mgsIPAddress:    "1.1.1.1",
+				subDir:          "testSubDir",
+				subDirOnDelete:  "retain",
+			},
+		},
+		{
+			desc:     "correct volume id with sub-dir project ID",
+			volumeID: "vol_1#lustrefs#1.1.1.1#testSubDir#f###delete#42",
+			expectedLustreVolume: &lustreVolume{
+				id:              "vol_1#lustrefs#1.1.1.1#testSubDir#f###delete#42",
+				name:            "vol_1",
+				azureLustreName: "lustrefs",
+				mgsIPAddress:    "1.1.1.1",
+				subDir:          "testSubDir",
+				subDirOnDelete:  "delete",
+				projectID:       42,
+			},
+		},
+		{
+			desc:                 "incorrect sub-dir project ID",
+			volumeID:             "vol_1#lustrefs#1.1.1.1#testSubDir#f###delete#-1",
+			expectedLustreVolume: nil,
+			expectedErr:          errors.New("could not parse project ID \"-1\" of volume ID \"vol_1#lustrefs#1.1.1.1#testSubDir#f###delete#-1\": strconv.ParseUint: parsing \"-1\": invalid syntax"),
+		},
 		{
 			desc:     "correct volume id with extra slashes",
 			volumeID: "vol_1#lustrefs/#1.1.1.1#/testSubDir/",
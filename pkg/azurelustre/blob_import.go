@@ -0,0 +1,452 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/azurelustre-csi-driver/pkg/util"
+)
+
+const (
+	blobImportAPIGroup = "azurelustre.csi.azure.com"
+	blobImportKind     = "LustreBlobImport"
+	// blobImportJobName is the import job that populates the cluster of a
+	// PVC, each PVC has its own cluster
+	blobImportJobName = "populate-pvc"
+	// The prime PVC provisions the cluster of a PVC with a LustreBlobImport
+	// data source, its PV is bound to the PVC once the blobs are imported
+	blobImportPrimePVCPrefix         = "lustre-blob-import-"
+	blobImportOperation              = "blob-import"
+	blobImportPVCNamespaceAnnotation = "azurelustre.csi.azure.com/populated-pvc-namespace"
+	blobImportPVCNameAnnotation      = "azurelustre.csi.azure.com/populated-pvc-name"
+	selectedNodeAnnotation           = "volume.kubernetes.io/selected-node"
+	// blobImportFailedAnnotation records on the PVC why it cannot be
+	// populated, the PVC is not populated anymore once it is set
+	blobImportFailedAnnotation = "azurelustre.csi.azure.com/blob-import-failed"
+
+	defaultBlobImportConflictResolutionMode = armstoragecache.ConflictResolutionModeFail
+	blobImportInProgressFmt                 = "import of LustreBlobImport %s into AMLFS cluster %s is in progress, %d of %d blobs imported"
+)
+
+var blobImportResource = schema.GroupVersionResource{
+	Group:    blobImportAPIGroup,
+	Version:  "v1alpha1",
+	Resource: "lustreblobimports",
+}
+
+// blobImportSpec is the spec of a LustreBlobImport, the blobs of the HSM
+// container of the storage class imported into the cluster of a new PVC
+type blobImportSpec struct {
+	// ImportPrefixes are the blob prefixes to import, all the blobs of the
+	// container are imported if empty
+	ImportPrefixes         []string `json:"importPrefixes,omitempty"`
+	ConflictResolutionMode string   `json:"conflictResolutionMode,omitempty"`
+	MaximumErrors          int32    `json:"maximumErrors,omitempty"`
+}
+
+// runBlobImportPopulator periodically populates the PVCs with a
+// LustreBlobImport data source. csi-provisioner does not provision these
+// PVCs, so each one is provisioned through a prime PVC of the same storage
+// class, and the PV of the prime PVC is bound to the PVC once the blobs are
+// imported into its cluster
+func (d *Driver) runBlobImportPopulator(ctx context.Context) {
+	if d.dynamicClient == nil {
+		klog.Warningf("kubernetes dynamic client is not available, PVCs with a %s data source will not be populated", blobImportKind)
+		return
+	}
+	klog.V(2).Infof("populating PVCs with a %s data source every %v", blobImportKind, d.blobImportPopulatorInterval)
+	wait.UntilWithContext(ctx, d.populateBlobImports, d.blobImportPopulatorInterval)
+}
+
+func (d *Driver) populateBlobImports(ctx context.Context) {
+	pvcs, err := d.kubeClient.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("error when listing PVCs to populate: %v", err)
+		return
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if !isBlobImportPersistentVolumeClaim(pvc) {
+			continue
+		}
+		if err := d.populateBlobImport(ctx, pvc); err != nil {
+			klog.Errorf("error when populating PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		}
+	}
+
+	d.deleteBlobImportPrimePersistentVolumeClaims(ctx)
+}
+
+// isBlobImportPersistentVolumeClaim returns true if the PVC has a
+// LustreBlobImport data source, is not bound yet and did not fail
+func isBlobImportPersistentVolumeClaim(pvc *corev1.PersistentVolumeClaim) bool {
+	dataSourceRef := pvc.Spec.DataSourceRef
+	return dataSourceRef != nil &&
+		ptr.Deref(dataSourceRef.APIGroup, "") == blobImportAPIGroup &&
+		dataSourceRef.Kind == blobImportKind &&
+		pvc.Spec.VolumeName == "" &&
+		pvc.DeletionTimestamp == nil &&
+		pvc.Annotations[blobImportFailedAnnotation] == ""
+}
+
+// populateBlobImport moves the population of the PVC one step forward: it
+// creates the prime PVC, starts the import job once the cluster is created,
+// and binds the PV to the PVC once the import job is completed
+func (d *Driver) populateBlobImport(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	storageClassName := ptr.Deref(pvc.Spec.StorageClassName, "")
+	if storageClassName == "" {
+		return nil
+	}
+	storageClass, err := d.kubeClient.StorageV1().StorageClasses().Get(ctx, storageClassName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get StorageClass %s: %w", storageClassName, err)
+	}
+	if storageClass.Provisioner != d.Name {
+		return nil
+	}
+	if ptr.Deref(storageClass.VolumeBindingMode, storagev1.VolumeBindingImmediate) == storagev1.VolumeBindingWaitForFirstConsumer &&
+		pvc.Annotations[selectedNodeAnnotation] == "" {
+		klog.V(4).Infof("PVC %s/%s waits for a pod to be scheduled before it is populated", pvc.Namespace, pvc.Name)
+		return nil
+	}
+
+	blobImportName := pvc.Spec.DataSourceRef.Name
+	spec, err := d.getBlobImportSpec(ctx, pvc)
+	if status.Code(err) == codes.InvalidArgument {
+		return d.failBlobImport(ctx, pvc, "cannot import LustreBlobImport %s: %s", blobImportName, status.Convert(err).Message())
+	}
+	if err != nil {
+		d.recordEvent(pvc, corev1.EventTypeWarning, eventReasonBlobImportFailed,
+			"cannot import LustreBlobImport %s: %s", blobImportName, status.Convert(err).Message())
+		return err
+	}
+
+	primePVC, err := d.getOrCreateBlobImportPrimePersistentVolumeClaim(ctx, pvc, blobImportName)
+	if err != nil || primePVC.Status.Phase != corev1.ClaimBound {
+		return err
+	}
+
+	pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, primePVC.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get PV %s of prime PVC %s/%s: %w", primePVC.Spec.VolumeName, primePVC.Namespace, primePVC.Name, err)
+	}
+	if pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.UID == pvc.UID {
+		// The PV is already bound to the PVC
+		return nil
+	}
+	if pv.Spec.CSI == nil {
+		return fmt.Errorf("PV %s of prime PVC %s/%s is not a CSI volume", pv.Name, primePVC.Namespace, primePVC.Name)
+	}
+	vol, err := getLustreVolFromID(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return fmt.Errorf("PV %s of prime PVC %s/%s has an invalid volume ID: %w", pv.Name, primePVC.Namespace, primePVC.Name, err)
+	}
+	if !vol.createdByDynamicProvisioning {
+		return d.failBlobImport(ctx, pvc,
+			"cannot import LustreBlobImport %s: StorageClass %s must create a new AMLFS cluster with an HSM container to import blobs into",
+			blobImportName, storageClassName)
+	}
+
+	// The import needs the credential the cluster was created with
+	secrets, err := d.getStorageClassProvisionerSecrets(ctx, storageClass.Parameters)
+	if err != nil {
+		return err
+	}
+	dynamicProvisioner, err := d.getVolumeDynamicProvisioner(vol, secrets)
+	if err != nil {
+		return err
+	}
+
+	importJobStatus, err := dynamicProvisioner.GetImportJobStatus(ctx, vol.resourceGroupName, vol.name, blobImportJobName)
+	if status.Code(err) == codes.NotFound {
+		return d.startBlobImport(ctx, dynamicProvisioner, pvc, storageClass, vol, spec)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch importJobStatus.State { //nolint:exhaustive // All other states are still in progress
+	case armstoragecache.ImportStatusTypeCompleted,
+		armstoragecache.ImportStatusTypeCompletedPartial:
+		// The import job fails if it exceeds the maximumErrors of the
+		// LustreBlobImport, so the PVC is bound with the errors it allows
+		if importJobStatus.TotalErrors > 0 {
+			d.recordEvent(pvc, corev1.EventTypeWarning, eventReasonBlobImportPartial,
+				"imported %d of %d blobs of LustreBlobImport %s into AMLFS cluster %s with %d conflicts and %d errors, at most %d errors are allowed",
+				importJobStatus.TotalBlobsImported, importJobStatus.TotalBlobsWalked, blobImportName, vol.name,
+				importJobStatus.TotalConflicts, importJobStatus.TotalErrors, spec.MaximumErrors)
+		} else {
+			d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonBlobImportSucceeded,
+				"imported %d of %d blobs of LustreBlobImport %s into AMLFS cluster %s with %d conflicts",
+				importJobStatus.TotalBlobsImported, importJobStatus.TotalBlobsWalked, blobImportName, vol.name,
+				importJobStatus.TotalConflicts)
+		}
+		return d.bindBlobImportPersistentVolume(ctx, pvc, pv)
+	case armstoragecache.ImportStatusTypeFailed,
+		armstoragecache.ImportStatusTypeCanceled:
+		klog.Errorf("import of LustreBlobImport %s into AMLFS cluster %s ended in state %s: %s",
+			blobImportName, vol.name, importJobStatus.State, importJobStatus.StatusMessage)
+		return d.failBlobImport(ctx, pvc,
+			"import of LustreBlobImport %s into AMLFS cluster %s ended in state %s with %d errors: %s, delete the PVC to delete the cluster",
+			blobImportName, vol.name, importJobStatus.State, importJobStatus.TotalErrors, importJobStatus.StatusMessage)
+	}
+
+	klog.V(2).Infof(blobImportInProgressFmt, blobImportName, vol.name, importJobStatus.TotalBlobsImported, importJobStatus.TotalBlobsWalked)
+	d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonBlobImportInProgress,
+		blobImportInProgressFmt, blobImportName, vol.name, importJobStatus.TotalBlobsImported, importJobStatus.TotalBlobsWalked)
+	return nil
+}
+
+// failBlobImport records why the PVC cannot be populated in an annotation of
+// the PVC, so that it is not populated anymore, and in an event, which is
+// only recorded once the PVC is annotated
+func (d *Driver) failBlobImport(ctx context.Context, pvc *corev1.PersistentVolumeClaim, messageFmt string, args ...interface{}) error {
+	message := fmt.Sprintf(messageFmt, args...)
+	failedPVC := pvc.DeepCopy()
+	if failedPVC.Annotations == nil {
+		failedPVC.Annotations = make(map[string]string)
+	}
+	failedPVC.Annotations[blobImportFailedAnnotation] = message
+	failedPVC, err := d.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(ctx, failedPVC, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate PVC %s/%s with the failure of its import: %w", pvc.Namespace, pvc.Name, err)
+	}
+	d.recordEvent(failedPVC, corev1.EventTypeWarning, eventReasonBlobImportFailed, "%s", message)
+	return nil
+}
+
+// getBlobImportSpec reads the LustreBlobImport of the PVC, which must be in
+// the namespace of the PVC
+func (d *Driver) getBlobImportSpec(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*blobImportSpec, error) {
+	dataSourceRef := pvc.Spec.DataSourceRef
+	if namespace := ptr.Deref(dataSourceRef.Namespace, pvc.Namespace); namespace != pvc.Namespace {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"%s must be in the namespace of the PVC %s, was: '%s'", blobImportKind, pvc.Namespace, namespace)
+	}
+
+	blobImport, err := d.dynamicClient.Resource(blobImportResource).Namespace(pvc.Namespace).Get(ctx, dataSourceRef.Name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "%s %s/%s not found", blobImportKind, pvc.Namespace, dataSourceRef.Name)
+		}
+		return nil, status.Errorf(codes.Unavailable, "failed to get %s %s/%s: %v", blobImportKind, pvc.Namespace, dataSourceRef.Name, err)
+	}
+
+	var spec blobImportSpec
+	if rawSpec, ok := blobImport.Object["spec"].(map[string]interface{}); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawSpec, &spec); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid spec of %s %s/%s: %v", blobImportKind, pvc.Namespace, dataSourceRef.Name, err)
+		}
+	}
+	if err := validateBlobImportSpec(&spec); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid spec of %s %s/%s: %v", blobImportKind, pvc.Namespace, dataSourceRef.Name, err)
+	}
+	return &spec, nil
+}
+
+// validateBlobImportSpec validates the spec and sets the conflict resolution
+// mode to its value in the import job API
+func validateBlobImportSpec(spec *blobImportSpec) error {
+	for _, importPrefix := range spec.ImportPrefixes {
+		if !strings.HasPrefix(importPrefix, "/") {
+			return fmt.Errorf("importPrefixes must be paths starting with '/', was: '%s'", importPrefix)
+		}
+	}
+	if spec.MaximumErrors < 0 {
+		return fmt.Errorf("maximumErrors must not be negative, was: %d", spec.MaximumErrors)
+	}
+
+	if spec.ConflictResolutionMode == "" {
+		spec.ConflictResolutionMode = string(defaultBlobImportConflictResolutionMode)
+		return nil
+	}
+	var conflictResolutionModes []string
+	for _, conflictResolutionMode := range armstoragecache.PossibleConflictResolutionModeValues() {
+		if strings.EqualFold(spec.ConflictResolutionMode, string(conflictResolutionMode)) {
+			spec.ConflictResolutionMode = string(conflictResolutionMode)
+			return nil
+		}
+		conflictResolutionModes = append(conflictResolutionModes, string(conflictResolutionMode))
+	}
+	return fmt.Errorf("conflictResolutionMode must be one of: %v, was: '%s'", conflictResolutionModes, spec.ConflictResolutionMode)
+}
+
+func getBlobImportPrimePersistentVolumeClaimName(pvc *corev1.PersistentVolumeClaim) string {
+	return blobImportPrimePVCPrefix + string(pvc.UID)
+}
+
+// getOrCreateBlobImportPrimePersistentVolumeClaim returns the prime PVC of
+// the PVC, which is created with the storage class, capacity and selected
+// node of the PVC
+func (d *Driver) getOrCreateBlobImportPrimePersistentVolumeClaim(ctx context.Context, pvc *corev1.PersistentVolumeClaim, blobImportName string) (*corev1.PersistentVolumeClaim, error) {
+	name := getBlobImportPrimePersistentVolumeClaimName(pvc)
+	primePVC, err := d.kubeClient.CoreV1().PersistentVolumeClaims(d.operationNamespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return primePVC, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get prime PVC %s/%s: %w", d.operationNamespace, name, err)
+	}
+
+	primePVC = &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: d.operationNamespace,
+			Labels: map[string]string{
+				createOperationLabel: blobImportOperation,
+			},
+			Annotations: map[string]string{
+				blobImportPVCNamespaceAnnotation: pvc.Namespace,
+				blobImportPVCNameAnnotation:      pvc.Name,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      pvc.Spec.AccessModes,
+			Resources:        pvc.Spec.Resources,
+			StorageClassName: pvc.Spec.StorageClassName,
+			VolumeMode:       pvc.Spec.VolumeMode,
+		},
+	}
+	if selectedNode := pvc.Annotations[selectedNodeAnnotation]; selectedNode != "" {
+		primePVC.Annotations[selectedNodeAnnotation] = selectedNode
+	}
+	primePVC, err = d.kubeClient.CoreV1().PersistentVolumeClaims(d.operationNamespace).Create(ctx, primePVC, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create prime PVC %s/%s: %w", d.operationNamespace, name, err)
+	}
+	klog.V(2).Infof("created prime PVC %s/%s to populate PVC %s/%s", primePVC.Namespace, primePVC.Name, pvc.Namespace, pvc.Name)
+	d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonBlobImportProvisioning,
+		"provisioning AMLFS cluster with PVC %s/%s to import LustreBlobImport %s", primePVC.Namespace, primePVC.Name, blobImportName)
+	return primePVC, nil
+}
+
+func (d *Driver) startBlobImport(ctx context.Context, dynamicProvisioner DynamicProvisionerInterface, pvc *corev1.PersistentVolumeClaim, storageClass *storagev1.StorageClass, vol *lustreVolume, spec *blobImportSpec) error {
+	location := util.GetValueInMap(storageClass.Parameters, VolumeContextLocation)
+	if location == "" {
+		location = d.location
+	}
+	importPrefixes := spec.ImportPrefixes
+	if len(importPrefixes) == 0 {
+		importPrefixes = []string{"/"}
+	}
+
+	klog.V(2).Infof("importing prefixes %v of LustreBlobImport %s into AMLFS cluster %s", importPrefixes, pvc.Spec.DataSourceRef.Name, vol.name)
+	err := dynamicProvisioner.CreateImportJob(ctx, &ImportJobProperties{
+		ResourceGroupName:      vol.resourceGroupName,
+		AmlFilesystemName:      vol.name,
+		ImportJobName:          blobImportJobName,
+		Location:               location,
+		ImportPrefixes:         importPrefixes,
+		ConflictResolutionMode: armstoragecache.ConflictResolutionMode(spec.ConflictResolutionMode),
+		MaximumErrors:          spec.MaximumErrors,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create import job of LustreBlobImport %s on AMLFS cluster %s: %w", pvc.Spec.DataSourceRef.Name, vol.name, err)
+	}
+	d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonBlobImportStarted,
+		"importing prefixes %s of LustreBlobImport %s into AMLFS cluster %s with conflict resolution mode %s and at most %d errors",
+		strings.Join(importPrefixes, ","), pvc.Spec.DataSourceRef.Name, vol.name, spec.ConflictResolutionMode, spec.MaximumErrors)
+	return nil
+}
+
+// bindBlobImportPersistentVolume binds the PV of the prime PVC to the PVC,
+// the prime PVC is deleted once the PVC is bound
+func (d *Driver) bindBlobImportPersistentVolume(ctx context.Context, pvc *corev1.PersistentVolumeClaim, pv *corev1.PersistentVolume) error {
+	if pv.Spec.ClaimRef == nil {
+		return fmt.Errorf("PV %s is not bound to a prime PVC", pv.Name)
+	}
+	patch, err := json.Marshal([]JSONPatch{
+		{
+			OP:    "test",
+			Path:  "/spec/claimRef/uid",
+			Value: pv.Spec.ClaimRef.UID,
+		},
+		{
+			OP:   "replace",
+			Path: "/spec/claimRef",
+			Value: &corev1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  pvc.Namespace,
+				Name:       pvc.Name,
+				UID:        pvc.UID,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := d.kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.JSONPatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to bind PV %s to PVC %s/%s: %w", pv.Name, pvc.Namespace, pvc.Name, err)
+	}
+	klog.V(2).Infof("bound PV %s to populated PVC %s/%s", pv.Name, pvc.Namespace, pvc.Name)
+	return nil
+}
+
+// deleteBlobImportPrimePersistentVolumeClaims deletes the prime PVCs whose
+// PVC is bound to their PV, or was deleted. The PV of a prime PVC, and its
+// cluster, are deleted with the prime PVC if they were not bound to the PVC
+func (d *Driver) deleteBlobImportPrimePersistentVolumeClaims(ctx context.Context) {
+	primePVCs, err := d.kubeClient.CoreV1().PersistentVolumeClaims(d.operationNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: createOperationLabel + "=" + blobImportOperation,
+	})
+	if err != nil {
+		klog.Errorf("error when listing prime PVCs in %s: %v", d.operationNamespace, err)
+		return
+	}
+
+	for _, primePVC := range primePVCs.Items {
+		namespace := primePVC.Annotations[blobImportPVCNamespaceAnnotation]
+		name := primePVC.Annotations[blobImportPVCNameAnnotation]
+		pvc, err := d.kubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			klog.V(2).Infof("deleting prime PVC %s/%s, PVC %s/%s was deleted", primePVC.Namespace, primePVC.Name, namespace, name)
+		case err != nil:
+			klog.Errorf("error when getting PVC %s/%s of prime PVC %s/%s: %v", namespace, name, primePVC.Namespace, primePVC.Name, err)
+			continue
+		case getBlobImportPrimePersistentVolumeClaimName(pvc) != primePVC.Name:
+			klog.V(2).Infof("deleting prime PVC %s/%s, PVC %s/%s was recreated", primePVC.Namespace, primePVC.Name, namespace, name)
+		case pvc.Spec.VolumeName != "" && pvc.Spec.VolumeName == primePVC.Spec.VolumeName:
+			klog.V(2).Infof("deleting prime PVC %s/%s, PVC %s/%s is bound", primePVC.Namespace, primePVC.Name, namespace, name)
+		default:
+			continue
+		}
+
+		err = d.kubeClient.CoreV1().PersistentVolumeClaims(primePVC.Namespace).Delete(ctx, primePVC.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Errorf("error when deleting prime PVC %s/%s: %v", primePVC.Namespace, primePVC.Name, err)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	blobImportTestStorageClassName = "azurelustre-blob-import"
+	blobImportTestName             = "training-data"
+	blobImportTestPVCUID           = "pvc-uid"
+)
+
+// blobImportTestStorageClass creates a new AMLFS cluster for each PVC
+var blobImportTestStorageClass = &storagev1.StorageClass{
+	ObjectMeta:  metav1.ObjectMeta{Name: blobImportTestStorageClassName},
+	Provisioner: fakeDriverName,
+	Parameters:  map[string]string{"location": "eastus"},
+}
+
+// blobImportTestPVC is populated from the LustreBlobImport blobImportTestName
+var blobImportTestPVC = &corev1.PersistentVolumeClaim{
+	ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default", UID: blobImportTestPVCUID},
+	Spec: corev1.PersistentVolumeClaimSpec{
+		AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+		StorageClassName: ptr.To(blobImportTestStorageClassName),
+		DataSourceRef: &corev1.TypedObjectReference{
+			APIGroup: ptr.To(blobImportAPIGroup),
+			Kind:     blobImportKind,
+			Name:     blobImportTestName,
+		},
+	},
+}
+
+func newTestBlobImport(spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": blobImportAPIGroup + "/v1alpha1",
+			"kind":       blobImportKind,
+			"metadata": map[string]interface{}{
+				"name":      blobImportTestName,
+				"namespace": "default",
+			},
+			"spec": spec,
+		},
+	}
+}
+
+// bindBlobImportTestPrimePVC binds the prime PVC to a PV of the cluster, as
+// csi-provisioner does once the cluster is created
+func bindBlobImportTestPrimePVC(t *testing.T, d *Driver, amlFilesystemName string) {
+	t.Helper()
+	ctx := context.Background()
+	primePVC, err := d.kubeClient.CoreV1().PersistentVolumeClaims(DefaultOperationNamespace).Get(ctx,
+		blobImportPrimePVCPrefix+blobImportTestPVCUID, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	volumeID, err := createVolumeIDFromParams(amlFilesystemName, map[string]string{
+		VolumeContextMGSIPAddress:               "127.0.0.2",
+		VolumeContextResourceGroupName:          "fake-resource-group",
+		VolumeContextInternalDynamicallyCreated: "t",
+	})
+	require.NoError(t, err)
+	_, err = d.kubeClient.CoreV1().PersistentVolumes().Create(ctx, &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-prime"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: fakeDriverName, VolumeHandle: volumeID},
+			},
+			ClaimRef: &corev1.ObjectReference{Namespace: primePVC.Namespace, Name: primePVC.Name, UID: "prime-uid"},
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	primePVC.Spec.VolumeName = "pvc-prime"
+	primePVC.Status.Phase = corev1.ClaimBound
+	_, err = d.kubeClient.CoreV1().PersistentVolumeClaims(DefaultOperationNamespace).Update(ctx, primePVC, metav1.UpdateOptions{})
+	require.NoError(t, err)
+}
+
+func TestPopulateBlobImports(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeKubeClient(blobImportTestStorageClass, blobImportTestPVC), withFakeEventRecorder(recorder))
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTestBlobImport(map[string]interface{}{
+		"importPrefixes":         []interface{}{"/datasets/a", "/datasets/b"},
+		"conflictResolutionMode": "skip",
+		"maximumErrors":          int64(5),
+	}))
+	ctx := context.Background()
+	fakeDynamicProvisioner := d.dynamicProvisioner.(*FakeDynamicProvisioner)
+
+	// The cluster is provisioned with a prime PVC
+	d.populateBlobImports(ctx)
+	primePVC, err := d.kubeClient.CoreV1().PersistentVolumeClaims(DefaultOperationNamespace).Get(ctx,
+		blobImportPrimePVCPrefix+blobImportTestPVCUID, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, blobImportOperation, primePVC.Labels[createOperationLabel])
+	assert.Equal(t, map[string]string{
+		blobImportPVCNamespaceAnnotation: "default",
+		blobImportPVCNameAnnotation:      "data",
+	}, primePVC.Annotations)
+	assert.Equal(t, ptr.To(blobImportTestStorageClassName), primePVC.Spec.StorageClassName)
+	assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, primePVC.Spec.AccessModes)
+	assert.Equal(t, []string{
+		"Normal AmlfsBlobImportProvisioning provisioning AMLFS cluster with PVC kube-system/lustre-blob-import-pvc-uid to import LustreBlobImport training-data",
+	}, receiveEvents(recorder))
+
+	// Nothing is imported until the cluster is created
+	d.populateBlobImports(ctx)
+	assert.Empty(t, fakeDynamicProvisioner.fakeCallCount)
+
+	bindBlobImportTestPrimePVC(t, d, "pvc-prime")
+	d.populateBlobImports(ctx)
+	assert.Equal(t, &ImportJobProperties{
+		ResourceGroupName:      "fake-resource-group",
+		AmlFilesystemName:      "pvc-prime",
+		ImportJobName:          blobImportJobName,
+		Location:               "eastus",
+		ImportPrefixes:         []string{"/datasets/a", "/datasets/b"},
+		ConflictResolutionMode: armstoragecache.ConflictResolutionModeSkip,
+		MaximumErrors:          5,
+	}, fakeDynamicProvisioner.ImportJobs["pvc-prime/"+blobImportJobName])
+	assert.Equal(t, []string{
+		"Normal AmlfsBlobImportStarted importing prefixes /datasets/a,/datasets/b of LustreBlobImport training-data into AMLFS cluster pvc-prime with conflict resolution mode Skip and at most 5 errors",
+	}, receiveEvents(recorder))
+
+	// The PV is bound to the PVC once the import is completed
+	d.populateBlobImports(ctx)
+	pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pvc-prime", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, &corev1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  "default",
+		Name:       "data",
+		UID:        blobImportTestPVCUID,
+	}, pv.Spec.ClaimRef)
+	assert.Equal(t, []string{
+		"Normal AmlfsBlobImportSucceeded imported 10 of 10 blobs of LustreBlobImport training-data into AMLFS cluster pvc-prime with 0 conflicts",
+	}, receiveEvents(recorder))
+	assert.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["CreateImportJob"])
+
+	// The prime PVC is deleted once the PVC is bound
+	pvc, err := d.kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "data", metav1.GetOptions{})
+	require.NoError(t, err)
+	pvc.Spec.VolumeName = "pvc-prime"
+	_, err = d.kubeClient.CoreV1().PersistentVolumeClaims("default").Update(ctx, pvc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	d.populateBlobImports(ctx)
+	_, err = d.kubeClient.CoreV1().PersistentVolumeClaims(DefaultOperationNamespace).Get(ctx,
+		blobImportPrimePVCPrefix+blobImportTestPVCUID, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Empty(t, receiveEvents(recorder))
+}
+
+func TestPopulateBlobImports_ImportFailed(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeKubeClient(blobImportTestStorageClass, blobImportTestPVC), withFakeEventRecorder(recorder))
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTestBlobImport(nil))
+	ctx := context.Background()
+
+	fakeDynamicProvisioner := d.dynamicProvisioner.(*FakeDynamicProvisioner)
+
+	d.populateBlobImports(ctx)
+	bindBlobImportTestPrimePVC(t, d, importFailureName)
+	d.populateBlobImports(ctx)
+	d.populateBlobImports(ctx)
+	d.populateBlobImports(ctx)
+	assert.Equal(t, []string{
+		"Normal AmlfsBlobImportProvisioning provisioning AMLFS cluster with PVC kube-system/lustre-blob-import-pvc-uid to import LustreBlobImport training-data",
+		"Normal AmlfsBlobImportStarted importing prefixes / of LustreBlobImport training-data into AMLFS cluster testImportShouldFail with conflict resolution mode Fail and at most 0 errors",
+		"Warning AmlfsBlobImportFailed import of LustreBlobImport training-data into AMLFS cluster testImportShouldFail ended in state Failed with 0 errors: container not found, delete the PVC to delete the cluster",
+	}, receiveEvents(recorder))
+
+	// The failed PVC is not populated anymore
+	pvc, err := d.kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "data", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, pvc.Annotations[blobImportFailedAnnotation], "ended in state Failed")
+	assert.Equal(t, 2, fakeDynamicProvisioner.fakeCallCount["GetImportJobStatus"])
+
+	// The PV stays bound to the prime PVC, which is deleted with the PVC
+	pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pvc-prime", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, blobImportPrimePVCPrefix+blobImportTestPVCUID, pv.Spec.ClaimRef.Name)
+	require.NoError(t, d.kubeClient.CoreV1().PersistentVolumeClaims("default").Delete(ctx, "data", metav1.DeleteOptions{}))
+	d.populateBlobImports(ctx)
+	_, err = d.kubeClient.CoreV1().PersistentVolumeClaims(DefaultOperationNamespace).Get(ctx,
+		blobImportPrimePVCPrefix+blobImportTestPVCUID, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestPopulateBlobImports_ImportPartial(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeKubeClient(blobImportTestStorageClass, blobImportTestPVC), withFakeEventRecorder(recorder))
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTestBlobImport(map[string]interface{}{
+		"maximumErrors": int64(5),
+	}))
+	ctx := context.Background()
+
+	d.populateBlobImports(ctx)
+	bindBlobImportTestPrimePVC(t, d, importPartialName)
+	d.populateBlobImports(ctx)
+	receiveEvents(recorder)
+
+	// The PV is bound to the PVC, as the errors are within maximumErrors
+	d.populateBlobImports(ctx)
+	assert.Equal(t, []string{
+		"Warning AmlfsBlobImportPartial imported 8 of 10 blobs of LustreBlobImport training-data into AMLFS cluster testImportPartial with 0 conflicts and 2 errors, at most 5 errors are allowed",
+	}, receiveEvents(recorder))
+	pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pvc-prime", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "data", pv.Spec.ClaimRef.Name)
+}
+
+func TestPopulateBlobImports_InProgress(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeKubeClient(blobImportTestStorageClass, blobImportTestPVC), withFakeEventRecorder(recorder))
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTestBlobImport(nil))
+	ctx := context.Background()
+
+	d.populateBlobImports(ctx)
+	bindBlobImportTestPrimePVC(t, d, importInProgressName)
+	d.populateBlobImports(ctx)
+	receiveEvents(recorder)
+	d.populateBlobImports(ctx)
+	assert.Equal(t, []string{
+		"Normal AmlfsBlobImportInProgress import of LustreBlobImport training-data into AMLFS cluster testImportInProgress is in progress, 4 of 10 blobs imported",
+	}, receiveEvents(recorder))
+	pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pvc-prime", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, blobImportPrimePVCPrefix+blobImportTestPVCUID, pv.Spec.ClaimRef.Name)
+}
+
+func TestPopulateBlobImports_NotPopulated(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		spec           map[string]interface{}
+		storageClass   *storagev1.StorageClass
+		expectedEvents []string
+	}{
+		{
+			desc: "StorageClass of another provisioner",
+			storageClass: &storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "other"},
+				Provisioner: "other.csi.azure.com",
+			},
+			expectedEvents: []string{},
+		},
+		{
+			desc: "PVC waiting for a pod",
+			storageClass: &storagev1.StorageClass{
+				ObjectMeta:        metav1.ObjectMeta{Name: "other"},
+				Provisioner:       fakeDriverName,
+				VolumeBindingMode: ptr.To(storagev1.VolumeBindingWaitForFirstConsumer),
+			},
+			expectedEvents: []string{},
+		},
+		{
+			desc: "invalid conflict resolution mode",
+			spec: map[string]interface{}{"conflictResolutionMode": "Merge"},
+			expectedEvents: []string{
+				"Warning AmlfsBlobImportFailed cannot import LustreBlobImport training-data: invalid spec of LustreBlobImport default/training-data: conflictResolutionMode must be one of: [Fail OverwriteAlways OverwriteIfDirty Skip], was: 'Merge'",
+			},
+		},
+		{
+			desc: "invalid import prefix",
+			spec: map[string]interface{}{"importPrefixes": []interface{}{"datasets"}},
+			expectedEvents: []string{
+				"Warning AmlfsBlobImportFailed cannot import LustreBlobImport training-data: invalid spec of LustreBlobImport default/training-data: importPrefixes must be paths starting with '/', was: 'datasets'",
+			},
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			objects := []runtime.Object{blobImportTestStorageClass, blobImportTestPVC}
+			if tC.storageClass != nil {
+				pvc := blobImportTestPVC.DeepCopy()
+				pvc.Spec.StorageClassName = ptr.To(tC.storageClass.Name)
+				objects = []runtime.Object{tC.storageClass, pvc}
+			}
+			recorder := record.NewFakeRecorder(20)
+			d := NewFakeDriver(withFakeKubeClient(objects...), withFakeEventRecorder(recorder))
+			d.dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTestBlobImport(tC.spec))
+			ctx := context.Background()
+
+			// A failure is only recorded once
+			d.populateBlobImports(ctx)
+			d.populateBlobImports(ctx)
+			primePVCs, err := d.kubeClient.CoreV1().PersistentVolumeClaims(DefaultOperationNamespace).List(ctx, metav1.ListOptions{})
+			require.NoError(t, err)
+			assert.Empty(t, primePVCs.Items)
+			assert.Equal(t, tC.expectedEvents, receiveEvents(recorder))
+		})
+	}
+}
+
+func TestPopulateBlobImports_BlobImportNotFound(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeKubeClient(blobImportTestStorageClass, blobImportTestPVC), withFakeEventRecorder(recorder))
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+
+	d.populateBlobImports(context.Background())
+	assert.Equal(t, []string{
+		"Warning AmlfsBlobImportFailed cannot import LustreBlobImport training-data: LustreBlobImport default/training-data not found",
+	}, receiveEvents(recorder))
+}
+
+func TestValidateBlobImportSpec(t *testing.T) {
+	spec := &blobImportSpec{}
+	require.NoError(t, validateBlobImportSpec(spec))
+	assert.Equal(t, "Fail", spec.ConflictResolutionMode)
+
+	spec = &blobImportSpec{ImportPrefixes: []string{"/a"}, ConflictResolutionMode: "overwriteifdirty", MaximumErrors: 1}
+	require.NoError(t, validateBlobImportSpec(spec))
+	assert.Equal(t, "OverwriteIfDirty", spec.ConflictResolutionMode)
+
+	require.ErrorContains(t, validateBlobImportSpec(&blobImportSpec{MaximumErrors: -1}),
+		"maximumErrors must not be negative, was: -1")
+}
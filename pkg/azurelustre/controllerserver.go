@@ -20,14 +20,19 @@ import (
 	"context"
 	"fmt"
 	"maps"
+	"math"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/azurelustre-csi-driver/pkg/util"
 	"sigs.k8s.io/cloud-provider-azure/pkg/metrics"
@@ -35,6 +40,8 @@ import (
 
 const (
 	VolumeContextMGSIPAddress               = "mgs-ip-address"
+	VolumeContextAmlfsName                  = "amlfs-name"
+	VolumeContextSubscriptionID             = "subscription-id"
 	VolumeContextFSName                     = "fs-name"
 	VolumeContextSubDir                     = "sub-dir"
 	VolumeContextLocation                   = "location"
@@ -49,14 +56,39 @@ const (
 	VolumeContextZonesSynonym               = "zones"
 	VolumeContextTags                       = "tags"
 	VolumeContextIdentities                 = "identities"
+	VolumeContextHsmContainer               = "hsm-container"
+	VolumeContextHsmLoggingContainer        = "hsm-logging-container"
+	VolumeContextHsmImportPrefixes          = "hsm-import-prefixes"
+	VolumeContextRootSquashMode             = "root-squash-mode"
+	VolumeContextRootSquashNoSquashNidLists = "root-squash-no-squash-nid-lists"
+	VolumeContextRootSquashUID              = "root-squash-uid"
+	VolumeContextRootSquashGID              = "root-squash-gid"
+	VolumeContextEncryptionKeyURL           = "encryption-key-url"
+	VolumeContextEncryptionKeyVaultID       = "encryption-key-vault-id"
+	VolumeContextArchiveOnDelete            = "archive-on-delete"
+	VolumeContextArchiveOnDeletePath        = "archive-on-delete-path"
+	VolumeContextProvisionSubDir            = "provision-sub-dir"
+	VolumeContextSubDirOnDelete             = "sub-dir-on-delete"
+	VolumeContextSubDirQuota                = "sub-dir-quota"
+	VolumeContextSubDirProjectID            = "sub-dir-project-id"
 	VolumeContextInternalDynamicallyCreated = "created-by-dynamic-provisioning"
+	VolumeContextInternalSubscriptionID     = "amlfs-subscription-id"
 	defaultSizeInBytes                      = 4 * util.TiB
 	defaultLaaSOBlockSizeInTib              = 4
+	defaultArchiveOnDeletePath              = "/"
+	subDirOnDeleteDelete                    = "delete"
+	subDirOnDeleteRetain                    = "retain"
 	pvcNamespaceTag                         = "kubernetes.io-created-for-pvc-namespace"
 	pvcNameTag                              = "kubernetes.io-created-for-pvc-name"
 	pvNameTag                               = "kubernetes.io-created-for-pv-name"
 	createdByTag                            = "k8s-azure-created-by"
+	subDirTag                               = "k8s-azure-lustre-sub-dir"
+	archiveOnDeletePathTag                  = "k8s-azure-lustre-archive-on-delete-path"
 	azureLustreDriverTag                    = "kubernetes-azurelustre-csi-driver"
+	topologyZoneKey                         = "topology.kubernetes.io/zone"
+	nonZonalTopologyZone                    = "0"
+	staticVolumeConditionMessage            = "volume was not created by dynamic provisioning, AMLFS cluster health is not monitored"
+	unknownSubscriptionConditionFmt         = "AMLFS cluster %s is in subscription %s, its health is not monitored until the controller uses secrets for that subscription"
 )
 
 var (
@@ -72,17 +104,69 @@ type SubnetProperties struct {
 }
 
 type AmlFilesystemProperties struct {
-	ResourceGroupName    string
-	AmlFilesystemName    string
-	Location             string
-	Tags                 map[string]string
-	Identities           []string // Can only be "UserAssigned" identity
-	SubnetInfo           SubnetProperties
+	ResourceGroupName string
+	AmlFilesystemName string
+	Location          string
+	Tags              map[string]string
+	Identities        []string // Can only be "UserAssigned" identity
+	SubnetInfo        SubnetProperties
+	// SubnetCandidates are the subnets to create the cluster in, in order of
+	// preference, when subnet-name lists more than one subnet
+	SubnetCandidates []string
+	// SubnetNamePrefix selects the subnets of the vnet whose name starts with
+	// it as candidates, when subnet-name ends with "*"
+	SubnetNamePrefix     string
 	MaintenanceDayOfWeek armstoragecache.MaintenanceDayOfWeekType
 	TimeOfDayUTC         string
 	StorageCapacityTiB   float32
 	SKUName              string
 	Zone                 string
+	HsmContainer         string
+	HsmLoggingContainer  string
+	HsmImportPrefixes    []string
+	RootSquashSettings   RootSquashProperties
+	EncryptionKeyURL     string
+	EncryptionKeyVaultID string
+	ArchiveOnDelete      bool
+	ArchiveOnDeletePath  string
+}
+
+type RootSquashProperties struct {
+	Mode             armstoragecache.AmlFilesystemSquashMode
+	NoSquashNidLists string
+	SquashUID        *int64
+	SquashGID        *int64
+}
+
+// parseSubnetName accepts a subnet name, a comma-separated list of candidate
+// subnet names in order of preference, or a subnet name prefix ending with "*"
+func parseSubnetName(amlFilesystemProperties *AmlFilesystemProperties, subnetName string) error {
+	invalidSubnetNameErr := status.Errorf(codes.InvalidArgument,
+		"CreateVolume Parameter %s must be a subnet name, a comma-separated list of subnet names, or a subnet name prefix ending with '*', was: '%s'",
+		VolumeContextSubnetName, subnetName)
+
+	if subnetNamePrefix, isPrefix := strings.CutSuffix(subnetName, "*"); isPrefix {
+		if subnetNamePrefix == "" || strings.ContainsAny(subnetNamePrefix, ",*") {
+			return invalidSubnetNameErr
+		}
+		amlFilesystemProperties.SubnetNamePrefix = subnetNamePrefix
+		return nil
+	}
+	if !strings.Contains(subnetName, ",") {
+		amlFilesystemProperties.SubnetInfo.SubnetName = subnetName
+		return nil
+	}
+
+	subnetNames := strings.Split(subnetName, ",")
+	for i, name := range subnetNames {
+		subnetNames[i] = strings.TrimSpace(name)
+		if subnetNames[i] == "" || strings.Contains(subnetNames[i], "*") {
+			return invalidSubnetNameErr
+		}
+	}
+	amlFilesystemProperties.SubnetInfo.SubnetName = subnetNames[0]
+	amlFilesystemProperties.SubnetCandidates = subnetNames
+	return nil
 }
 
 func parseAmlFilesystemProperties(properties map[string]string) (*AmlFilesystemProperties, error) {
@@ -99,6 +183,12 @@ func parseAmlFilesystemProperties(properties map[string]string) (*AmlFilesystemP
 		case VolumeContextResourceGroupName:
 			amlFilesystemProperties.ResourceGroupName = propertyValue
 		case VolumeContextMGSIPAddress:
+			if _, err := parseMgsNids(propertyValue); propertyValue != "" && err != nil {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"CreateVolume Parameter %s %v", VolumeContextMGSIPAddress, err)
+			}
+			shouldCreateAmlfsCluster = false
+		case VolumeContextAmlfsName:
 			shouldCreateAmlfsCluster = false
 		case VolumeContextLocation:
 			amlFilesystemProperties.Location = propertyValue
@@ -107,7 +197,9 @@ func parseAmlFilesystemProperties(properties map[string]string) (*AmlFilesystemP
 		case VolumeContextVnetResourceGroup:
 			amlFilesystemProperties.SubnetInfo.VnetResourceGroup = propertyValue
 		case VolumeContextSubnetName:
-			amlFilesystemProperties.SubnetInfo.SubnetName = propertyValue
+			if err := parseSubnetName(&amlFilesystemProperties, propertyValue); err != nil {
+				return nil, err
+			}
 		case VolumeContextMaintenanceDayOfWeek:
 			possibleDayValues := armstoragecache.PossibleMaintenanceDayOfWeekTypeValues()
 			for _, dayOfWeekValue := range possibleDayValues {
@@ -145,12 +237,68 @@ func parseAmlFilesystemProperties(properties map[string]string) (*AmlFilesystemP
 			}
 			if len(tags) > 0 {
 				for tag, value := range tags {
-					if tag == pvcNameTag || tag == pvcNamespaceTag || tag == pvNameTag || tag == createdByTag {
+					if tag == pvcNameTag || tag == pvcNamespaceTag || tag == pvNameTag || tag == createdByTag || tag == subDirTag || tag == archiveOnDeletePathTag {
 						return nil, status.Errorf(codes.InvalidArgument, "CreateVolume Parameter %s must not contain %s as a tag", VolumeContextTags, tag)
 					}
 					amlFilesystemProperties.Tags[tag] = value
 				}
 			}
+		case VolumeContextRootSquashMode:
+			possibleSquashModes := armstoragecache.PossibleAmlFilesystemSquashModeValues()
+			for _, squashMode := range possibleSquashModes {
+				if string(squashMode) == propertyValue {
+					amlFilesystemProperties.RootSquashSettings.Mode = squashMode
+					break
+				}
+			}
+			if len(amlFilesystemProperties.RootSquashSettings.Mode) == 0 {
+				return nil, status.Errorf(
+					codes.InvalidArgument,
+					"CreateVolume Parameter %s must be one of: %v",
+					VolumeContextRootSquashMode,
+					possibleSquashModes,
+				)
+			}
+		case VolumeContextRootSquashNoSquashNidLists:
+			amlFilesystemProperties.RootSquashSettings.NoSquashNidLists = propertyValue
+		case VolumeContextRootSquashUID:
+			squashUID, err := parseSquashID(VolumeContextRootSquashUID, propertyValue)
+			if err != nil {
+				return nil, err
+			}
+			amlFilesystemProperties.RootSquashSettings.SquashUID = &squashUID
+		case VolumeContextRootSquashGID:
+			squashGID, err := parseSquashID(VolumeContextRootSquashGID, propertyValue)
+			if err != nil {
+				return nil, err
+			}
+			amlFilesystemProperties.RootSquashSettings.SquashGID = &squashGID
+		case VolumeContextEncryptionKeyURL:
+			amlFilesystemProperties.EncryptionKeyURL = propertyValue
+		case VolumeContextEncryptionKeyVaultID:
+			amlFilesystemProperties.EncryptionKeyVaultID = propertyValue
+		case VolumeContextArchiveOnDelete:
+			archiveOnDelete, err := strconv.ParseBool(propertyValue)
+			if err != nil {
+				return nil, status.Errorf(
+					codes.InvalidArgument,
+					"CreateVolume Parameter %s must be a boolean value, was: '%s'",
+					VolumeContextArchiveOnDelete,
+					propertyValue,
+				)
+			}
+			amlFilesystemProperties.ArchiveOnDelete = archiveOnDelete
+		case VolumeContextArchiveOnDeletePath:
+			if !strings.HasPrefix(propertyValue, "/") || strings.Contains(propertyValue, separator) {
+				return nil, status.Errorf(
+					codes.InvalidArgument,
+					"CreateVolume Parameter %s must be a path starting with '/' and must not contain '%s', was: '%s'",
+					VolumeContextArchiveOnDeletePath,
+					separator,
+					propertyValue,
+				)
+			}
+			amlFilesystemProperties.ArchiveOnDeletePath = propertyValue
 		case pvcNameKey:
 			amlFilesystemProperties.Tags[pvcNameTag] = propertyValue
 		case pvcNamespaceKey:
@@ -159,8 +307,37 @@ func parseAmlFilesystemProperties(properties map[string]string) (*AmlFilesystemP
 			amlFilesystemProperties.Tags[pvNameTag] = propertyValue
 		case VolumeContextIdentities:
 			amlFilesystemProperties.Identities = strings.Split(propertyValue, ",")
-			// These will be used by the node methods
-		case VolumeContextFSName, VolumeContextSubDir:
+		case VolumeContextHsmContainer:
+			amlFilesystemProperties.HsmContainer = propertyValue
+		case VolumeContextHsmLoggingContainer:
+			amlFilesystemProperties.HsmLoggingContainer = propertyValue
+		case VolumeContextHsmImportPrefixes:
+			for _, importPrefix := range strings.Split(propertyValue, ",") {
+				importPrefix = strings.TrimSpace(importPrefix)
+				if !strings.HasPrefix(importPrefix, "/") {
+					return nil, status.Errorf(
+						codes.InvalidArgument,
+						"CreateVolume Parameter %s must be a comma-separated list of paths starting with '/', was: '%s'",
+						VolumeContextHsmImportPrefixes,
+						propertyValue,
+					)
+				}
+				amlFilesystemProperties.HsmImportPrefixes = append(amlFilesystemProperties.HsmImportPrefixes, importPrefix)
+			}
+		case VolumeContextSubDir:
+			// Used by the node methods, recorded as a tag so that
+			// ListVolumes can rebuild the volume ID
+			amlFilesystemProperties.Tags[subDirTag] = strings.Trim(propertyValue, "/")
+		case VolumeContextFSName:
+			if err := validateFsName(getFsName(propertyValue)); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"CreateVolume Parameter %s %v", VolumeContextFSName, err)
+			}
+			// These are validated by parseSubDirProvisioningProperties
+		case VolumeContextProvisionSubDir, VolumeContextSubDirOnDelete, VolumeContextSubDirQuota:
+			continue
+			// This is validated by parseExistingAmlFilesystemProperties
+		case VolumeContextSubscriptionID:
 			continue
 		default:
 			errorParameters = append(
@@ -196,11 +373,325 @@ func parseAmlFilesystemProperties(properties map[string]string) (*AmlFilesystemP
 				"CreateVolume %s must be provided for dynamically provisioned AMLFS",
 				VolumeContextMaintenanceTimeOfDayUtc)
 		}
+
+		if err := validateHsmProperties(&amlFilesystemProperties); err != nil {
+			return nil, err
+		}
+
+		if err := validateArchiveOnDeleteProperties(&amlFilesystemProperties); err != nil {
+			return nil, err
+		}
+
+		if err := validateRootSquashProperties(&amlFilesystemProperties.RootSquashSettings); err != nil {
+			return nil, err
+		}
+
+		if err := validateEncryptionProperties(&amlFilesystemProperties); err != nil {
+			return nil, err
+		}
 	}
 
 	return &amlFilesystemProperties, nil
 }
 
+type existingAmlFilesystemProperties struct {
+	amlFilesystemName string
+	resourceGroupName string
+	subscriptionID    string
+}
+
+// parseExistingAmlFilesystemProperties returns nil if the volume does not
+// reference an existing cluster by its Azure resource name
+func parseExistingAmlFilesystemProperties(properties map[string]string) (*existingAmlFilesystemProperties, error) {
+	var existingProperties existingAmlFilesystemProperties
+
+	for propertyName, propertyValue := range properties {
+		switch strings.ToLower(propertyName) {
+		case VolumeContextAmlfsName:
+			existingProperties.amlFilesystemName = propertyValue
+		case VolumeContextResourceGroupName:
+			existingProperties.resourceGroupName = propertyValue
+		case VolumeContextSubscriptionID:
+			existingProperties.subscriptionID = propertyValue
+		}
+	}
+
+	if len(existingProperties.amlFilesystemName) == 0 {
+		if len(existingProperties.subscriptionID) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Parameter %s can only be used with %s",
+				VolumeContextSubscriptionID, VolumeContextAmlfsName)
+		}
+		return nil, nil //nolint:nilnil // No existing cluster is referenced by name
+	}
+
+	if !amlFilesystemNameRegex.MatchString(existingProperties.amlFilesystemName) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Parameter %s must be a valid AMLFS cluster name, was: '%s'",
+			VolumeContextAmlfsName, existingProperties.amlFilesystemName)
+	}
+
+	return &existingProperties, nil
+}
+
+// getExistingAmlFilesystem gets the cluster referenced by name, which must
+// have an MGS address to be mounted
+func (d *Driver) getExistingAmlFilesystem(ctx context.Context, dynamicProvisioner DynamicProvisionerInterface, existingProperties *existingAmlFilesystemProperties) (*AmlFilesystemInfo, error) {
+	resourceGroupName := existingProperties.resourceGroupName
+	if len(resourceGroupName) == 0 {
+		resourceGroupName = d.resourceGroup
+	}
+
+	amlFilesystem, err := dynamicProvisioner.GetAmlFilesystemInSubscription(ctx, existingProperties.subscriptionID, resourceGroupName, existingProperties.amlFilesystemName)
+	if err != nil {
+		return nil, status.Errorf(status.Code(err), "error when getting AMLFS cluster %s in resource group %s: %v",
+			existingProperties.amlFilesystemName, resourceGroupName, err)
+	}
+	if len(amlFilesystem.MgsAddress) == 0 {
+		return nil, status.Errorf(codes.Unavailable, "AMLFS cluster %s in resource group %s has no MGS address, provisioning state: %s",
+			existingProperties.amlFilesystemName, resourceGroupName, amlFilesystem.ProvisioningState)
+	}
+
+	return amlFilesystem, nil
+}
+
+type subDirProvisioningProperties struct {
+	subDir   string
+	onDelete string
+	quota    bool
+}
+
+// parseSubDirProvisioningProperties returns nil if the StorageClass does not
+// provision volumes as sub-dirs of an existing cluster
+func parseSubDirProvisioningProperties(properties map[string]string) (*subDirProvisioningProperties, error) {
+	var subDirProperties subDirProvisioningProperties
+	provisionSubDir := false
+	hasExistingCluster := false
+	hasQuota := false
+	subDirProperties.quota = true
+
+	for propertyName, propertyValue := range properties {
+		switch strings.ToLower(propertyName) {
+		case VolumeContextProvisionSubDir:
+			var err error
+			provisionSubDir, err = strconv.ParseBool(propertyValue)
+			if err != nil {
+				return nil, status.Errorf(
+					codes.InvalidArgument,
+					"CreateVolume Parameter %s must be a boolean value, was: '%s'",
+					VolumeContextProvisionSubDir,
+					propertyValue,
+				)
+			}
+		case VolumeContextSubDirOnDelete:
+			onDelete := strings.ToLower(propertyValue)
+			if onDelete != subDirOnDeleteDelete && onDelete != subDirOnDeleteRetain {
+				return nil, status.Errorf(
+					codes.InvalidArgument,
+					"CreateVolume Parameter %s must be one of: [%s %s], was: '%s'",
+					VolumeContextSubDirOnDelete,
+					subDirOnDeleteDelete,
+					subDirOnDeleteRetain,
+					propertyValue,
+				)
+			}
+			subDirProperties.onDelete = onDelete
+		case VolumeContextSubDirQuota:
+			var err error
+			subDirProperties.quota, err = strconv.ParseBool(propertyValue)
+			if err != nil {
+				return nil, status.Errorf(
+					codes.InvalidArgument,
+					"CreateVolume Parameter %s must be a boolean value, was: '%s'",
+					VolumeContextSubDirQuota,
+					propertyValue,
+				)
+			}
+			hasQuota = true
+		case VolumeContextSubDir:
+			subDirProperties.subDir = propertyValue
+		case VolumeContextMGSIPAddress, VolumeContextAmlfsName:
+			hasExistingCluster = hasExistingCluster || len(propertyValue) > 0
+		}
+	}
+
+	if !provisionSubDir {
+		if len(subDirProperties.onDelete) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"CreateVolume Parameter %s can only be used when %s is true",
+				VolumeContextSubDirOnDelete, VolumeContextProvisionSubDir)
+		}
+		if hasQuota {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"CreateVolume Parameter %s can only be used when %s is true",
+				VolumeContextSubDirQuota, VolumeContextProvisionSubDir)
+		}
+		return nil, nil //nolint:nilnil // No sub-dir is provisioned
+	}
+
+	if !hasExistingCluster {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s can only be used with %s or %s of an existing cluster",
+			VolumeContextProvisionSubDir, VolumeContextMGSIPAddress, VolumeContextAmlfsName)
+	}
+
+	if len(subDirProperties.onDelete) == 0 {
+		subDirProperties.onDelete = subDirOnDeleteDelete
+	}
+
+	return &subDirProperties, nil
+}
+
+// getProvisionedSubDir returns the sub-dir to create for a volume. Only the
+// PVC and PV metadata are known when the volume is created, so the sub-dir
+// defaults to the volume name and cannot use the pod metadata
+func getProvisionedSubDir(volName string, subDirProperties *subDirProvisioningProperties, parameters map[string]string) (string, error) {
+	subDir := subDirProperties.subDir
+	if len(subDir) == 0 {
+		subDir = volName
+	}
+
+	interpolatedSubDir := strings.Trim(interpolateSubDirVariables(parameters, &lustreVolume{subDir: subDir}), "/")
+	if strings.Contains(interpolatedSubDir, "${") {
+		return "", status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s can only use %s, %s and %s when %s is true, was: '%s'",
+			VolumeContextSubDir, pvcNameMetadata, pvcNamespaceMetadata, pvNameMetadata, VolumeContextProvisionSubDir, subDir)
+	}
+	if !ensureStrictSubpath(interpolatedSubDir) || strings.Contains(interpolatedSubDir, separator) {
+		return "", status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s must be a strict subpath and must not contain '%s', was: '%s'",
+			VolumeContextSubDir, separator, interpolatedSubDir)
+	}
+	// Every volume must get its own sub-dir, as DeleteVolume removes it and
+	// the quota of the volume sets the project ID of everything in it
+	if len(subDirProperties.subDir) > 0 &&
+		!strings.Contains(subDir, pvcNameMetadata) && !strings.Contains(subDir, pvNameMetadata) {
+		return "", status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s must contain %s or %s when %s is true, so that volumes do not share a sub-dir, was: '%s'",
+			VolumeContextSubDir, pvcNameMetadata, pvNameMetadata, VolumeContextProvisionSubDir, subDir)
+	}
+
+	return interpolatedSubDir, nil
+}
+
+func getMountFlags(capabilities []*csi.VolumeCapability) []string {
+	for _, capability := range capabilities {
+		if mountFlags := capability.GetMount().GetMountFlags(); len(mountFlags) > 0 {
+			return mountFlags
+		}
+	}
+	return nil
+}
+
+func validateHsmProperties(amlFilesystemProperties *AmlFilesystemProperties) error {
+	hasContainer := len(amlFilesystemProperties.HsmContainer) > 0
+	hasLoggingContainer := len(amlFilesystemProperties.HsmLoggingContainer) > 0
+
+	if hasContainer != hasLoggingContainer {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameters %s and %s must be provided together for blob integration",
+			VolumeContextHsmContainer, VolumeContextHsmLoggingContainer)
+	}
+
+	if hasContainer && amlFilesystemProperties.HsmContainer == amlFilesystemProperties.HsmLoggingContainer {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s must be a different container than %s",
+			VolumeContextHsmLoggingContainer, VolumeContextHsmContainer)
+	}
+
+	if !hasContainer && len(amlFilesystemProperties.HsmImportPrefixes) > 0 {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s can only be used with %s",
+			VolumeContextHsmImportPrefixes, VolumeContextHsmContainer)
+	}
+
+	return nil
+}
+
+func validateArchiveOnDeleteProperties(amlFilesystemProperties *AmlFilesystemProperties) error {
+	if !amlFilesystemProperties.ArchiveOnDelete {
+		if len(amlFilesystemProperties.ArchiveOnDeletePath) > 0 {
+			return status.Errorf(codes.InvalidArgument,
+				"CreateVolume Parameter %s can only be used when %s is true",
+				VolumeContextArchiveOnDeletePath, VolumeContextArchiveOnDelete)
+		}
+		return nil
+	}
+
+	if len(amlFilesystemProperties.HsmContainer) == 0 {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s can only be used with %s",
+			VolumeContextArchiveOnDelete, VolumeContextHsmContainer)
+	}
+
+	if len(amlFilesystemProperties.ArchiveOnDeletePath) == 0 {
+		amlFilesystemProperties.ArchiveOnDeletePath = defaultArchiveOnDeletePath
+	}
+	amlFilesystemProperties.Tags[archiveOnDeletePathTag] = amlFilesystemProperties.ArchiveOnDeletePath
+
+	return nil
+}
+
+func parseSquashID(parameterName, value string) (int64, error) {
+	squashID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || squashID < 0 || squashID > math.MaxUint32 {
+		return 0, status.Errorf(
+			codes.InvalidArgument,
+			"CreateVolume Parameter %s must be an integer between 0 and %d, was: '%s'",
+			parameterName,
+			uint32(math.MaxUint32),
+			value,
+		)
+	}
+	return squashID, nil
+}
+
+func validateRootSquashProperties(rootSquashProperties *RootSquashProperties) error {
+	if len(rootSquashProperties.Mode) == 0 {
+		if len(rootSquashProperties.NoSquashNidLists) > 0 || rootSquashProperties.SquashUID != nil || rootSquashProperties.SquashGID != nil {
+			return status.Errorf(codes.InvalidArgument,
+				"CreateVolume Parameters %s, %s and %s can only be used with %s",
+				VolumeContextRootSquashNoSquashNidLists, VolumeContextRootSquashUID, VolumeContextRootSquashGID, VolumeContextRootSquashMode)
+		}
+		return nil
+	}
+
+	if rootSquashProperties.Mode == armstoragecache.AmlFilesystemSquashModeNone {
+		return nil
+	}
+
+	if rootSquashProperties.SquashUID == nil || rootSquashProperties.SquashGID == nil {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameters %s and %s must be provided when %s is %s",
+			VolumeContextRootSquashUID, VolumeContextRootSquashGID, VolumeContextRootSquashMode, rootSquashProperties.Mode)
+	}
+
+	return nil
+}
+
+func validateEncryptionProperties(amlFilesystemProperties *AmlFilesystemProperties) error {
+	hasKeyURL := len(amlFilesystemProperties.EncryptionKeyURL) > 0
+	hasKeyVaultID := len(amlFilesystemProperties.EncryptionKeyVaultID) > 0
+
+	if !hasKeyURL && !hasKeyVaultID {
+		return nil
+	}
+
+	if hasKeyURL != hasKeyVaultID {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameters %s and %s must be provided together for customer-managed key encryption",
+			VolumeContextEncryptionKeyURL, VolumeContextEncryptionKeyVaultID)
+	}
+
+	if len(amlFilesystemProperties.Identities) == 0 {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s must be provided for customer-managed key encryption, the user-assigned identity is used to access the key vault",
+			VolumeContextIdentities)
+	}
+
+	return nil
+}
+
 func isValidVolumeName(volName string) bool {
 	validAmlFilesystemName := volName
 	if !amlFilesystemNameRegex.MatchString(validAmlFilesystemName) {
@@ -269,12 +760,13 @@ func (d *Driver) CreateVolume(
 			"CreateVolume Parameters must be provided")
 	}
 
-	shouldCreateAmlfsCluster := false
+	dynamicProvisioner, credential, err := d.getDynamicProvisioner(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(status.Code(err), "CreateVolume %s", status.Convert(err).Message())
+	}
 
 	mgsIPAddress := util.GetValueInMap(parameters, VolumeContextMGSIPAddress)
-	if mgsIPAddress == "" {
-		shouldCreateAmlfsCluster = true
-	}
+	fsName := getFsName(util.GetValueInMap(parameters, VolumeContextFSName))
 
 	// Check parameters to ensure validity of static and dynamic configs
 	amlFilesystemProperties, err := parseAmlFilesystemProperties(parameters)
@@ -282,6 +774,53 @@ func (d *Driver) CreateVolume(
 		return nil, err
 	}
 
+	existingProperties, err := parseExistingAmlFilesystemProperties(parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume %s", status.Convert(err).Message())
+	}
+	if existingProperties != nil && mgsIPAddress != "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameters %s and %s cannot be used together",
+			VolumeContextMGSIPAddress, VolumeContextAmlfsName)
+	}
+
+	shouldCreateAmlfsCluster := mgsIPAddress == "" && existingProperties == nil
+
+	subDirProperties, err := parseSubDirProvisioningProperties(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot *amlFilesystemSnapshot
+	if snapshotSource := req.GetVolumeContentSource().GetSnapshot(); snapshotSource != nil {
+		snapshot, err = d.getSnapshotToRestore(ctx, snapshotSource.GetSnapshotId(), shouldCreateAmlfsCluster, amlFilesystemProperties, parameters)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var existingAmlFilesystem *AmlFilesystemInfo
+	if existingProperties != nil {
+		existingAmlFilesystem, err = d.getExistingAmlFilesystem(ctx, dynamicProvisioner, existingProperties)
+		if err != nil {
+			klog.Errorf("failed to resolve AMLFS cluster %s: %v", existingProperties.amlFilesystemName, err)
+			return nil, status.Errorf(status.Code(err), "CreateVolume %s", status.Convert(err).Message())
+		}
+		mgsIPAddress = existingAmlFilesystem.MgsAddress
+		klog.V(2).Infof("resolved MGS address %s of AMLFS cluster %s", mgsIPAddress, existingProperties.amlFilesystemName)
+
+		util.SetKeyValueInMap(parameters, VolumeContextResourceGroupName, existingAmlFilesystem.ResourceGroupName)
+		util.SetKeyValueInMap(parameters, VolumeContextMGSIPAddress, mgsIPAddress)
+	}
+
+	var sourceVolume *lustreVolume
+	if volumeSource := req.GetVolumeContentSource().GetVolume(); volumeSource != nil {
+		sourceVolume, err = getCloneSourceVolume(volumeSource.GetVolumeId(), subDirProperties, mgsIPAddress, fsName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	capacityRange := req.GetCapacityRange()
 
 	capacityInBytes := capacityRange.GetRequiredBytes()
@@ -308,10 +847,10 @@ func (d *Driver) CreateVolume(
 			amlFilesystemProperties.ResourceGroupName = d.resourceGroup
 		}
 
-		amlFilesystemProperties.SubnetInfo = d.populateSubnetPropertiesFromCloudConfig(amlFilesystemProperties.SubnetInfo)
+		amlFilesystemProperties.SubnetInfo = d.populateSubnetProperties(amlFilesystemProperties.SubnetInfo, credential)
 
 		klog.V(2).Infof("finding capacity based on SKU %s for location %s", amlFilesystemProperties.SKUName, amlFilesystemProperties.Location)
-		lustreSkuValue, err := d.getSkuValuesForLocation(ctx, amlFilesystemProperties.SKUName, amlFilesystemProperties.Location)
+		lustreSkuValue, err := d.getSkuValuesForLocation(ctx, dynamicProvisioner, amlFilesystemProperties.SKUName, amlFilesystemProperties.Location)
 		if err != nil {
 			klog.Errorf("failed to get SKU values for %s in location %s, error: %v", amlFilesystemProperties.SKUName, amlFilesystemProperties.Location, err)
 			return nil, err
@@ -321,12 +860,27 @@ func (d *Driver) CreateVolume(
 		availableZones = lustreSkuValue.AvailableZones
 	}
 
-	capacityInBytes, err = d.roundToAmlfsBlockSize(capacityInBytes, blockSizeInBytes, maxCapacityInBytes)
-	if err != nil {
-		klog.Errorf("failed to round capacity: %v", err)
-		return nil, err
+	switch {
+	case subDirProperties != nil:
+		// The capacity of a provisioned sub-dir is only limited by its quota,
+		// so it is not rounded to the size of a cluster
+	case existingAmlFilesystem != nil:
+		clusterCapacityInBytes := int64(float64(existingAmlFilesystem.StorageCapacityTiB) * util.TiB)
+		if capacityRange.GetRequiredBytes() > clusterCapacityInBytes {
+			return nil, status.Errorf(codes.OutOfRange,
+				"CreateVolume required capacity %v is greater than the capacity %v of AMLFS cluster %s",
+				capacityRange.GetRequiredBytes(), clusterCapacityInBytes, existingAmlFilesystem.Name)
+		}
+		capacityInBytes = clusterCapacityInBytes
+		klog.V(2).Infof("capacity (in bytes) of AMLFS cluster %s: %#v", existingAmlFilesystem.Name, capacityInBytes)
+	default:
+		capacityInBytes, err = d.roundToAmlfsBlockSize(capacityInBytes, blockSizeInBytes, maxCapacityInBytes)
+		if err != nil {
+			klog.Errorf("failed to round capacity: %v", err)
+			return nil, err
+		}
+		klog.V(2).Infof("capacity (in bytes) after rounding to next cluster increment: %#v", capacityInBytes)
 	}
-	klog.V(2).Infof("capacity (in bytes) after rounding to next cluster increment: %#v", capacityInBytes)
 
 	storageCapacityTib := float32(capacityInBytes) / util.TiB
 	klog.V(2).Infof("storage capacity requested (in TiB): %#v", storageCapacityTib)
@@ -341,25 +895,8 @@ func (d *Driver) CreateVolume(
 	if shouldCreateAmlfsCluster {
 		amlFilesystemProperties.StorageCapacityTiB = storageCapacityTib
 
-		if len(availableZones) > 0 {
-			klog.V(2).Infof("available zones for SKU %s in location %s: %v", amlFilesystemProperties.SKUName, amlFilesystemProperties.Location, availableZones)
-			if len(amlFilesystemProperties.Zone) == 0 {
-				return nil, status.Errorf(codes.InvalidArgument,
-					"CreateVolume Parameter %s must be provided for dynamically provisioned AMLFS in location %s, available zones: %v",
-					VolumeContextZone, amlFilesystemProperties.Location, availableZones)
-			}
-			if !slices.Contains(availableZones, amlFilesystemProperties.Zone) {
-				return nil, status.Errorf(codes.InvalidArgument,
-					"CreateVolume Parameter %s %s must be one of: %v",
-					VolumeContextZone, amlFilesystemProperties.Zone, availableZones)
-			}
-		} else {
-			klog.Warningf("no zones available for SKU %s in location %s", amlFilesystemProperties.SKUName, amlFilesystemProperties.Location)
-			if len(amlFilesystemProperties.Zone) > 0 {
-				return nil, status.Errorf(codes.InvalidArgument,
-					"CreateVolume Parameter %s cannot be used in location %s, no zones available for SKU %s",
-					VolumeContextZone, amlFilesystemProperties.Location, amlFilesystemProperties.SKUName)
-			}
+		if err := selectZone(amlFilesystemProperties, availableZones, req.GetAccessibilityRequirements()); err != nil {
+			return nil, err
 		}
 
 		if !isValidVolumeName(volName) {
@@ -374,7 +911,7 @@ func (d *Driver) CreateVolume(
 			amlFilesystemProperties,
 		)
 
-		mgsIPAddress, err = d.dynamicProvisioner.CreateAmlFilesystem(ctx, amlFilesystemProperties)
+		mgsIPAddress, err = d.createAmlFilesystem(ctx, dynamicProvisioner, amlFilesystemProperties)
 		if err != nil {
 			errCode := status.Code(err)
 			if errCode == codes.Unknown {
@@ -385,9 +922,73 @@ func (d *Driver) CreateVolume(
 			return nil, status.Errorf(errCode, "CreateVolume error when creating AMLFS %s: %v", amlFilesystemProperties.AmlFilesystemName, err)
 		}
 
+		if snapshot != nil {
+			if err := d.restoreSnapshot(ctx, dynamicProvisioner, amlFilesystemProperties, snapshot); err != nil {
+				return nil, err
+			}
+		}
+
 		util.SetKeyValueInMap(parameters, VolumeContextResourceGroupName, amlFilesystemProperties.ResourceGroupName)
 		util.SetKeyValueInMap(parameters, VolumeContextMGSIPAddress, mgsIPAddress)
 		util.SetKeyValueInMap(parameters, VolumeContextFSName, DefaultLustreFsName)
+		if len(amlFilesystemProperties.SubnetCandidates) > 0 || amlFilesystemProperties.SubnetNamePrefix != "" {
+			util.SetKeyValueInMap(parameters, VolumeContextSubnetName, amlFilesystemProperties.SubnetInfo.SubnetName)
+		}
+		if amlFilesystemProperties.ArchiveOnDelete {
+			util.SetKeyValueInMap(parameters, VolumeContextArchiveOnDeletePath, amlFilesystemProperties.ArchiveOnDeletePath)
+		}
+		if credential != nil {
+			util.SetKeyValueInMap(parameters, VolumeContextInternalSubscriptionID, credential.subscriptionID)
+		}
+	}
+
+	if subDirProperties != nil {
+		subDir, err := getProvisionedSubDir(volName, subDirProperties, parameters)
+		if err != nil {
+			return nil, err
+		}
+
+		var quota *projectQuota
+		if subDirProperties.quota {
+			quota = newProjectQuota(volName, capacityInBytes)
+		}
+
+		if sourceVolume != nil && isNestedSubDir(subDir, sourceVolume.subDir) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"CreateVolume sub-dir %q cannot be cloned into sub-dir %q, one contains the other",
+				sourceVolume.subDir, subDir)
+		}
+
+		if !d.enableAzureLustreMockMount {
+			vol := &lustreVolume{
+				name:            volName,
+				id:              volName,
+				mgsIPAddress:    mgsIPAddress,
+				azureLustreName: fsName,
+			}
+			if sourceVolume != nil {
+				klog.V(2).Infof("cloning sub-dir %q of volume %s into sub-dir %q for volume %s on %s", sourceVolume.subDir, sourceVolume.id, subDir, volName, mgsIPAddress)
+				pvc := d.getPersistentVolumeClaim(ctx, amlFilesystemProperties)
+				if err := d.cloneSubDir(ctx, vol, volName, sourceVolume.subDir, subDir, getMountFlags(req.GetVolumeCapabilities()), quota, pvc); err != nil {
+					if status.Code(err) != codes.Aborted {
+						klog.Errorf("error when cloning sub-dir %q into sub-dir %q for volume %s: %v", sourceVolume.subDir, subDir, volName, err)
+					}
+					return nil, status.Errorf(status.Code(err), "CreateVolume error when cloning sub-dir %q into sub-dir %q: %v", sourceVolume.subDir, subDir, status.Convert(err).Message())
+				}
+			} else {
+				klog.V(2).Infof("creating sub-dir %q for volume %s on %s", subDir, volName, mgsIPAddress)
+				if err := d.createSubDir(vol, volName, subDir, getMountFlags(req.GetVolumeCapabilities()), quota); err != nil {
+					klog.Errorf("error when creating sub-dir %q for volume %s: %v", subDir, volName, err)
+					return nil, status.Errorf(status.Code(err), "CreateVolume error when creating sub-dir %q: %v", subDir, err)
+				}
+			}
+		}
+
+		util.SetKeyValueInMap(parameters, VolumeContextSubDir, subDir)
+		util.SetKeyValueInMap(parameters, VolumeContextSubDirOnDelete, subDirProperties.onDelete)
+		if quota != nil {
+			util.SetKeyValueInMap(parameters, VolumeContextSubDirProjectID, strconv.FormatUint(uint64(quota.projectID), 10))
+		}
 	}
 
 	util.SetKeyValueInMap(parameters, VolumeContextInternalDynamicallyCreated, createdByDynamicProvisioningStringValue)
@@ -403,22 +1004,90 @@ func (d *Driver) CreateVolume(
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			CapacityBytes: capacityInBytes,
-			VolumeContext: parameters,
+			VolumeId:           volumeID,
+			CapacityBytes:      capacityInBytes,
+			VolumeContext:      parameters,
+			ContentSource:      req.GetVolumeContentSource(),
+			AccessibleTopology: getAccessibleTopology(req.GetAccessibilityRequirements(), amlFilesystemProperties),
 		},
 	}, nil
 }
 
-func (d *Driver) getSkuValuesForLocation(ctx context.Context, skuName, location string) (*LustreSkuValue, error) {
-	skus, err := d.dynamicProvisioner.GetSkuValuesForLocation(ctx, location)
-	if err != nil {
-		return nil, err
+// selectZone selects the zone of the cluster from the accessibility
+// requirements if it is not set, and checks that the SKU is available in it
+func selectZone(amlFilesystemProperties *AmlFilesystemProperties, availableZones []string, requirements *csi.TopologyRequirement) error {
+	if len(availableZones) == 0 {
+		klog.Warningf("no zones available for SKU %s in location %s", amlFilesystemProperties.SKUName, amlFilesystemProperties.Location)
+		if len(amlFilesystemProperties.Zone) > 0 {
+			return status.Errorf(codes.InvalidArgument,
+				"CreateVolume Parameter %s cannot be used in location %s, no zones available for SKU %s",
+				VolumeContextZone, amlFilesystemProperties.Location, amlFilesystemProperties.SKUName)
+		}
+		return nil
 	}
-	retrievedSkuValue, ok := skus[skuName]
-	if !ok {
-		validSkuNames := slices.Sorted(maps.Keys(skus))
-		return nil, status.Errorf(
+
+	klog.V(2).Infof("available zones for SKU %s in location %s: %v", amlFilesystemProperties.SKUName, amlFilesystemProperties.Location, availableZones)
+	if len(amlFilesystemProperties.Zone) == 0 {
+		amlFilesystemProperties.Zone = getZoneFromAccessibilityRequirements(requirements, amlFilesystemProperties.Location, availableZones)
+		klog.V(2).Infof("zone %q selected from accessibility requirements", amlFilesystemProperties.Zone)
+	}
+	if len(amlFilesystemProperties.Zone) == 0 {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s must be provided for dynamically provisioned AMLFS in location %s when no accessibility requirement is in an available zone, available zones: %v",
+			VolumeContextZone, amlFilesystemProperties.Location, availableZones)
+	}
+	if !slices.Contains(availableZones, amlFilesystemProperties.Zone) {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s %s must be one of: %v",
+			VolumeContextZone, amlFilesystemProperties.Zone, availableZones)
+	}
+	return nil
+}
+
+// getZoneFromAccessibilityRequirements returns the first zone of the preferred,
+// then requisite, topologies that is available for the SKU
+func getZoneFromAccessibilityRequirements(requirements *csi.TopologyRequirement, location string, availableZones []string) string {
+	topologies := slices.Concat(requirements.GetPreferred(), requirements.GetRequisite())
+	for _, topology := range topologies {
+		zone := getZoneFromTopology(topology, location)
+		if slices.Contains(availableZones, zone) {
+			return zone
+		}
+	}
+	return ""
+}
+
+// getAccessibleTopology restricts a dynamically provisioned volume to the zone
+// of its cluster, as long as nodes in that zone were part of the requirements.
+// Otherwise the volume is left accessible from everywhere, so that nodes that
+// are not in a zone, or in a zone without clusters, can still use it
+func getAccessibleTopology(requirements *csi.TopologyRequirement, amlFilesystemProperties *AmlFilesystemProperties) []*csi.Topology {
+	if len(amlFilesystemProperties.AmlFilesystemName) == 0 || len(amlFilesystemProperties.Zone) == 0 {
+		return nil
+	}
+
+	topologies := slices.Concat(requirements.GetPreferred(), requirements.GetRequisite())
+	for _, topology := range topologies {
+		if getZoneFromTopology(topology, amlFilesystemProperties.Location) == amlFilesystemProperties.Zone {
+			return []*csi.Topology{
+				{
+					Segments: map[string]string{topologyZoneKey: topology.GetSegments()[topologyZoneKey]},
+				},
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Driver) getSkuValuesForLocation(ctx context.Context, dynamicProvisioner DynamicProvisionerInterface, skuName, location string) (*LustreSkuValue, error) {
+	skus, err := dynamicProvisioner.GetSkuValuesForLocation(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	retrievedSkuValue, ok := skus[skuName]
+	if !ok {
+		validSkuNames := slices.Sorted(maps.Keys(skus))
+		return nil, status.Errorf(
 			codes.InvalidArgument,
 			"CreateVolume Parameter %s must be one of: %v",
 			VolumeContextSkuName,
@@ -459,22 +1128,11 @@ func checkVolumeRequest(req *csi.CreateVolumeRequest) error {
 			"CreateVolume Volume capabilities must be provided",
 		)
 	}
-	if req.GetVolumeContentSource() != nil {
-		return status.Error(
-			codes.InvalidArgument,
-			"CreateVolume doesn't support being created from an existing volume",
-		)
-	}
-	if req.GetSecrets() != nil {
-		return status.Error(
-			codes.InvalidArgument,
-			"CreateVolume doesn't support secrets",
-		)
-	}
-	if req.GetAccessibilityRequirements() != nil {
+	if contentSource := req.GetVolumeContentSource(); contentSource != nil &&
+		contentSource.GetSnapshot() == nil && contentSource.GetVolume() == nil {
 		return status.Error(
 			codes.InvalidArgument,
-			"CreateVolume doesn't support accessibility_requirements",
+			"CreateVolume content source must be a snapshot or an existing volume",
 		)
 	}
 	capabilityError := validateVolumeCapabilities(volumeCapabilities)
@@ -499,12 +1157,6 @@ func (d *Driver) DeleteVolume(
 		return nil, status.Error(codes.InvalidArgument,
 			"Volume ID missing in request")
 	}
-	if req.GetSecrets() != nil {
-		return nil, status.Error(
-			codes.InvalidArgument,
-			"CreateVolume doesn't support secrets",
-		)
-	}
 
 	lustreVolume, err := getLustreVolFromID(volumeID)
 	if err != nil {
@@ -533,8 +1185,43 @@ func (d *Driver) DeleteVolume(
 			return nil, status.Errorf(codes.InvalidArgument, "volume was dynamically created but associated resource group is not specified. AMLFS cluster may need to be deleted manually")
 		}
 
-		err := d.dynamicProvisioner.DeleteAmlFilesystem(ctx, resourceGroupName, amlFilesystemName)
+		dynamicProvisioner, err := d.getVolumeDynamicProvisioner(lustreVolume, req.GetSecrets())
 		if err != nil {
+			return nil, status.Errorf(status.Code(err), "DeleteVolume %s", status.Convert(err).Message())
+		}
+
+		volumeObject := d.getDeletedVolumeObject(ctx, lustreVolume.name)
+
+		operationKey := deleteOperationKeyPrefix + resourceGroupName + "/" + amlFilesystemName
+		if position := d.amlFilesystemOperations.tryAcquire(operationKey); position > 0 {
+			inProgress := d.amlFilesystemOperations.inProgress()
+			klog.V(2).Infof(amlFilesystemOperationQueuedFmt, "deletion", amlFilesystemName, position, inProgress)
+			d.recordEvent(volumeObject, corev1.EventTypeNormal, eventReasonDeletionQueued,
+				amlFilesystemOperationQueuedFmt, "deletion", amlFilesystemName, position, inProgress)
+			return nil, status.Errorf(codes.Aborted,
+				"DeleteVolume "+amlFilesystemOperationQueuedFmt, "deletion", amlFilesystemName, position, inProgress)
+		}
+		defer d.amlFilesystemOperations.release(operationKey)
+
+		if lustreVolume.archiveOnDeletePath != "" {
+			d.recordEvent(volumeObject, corev1.EventTypeNormal, eventReasonArchiveStarted,
+				"archiving path %s of AMLFS cluster %s before deleting it", lustreVolume.archiveOnDeletePath, amlFilesystemName)
+			err := dynamicProvisioner.ArchiveAmlFilesystem(ctx, resourceGroupName, amlFilesystemName, lustreVolume.archiveOnDeletePath)
+			if err != nil {
+				klog.Errorf("error when archiving AMLFS %s in resource group %s before deletion: %v", amlFilesystemName, resourceGroupName, err)
+				d.recordEvent(volumeObject, corev1.EventTypeWarning, eventReasonDeletionFailed,
+					"AMLFS cluster %s will not be deleted until archiving path %s succeeds: %s",
+					amlFilesystemName, lustreVolume.archiveOnDeletePath, status.Convert(err).Message())
+				return nil, status.Errorf(status.Code(err), "DeleteVolume error when archiving AMLFS %s in resource group %s, cluster will not be deleted until archive succeeds: %v", amlFilesystemName, resourceGroupName, err)
+			}
+		}
+
+		d.recordEvent(volumeObject, corev1.EventTypeNormal, eventReasonDeletionStarted,
+			"deleting AMLFS cluster %s in resource group %s", amlFilesystemName, resourceGroupName)
+		err = dynamicProvisioner.DeleteAmlFilesystem(ctx, resourceGroupName, amlFilesystemName)
+		if err != nil {
+			d.recordEvent(volumeObject, corev1.EventTypeWarning, eventReasonDeletionFailed,
+				"failed to delete AMLFS cluster %s in resource group %s: %s", amlFilesystemName, resourceGroupName, status.Convert(err).Message())
 			errCode := status.Code(err)
 			if errCode == codes.Unknown {
 				klog.Errorf("unknown error occurred when deleting AMLFS %s in resource group %s: %v", amlFilesystemName, lustreVolume.resourceGroupName, err)
@@ -543,6 +1230,14 @@ func (d *Driver) DeleteVolume(
 			klog.Errorf("error when deleting AMLFS %s in resource group %s: %v", amlFilesystemName, lustreVolume.resourceGroupName, err)
 			return nil, status.Errorf(errCode, "DeleteVolume error when deleting AMLFS %s in resource group %s: %v", amlFilesystemName, lustreVolume.resourceGroupName, err)
 		}
+		d.recordEvent(volumeObject, corev1.EventTypeNormal, eventReasonDeletionSucceeded,
+			"deleted AMLFS cluster %s in resource group %s", amlFilesystemName, resourceGroupName)
+	}
+
+	if lustreVolume != nil && lustreVolume.subDirOnDelete != "" {
+		if err := d.deleteProvisionedSubDir(lustreVolume); err != nil {
+			return nil, err
+		}
 	}
 
 	isOperationSucceeded = true
@@ -550,6 +1245,553 @@ func (d *Driver) DeleteVolume(
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+// getVolumeDynamicProvisioner returns the provisioner of the credential in
+// the secrets of a request on an existing volume if its cluster was created
+// with one, which csi-provisioner passes to DeleteVolume again and
+// csi-snapshotter passes to CreateSnapshot. The secrets must be for the
+// subscription of the cluster. Clusters created without secrets are managed
+// by the driver
+func (d *Driver) getVolumeDynamicProvisioner(vol *lustreVolume, secrets map[string]string) (DynamicProvisionerInterface, error) {
+	dynamicProvisioner, credential, err := d.getDynamicProvisioner(secrets)
+	if err != nil {
+		return nil, err
+	}
+	if vol.subscriptionID == "" {
+		return d.dynamicProvisioner, nil
+	}
+	if credential == nil {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"AMLFS cluster %s was created in subscription %s, secrets for that subscription are required",
+			vol.name, vol.subscriptionID)
+	}
+	if !strings.EqualFold(credential.subscriptionID, vol.subscriptionID) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"AMLFS cluster %s was created in subscription %s, secrets are for subscription %s",
+			vol.name, vol.subscriptionID, credential.subscriptionID)
+	}
+	return dynamicProvisioner, nil
+}
+
+func (d *Driver) deleteProvisionedSubDir(vol *lustreVolume) error {
+	switch vol.subDirOnDelete {
+	case subDirOnDeleteRetain:
+		klog.V(2).Infof("retaining sub-dir %q of volume %s", vol.subDir, vol.id)
+		return nil
+	case subDirOnDeleteDelete:
+		if d.enableAzureLustreMockMount {
+			return nil
+		}
+		klog.V(2).Infof("removing sub-dir %q of volume %s from %s", vol.subDir, vol.id, vol.mgsIPAddress)
+		if err := d.removeSubDir(vol, vol.name, vol.subDir, vol.projectID); err != nil {
+			klog.Errorf("error when removing sub-dir %q of volume %s: %v", vol.subDir, vol.id, err)
+			return status.Errorf(status.Code(err), "DeleteVolume error when removing sub-dir %q: %v", vol.subDir, err)
+		}
+		return nil
+	default:
+		return status.Errorf(codes.InvalidArgument,
+			"DeleteVolume volume %s has an invalid %s value %q, sub-dir may need to be deleted manually",
+			vol.id, VolumeContextSubDirOnDelete, vol.subDirOnDelete)
+	}
+}
+
+// ListVolumes lists the AMLFS clusters created by this driver, and the other
+// volumes of the PVs of this driver
+//
+// Volume IDs of the clusters are rebuilt from the cluster and the tags
+// recorded when it was created. The volumes of existing clusters and the
+// sub-dir volumes are not recorded by the driver, so they are listed from
+// their PVs, and reported as normal as in ControllerGetVolume. The starting
+// token is the index of the next entry in the list of volumes, sorted by
+// volume ID.
+//
+// ListVolumes receives no secrets, so the clusters created with provisioner
+// secrets in other subscriptions are listed from their PVs, with the
+// credential last used in their subscription. Until a credential is known,
+// they are reported as normal as in ControllerGetVolume.
+func (d *Driver) ListVolumes(
+	ctx context.Context,
+	req *csi.ListVolumesRequest,
+) (*csi.ListVolumesResponse, error) {
+	mc := metrics.NewMetricContext(azureLustreCSIDriverName,
+		"controller_list_volumes",
+		d.resourceGroup,
+		d.cloud.SubscriptionID,
+		d.Name)
+
+	isOperationSucceeded := false
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded)
+	}()
+
+	maxEntries := int(req.GetMaxEntries())
+	if maxEntries < 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"ListVolumes max_entries must not be negative, was: %d", maxEntries)
+	}
+
+	start := 0
+	if startingToken := req.GetStartingToken(); startingToken != "" {
+		var err error
+		start, err = strconv.Atoi(startingToken)
+		if err != nil || start < 0 {
+			return nil, status.Errorf(codes.Aborted,
+				"ListVolumes starting_token %q is not valid", startingToken)
+		}
+	}
+
+	amlFilesystems, err := d.dynamicProvisioner.ListAmlFilesystems(ctx)
+	if err != nil {
+		klog.Errorf("error when listing AMLFS clusters: %v", err)
+		return nil, status.Errorf(status.Code(err), "ListVolumes error when listing AMLFS clusters: %v", err)
+	}
+
+	volumes := make([]*csi.ListVolumesResponse_Entry, 0, len(amlFilesystems))
+	for _, amlFilesystem := range amlFilesystems {
+		parameters := map[string]string{
+			VolumeContextMGSIPAddress:               amlFilesystem.MgsAddress,
+			VolumeContextResourceGroupName:          amlFilesystem.ResourceGroupName,
+			VolumeContextInternalDynamicallyCreated: "t",
+		}
+		if subDir := amlFilesystem.Tags[subDirTag]; subDir != "" {
+			parameters[VolumeContextSubDir] = subDir
+		}
+		if archiveOnDeletePath := amlFilesystem.Tags[archiveOnDeletePathTag]; archiveOnDeletePath != "" {
+			parameters[VolumeContextArchiveOnDeletePath] = archiveOnDeletePath
+		}
+
+		volumeID, err := createVolumeIDFromParams(amlFilesystem.Name, parameters)
+		if err != nil {
+			klog.Warningf("skipping AMLFS cluster %s, could not build volume ID: %v", amlFilesystem.Name, err)
+			continue
+		}
+
+		volumes = append(volumes, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:      volumeID,
+				CapacityBytes: int64(amlFilesystem.StorageCapacityTiB * util.TiB),
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				VolumeCondition: getVolumeCondition(amlFilesystem),
+			},
+		})
+	}
+
+	pvVolumes, err := d.listPersistentVolumes(ctx)
+	if err != nil {
+		klog.Errorf("error when listing volumes of PVs: %v", err)
+		return nil, status.Errorf(status.Code(err), "ListVolumes error when listing volumes of PVs: %v", err)
+	}
+	volumes = append(volumes, pvVolumes...)
+	slices.SortFunc(volumes, func(a, b *csi.ListVolumesResponse_Entry) int {
+		return strings.Compare(a.GetVolume().GetVolumeId(), b.GetVolume().GetVolumeId())
+	})
+
+	if start > len(volumes) {
+		return nil, status.Errorf(codes.Aborted,
+			"ListVolumes starting_token %d is greater than the number of volumes %d", start, len(volumes))
+	}
+
+	end := len(volumes)
+	if maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	nextToken := ""
+	if end < len(volumes) {
+		nextToken = strconv.Itoa(end)
+	}
+
+	isOperationSucceeded = true
+	return &csi.ListVolumesResponse{
+		Entries:   volumes[start:end],
+		NextToken: nextToken,
+	}, nil
+}
+
+// listPersistentVolumes returns the volumes of the PVs of this driver that
+// are not clusters of the controller subscription, they are empty without a
+// kubernetes client. The clusters of the other subscriptions are listed once
+// per subscription, and their volumes are only returned while they exist
+func (d *Driver) listPersistentVolumes(ctx context.Context) ([]*csi.ListVolumesResponse_Entry, error) {
+	if d.kubeClient == nil {
+		return nil, nil
+	}
+
+	pvs, err := d.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to list PVs: %v", err)
+	}
+
+	subscriptionAmlFilesystems := make(map[string]map[string]*AmlFilesystemInfo)
+	var volumes []*csi.ListVolumesResponse_Entry
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name {
+			continue
+		}
+		volumeID := pv.Spec.CSI.VolumeHandle
+		vol, err := getLustreVolFromID(volumeID)
+		if err != nil || !vol.createdByDynamicProvisioning {
+			volumes = append(volumes, newNormalVolumeEntry(volumeID, pv.Spec.Capacity.Storage().Value(), staticVolumeConditionMessage))
+			continue
+		}
+		if vol.subscriptionID == "" {
+			continue
+		}
+
+		dynamicProvisioner, ok := d.getSubscriptionDynamicProvisioner(vol.subscriptionID)
+		if !ok {
+			volumes = append(volumes, newNormalVolumeEntry(volumeID, pv.Spec.Capacity.Storage().Value(),
+				fmt.Sprintf(unknownSubscriptionConditionFmt, vol.name, vol.subscriptionID)))
+			continue
+		}
+		subscriptionKey := strings.ToLower(vol.subscriptionID)
+		amlFilesystems, ok := subscriptionAmlFilesystems[subscriptionKey]
+		if !ok {
+			amlFilesystemList, err := dynamicProvisioner.ListAmlFilesystems(ctx)
+			if err != nil {
+				return nil, status.Errorf(status.Code(err), "failed to list AMLFS clusters in subscription %s: %v", vol.subscriptionID, err)
+			}
+			amlFilesystems = make(map[string]*AmlFilesystemInfo, len(amlFilesystemList))
+			for _, amlFilesystem := range amlFilesystemList {
+				amlFilesystems[strings.ToLower(amlFilesystem.ResourceGroupName+"/"+amlFilesystem.Name)] = amlFilesystem
+			}
+			subscriptionAmlFilesystems[subscriptionKey] = amlFilesystems
+		}
+		amlFilesystem, ok := amlFilesystems[strings.ToLower(vol.resourceGroupName+"/"+vol.name)]
+		if !ok {
+			continue
+		}
+		volumes = append(volumes, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:      volumeID,
+				CapacityBytes: int64(amlFilesystem.StorageCapacityTiB * util.TiB),
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				VolumeCondition: getVolumeCondition(amlFilesystem),
+			},
+		})
+	}
+	return volumes, nil
+}
+
+func newNormalVolumeEntry(volumeID string, capacityBytes int64, message string) *csi.ListVolumesResponse_Entry {
+	return &csi.ListVolumesResponse_Entry{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: capacityBytes,
+		},
+		Status: &csi.ListVolumesResponse_VolumeStatus{
+			VolumeCondition: &csi.VolumeCondition{
+				Abnormal: false,
+				Message:  message,
+			},
+		},
+	}
+}
+
+// ControllerGetVolume returns the condition of a volume
+//
+// For dynamically provisioned volumes the condition is built from the
+// provisioning state, health and HSM archive status of the AMLFS cluster.
+// Statically provisioned volumes are not backed by a cluster known to the
+// driver, so they are always reported as normal. The clusters created with
+// provisioner secrets are read with the credential last used in their
+// subscription, and reported as normal until a credential is known.
+func (d *Driver) ControllerGetVolume(
+	ctx context.Context,
+	req *csi.ControllerGetVolumeRequest,
+) (*csi.ControllerGetVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume ID missing in request")
+	}
+
+	lustreVolume, err := getLustreVolFromID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound,
+			"ControllerGetVolume volume %s not found: %v", volumeID, err)
+	}
+
+	mc := metrics.NewMetricContext(azureLustreCSIDriverName,
+		"controller_get_volume",
+		d.resourceGroup,
+		d.cloud.SubscriptionID,
+		d.Name)
+
+	isOperationSucceeded := false
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded)
+	}()
+
+	if !lustreVolume.createdByDynamicProvisioning {
+		isOperationSucceeded = true
+		return &csi.ControllerGetVolumeResponse{
+			Volume: &csi.Volume{
+				VolumeId: volumeID,
+			},
+			Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: false,
+					Message:  staticVolumeConditionMessage,
+				},
+			},
+		}, nil
+	}
+
+	amlFilesystemName := lustreVolume.name
+	resourceGroupName := lustreVolume.resourceGroupName
+	if resourceGroupName == "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"ControllerGetVolume volume %s was dynamically created but associated resource group is not specified", volumeID)
+	}
+
+	dynamicProvisioner, ok := d.getSubscriptionDynamicProvisioner(lustreVolume.subscriptionID)
+	if !ok {
+		isOperationSucceeded = true
+		return &csi.ControllerGetVolumeResponse{
+			Volume: &csi.Volume{
+				VolumeId: volumeID,
+			},
+			Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: false,
+					Message:  fmt.Sprintf(unknownSubscriptionConditionFmt, amlFilesystemName, lustreVolume.subscriptionID),
+				},
+			},
+		}, nil
+	}
+
+	amlFilesystem, err := dynamicProvisioner.GetAmlFilesystem(ctx, resourceGroupName, amlFilesystemName)
+	if err != nil {
+		klog.Errorf("error when getting AMLFS %s in resource group %s: %v", amlFilesystemName, resourceGroupName, err)
+		return nil, status.Errorf(status.Code(err), "ControllerGetVolume error when getting AMLFS %s in resource group %s: %v", amlFilesystemName, resourceGroupName, err)
+	}
+
+	isOperationSucceeded = true
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: int64(amlFilesystem.StorageCapacityTiB * util.TiB),
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			VolumeCondition: getVolumeCondition(amlFilesystem),
+		},
+	}, nil
+}
+
+func getVolumeCondition(amlFilesystem *AmlFilesystemInfo) *csi.VolumeCondition {
+	var problems []string
+
+	switch amlFilesystem.ProvisioningState { //nolint:exhaustive // Other provisioning states are not a problem for the volume
+	case armstoragecache.AmlFilesystemProvisioningStateTypeFailed,
+		armstoragecache.AmlFilesystemProvisioningStateTypeDeleting:
+		problems = append(problems, fmt.Sprintf("provisioning state is %s", amlFilesystem.ProvisioningState))
+	}
+
+	switch amlFilesystem.HealthState { //nolint:exhaustive // Other health states are not a problem for the volume
+	case armstoragecache.AmlFilesystemHealthStateTypeDegraded,
+		armstoragecache.AmlFilesystemHealthStateTypeUnavailable,
+		armstoragecache.AmlFilesystemHealthStateTypeMaintenance:
+		healthProblem := fmt.Sprintf("health state is %s", amlFilesystem.HealthState)
+		if amlFilesystem.HealthStatusDescription != "" {
+			healthProblem += ": " + amlFilesystem.HealthStatusDescription
+		}
+		problems = append(problems, healthProblem)
+	}
+
+	if amlFilesystem.ArchiveState == armstoragecache.ArchiveStatusTypeFailed {
+		archiveProblem := "last HSM archive failed"
+		if amlFilesystem.ArchiveErrorMessage != "" {
+			archiveProblem += ": " + amlFilesystem.ArchiveErrorMessage
+		}
+		problems = append(problems, archiveProblem)
+	}
+
+	if len(problems) > 0 {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("AMLFS cluster %s %s", amlFilesystem.Name, strings.Join(problems, ", ")),
+		}
+	}
+
+	message := fmt.Sprintf("AMLFS cluster %s is healthy", amlFilesystem.Name)
+	if amlFilesystem.HealthState != "" {
+		message = fmt.Sprintf("AMLFS cluster %s health state is %s", amlFilesystem.Name, amlFilesystem.HealthState)
+	}
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  message,
+	}
+}
+
+// GetCapacity returns the capacity available for new volumes
+//
+// Every dynamically provisioned volume is a new AMLFS cluster, so the
+// available capacity is the size of the largest cluster that could be created
+// with the StorageClass parameters in the zone of the requested topology.
+func (d *Driver) GetCapacity(
+	ctx context.Context,
+	req *csi.GetCapacityRequest,
+) (*csi.GetCapacityResponse, error) {
+	if err := validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, err
+	}
+
+	parameters := req.GetParameters()
+	if len(parameters) == 0 {
+		// Nothing is known about the volumes that would be created
+		return &csi.GetCapacityResponse{}, nil
+	}
+
+	if util.GetValueInMap(parameters, VolumeContextMGSIPAddress) != "" {
+		// Static volumes use an existing cluster and are never short of capacity
+		return &csi.GetCapacityResponse{AvailableCapacity: math.MaxInt64}, nil
+	}
+
+	existingProperties, err := parseExistingAmlFilesystemProperties(parameters)
+	if err != nil {
+		return nil, err
+	}
+	if existingProperties != nil {
+		// Volumes of a cluster referenced by name, with or without a sub-dir,
+		// use the capacity of the cluster and no SKU
+		return &csi.GetCapacityResponse{AvailableCapacity: math.MaxInt64}, nil
+	}
+
+	if hasProvisionerSecrets(parameters) {
+		// The subscription and the credential of the clusters are in the
+		// secrets, which GetCapacity does not receive, so the capacity is
+		// left to CreateVolume
+		klog.V(4).Infof("capacity of StorageClass with provisioner secrets is not known, reporting unlimited capacity")
+		return &csi.GetCapacityResponse{AvailableCapacity: math.MaxInt64}, nil
+	}
+
+	mc := metrics.NewMetricContext(azureLustreCSIDriverName,
+		"controller_get_capacity",
+		d.resourceGroup,
+		d.cloud.SubscriptionID,
+		d.Name)
+
+	isOperationSucceeded := false
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded)
+	}()
+
+	// GetCapacity receives all the StorageClass parameters, including the
+	// ones csi-provisioner removes before calling CreateVolume
+	parameters = maps.Clone(parameters)
+	maps.DeleteFunc(parameters, func(key, _ string) bool {
+		return strings.HasPrefix(strings.ToLower(key), csiParameterPrefix)
+	})
+	amlFilesystemProperties, err := parseAmlFilesystemProperties(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(amlFilesystemProperties.Location) == 0 {
+		amlFilesystemProperties.Location = d.location
+	}
+	amlFilesystemProperties.SubnetInfo = d.populateSubnetPropertiesFromCloudConfig(amlFilesystemProperties.SubnetInfo)
+
+	lustreSkuValue, err := d.getSkuValuesForLocation(ctx, d.dynamicProvisioner, amlFilesystemProperties.SKUName, amlFilesystemProperties.Location)
+	if err != nil {
+		klog.Errorf("failed to get SKU values for %s in location %s, error: %v", amlFilesystemProperties.SKUName, amlFilesystemProperties.Location, err)
+		return nil, err
+	}
+
+	zone := amlFilesystemProperties.Zone
+	if topologyZone := getZoneFromTopology(req.GetAccessibleTopology(), amlFilesystemProperties.Location); topologyZone != "" {
+		if zone != "" && zone != topologyZone {
+			klog.V(2).Infof("StorageClass zone %s does not match topology zone %s, no capacity available", zone, topologyZone)
+			isOperationSucceeded = true
+			return &csi.GetCapacityResponse{}, nil
+		}
+		zone = topologyZone
+	}
+
+	// Mirrors the zone validation in CreateVolume
+	zoneAvailable := slices.Contains(lustreSkuValue.AvailableZones, zone)
+	if zone == "" {
+		zoneAvailable = len(lustreSkuValue.AvailableZones) == 0
+	}
+	if !zoneAvailable {
+		klog.V(2).Infof("zone %q cannot be used for SKU %s in location %s, available zones: %v",
+			zone, amlFilesystemProperties.SKUName, amlFilesystemProperties.Location, lustreSkuValue.AvailableZones)
+		isOperationSucceeded = true
+		return &csi.GetCapacityResponse{}, nil
+	}
+	amlFilesystemProperties.Zone = zone
+
+	availableCapacityTiB, err := d.dynamicProvisioner.GetAvailableCapacity(ctx, amlFilesystemProperties, lustreSkuValue)
+	if err != nil {
+		klog.Errorf("error when getting available capacity for SKU %s in location %s: %v", amlFilesystemProperties.SKUName, amlFilesystemProperties.Location, err)
+		return nil, status.Errorf(status.Code(err), "GetCapacity error when getting available capacity for SKU %s in location %s: %v", amlFilesystemProperties.SKUName, amlFilesystemProperties.Location, err)
+	}
+
+	isOperationSucceeded = true
+
+	availableCapacityInBytes := availableCapacityTiB * util.TiB
+	if availableCapacityInBytes == 0 {
+		return &csi.GetCapacityResponse{}, nil
+	}
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: availableCapacityInBytes,
+		MaximumVolumeSize: wrapperspb.Int64(availableCapacityInBytes),
+		MinimumVolumeSize: wrapperspb.Int64(lustreSkuValue.IncrementInTib * util.TiB),
+	}, nil
+}
+
+// getZoneFromTopology returns the AMLFS zone of a topology segment. Nodes are
+// labeled with <location>-<zone> in zonal clusters and 0 in non-zonal ones
+func getZoneFromTopology(topology *csi.Topology, location string) string {
+	zone := topology.GetSegments()[topologyZoneKey]
+	if zone == nonZonalTopologyZone {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(zone), strings.ToLower(location)+"-")
+}
+
+// ControllerExpandVolume expands a volume
+//
+// The AMLFS update API (AmlFilesystemUpdateProperties) does not allow the
+// storage capacity of an existing cluster to be changed, so expansion cannot
+// be performed through BeginUpdate. EXPAND_VOLUME is intentionally not
+// advertised; this only returns a descriptive error instead of the default
+// Unimplemented response.
+func (d *Driver) ControllerExpandVolume(
+	_ context.Context,
+	req *csi.ControllerExpandVolumeRequest,
+) (*csi.ControllerExpandVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume ID missing in request")
+	}
+
+	if req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument,
+			"ControllerExpandVolume Capacity range must be provided")
+	}
+
+	lustreVolume, err := getLustreVolFromID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound,
+			"ControllerExpandVolume invalid volume ID %s: %v", volumeID, err)
+	}
+
+	if !lustreVolume.createdByDynamicProvisioning {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"ControllerExpandVolume volume %s was not created by dynamic provisioning, resize the Lustre filesystem outside of Kubernetes instead",
+			volumeID)
+	}
+
+	return nil, status.Errorf(codes.Unimplemented,
+		"ControllerExpandVolume AMLFS cluster %s cannot be expanded, changing the storage capacity of an existing AMLFS cluster is not supported",
+		lustreVolume.name)
+}
+
 // ValidateVolumeCapabilities return the capabilities of the volume
 func (d *Driver) ValidateVolumeCapabilities(
 	_ context.Context,
@@ -600,17 +1842,28 @@ func (d *Driver) ControllerGetCapabilities(
 
 // Convert VolumeCreate parameters to a volume id
 func createVolumeIDFromParams(volName string, params map[string]string) (string, error) {
-	var mgsIPAddress, createdByDynamicProvisioningStringValue, resourceGroupName, subDir string
+	var mgsIPAddress, createdByDynamicProvisioningStringValue, resourceGroupName, subDir, archiveOnDeletePath, subDirOnDelete, subDirProjectID, subscriptionID string
+	fsName := DefaultLustreFsName
 
 	// validate parameters (case-insensitive).
 	for k, v := range params {
 		switch strings.ToLower(k) {
 		case VolumeContextMGSIPAddress:
 			mgsIPAddress = v
+		case VolumeContextFSName:
+			fsName = getFsName(v)
 		case VolumeContextInternalDynamicallyCreated:
 			createdByDynamicProvisioningStringValue = v
 		case VolumeContextResourceGroupName:
 			resourceGroupName = v
+		case VolumeContextArchiveOnDeletePath:
+			archiveOnDeletePath = v
+		case VolumeContextSubDirOnDelete:
+			subDirOnDelete = v
+		case VolumeContextSubDirProjectID:
+			subDirProjectID = v
+		case VolumeContextInternalSubscriptionID:
+			subscriptionID = v
 		case VolumeContextSubDir:
 			subDir = v
 			subDir = strings.Trim(subDir, "/")
@@ -624,7 +1877,28 @@ func createVolumeIDFromParams(volName string, params map[string]string) (string,
 		}
 	}
 
-	volumeID := fmt.Sprintf(volumeIDTemplate, volName, DefaultLustreFsName, mgsIPAddress, subDir, createdByDynamicProvisioningStringValue, resourceGroupName)
+	volumeID := fmt.Sprintf(volumeIDTemplate, volName, fsName, mgsIPAddress, subDir, createdByDynamicProvisioningStringValue, resourceGroupName)
+
+	// The archive path, sub-dir delete policy, project ID and subscription
+	// are only needed by DeleteVolume and NodeGetVolumeStats, so they are
+	// appended as optional trailing segments to keep existing volume IDs
+	// unchanged
+	optionalSegments := make([]string, 4)
+	if createdByDynamicProvisioningStringValue == "t" {
+		optionalSegments[0] = archiveOnDeletePath
+		optionalSegments[3] = subscriptionID
+	} else if subDirOnDelete != "" {
+		// Sub-dirs are only provisioned on existing clusters, which are never
+		// archived, so the archive path segment is left empty
+		optionalSegments[1] = subDirOnDelete
+		optionalSegments[2] = subDirProjectID
+	}
+	for len(optionalSegments) > 0 && optionalSegments[len(optionalSegments)-1] == "" {
+		optionalSegments = optionalSegments[:len(optionalSegments)-1]
+	}
+	for _, segment := range optionalSegments {
+		volumeID += separator + segment
+	}
 
 	return volumeID, nil
 }
@@ -18,18 +18,29 @@ package azurelustre
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"math"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mount "k8s.io/mount-utils"
+	testingexec "k8s.io/utils/exec/testing"
 	"sigs.k8s.io/azurelustre-csi-driver/pkg/util"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 )
@@ -129,6 +140,148 @@ func TestCreateVolume_Success_DoesNotCallDynamicProvisioner(t *testing.T) {
 	assert.Empty(t, fakeDynamicProvisioner.fakeCallCount, "unexpected calls made to dynamic provisioner, all calls: %#v", fakeDynamicProvisioner.fakeCallCount)
 }
 
+func buildExistingAmlfsCreateVolumeRequest() *csi.CreateVolumeRequest {
+	req := buildCreateVolumeRequest()
+	req.Parameters = map[string]string{
+		"amlfs-name":          "existing-cluster",
+		"resource-group-name": "existing-resource-group",
+		"sub-dir":             "testSubDir",
+	}
+	return req
+}
+
+func newExistingAmlfsFakeDynamicProvisioner() *FakeDynamicProvisioner {
+	return &FakeDynamicProvisioner{
+		Filesystems: []*AmlFilesystemProperties{
+			{
+				ResourceGroupName:  "existing-resource-group",
+				AmlFilesystemName:  "existing-cluster",
+				StorageCapacityTiB: 8,
+			},
+		},
+	}
+}
+
+func TestCreateVolume_Success_AmlfsName(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := newExistingAmlfsFakeDynamicProvisioner()
+	d.dynamicProvisioner = fakeDynamicProvisioner
+	req := buildExistingAmlfsCreateVolumeRequest()
+	req.Parameters["subscription-id"] = "other-subscription"
+	req.CapacityRange = &csi.CapacityRange{RequiredBytes: util.TiB}
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "test_volume#lustrefs#127.0.0.2#testSubDir#f#existing-resource-group", rep.GetVolume().GetVolumeId())
+	assert.Equal(t, int64(8*util.TiB), rep.GetVolume().GetCapacityBytes())
+	assert.Equal(t, "127.0.0.2", rep.GetVolume().GetVolumeContext()["mgs-ip-address"])
+	assert.Equal(t, "existing-cluster", rep.GetVolume().GetVolumeContext()["amlfs-name"])
+	assert.Equal(t, "other-subscription", rep.GetVolume().GetVolumeContext()["subscription-id"])
+	assert.Equal(t, map[string]int{"GetAmlFilesystemInSubscription": 1, "GetAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Len(t, fakeDynamicProvisioner.Filesystems, 1)
+}
+
+func TestCreateVolume_Success_AmlfsNameDefaultResourceGroup(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := newExistingAmlfsFakeDynamicProvisioner()
+	fakeDynamicProvisioner.Filesystems[0].ResourceGroupName = d.resourceGroup
+	d.dynamicProvisioner = fakeDynamicProvisioner
+	req := buildExistingAmlfsCreateVolumeRequest()
+	delete(req.Parameters, "resource-group-name")
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, d.resourceGroup, rep.GetVolume().GetVolumeContext()["resource-group-name"])
+}
+
+func TestCreateVolume_Success_AmlfsNameProvisionSubDir(t *testing.T) {
+	d := NewFakeDriver()
+	d.dynamicProvisioner = newExistingAmlfsFakeDynamicProvisioner()
+	fakeMounter := setSubDirProvisioningFakeMounter(t, d)
+	addFakeLfsCommands(t, d, withFreeProject(fakeLfsCommand{}, fakeLfsCommand{})...)
+	req := buildExistingAmlfsCreateVolumeRequest()
+	req.Parameters["provision-sub-dir"] = "true"
+	delete(req.Parameters, "sub-dir")
+	req.CapacityRange = &csi.CapacityRange{RequiredBytes: 10 * util.GiB}
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "test_volume#lustrefs#127.0.0.2#test_volume#f#existing-resource-group##delete#1498690128", rep.GetVolume().GetVolumeId())
+	assert.Equal(t, int64(10*util.GiB), rep.GetVolume().GetCapacityBytes())
+	mountTarget := filepath.Join(d.workingMountDir, "test_volume")
+	assert.Equal(t, []mount.FakeAction{
+		{Action: "mount", Target: mountTarget, Source: "127.0.0.2@tcp:/lustrefs", FSType: "lustre"},
+		{Action: "unmount", Target: mountTarget},
+	}, fakeMounter.GetLog())
+}
+
+func TestCreateVolume_Err_AmlfsName(t *testing.T) {
+	cases := []struct {
+		desc          string
+		parameters    map[string]string
+		capacityRange *csi.CapacityRange
+		expectedCode  codes.Code
+		expectedError string
+	}{
+		{
+			desc:          "with mgs-ip-address",
+			parameters:    map[string]string{"mgs-ip-address": "127.0.0.1"},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "mgs-ip-address and amlfs-name cannot be used together",
+		},
+		{
+			desc:          "invalid name",
+			parameters:    map[string]string{"amlfs-name": "-invalid"},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "amlfs-name must be a valid AMLFS cluster name",
+		},
+		{
+			desc:          "subscription-id without amlfs-name",
+			parameters:    map[string]string{"amlfs-name": "", "mgs-ip-address": "127.0.0.1", "subscription-id": "other-subscription"},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "subscription-id can only be used with amlfs-name",
+		},
+		{
+			desc:          "cluster not found",
+			parameters:    map[string]string{"amlfs-name": "missing-cluster"},
+			expectedCode:  codes.NotFound,
+			expectedError: "error when getting AMLFS cluster missing-cluster in resource group existing-resource-group",
+		},
+		{
+			desc:          "no access to subscription",
+			parameters:    map[string]string{"subscription-id": unknownSubscriptionID},
+			expectedCode:  codes.PermissionDenied,
+			expectedError: "no access to subscription",
+		},
+		{
+			desc:          "required capacity above cluster capacity",
+			capacityRange: &csi.CapacityRange{RequiredBytes: 9 * util.TiB},
+			expectedCode:  codes.OutOfRange,
+			expectedError: "greater than the capacity 8796093022208 of AMLFS cluster existing-cluster",
+		},
+		{
+			desc:          "cluster capacity above limit",
+			capacityRange: &csi.CapacityRange{LimitBytes: 4 * util.TiB},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "is greater than capacity limit",
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			d.dynamicProvisioner = newExistingAmlfsFakeDynamicProvisioner()
+			req := buildExistingAmlfsCreateVolumeRequest()
+			maps.Copy(req.Parameters, test.parameters)
+			req.CapacityRange = test.capacityRange
+
+			_, err := d.CreateVolume(context.Background(), req)
+			require.Error(t, err)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+			assert.ErrorContains(t, err, test.expectedError)
+		})
+	}
+}
+
 func TestDynamicCreateVolume_Success(t *testing.T) {
 	d := NewFakeDriver()
 	ctrl := gomock.NewController(t)
@@ -160,6 +313,7 @@ func TestDynamicCreateVolume_Success_SendsCorrectProperties(t *testing.T) {
 			"kubernetes.io-created-for-pvc-name": "pvc_name",
 			"kubernetes.io-created-for-pv-name":  "pv_name",
 			"kubernetes.io-created-for-pvc-namespace": "pvc_namespace",
+			"k8s-azure-lustre-sub-dir":                "testSubDir",
 		},
 		Zone: "zone1",
 		SubnetInfo: SubnetProperties{
@@ -184,12 +338,70 @@ func TestDynamicCreateVolume_Success_SendsCorrectProperties(t *testing.T) {
 	assert.NotZero(t, rep.GetVolume().GetCapacityBytes())
 	assert.NotEmpty(t, rep.GetVolume().GetVolumeContext())
 	require.Len(t, fakeDynamicProvisioner.Filesystems, 1)
-	require.Len(t, fakeDynamicProvisioner.fakeCallCount, 2, "unexpected calls made to dynamic provisioner, all calls: %#v", fakeDynamicProvisioner.fakeCallCount)
-	require.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["CreateAmlFilesystem"])
+	require.Len(t, fakeDynamicProvisioner.fakeCallCount, 4, "unexpected calls made to dynamic provisioner, all calls: %#v", fakeDynamicProvisioner.fakeCallCount)
+	require.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["SelectSubnet"])
+	require.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["BeginCreateAmlFilesystem"])
+	require.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["PollCreateAmlFilesystem"])
 	require.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["GetSkuValuesForLocation"])
 	assert.Equal(t, expectedAmlfsProperties, fakeDynamicProvisioner.Filesystems[0])
 }
 
+func TestDynamicCreateVolume_Success_SubnetCandidates(t *testing.T) {
+	testCases := []struct {
+		desc               string
+		subnetName         string
+		expectedSubnetName string
+	}{
+		{
+			desc:               "Subnet list",
+			subnetName:         fullSubnetName + ",test-subnet-2",
+			expectedSubnetName: "test-subnet-2",
+		},
+		{
+			desc:               "Subnet prefix",
+			subnetName:         "test-subnet-*",
+			expectedSubnetName: "test-subnet-1",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+			d.dynamicProvisioner = fakeDynamicProvisioner
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			d.cloud = azure.GetTestCloud(ctrl)
+			req := buildDynamicProvCreateVolumeRequest()
+			req.Parameters["subnet-name"] = tC.subnetName
+
+			rep, err := d.CreateVolume(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, tC.expectedSubnetName, rep.GetVolume().GetVolumeContext()["subnet-name"])
+			require.Len(t, fakeDynamicProvisioner.Filesystems, 1)
+			assert.Equal(t, tC.expectedSubnetName, fakeDynamicProvisioner.Filesystems[0].SubnetInfo.SubnetName)
+			assert.Equal(t, "/subscriptions/subscription/resourceGroups/test-vnet-rg/providers/Microsoft.Network/virtualNetworks/test-vnet-name/subnets/"+tC.expectedSubnetName,
+				fakeDynamicProvisioner.Filesystems[0].SubnetInfo.SubnetID)
+		})
+	}
+}
+
+func TestDynamicCreateVolume_Err_NoSubnetWithCapacity(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d.cloud = azure.GetTestCloud(ctrl)
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Parameters["subnet-name"] = fullSubnetName + ",other" + fullSubnetName
+
+	_, err := d.CreateVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.ErrorContains(t, err, "not enough IP addresses available")
+	assert.Empty(t, fakeDynamicProvisioner.Filesystems)
+}
+
 func TestDynamicCreateVolume_Success_ZonesSynonym(t *testing.T) {
 	expectedZone := "zone1"
 
@@ -258,6 +470,60 @@ func TestDynamicCreateVolume_Success_UsesReturnedIPAddress(t *testing.T) {
 	assert.Contains(t, rep.GetVolume().GetVolumeId(), "127.0.0.2")
 }
 
+func TestDynamicCreateVolume_Success_ArchiveOnDelete(t *testing.T) {
+	testCases := []struct {
+		desc                string
+		archiveOnDeletePath string
+		expectedPath        string
+	}{
+		{
+			desc:         "default path",
+			expectedPath: "/",
+		},
+		{
+			desc:                "custom path",
+			archiveOnDeletePath: "/results",
+			expectedPath:        "/results",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			d.cloud = azure.GetTestCloud(ctrl)
+			req := buildDynamicProvCreateVolumeRequest()
+			req.Parameters[VolumeContextHsmContainer] = "data-container"
+			req.Parameters[VolumeContextHsmLoggingContainer] = "logging-container"
+			req.Parameters[VolumeContextArchiveOnDelete] = "true"
+			if tC.archiveOnDeletePath != "" {
+				req.Parameters[VolumeContextArchiveOnDeletePath] = tC.archiveOnDeletePath
+			}
+			rep, err := d.CreateVolume(context.Background(), req)
+			require.NoError(t, err)
+			assert.True(t, strings.HasSuffix(rep.GetVolume().GetVolumeId(), "#"+tC.expectedPath), "volume ID %s should end with archive path", rep.GetVolume().GetVolumeId())
+			assert.Equal(t, tC.expectedPath, rep.GetVolume().GetVolumeContext()[VolumeContextArchiveOnDeletePath])
+
+			lustreVolume, err := getLustreVolFromID(rep.GetVolume().GetVolumeId())
+			require.NoError(t, err)
+			assert.Equal(t, tC.expectedPath, lustreVolume.archiveOnDeletePath)
+		})
+	}
+}
+
+func TestDynamicCreateVolume_Success_NoArchiveOnDelete(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d.cloud = azure.GetTestCloud(ctrl)
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Parameters[VolumeContextArchiveOnDelete] = "false"
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(rep.GetVolume().GetVolumeId(), separator), 6)
+	assert.NotContains(t, rep.GetVolume().GetVolumeContext(), VolumeContextArchiveOnDeletePath)
+}
+
 func TestCreateVolume_Success_CapacityRoundUp(t *testing.T) {
 	defaultLaaSOBlockSizeInBytes := int64(defaultLaaSOBlockSizeInTib) * util.TiB
 
@@ -536,6 +802,60 @@ func TestCreateVolume_Success_EmptyFSName(t *testing.T) {
 	assert.Equal(t, expectedOutput, rep.GetVolume().GetVolumeId())
 }
 
+func TestCreateVolume_Success_FSNameAndFailoverMGS(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildCreateVolumeRequest()
+	req.Parameters[VolumeContextMGSIPAddress] = "10.0.0.4@tcp1,10.1.0.4@o2ib:mgs2.example.com@tcp1"
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "test_volume#tfs#10.0.0.4@tcp1,10.1.0.4@o2ib:mgs2.example.com@tcp1#testSubDir#f#", rep.GetVolume().GetVolumeId())
+	assert.Equal(t, "10.0.0.4@tcp1,10.1.0.4@o2ib:mgs2.example.com@tcp1", rep.GetVolume().GetVolumeContext()["mgs-ip-address"])
+	assert.Equal(t, "tfs", rep.GetVolume().GetVolumeContext()["fs-name"])
+
+	vol, err := getLustreVolFromID(rep.GetVolume().GetVolumeId())
+	require.NoError(t, err)
+	assert.Equal(t, "tfs", vol.azureLustreName)
+	assert.Equal(t, "10.0.0.4@tcp1,10.1.0.4@o2ib:mgs2.example.com@tcp1", vol.mgsIPAddress)
+}
+
+func TestCreateVolume_Err_LustreServerParameters(t *testing.T) {
+	tests := []struct {
+		desc          string
+		parameters    map[string]string
+		expectedError string
+	}{
+		{
+			desc:          "invalid LNet network name",
+			parameters:    map[string]string{"mgs-ip-address": "127.0.0.1@tcp_1"},
+			expectedError: `CreateVolume Parameter mgs-ip-address must have valid LNet network names such as tcp or tcp1, "tcp_1" is invalid`,
+		},
+		{
+			desc:          "empty failover MGS address",
+			parameters:    map[string]string{"mgs-ip-address": "127.0.0.1:"},
+			expectedError: "CreateVolume Parameter mgs-ip-address must be MGS addresses separated by ':' or ',' without empty addresses",
+		},
+		{
+			desc:          "invalid fs name",
+			parameters:    map[string]string{"fs-name": "lustre.fs"},
+			expectedError: "CreateVolume Parameter fs-name must be 1 to 8 letters, digits, '_' or '-', was: 'lustre.fs'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			req := buildCreateVolumeRequest()
+			for key, value := range test.parameters {
+				req.Parameters[key] = value
+			}
+			_, err := d.CreateVolume(context.Background(), req)
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.ErrorContains(t, err, test.expectedError)
+		})
+	}
+}
+
 func TestCreateVolume_Err_ParametersEmptySubDir(t *testing.T) {
 	d := NewFakeDriver()
 	req := buildCreateVolumeRequest()
@@ -590,19 +910,15 @@ func TestCreateVolume_Err_HasVolumeContentSource(t *testing.T) {
 	require.ErrorContains(t, err, "existing volume")
 }
 
-func TestCreateVolume_Err_HasSecrets(t *testing.T) {
+func TestCreateVolume_Success_EmptySecrets(t *testing.T) {
 	d := NewFakeDriver()
 	req := buildCreateVolumeRequest()
 	req.Secrets = map[string]string{}
 	_, err := d.CreateVolume(context.Background(), req)
-	require.Error(t, err)
-	grpcStatus, ok := status.FromError(err)
-	assert.True(t, ok)
-	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
-	require.ErrorContains(t, err, "secrets")
+	require.NoError(t, err)
 }
 
-func TestCreateVolume_Err_HasSecretsValue(t *testing.T) {
+func TestCreateVolume_Err_InvalidSecrets(t *testing.T) {
 	d := NewFakeDriver()
 	req := buildCreateVolumeRequest()
 	req.Secrets = map[string]string{"test": "test"}
@@ -611,19 +927,138 @@ func TestCreateVolume_Err_HasSecretsValue(t *testing.T) {
 	grpcStatus, ok := status.FromError(err)
 	assert.True(t, ok)
 	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
-	require.ErrorContains(t, err, "secrets")
+	require.ErrorContains(t, err, "CreateVolume provisioner secrets must contain subscription-id")
 }
 
-func TestCreateVolume_Err_HasAccessibilityRequirements(t *testing.T) {
+func TestCreateVolume_Success_HasAccessibilityRequirements(t *testing.T) {
 	d := NewFakeDriver()
 	req := buildCreateVolumeRequest()
-	req.AccessibilityRequirements = &csi.TopologyRequirement{}
+	req.AccessibilityRequirements = &csi.TopologyRequirement{
+		Preferred: []*csi.Topology{
+			{Segments: map[string]string{topologyZoneKey: "test-location-zone1"}},
+		},
+	}
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, rep.GetVolume().GetAccessibleTopology())
+}
+
+func buildTopologyRequirement(preferredZones, requisiteZones []string) *csi.TopologyRequirement {
+	requirement := &csi.TopologyRequirement{}
+	for _, zone := range preferredZones {
+		requirement.Preferred = append(requirement.Preferred, &csi.Topology{Segments: map[string]string{topologyZoneKey: zone}})
+	}
+	for _, zone := range requisiteZones {
+		requirement.Requisite = append(requirement.Requisite, &csi.Topology{Segments: map[string]string{topologyZoneKey: zone}})
+	}
+	return requirement
+}
+
+func TestDynamicCreateVolume_Success_AccessibilityRequirements(t *testing.T) {
+	tests := []struct {
+		desc             string
+		zone             string
+		requirements     *csi.TopologyRequirement
+		expectedZone     string
+		expectedTopology []*csi.Topology
+	}{
+		{
+			desc: "zone from preferred topology",
+			requirements: buildTopologyRequirement(
+				[]string{"test-location-zone2", "test-location-zone1"},
+				[]string{"test-location-zone1", "test-location-zone2"},
+			),
+			expectedZone: "zone2",
+			expectedTopology: []*csi.Topology{
+				{Segments: map[string]string{topologyZoneKey: "test-location-zone2"}},
+			},
+		},
+		{
+			desc: "preferred topology not available for SKU",
+			requirements: buildTopologyRequirement(
+				[]string{"test-location-zone4", "test-location-zone3"},
+				[]string{"test-location-zone4", "test-location-zone3"},
+			),
+			expectedZone: "zone3",
+			expectedTopology: []*csi.Topology{
+				{Segments: map[string]string{topologyZoneKey: "test-location-zone3"}},
+			},
+		},
+		{
+			desc:         "zone from requisite topology",
+			requirements: buildTopologyRequirement(nil, []string{"0", "test-location-zone1"}),
+			expectedZone: "zone1",
+			expectedTopology: []*csi.Topology{
+				{Segments: map[string]string{topologyZoneKey: "test-location-zone1"}},
+			},
+		},
+		{
+			desc: "zone parameter takes precedence",
+			zone: "zone1",
+			requirements: buildTopologyRequirement(
+				[]string{"test-location-zone2"},
+				[]string{"test-location-zone1", "test-location-zone2"},
+			),
+			expectedZone: "zone1",
+			expectedTopology: []*csi.Topology{
+				{Segments: map[string]string{topologyZoneKey: "test-location-zone1"}},
+			},
+		},
+		{
+			desc:             "zone parameter with non-zonal nodes",
+			zone:             "zone1",
+			requirements:     buildTopologyRequirement([]string{"0"}, []string{"0"}),
+			expectedZone:     "zone1",
+			expectedTopology: nil,
+		},
+		{
+			desc:             "zone parameter without requirements",
+			zone:             "zone1",
+			requirements:     nil,
+			expectedZone:     "zone1",
+			expectedTopology: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			d.cloud = azure.GetTestCloud(ctrl)
+			fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+			d.dynamicProvisioner = fakeDynamicProvisioner
+			req := buildDynamicProvCreateVolumeRequest()
+			delete(req.Parameters, "zone")
+			if test.zone != "" {
+				req.Parameters["zone"] = test.zone
+			}
+			req.AccessibilityRequirements = test.requirements
+
+			rep, err := d.CreateVolume(context.Background(), req)
+			require.NoError(t, err)
+			require.Len(t, fakeDynamicProvisioner.Filesystems, 1)
+			assert.Equal(t, test.expectedZone, fakeDynamicProvisioner.Filesystems[0].Zone)
+			assert.Equal(t, test.expectedTopology, rep.GetVolume().GetAccessibleTopology())
+		})
+	}
+}
+
+func TestDynamicCreateVolume_Err_NoZoneInAccessibilityRequirements(t *testing.T) {
+	d := NewFakeDriver()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d.cloud = azure.GetTestCloud(ctrl)
+	req := buildDynamicProvCreateVolumeRequest()
+	delete(req.Parameters, "zone")
+	req.AccessibilityRequirements = buildTopologyRequirement([]string{"0"}, []string{"0", "test-location-zone4"})
+
 	_, err := d.CreateVolume(context.Background(), req)
 	require.Error(t, err)
 	grpcStatus, ok := status.FromError(err)
 	assert.True(t, ok)
 	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
-	require.ErrorContains(t, err, "accessibility_requirements")
+	require.ErrorContains(t, err, "accessibility requirement")
 }
 
 func TestCreateVolume_Err_BlockVolume(t *testing.T) {
@@ -814,6 +1249,66 @@ func TestDynamicDeleteVolume_Err_DeleteError(t *testing.T) {
 	require.ErrorContains(t, err, clusterRequestFailureName)
 }
 
+func TestDynamicDeleteVolume_Success_ArchiveOnDelete(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
+
+	req := &csi.DeleteVolumeRequest{
+		VolumeId: fmt.Sprintf(volumeIDTemplate,
+			"test_volume", "testFs", "127.0.0.1", "testSubDir", "t", "testResourceGroupName") + "#/",
+	}
+	_, err := d.DeleteVolume(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, fakeDynamicProvisioner.fakeCallCount, 2, "unexpected calls made to dynamic provisioner, all calls: %#v", fakeDynamicProvisioner.fakeCallCount)
+	assert.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["ArchiveAmlFilesystem"])
+	assert.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["DeleteAmlFilesystem"])
+}
+
+func TestDynamicDeleteVolume_Err_ArchiveError(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
+
+	req := &csi.DeleteVolumeRequest{
+		VolumeId: fmt.Sprintf(volumeIDTemplate,
+			archiveRequestFailureName, "testFs", "127.0.0.1", "testSubDir", "t", "testResourceGroupName") + "#/",
+	}
+	_, err := d.DeleteVolume(context.Background(), req)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Aborted, grpcStatus.Code())
+	require.ErrorContains(t, err, "error when archiving AMLFS")
+	assert.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["ArchiveAmlFilesystem"])
+	assert.Zero(t, fakeDynamicProvisioner.fakeCallCount["DeleteAmlFilesystem"], "cluster must not be deleted when archive fails")
+}
+
+func TestDynamicDeleteVolume_Queued(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
+	d.amlFilesystemOperations = newAmlFilesystemOperationQueue(1)
+	otherOperationKey := createOperationKeyPrefix + "testResourceGroupName/other_volume"
+	assert.Zero(t, d.amlFilesystemOperations.tryAcquire(otherOperationKey))
+
+	req := &csi.DeleteVolumeRequest{
+		VolumeId: fmt.Sprintf(volumeIDTemplate,
+			"test_volume", "testFs", "127.0.0.1", "testSubDir", "t", "testResourceGroupName"),
+	}
+	_, err := d.DeleteVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	require.ErrorContains(t, err, "DeleteVolume deletion of AMLFS cluster test_volume is queued at position 1")
+	assert.Empty(t, fakeDynamicProvisioner.fakeCallCount)
+
+	d.amlFilesystemOperations.release(otherOperationKey)
+	_, err = d.DeleteVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"DeleteAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Zero(t, d.amlFilesystemOperations.inProgress())
+}
+
 func TestDeleteVolume_Err_NoVolumeID(t *testing.T) {
 	d := NewFakeDriver()
 	req := &csi.DeleteVolumeRequest{
@@ -836,19 +1331,18 @@ func TestDeleteVolume_Success_InvalidVolumeID(t *testing.T) {
 	require.NoError(t, err)
 }
 
-func TestDeleteVolume_Err_HasSecrets(t *testing.T) {
+func TestDeleteVolume_Success_EmptySecrets(t *testing.T) {
 	d := NewFakeDriver()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
 	req := &csi.DeleteVolumeRequest{
 		VolumeId: fmt.Sprintf(volumeIDTemplate,
 			"test_volume", "testFs", "127.0.0.1", "testSubDir", "t", "testResourceGroupName"),
 		Secrets: map[string]string{},
 	}
 	_, err := d.DeleteVolume(context.Background(), req)
-	require.Error(t, err)
-	grpcStatus, ok := status.FromError(err)
-	assert.True(t, ok)
-	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
-	require.ErrorContains(t, err, "secrets")
+	require.NoError(t, err)
+	assert.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["DeleteAmlFilesystem"])
 }
 
 func TestDynamicDeleteVolume_Err_NoResourceGroup(t *testing.T) {
@@ -872,7 +1366,7 @@ func TestDynamicDeleteVolume_Err_NoResourceGroup(t *testing.T) {
 	require.Empty(t, fakeDynamicProvisioner.fakeCallCount, "unexpected calls made to dynamic provisioner, all calls: %#v", fakeDynamicProvisioner.fakeCallCount)
 }
 
-func TestDeleteVolume_Err_HasSecretsValue(t *testing.T) {
+func TestDeleteVolume_Err_InvalidSecrets(t *testing.T) {
 	d := NewFakeDriver()
 	req := &csi.DeleteVolumeRequest{
 		VolumeId: fmt.Sprintf(volumeIDTemplate,
@@ -886,7 +1380,7 @@ func TestDeleteVolume_Err_HasSecretsValue(t *testing.T) {
 	grpcStatus, ok := status.FromError(err)
 	assert.True(t, ok)
 	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
-	require.ErrorContains(t, err, "secrets")
+	require.ErrorContains(t, err, "DeleteVolume provisioner secrets must contain subscription-id")
 }
 
 func TestDeleteVolume_Err_OperationExists(t *testing.T) {
@@ -906,11 +1400,987 @@ func TestDeleteVolume_Err_OperationExists(t *testing.T) {
 	assert.Regexp(t, "operation.*already exists", err.Error())
 }
 
-func TestValidateVolumeCapabilities_Success(t *testing.T) {
+func setSubDirProvisioningFakeMounter(t *testing.T, d *Driver) *fakeMounter {
+	t.Helper()
+	fakeMounter := &fakeMounter{}
+	d.mounter = &mount.SafeFormatAndMount{
+		Interface: fakeMounter,
+		Exec:      &testingexec.FakeExec{ExactOrder: true},
+	}
+	forceMounter, ok := d.mounter.Interface.(mount.MounterForceUnmounter)
+	require.True(t, ok, "Mounter should implement MounterForceUnmounter")
+	d.forceMounter = &forceMounter
+	d.workingMountDir = t.TempDir()
+	return fakeMounter
+}
+
+func buildProvisionSubDirCreateVolumeRequest() *csi.CreateVolumeRequest {
+	req := buildCreateVolumeRequest()
+	req.Parameters = map[string]string{
+		"mgs-ip-address":                   "127.0.0.1",
+		"provision-sub-dir":                "true",
+		"csi.storage.k8s.io/pvc/name":      "pvc_name",
+		"csi.storage.k8s.io/pvc/namespace": "pvc_namespace",
+		"csi.storage.k8s.io/pv/name":       "pv_name",
+	}
+	return req
+}
+
+func TestCreateVolume_Success_ProvisionSubDir(t *testing.T) {
 	d := NewFakeDriver()
-	capabilities := []*csi.VolumeCapability{}
-	for _, capability := range volumeCapabilities {
-		capabilities = append(
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
+	fakeMounter := setSubDirProvisioningFakeMounter(t, d)
+	commandLines := addFakeLfsCommands(t, d, withFreeProject(fakeLfsCommand{}, fakeLfsCommand{})...)
+	req := buildProvisionSubDirCreateVolumeRequest()
+	req.Parameters["sub-dir"] = "/${pvc.metadata.namespace}/${pvc.metadata.name}/"
+	req.CapacityRange = &csi.CapacityRange{RequiredBytes: 10 * util.GiB}
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, fakeDynamicProvisioner.fakeCallCount, "unexpected calls made to dynamic provisioner, all calls: %#v", fakeDynamicProvisioner.fakeCallCount)
+	assert.Equal(t, "test_volume#lustrefs#127.0.0.1#pvc_namespace/pvc_name#f###delete#1498690128", rep.GetVolume().GetVolumeId())
+	assert.Equal(t, int64(10*util.GiB), rep.GetVolume().GetCapacityBytes())
+	assert.Equal(t, "pvc_namespace/pvc_name", rep.GetVolume().GetVolumeContext()["sub-dir"])
+	assert.Equal(t, "delete", rep.GetVolume().GetVolumeContext()["sub-dir-on-delete"])
+	assert.Equal(t, "1498690128", rep.GetVolume().GetVolumeContext()["sub-dir-project-id"])
+	assert.DirExists(t, filepath.Join(d.workingMountDir, "test_volume", "pvc_namespace", "pvc_name"))
+
+	mountTarget := filepath.Join(d.workingMountDir, "test_volume")
+	assert.Equal(t, []string{
+		"lfs project -d " + filepath.Join(mountTarget, "pvc_namespace", "pvc_name"),
+		"lfs quota -q -p 1498690128 " + mountTarget,
+		"lfs project -p 1498690128 -s -r " + filepath.Join(mountTarget, "pvc_namespace", "pvc_name"),
+		"lfs setquota -p 1498690128 -B 10485760 -I 655360 " + mountTarget,
+	}, *commandLines)
+	assert.Equal(t, []mount.FakeAction{
+		{Action: "mount", Target: mountTarget, Source: "127.0.0.1@tcp:/lustrefs", FSType: "lustre"},
+		{Action: "unmount", Target: mountTarget},
+	}, fakeMounter.GetLog())
+}
+
+func TestCreateVolume_Success_ProvisionSubDirProjectIDInUse(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	addFakeLfsCommands(t, d,
+		fakeLfsCommand{output: "0 - test_volume\n"},
+		fakeLfsCommand{output: "/mnt/lustre 4 0 1024 - 1 0 64 -\n"},
+		fakeLfsCommand{output: "/mnt/lustre 0 0 0 - 0 0 0 -\n"},
+		fakeLfsCommand{},
+		fakeLfsCommand{},
+	)
+	req := buildProvisionSubDirCreateVolumeRequest()
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "test_volume#lustrefs#127.0.0.1#test_volume#f###delete#1498690129", rep.GetVolume().GetVolumeId())
+	assert.Equal(t, "1498690129", rep.GetVolume().GetVolumeContext()["sub-dir-project-id"])
+}
+
+func TestCreateVolume_Success_ProvisionSubDirDefaultsToVolumeName(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	req := buildProvisionSubDirCreateVolumeRequest()
+	req.Parameters["sub-dir-on-delete"] = "Retain"
+	req.Parameters["sub-dir-quota"] = "false"
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "test_volume#lustrefs#127.0.0.1#test_volume#f###retain", rep.GetVolume().GetVolumeId())
+	assert.DirExists(t, filepath.Join(d.workingMountDir, "test_volume", "test_volume"))
+}
+
+func TestCreateVolume_Success_ProvisionSubDirFSNameAndLNetName(t *testing.T) {
+	d := NewFakeDriver()
+	fakeMounter := setSubDirProvisioningFakeMounter(t, d)
+	req := buildProvisionSubDirCreateVolumeRequest()
+	req.Parameters["mgs-ip-address"] = "127.0.0.1@tcp1:127.0.0.2@tcp1"
+	req.Parameters["fs-name"] = "scratch"
+	req.Parameters["sub-dir-quota"] = "false"
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "test_volume#scratch#127.0.0.1@tcp1:127.0.0.2@tcp1#test_volume#f###delete", rep.GetVolume().GetVolumeId())
+
+	mountTarget := filepath.Join(d.workingMountDir, "test_volume")
+	assert.Equal(t, []mount.FakeAction{
+		{Action: "mount", Target: mountTarget, Source: "127.0.0.1@tcp1:127.0.0.2@tcp1:/scratch", FSType: "lustre"},
+		{Action: "unmount", Target: mountTarget},
+	}, fakeMounter.GetLog())
+}
+
+func TestCreateVolume_Success_ProvisionSubDirMockMount(t *testing.T) {
+	d := NewFakeDriver()
+	d.enableAzureLustreMockMount = true
+	fakeMounter := setSubDirProvisioningFakeMounter(t, d)
+	req := buildProvisionSubDirCreateVolumeRequest()
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "test_volume#lustrefs#127.0.0.1#test_volume#f###delete#1498690128", rep.GetVolume().GetVolumeId())
+	assert.Empty(t, fakeMounter.GetLog())
+}
+
+func TestCreateVolume_Err_ProvisionSubDir(t *testing.T) {
+	cases := []struct {
+		desc          string
+		parameters    map[string]string
+		expectedError string
+	}{
+		{
+			desc:          "invalid provision-sub-dir",
+			parameters:    map[string]string{"provision-sub-dir": "yes please"},
+			expectedError: "provision-sub-dir must be a boolean value",
+		},
+		{
+			desc:          "invalid sub-dir-on-delete",
+			parameters:    map[string]string{"sub-dir-on-delete": "archive"},
+			expectedError: "sub-dir-on-delete must be one of",
+		},
+		{
+			desc:          "sub-dir-on-delete without provision-sub-dir",
+			parameters:    map[string]string{"provision-sub-dir": "false", "sub-dir-on-delete": "retain"},
+			expectedError: "sub-dir-on-delete can only be used when provision-sub-dir is true",
+		},
+		{
+			desc:          "invalid sub-dir-quota",
+			parameters:    map[string]string{"sub-dir-quota": "always"},
+			expectedError: "sub-dir-quota must be a boolean value",
+		},
+		{
+			desc:          "sub-dir-quota without provision-sub-dir",
+			parameters:    map[string]string{"provision-sub-dir": "false", "sub-dir-quota": "true"},
+			expectedError: "sub-dir-quota can only be used when provision-sub-dir is true",
+		},
+		{
+			desc:          "no mgs-ip-address",
+			parameters:    map[string]string{"mgs-ip-address": ""},
+			expectedError: "provision-sub-dir can only be used with mgs-ip-address",
+		},
+		{
+			desc:          "pod metadata in sub-dir",
+			parameters:    map[string]string{"sub-dir": "${pod.metadata.name}"},
+			expectedError: "sub-dir can only use",
+		},
+		{
+			desc:          "sub-dir outside of cluster root",
+			parameters:    map[string]string{"sub-dir": "../other"},
+			expectedError: "sub-dir must be a strict subpath",
+		},
+		{
+			desc:          "sub-dir with separator",
+			parameters:    map[string]string{"sub-dir": "test#dir"},
+			expectedError: "sub-dir must be a strict subpath",
+		},
+		{
+			desc:          "sub-dir shared by all volumes",
+			parameters:    map[string]string{"sub-dir": "shared"},
+			expectedError: "sub-dir must contain ${pvc.metadata.name} or ${pv.metadata.name} when provision-sub-dir is true",
+		},
+		{
+			desc:          "sub-dir shared by the volumes of a namespace",
+			parameters:    map[string]string{"sub-dir": "${pvc.metadata.namespace}"},
+			expectedError: "sub-dir must contain ${pvc.metadata.name} or ${pv.metadata.name} when provision-sub-dir is true",
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			fakeMounter := setSubDirProvisioningFakeMounter(t, d)
+			req := buildProvisionSubDirCreateVolumeRequest()
+			maps.Copy(req.Parameters, test.parameters)
+
+			_, err := d.CreateVolume(context.Background(), req)
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.ErrorContains(t, err, test.expectedError)
+			assert.Empty(t, fakeMounter.GetLog())
+		})
+	}
+}
+
+func TestCreateVolume_Err_ProvisionSubDirMountError(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	req := buildProvisionSubDirCreateVolumeRequest()
+	req.Name = "error_mount_sens_mountflags"
+
+	_, err := d.CreateVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "CreateVolume error when creating sub-dir")
+}
+
+func TestCreateVolume_Err_ProvisionSubDirQuotaError(t *testing.T) {
+	d := NewFakeDriver()
+	fakeMounter := setSubDirProvisioningFakeMounter(t, d)
+	addFakeLfsCommands(t, d, withFreeProject(fakeLfsCommand{}, fakeLfsCommand{output: "Operation not supported", err: errors.New("exit status 1")})...)
+	req := buildProvisionSubDirCreateVolumeRequest()
+
+	_, err := d.CreateVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.ErrorContains(t, err, "project quotas must be enabled on the Lustre filesystem")
+
+	mountTarget := filepath.Join(d.workingMountDir, "test_volume")
+	assert.Equal(t, []mount.FakeAction{
+		{Action: "mount", Target: mountTarget, Source: "127.0.0.1@tcp:/lustrefs", FSType: "lustre"},
+		{Action: "unmount", Target: mountTarget},
+	}, fakeMounter.GetLog())
+}
+
+func TestDeleteVolume_Success_ProvisionedSubDirDelete(t *testing.T) {
+	d := NewFakeDriver()
+	fakeMounter := setSubDirProvisioningFakeMounter(t, d)
+	subDirPath := filepath.Join(d.workingMountDir, "test_volume", "testSubDir")
+	require.NoError(t, os.MkdirAll(filepath.Join(subDirPath, "nested"), 0o750))
+
+	req := &csi.DeleteVolumeRequest{
+		VolumeId: "test_volume#lustrefs#127.0.0.1#testSubDir#f###delete",
+	}
+	_, err := d.DeleteVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.NoDirExists(t, subDirPath)
+
+	mountTarget := filepath.Join(d.workingMountDir, "test_volume")
+	assert.Equal(t, []mount.FakeAction{
+		{Action: "mount", Target: mountTarget, Source: "127.0.0.1@tcp:/lustrefs", FSType: "lustre"},
+		{Action: "unmount", Target: mountTarget},
+	}, fakeMounter.GetLog())
+}
+
+func TestDeleteVolume_Success_ProvisionedSubDirDeleteClearsQuota(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	commandLines := addFakeLfsCommands(t, d, fakeLfsCommand{})
+	subDirPath := filepath.Join(d.workingMountDir, "test_volume", "testSubDir")
+	require.NoError(t, os.MkdirAll(subDirPath, 0o750))
+
+	req := &csi.DeleteVolumeRequest{
+		VolumeId: "test_volume#lustrefs#127.0.0.1#testSubDir#f###delete#42",
+	}
+	_, err := d.DeleteVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.NoDirExists(t, subDirPath)
+	assert.Equal(t, []string{
+		"lfs setquota -p 42 -B 0 -I 0 " + filepath.Join(d.workingMountDir, "test_volume"),
+	}, *commandLines)
+}
+
+func TestDeleteVolume_Success_ProvisionedSubDirRetain(t *testing.T) {
+	d := NewFakeDriver()
+	fakeMounter := setSubDirProvisioningFakeMounter(t, d)
+
+	req := &csi.DeleteVolumeRequest{
+		VolumeId: "test_volume#lustrefs#127.0.0.1#testSubDir#f###retain",
+	}
+	_, err := d.DeleteVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, fakeMounter.GetLog())
+}
+
+func TestDeleteVolume_Err_ProvisionedSubDirInvalidPolicy(t *testing.T) {
+	d := NewFakeDriver()
+	fakeMounter := setSubDirProvisioningFakeMounter(t, d)
+
+	req := &csi.DeleteVolumeRequest{
+		VolumeId: "test_volume#lustrefs#127.0.0.1#testSubDir#f###archive",
+	}
+	_, err := d.DeleteVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Empty(t, fakeMounter.GetLog())
+}
+
+func TestDeleteVolume_Err_ProvisionedSubDirMountError(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+
+	req := &csi.DeleteVolumeRequest{
+		VolumeId: "error_mount_sens_mountflags#lustrefs#127.0.0.1#testSubDir#f###delete",
+	}
+	_, err := d.DeleteVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "DeleteVolume error when removing sub-dir")
+}
+
+func buildListVolumesFakeDynamicProvisioner() *FakeDynamicProvisioner {
+	return &FakeDynamicProvisioner{
+		Filesystems: []*AmlFilesystemProperties{
+			{
+				AmlFilesystemName:  "test_volume_1",
+				ResourceGroupName:  "test-resource-group",
+				StorageCapacityTiB: 8,
+				Tags: map[string]string{
+					createdByTag: azureLustreDriverTag,
+				},
+			},
+			{
+				AmlFilesystemName:  "test_volume_2",
+				ResourceGroupName:  "test-resource-group",
+				StorageCapacityTiB: 16,
+				Tags: map[string]string{
+					createdByTag: azureLustreDriverTag,
+					subDirTag:    "testSubDir",
+				},
+			},
+			{
+				AmlFilesystemName:  "test_volume_3",
+				ResourceGroupName:  "test-resource-group",
+				StorageCapacityTiB: 8,
+				Tags: map[string]string{
+					createdByTag:           azureLustreDriverTag,
+					archiveOnDeletePathTag: "/results",
+				},
+			},
+		},
+	}
+}
+
+func TestListVolumes_Success(t *testing.T) {
+	d := NewFakeDriver()
+	d.dynamicProvisioner = buildListVolumesFakeDynamicProvisioner()
+
+	resp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, resp.GetNextToken())
+	require.Len(t, resp.GetEntries(), 3)
+	expectedVolumeIDs := []string{
+		"test_volume_1#lustrefs#127.0.0.2##t#test-resource-group",
+		"test_volume_2#lustrefs#127.0.0.2#testSubDir#t#test-resource-group",
+		"test_volume_3#lustrefs#127.0.0.2##t#test-resource-group#/results",
+	}
+	for i, entry := range resp.GetEntries() {
+		assert.Equal(t, expectedVolumeIDs[i], entry.GetVolume().GetVolumeId())
+	}
+	assert.Equal(t, int64(16*util.TiB), resp.GetEntries()[1].GetVolume().GetCapacityBytes())
+	assert.False(t, resp.GetEntries()[0].GetStatus().GetVolumeCondition().GetAbnormal())
+}
+
+func TestListVolumes_Success_NoVolumes(t *testing.T) {
+	d := NewFakeDriver()
+	d.dynamicProvisioner = &FakeDynamicProvisioner{}
+
+	resp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, resp.GetEntries())
+	assert.Empty(t, resp.GetNextToken())
+}
+
+func newListVolumesTestPV(pvName, driverName, volumeHandle string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: pvName},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1Ti"),
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       driverName,
+					VolumeHandle: volumeHandle,
+				},
+			},
+		},
+	}
+}
+
+func TestListVolumes_Success_StaticVolumes(t *testing.T) {
+	d := NewFakeDriver(withFakeKubeClient(
+		newListVolumesTestPV("pv-static", fakeDriverName, "static_volume#lustrefs#127.0.0.3#testSubDir#f###delete"),
+		newListVolumesTestPV("pv-other-driver", "other.csi.azure.com", "other_volume"),
+		newListVolumesTestPV("pv-dynamic", fakeDriverName, "test_volume_1#lustrefs#127.0.0.2##t#test-resource-group"),
+		newListVolumesTestPV("pv-deleted-cluster", fakeDriverName, "deleted_volume#lustrefs#127.0.0.2##t#test-resource-group"),
+	))
+	d.dynamicProvisioner = buildListVolumesFakeDynamicProvisioner()
+
+	resp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	require.NoError(t, err)
+	expectedVolumeIDs := []string{
+		"static_volume#lustrefs#127.0.0.3#testSubDir#f###delete",
+		"test_volume_1#lustrefs#127.0.0.2##t#test-resource-group",
+		"test_volume_2#lustrefs#127.0.0.2#testSubDir#t#test-resource-group",
+		"test_volume_3#lustrefs#127.0.0.2##t#test-resource-group#/results",
+	}
+	volumeIDs := make([]string, 0, len(resp.GetEntries()))
+	for _, entry := range resp.GetEntries() {
+		volumeIDs = append(volumeIDs, entry.GetVolume().GetVolumeId())
+	}
+	assert.Equal(t, expectedVolumeIDs, volumeIDs)
+	assert.Equal(t, int64(util.TiB), resp.GetEntries()[0].GetVolume().GetCapacityBytes())
+	assert.False(t, resp.GetEntries()[0].GetStatus().GetVolumeCondition().GetAbnormal())
+	assert.Equal(t, staticVolumeConditionMessage, resp.GetEntries()[0].GetStatus().GetVolumeCondition().GetMessage())
+
+	resp, err = d.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 3})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEntries(), 3)
+	assert.Equal(t, "3", resp.GetNextToken())
+	resp, err = d.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 3, StartingToken: resp.GetNextToken()})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEntries(), 1)
+	assert.Equal(t, expectedVolumeIDs[3], resp.GetEntries()[0].GetVolume().GetVolumeId())
+	assert.Empty(t, resp.GetNextToken())
+}
+
+func TestListVolumes_Success_Pagination(t *testing.T) {
+	d := NewFakeDriver()
+	d.dynamicProvisioner = buildListVolumesFakeDynamicProvisioner()
+
+	resp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 2})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEntries(), 2)
+	assert.Equal(t, "2", resp.GetNextToken())
+	assert.True(t, strings.HasPrefix(resp.GetEntries()[0].GetVolume().GetVolumeId(), "test_volume_1#"))
+	assert.True(t, strings.HasPrefix(resp.GetEntries()[1].GetVolume().GetVolumeId(), "test_volume_2#"))
+
+	resp, err = d.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 2, StartingToken: resp.GetNextToken()})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEntries(), 1)
+	assert.Empty(t, resp.GetNextToken())
+	assert.True(t, strings.HasPrefix(resp.GetEntries()[0].GetVolume().GetVolumeId(), "test_volume_3#"))
+}
+
+func TestListVolumes_Err_InvalidStartingToken(t *testing.T) {
+	for _, startingToken := range []string{"invalid-token", "-1", "4"} {
+		t.Run(startingToken, func(t *testing.T) {
+			d := NewFakeDriver()
+			d.dynamicProvisioner = buildListVolumesFakeDynamicProvisioner()
+
+			_, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{StartingToken: startingToken})
+			require.Error(t, err)
+			grpcStatus, ok := status.FromError(err)
+			assert.True(t, ok)
+			assert.Equal(t, codes.Aborted, grpcStatus.Code())
+			require.ErrorContains(t, err, "starting_token")
+		})
+	}
+}
+
+func TestListVolumes_Err_NegativeMaxEntries(t *testing.T) {
+	d := NewFakeDriver()
+	d.dynamicProvisioner = buildListVolumesFakeDynamicProvisioner()
+
+	_, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: -1})
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+	require.ErrorContains(t, err, "max_entries")
+}
+
+func TestListVolumes_Err_ListError(t *testing.T) {
+	d := NewFakeDriver()
+	d.dynamicProvisioner = &FakeDynamicProvisioner{
+		Filesystems: []*AmlFilesystemProperties{
+			{AmlFilesystemName: clusterRequestFailureName},
+		},
+	}
+
+	_, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Unavailable, grpcStatus.Code())
+	require.ErrorContains(t, err, "error when listing AMLFS clusters")
+}
+
+func TestControllerGetVolume_Success(t *testing.T) {
+	d := NewFakeDriver()
+	d.dynamicProvisioner = buildListVolumesFakeDynamicProvisioner()
+
+	volumeID := "test_volume_2#lustrefs#127.0.0.2#testSubDir#t#test-resource-group"
+	resp, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+	require.NoError(t, err)
+	assert.Equal(t, volumeID, resp.GetVolume().GetVolumeId())
+	assert.Equal(t, int64(16*util.TiB), resp.GetVolume().GetCapacityBytes())
+	assert.False(t, resp.GetStatus().GetVolumeCondition().GetAbnormal())
+	assert.Contains(t, resp.GetStatus().GetVolumeCondition().GetMessage(), "Available")
+}
+
+func TestControllerGetVolume_Success_Degraded(t *testing.T) {
+	d := NewFakeDriver()
+	d.dynamicProvisioner = &FakeDynamicProvisioner{
+		Filesystems: []*AmlFilesystemProperties{
+			{
+				AmlFilesystemName:  degradedClusterName,
+				ResourceGroupName:  "test-resource-group",
+				StorageCapacityTiB: 8,
+			},
+		},
+	}
+
+	volumeID := degradedClusterName + "#lustrefs#127.0.0.2##t#test-resource-group"
+	resp, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+	require.NoError(t, err)
+	assert.True(t, resp.GetStatus().GetVolumeCondition().GetAbnormal())
+	assert.Contains(t, resp.GetStatus().GetVolumeCondition().GetMessage(), "health state is Degraded: OSS is unreachable")
+}
+
+func TestControllerGetVolume_Success_StaticVolume(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
+
+	volumeID := "test_volume#lustrefs#127.0.0.2##f#"
+	resp, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+	require.NoError(t, err)
+	assert.Equal(t, volumeID, resp.GetVolume().GetVolumeId())
+	assert.False(t, resp.GetStatus().GetVolumeCondition().GetAbnormal())
+	assert.Equal(t, 0, fakeDynamicProvisioner.fakeCallCount["GetAmlFilesystem"])
+}
+
+func TestControllerGetVolume_Err_NoVolumeID(t *testing.T) {
+	d := NewFakeDriver()
+	_, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{})
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+	require.ErrorContains(t, err, "Volume ID missing")
+}
+
+func TestControllerGetVolume_Err_InvalidVolumeID(t *testing.T) {
+	d := NewFakeDriver()
+	_, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "invalid-volume-id"})
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.NotFound, grpcStatus.Code())
+}
+
+func TestControllerGetVolume_Err_NoResourceGroup(t *testing.T) {
+	d := NewFakeDriver()
+	_, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{
+		VolumeId: "test_volume#lustrefs#127.0.0.2##t#",
+	})
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+	require.ErrorContains(t, err, "resource group is not specified")
+}
+
+func TestControllerGetVolume_Err_NotFound(t *testing.T) {
+	d := NewFakeDriver()
+	d.dynamicProvisioner = buildListVolumesFakeDynamicProvisioner()
+
+	_, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{
+		VolumeId: "missing_volume#lustrefs#127.0.0.2##t#test-resource-group",
+	})
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.NotFound, grpcStatus.Code())
+}
+
+func TestControllerGetVolume_Err_GetError(t *testing.T) {
+	d := NewFakeDriver()
+	_, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{
+		VolumeId: clusterRequestFailureName + "#lustrefs#127.0.0.2##t#test-resource-group",
+	})
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Unavailable, grpcStatus.Code())
+	require.ErrorContains(t, err, "error when getting AMLFS")
+}
+
+func TestGetVolumeCondition(t *testing.T) {
+	tests := []struct {
+		desc             string
+		amlFilesystem    *AmlFilesystemInfo
+		expectedAbnormal bool
+		expectedMessage  string
+	}{
+		{
+			desc: "healthy cluster",
+			amlFilesystem: &AmlFilesystemInfo{
+				Name:              "test",
+				ProvisioningState: armstoragecache.AmlFilesystemProvisioningStateTypeSucceeded,
+				HealthState:       armstoragecache.AmlFilesystemHealthStateTypeAvailable,
+				ArchiveState:      armstoragecache.ArchiveStatusTypeCompleted,
+			},
+			expectedAbnormal: false,
+			expectedMessage:  "AMLFS cluster test health state is Available",
+		},
+		{
+			desc:             "no health reported",
+			amlFilesystem:    &AmlFilesystemInfo{Name: "test"},
+			expectedAbnormal: false,
+			expectedMessage:  "AMLFS cluster test is healthy",
+		},
+		{
+			desc: "failed provisioning",
+			amlFilesystem: &AmlFilesystemInfo{
+				Name:              "test",
+				ProvisioningState: armstoragecache.AmlFilesystemProvisioningStateTypeFailed,
+			},
+			expectedAbnormal: true,
+			expectedMessage:  "AMLFS cluster test provisioning state is Failed",
+		},
+		{
+			desc: "maintenance",
+			amlFilesystem: &AmlFilesystemInfo{
+				Name:        "test",
+				HealthState: armstoragecache.AmlFilesystemHealthStateTypeMaintenance,
+			},
+			expectedAbnormal: true,
+			expectedMessage:  "AMLFS cluster test health state is Maintenance",
+		},
+		{
+			desc: "unavailable and failed archive",
+			amlFilesystem: &AmlFilesystemInfo{
+				Name:                    "test",
+				HealthState:             armstoragecache.AmlFilesystemHealthStateTypeUnavailable,
+				HealthStatusDescription: "MGS is down",
+				ArchiveState:            armstoragecache.ArchiveStatusTypeFailed,
+				ArchiveErrorMessage:     "container not found",
+			},
+			expectedAbnormal: true,
+			expectedMessage:  "AMLFS cluster test health state is Unavailable: MGS is down, last HSM archive failed: container not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			volumeCondition := getVolumeCondition(test.amlFilesystem)
+			assert.Equal(t, test.expectedAbnormal, volumeCondition.GetAbnormal())
+			assert.Equal(t, test.expectedMessage, volumeCondition.GetMessage())
+		})
+	}
+}
+
+func buildGetCapacityRequest() *csi.GetCapacityRequest {
+	return &csi.GetCapacityRequest{
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{
+			"location":                    "test-location",
+			"vnet-resource-group":         "test-vnet-rg",
+			"vnet-name":                   "test-vnet-name",
+			"subnet-name":                 "test-subnet-name",
+			"maintenance-day-of-week":     "Monday",
+			"maintenance-time-of-day-utc": "12:00",
+			"sku-name":                    "AMLFS-Durable-Premium-250",
+		},
+	}
+}
+
+func TestGetCapacity_Success(t *testing.T) {
+	tests := []struct {
+		desc             string
+		zone             string
+		topologyZone     string
+		expectedCapacity int64
+	}{
+		{
+			desc:             "zone from parameters",
+			zone:             "zone1",
+			expectedCapacity: 128 * util.TiB,
+		},
+		{
+			desc:             "zone from topology",
+			topologyZone:     "test-location-zone2",
+			expectedCapacity: 128 * util.TiB,
+		},
+		{
+			desc:             "matching zone in parameters and topology",
+			zone:             "zone2",
+			topologyZone:     "test-location-zone2",
+			expectedCapacity: 128 * util.TiB,
+		},
+		{
+			desc:             "non-zonal topology",
+			zone:             "zone3",
+			topologyZone:     "0",
+			expectedCapacity: 128 * util.TiB,
+		},
+		{
+			desc:             "different zone in parameters and topology",
+			zone:             "zone1",
+			topologyZone:     "test-location-zone2",
+			expectedCapacity: 0,
+		},
+		{
+			desc:             "zone not available for SKU",
+			topologyZone:     "test-location-zone4",
+			expectedCapacity: 0,
+		},
+		{
+			desc:             "no zone",
+			expectedCapacity: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			req := buildGetCapacityRequest()
+			if test.zone != "" {
+				req.Parameters["zone"] = test.zone
+			}
+			if test.topologyZone != "" {
+				req.AccessibleTopology = &csi.Topology{
+					Segments: map[string]string{topologyZoneKey: test.topologyZone},
+				}
+			}
+
+			resp, err := d.GetCapacity(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedCapacity, resp.GetAvailableCapacity())
+			if test.expectedCapacity > 0 {
+				assert.Equal(t, test.expectedCapacity, resp.GetMaximumVolumeSize().GetValue())
+				assert.Equal(t, int64(8*util.TiB), resp.GetMinimumVolumeSize().GetValue())
+			}
+		})
+	}
+}
+
+func TestGetCapacity_Success_NoZonesInLocation(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildGetCapacityRequest()
+	req.Parameters["location"] = emptyZonesLocation
+
+	resp, err := d.GetCapacity(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(128*util.TiB), resp.GetAvailableCapacity())
+}
+
+func TestGetCapacity_Success_CSIParameters(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildGetCapacityRequest()
+	req.Parameters["zone"] = "zone1"
+	req.Parameters["csi.storage.k8s.io/fstype"] = "lustre"
+	req.Parameters["csi.storage.k8s.io/node-publish-secret-name"] = "test-secret"
+	req.Parameters["csi.storage.k8s.io/node-publish-secret-namespace"] = "default"
+
+	resp, err := d.GetCapacity(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(128*util.TiB), resp.GetAvailableCapacity())
+	assert.Equal(t, "lustre", req.GetParameters()["csi.storage.k8s.io/fstype"], "parameters of the request must not be modified")
+}
+
+func TestGetCapacity_Success_NoParameters(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
+
+	resp, err := d.GetCapacity(context.Background(), &csi.GetCapacityRequest{})
+	require.NoError(t, err)
+	assert.Zero(t, resp.GetAvailableCapacity())
+	assert.Empty(t, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestGetCapacity_Success_StaticVolume(t *testing.T) {
+	tests := []struct {
+		desc       string
+		parameters map[string]string
+	}{
+		{
+			desc: "MGS address",
+			parameters: map[string]string{
+				"mgs-ip-address": "127.0.0.1",
+				"fs-name":        "tfs",
+			},
+		},
+		{
+			desc: "existing cluster",
+			parameters: map[string]string{
+				"amlfs-name":          "existing-amlfs",
+				"resource-group-name": "existing-rg",
+			},
+		},
+		{
+			desc: "existing cluster with sub-dirs",
+			parameters: map[string]string{
+				"amlfs-name":          "existing-amlfs",
+				"provision-sub-dir":   "true",
+				"sub-dir":             "${pvc.metadata.name}",
+				"subscription-id":     "other-subscription",
+				"resource-group-name": "existing-rg",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+			d.dynamicProvisioner = fakeDynamicProvisioner
+
+			resp, err := d.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+				Parameters: test.parameters,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, int64(math.MaxInt64), resp.GetAvailableCapacity())
+			assert.Empty(t, fakeDynamicProvisioner.fakeCallCount)
+		})
+	}
+}
+
+func TestGetCapacity_Err_InvalidAmlfsName(t *testing.T) {
+	d := NewFakeDriver()
+
+	_, err := d.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{"amlfs-name": "invalid_name!"},
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.ErrorContains(t, err, "Parameter amlfs-name must be a valid AMLFS cluster name")
+}
+
+func TestGetCapacity_Err_InvalidParameters(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildGetCapacityRequest()
+	delete(req.Parameters, "sku-name")
+
+	_, err := d.GetCapacity(context.Background(), req)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+	require.ErrorContains(t, err, "sku-name")
+}
+
+func TestGetCapacity_Err_InvalidVolumeCapabilities(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildGetCapacityRequest()
+	req.VolumeCapabilities[0].AccessType = &csi.VolumeCapability_Block{
+		Block: &csi.VolumeCapability_BlockVolume{},
+	}
+
+	_, err := d.GetCapacity(context.Background(), req)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+}
+
+func TestGetCapacity_Err_GetAvailableCapacityError(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildGetCapacityRequest()
+	req.Parameters["zone"] = "zone1"
+	req.Parameters["subnet-name"] = clusterRequestFailureName
+
+	_, err := d.GetCapacity(context.Background(), req)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Unavailable, grpcStatus.Code())
+	require.ErrorContains(t, err, "error when getting available capacity")
+}
+
+func TestGetZoneFromTopology(t *testing.T) {
+	tests := []struct {
+		desc         string
+		topology     *csi.Topology
+		expectedZone string
+	}{
+		{
+			desc:         "nil topology",
+			topology:     nil,
+			expectedZone: "",
+		},
+		{
+			desc:         "no zone segment",
+			topology:     &csi.Topology{Segments: map[string]string{"other": "value"}},
+			expectedZone: "",
+		},
+		{
+			desc:         "zonal node",
+			topology:     &csi.Topology{Segments: map[string]string{topologyZoneKey: "eastus-2"}},
+			expectedZone: "2",
+		},
+		{
+			desc:         "different case location",
+			topology:     &csi.Topology{Segments: map[string]string{topologyZoneKey: "EastUS-3"}},
+			expectedZone: "3",
+		},
+		{
+			desc:         "non-zonal node",
+			topology:     &csi.Topology{Segments: map[string]string{topologyZoneKey: "0"}},
+			expectedZone: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expectedZone, getZoneFromTopology(test.topology, "eastus"))
+		})
+	}
+}
+
+func TestControllerExpandVolume_Err_NoVolumeID(t *testing.T) {
+	d := NewFakeDriver()
+	req := &csi.ControllerExpandVolumeRequest{
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 8 * util.TiB},
+	}
+	_, err := d.ControllerExpandVolume(context.Background(), req)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+	require.ErrorContains(t, err, "Volume ID missing")
+}
+
+func TestControllerExpandVolume_Err_NoCapacityRange(t *testing.T) {
+	d := NewFakeDriver()
+	req := &csi.ControllerExpandVolumeRequest{
+		VolumeId: fmt.Sprintf(volumeIDTemplate,
+			"test_volume", "testFs", "127.0.0.1", "testSubDir", "t", "testResourceGroupName"),
+	}
+	_, err := d.ControllerExpandVolume(context.Background(), req)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+	require.ErrorContains(t, err, "Capacity range must be provided")
+}
+
+func TestControllerExpandVolume_Err_StaticVolume(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
+	req := &csi.ControllerExpandVolumeRequest{
+		VolumeId: fmt.Sprintf(volumeIDTemplate,
+			"test_volume", "testFs", "127.0.0.1", "testSubDir", "f", ""),
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 8 * util.TiB},
+	}
+	_, err := d.ControllerExpandVolume(context.Background(), req)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+	require.ErrorContains(t, err, "not created by dynamic provisioning")
+	require.Empty(t, fakeDynamicProvisioner.fakeCallCount, "unexpected calls made to dynamic provisioner, all calls: %#v", fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestControllerExpandVolume_Err_DynamicVolume(t *testing.T) {
+	d := NewFakeDriver()
+	req := &csi.ControllerExpandVolumeRequest{
+		VolumeId: fmt.Sprintf(volumeIDTemplate,
+			"test_volume", "testFs", "127.0.0.1", "testSubDir", "t", "testResourceGroupName"),
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 8 * util.TiB},
+	}
+	_, err := d.ControllerExpandVolume(context.Background(), req)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Unimplemented, grpcStatus.Code())
+	require.ErrorContains(t, err, "test_volume cannot be expanded")
+}
+
+func TestValidateVolumeCapabilities_Success(t *testing.T) {
+	d := NewFakeDriver()
+	capabilities := []*csi.VolumeCapability{}
+	for _, capability := range volumeCapabilities {
+		capabilities = append(
 			capabilities,
 			&csi.VolumeCapability{
 				AccessType: &csi.VolumeCapability_Mount{},
@@ -1155,6 +2625,407 @@ func TestParseAmlfilesystemProperties_Success(t *testing.T) {
 	assert.Equal(t, expected, result)
 }
 
+func TestParseAmlfilesystemProperties_Success_Hsm(t *testing.T) {
+	properties := map[string]string{
+		"maintenance-day-of-week":     "Monday",
+		"maintenance-time-of-day-utc": "12:00",
+		"sku-name":                    "AMLFS-Durable-Premium-40",
+		"hsm-container":               "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa/blobServices/default/containers/data",
+		"hsm-logging-container":       "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa/blobServices/default/containers/logs",
+		"hsm-import-prefixes":         "/training, /validation",
+	}
+
+	result, err := parseAmlFilesystemProperties(properties)
+	require.NoError(t, err)
+	assert.Equal(t, properties["hsm-container"], result.HsmContainer)
+	assert.Equal(t, properties["hsm-logging-container"], result.HsmLoggingContainer)
+	assert.Equal(t, []string{"/training", "/validation"}, result.HsmImportPrefixes)
+}
+
+func TestParseAmlfilesystemProperties_Err_InvalidHsm(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		hsmProperties map[string]string
+		expectedErr   string
+	}{
+		{
+			desc: "container without logging container",
+			hsmProperties: map[string]string{
+				"hsm-container": "data-container",
+			},
+			expectedErr: "hsm-container and hsm-logging-container must be provided together",
+		},
+		{
+			desc: "logging container without container",
+			hsmProperties: map[string]string{
+				"hsm-logging-container": "logging-container",
+			},
+			expectedErr: "hsm-container and hsm-logging-container must be provided together",
+		},
+		{
+			desc: "same container for data and logging",
+			hsmProperties: map[string]string{
+				"hsm-container":         "data-container",
+				"hsm-logging-container": "data-container",
+			},
+			expectedErr: "hsm-logging-container must be a different container",
+		},
+		{
+			desc: "import prefixes without container",
+			hsmProperties: map[string]string{
+				"hsm-import-prefixes": "/training",
+			},
+			expectedErr: "hsm-import-prefixes can only be used with hsm-container",
+		},
+		{
+			desc: "import prefix without leading slash",
+			hsmProperties: map[string]string{
+				"hsm-container":         "data-container",
+				"hsm-logging-container": "logging-container",
+				"hsm-import-prefixes":   "/training,validation",
+			},
+			expectedErr: "hsm-import-prefixes must be a comma-separated list of paths starting with '/'",
+		},
+		{
+			desc: "empty import prefix",
+			hsmProperties: map[string]string{
+				"hsm-container":         "data-container",
+				"hsm-logging-container": "logging-container",
+				"hsm-import-prefixes":   "",
+			},
+			expectedErr: "hsm-import-prefixes must be a comma-separated list of paths starting with '/'",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			properties := map[string]string{
+				"maintenance-day-of-week":     "Monday",
+				"maintenance-time-of-day-utc": "12:00",
+				"sku-name":                    "AMLFS-Durable-Premium-40",
+			}
+			maps.Copy(properties, tC.hsmProperties)
+
+			_, err := parseAmlFilesystemProperties(properties)
+			require.Error(t, err)
+			grpcStatus, ok := status.FromError(err)
+			assert.True(t, ok)
+			assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+			require.ErrorContains(t, err, tC.expectedErr)
+		})
+	}
+}
+
+func TestParseAmlfilesystemProperties_Success_ArchiveOnDelete(t *testing.T) {
+	testCases := []struct {
+		desc                    string
+		archiveProperties       map[string]string
+		expectedArchiveOnDelete bool
+		expectedPath            string
+	}{
+		{
+			desc: "archive on delete with default path",
+			archiveProperties: map[string]string{
+				"archive-on-delete": "true",
+			},
+			expectedArchiveOnDelete: true,
+			expectedPath:            "/",
+		},
+		{
+			desc: "archive on delete with custom path",
+			archiveProperties: map[string]string{
+				"archive-on-delete":      "true",
+				"archive-on-delete-path": "/results",
+			},
+			expectedArchiveOnDelete: true,
+			expectedPath:            "/results",
+		},
+		{
+			desc: "archive on delete disabled",
+			archiveProperties: map[string]string{
+				"archive-on-delete": "false",
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			properties := map[string]string{
+				"maintenance-day-of-week":     "Monday",
+				"maintenance-time-of-day-utc": "12:00",
+				"sku-name":                    "AMLFS-Durable-Premium-40",
+				"hsm-container":               "data-container",
+				"hsm-logging-container":       "logging-container",
+			}
+			maps.Copy(properties, tC.archiveProperties)
+
+			result, err := parseAmlFilesystemProperties(properties)
+			require.NoError(t, err)
+			assert.Equal(t, tC.expectedArchiveOnDelete, result.ArchiveOnDelete)
+			assert.Equal(t, tC.expectedPath, result.ArchiveOnDeletePath)
+		})
+	}
+}
+
+func TestParseAmlfilesystemProperties_Err_InvalidArchiveOnDelete(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		archiveProperties map[string]string
+		expectedErr       string
+	}{
+		{
+			desc: "invalid boolean",
+			archiveProperties: map[string]string{
+				"hsm-container":         "data-container",
+				"hsm-logging-container": "logging-container",
+				"archive-on-delete":     "sometimes",
+			},
+			expectedErr: "archive-on-delete must be a boolean value",
+		},
+		{
+			desc: "without hsm container",
+			archiveProperties: map[string]string{
+				"archive-on-delete": "true",
+			},
+			expectedErr: "archive-on-delete can only be used with hsm-container",
+		},
+		{
+			desc: "path without archive on delete",
+			archiveProperties: map[string]string{
+				"hsm-container":          "data-container",
+				"hsm-logging-container":  "logging-container",
+				"archive-on-delete-path": "/results",
+			},
+			expectedErr: "archive-on-delete-path can only be used when archive-on-delete is true",
+		},
+		{
+			desc: "path without leading slash",
+			archiveProperties: map[string]string{
+				"hsm-container":          "data-container",
+				"hsm-logging-container":  "logging-container",
+				"archive-on-delete":      "true",
+				"archive-on-delete-path": "results",
+			},
+			expectedErr: "archive-on-delete-path must be a path starting with '/'",
+		},
+		{
+			desc: "path with separator",
+			archiveProperties: map[string]string{
+				"hsm-container":          "data-container",
+				"hsm-logging-container":  "logging-container",
+				"archive-on-delete":      "true",
+				"archive-on-delete-path": "/results#1",
+			},
+			expectedErr: "archive-on-delete-path must be a path starting with '/'",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			properties := map[string]string{
+				"maintenance-day-of-week":     "Monday",
+				"maintenance-time-of-day-utc": "12:00",
+				"sku-name":                    "AMLFS-Durable-Premium-40",
+			}
+			maps.Copy(properties, tC.archiveProperties)
+
+			_, err := parseAmlFilesystemProperties(properties)
+			require.Error(t, err)
+			grpcStatus, ok := status.FromError(err)
+			assert.True(t, ok)
+			assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+			require.ErrorContains(t, err, tC.expectedErr)
+		})
+	}
+}
+
+func TestParseAmlfilesystemProperties_Success_RootSquash(t *testing.T) {
+	properties := map[string]string{
+		"maintenance-day-of-week":         "Monday",
+		"maintenance-time-of-day-utc":     "12:00",
+		"sku-name":                        "AMLFS-Durable-Premium-40",
+		"root-squash-mode":                "RootOnly",
+		"root-squash-no-squash-nid-lists": "10.0.0.4@tcp;10.0.0.[5-10]@tcp",
+		"root-squash-uid":                 "65534",
+		"root-squash-gid":                 "65533",
+	}
+
+	result, err := parseAmlFilesystemProperties(properties)
+	require.NoError(t, err)
+	assert.Equal(t, RootSquashProperties{
+		Mode:             "RootOnly",
+		NoSquashNidLists: "10.0.0.4@tcp;10.0.0.[5-10]@tcp",
+		SquashUID:        to.Ptr(int64(65534)),
+		SquashGID:        to.Ptr(int64(65533)),
+	}, result.RootSquashSettings)
+}
+
+func TestParseAmlfilesystemProperties_Success_RootSquashModeNone(t *testing.T) {
+	properties := map[string]string{
+		"maintenance-day-of-week":     "Monday",
+		"maintenance-time-of-day-utc": "12:00",
+		"sku-name":                    "AMLFS-Durable-Premium-40",
+		"root-squash-mode":            "None",
+	}
+
+	result, err := parseAmlFilesystemProperties(properties)
+	require.NoError(t, err)
+	assert.Equal(t, RootSquashProperties{Mode: "None"}, result.RootSquashSettings)
+}
+
+func TestParseAmlfilesystemProperties_Err_InvalidRootSquash(t *testing.T) {
+	testCases := []struct {
+		desc                 string
+		rootSquashProperties map[string]string
+		expectedErr          string
+	}{
+		{
+			desc: "invalid mode",
+			rootSquashProperties: map[string]string{
+				"root-squash-mode": "rootonly",
+			},
+			expectedErr: "root-squash-mode must be one of",
+		},
+		{
+			desc: "missing uid",
+			rootSquashProperties: map[string]string{
+				"root-squash-mode": "All",
+				"root-squash-gid":  "1000",
+			},
+			expectedErr: "root-squash-uid and root-squash-gid must be provided when root-squash-mode is All",
+		},
+		{
+			desc: "missing gid",
+			rootSquashProperties: map[string]string{
+				"root-squash-mode": "RootOnly",
+				"root-squash-uid":  "1000",
+			},
+			expectedErr: "root-squash-uid and root-squash-gid must be provided when root-squash-mode is RootOnly",
+		},
+		{
+			desc: "uid without mode",
+			rootSquashProperties: map[string]string{
+				"root-squash-uid": "1000",
+			},
+			expectedErr: "can only be used with root-squash-mode",
+		},
+		{
+			desc: "nid lists without mode",
+			rootSquashProperties: map[string]string{
+				"root-squash-no-squash-nid-lists": "10.0.0.4@tcp",
+			},
+			expectedErr: "can only be used with root-squash-mode",
+		},
+		{
+			desc: "non-numeric uid",
+			rootSquashProperties: map[string]string{
+				"root-squash-mode": "All",
+				"root-squash-uid":  "nobody",
+				"root-squash-gid":  "1000",
+			},
+			expectedErr: "root-squash-uid must be an integer",
+		},
+		{
+			desc: "negative gid",
+			rootSquashProperties: map[string]string{
+				"root-squash-mode": "All",
+				"root-squash-uid":  "1000",
+				"root-squash-gid":  "-1",
+			},
+			expectedErr: "root-squash-gid must be an integer",
+		},
+		{
+			desc: "gid out of range",
+			rootSquashProperties: map[string]string{
+				"root-squash-mode": "All",
+				"root-squash-uid":  "1000",
+				"root-squash-gid":  "4294967296",
+			},
+			expectedErr: "root-squash-gid must be an integer",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			properties := map[string]string{
+				"maintenance-day-of-week":     "Monday",
+				"maintenance-time-of-day-utc": "12:00",
+				"sku-name":                    "AMLFS-Durable-Premium-40",
+			}
+			maps.Copy(properties, tC.rootSquashProperties)
+
+			_, err := parseAmlFilesystemProperties(properties)
+			require.Error(t, err)
+			grpcStatus, ok := status.FromError(err)
+			assert.True(t, ok)
+			assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+			require.ErrorContains(t, err, tC.expectedErr)
+		})
+	}
+}
+
+func TestParseAmlfilesystemProperties_Success_Encryption(t *testing.T) {
+	properties := map[string]string{
+		"maintenance-day-of-week":     "Monday",
+		"maintenance-time-of-day-utc": "12:00",
+		"sku-name":                    "AMLFS-Durable-Premium-40",
+		"identities":                  "identity1",
+		"encryption-key-url":          "https://vault.vault.azure.net/keys/key/version",
+		"encryption-key-vault-id":     "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/vault",
+	}
+
+	result, err := parseAmlFilesystemProperties(properties)
+	require.NoError(t, err)
+	assert.Equal(t, properties["encryption-key-url"], result.EncryptionKeyURL)
+	assert.Equal(t, properties["encryption-key-vault-id"], result.EncryptionKeyVaultID)
+}
+
+func TestParseAmlfilesystemProperties_Err_InvalidEncryption(t *testing.T) {
+	testCases := []struct {
+		desc                 string
+		encryptionProperties map[string]string
+		expectedErr          string
+	}{
+		{
+			desc: "key url without key vault id",
+			encryptionProperties: map[string]string{
+				"identities":         "identity1",
+				"encryption-key-url": "https://vault.vault.azure.net/keys/key/version",
+			},
+			expectedErr: "encryption-key-url and encryption-key-vault-id must be provided together",
+		},
+		{
+			desc: "key vault id without key url",
+			encryptionProperties: map[string]string{
+				"identities":              "identity1",
+				"encryption-key-vault-id": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/vault",
+			},
+			expectedErr: "encryption-key-url and encryption-key-vault-id must be provided together",
+		},
+		{
+			desc: "no identities",
+			encryptionProperties: map[string]string{
+				"encryption-key-url":      "https://vault.vault.azure.net/keys/key/version",
+				"encryption-key-vault-id": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/vault",
+			},
+			expectedErr: "identities must be provided for customer-managed key encryption",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			properties := map[string]string{
+				"maintenance-day-of-week":     "Monday",
+				"maintenance-time-of-day-utc": "12:00",
+				"sku-name":                    "AMLFS-Durable-Premium-40",
+			}
+			maps.Copy(properties, tC.encryptionProperties)
+
+			_, err := parseAmlFilesystemProperties(properties)
+			require.Error(t, err)
+			grpcStatus, ok := status.FromError(err)
+			assert.True(t, ok)
+			assert.Equal(t, codes.InvalidArgument, grpcStatus.Code())
+			require.ErrorContains(t, err, tC.expectedErr)
+		})
+	}
+}
+
 func TestParseAmlfilesystemProperties_Err_InvalidParameters(t *testing.T) {
 	properties := map[string]string{
 		"invalid-param":               "invalid",
@@ -1178,6 +3049,67 @@ func TestParseAmlfilesystemProperties_Err_InvalidParameters(t *testing.T) {
 	require.ErrorContains(t, err, "invalid-param")
 }
 
+func TestParseAmlfilesystemProperties_Success_SubnetName(t *testing.T) {
+	testCases := []struct {
+		desc                     string
+		subnetName               string
+		expectedSubnetName       string
+		expectedSubnetCandidates []string
+		expectedSubnetNamePrefix string
+	}{
+		{
+			desc:               "Single subnet",
+			subnetName:         "subnet1",
+			expectedSubnetName: "subnet1",
+		},
+		{
+			desc:                     "Subnet list",
+			subnetName:               "subnet1, subnet2,subnet3",
+			expectedSubnetName:       "subnet1",
+			expectedSubnetCandidates: []string{"subnet1", "subnet2", "subnet3"},
+		},
+		{
+			desc:                     "Subnet prefix",
+			subnetName:               "amlfs-*",
+			expectedSubnetNamePrefix: "amlfs-",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			properties := map[string]string{
+				"subnet-name":                 tC.subnetName,
+				"maintenance-day-of-week":     "Monday",
+				"maintenance-time-of-day-utc": "12:00",
+				"sku-name":                    "AMLFS-Durable-Premium-40",
+			}
+
+			amlFilesystemProperties, err := parseAmlFilesystemProperties(properties)
+			require.NoError(t, err)
+			assert.Equal(t, tC.expectedSubnetName, amlFilesystemProperties.SubnetInfo.SubnetName)
+			assert.Equal(t, tC.expectedSubnetCandidates, amlFilesystemProperties.SubnetCandidates)
+			assert.Equal(t, tC.expectedSubnetNamePrefix, amlFilesystemProperties.SubnetNamePrefix)
+		})
+	}
+}
+
+func TestParseAmlfilesystemProperties_Err_InvalidSubnetName(t *testing.T) {
+	for _, subnetName := range []string{"*", "subnet1,,subnet2", "subnet1,", "subnet1,amlfs-*", "amlfs-*-*"} {
+		t.Run(subnetName, func(t *testing.T) {
+			properties := map[string]string{
+				"subnet-name":                 subnetName,
+				"maintenance-day-of-week":     "Monday",
+				"maintenance-time-of-day-utc": "12:00",
+				"sku-name":                    "AMLFS-Durable-Premium-40",
+			}
+
+			_, err := parseAmlFilesystemProperties(properties)
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			require.ErrorContains(t, err, "subnet-name must be a subnet name, a comma-separated list of subnet names, or a subnet name prefix")
+		})
+	}
+}
+
 func TestParseAmlfilesystemProperties_Err_MissingMaintenanceDayOfWeek(t *testing.T) {
 	properties := map[string]string{
 		"resource-group-name":         "test-resource-group",
@@ -1339,6 +3271,12 @@ func TestParseAmlfilesystemProperties_Err_ReservedTags(t *testing.T) {
 		{
 			reservedTag: pvcNamespaceTag,
 		},
+		{
+			reservedTag: subDirTag,
+		},
+		{
+			reservedTag: archiveOnDeletePathTag,
+		},
 	}
 	for _, tC := range testCases {
 		properties := map[string]string{
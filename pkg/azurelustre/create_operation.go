@@ -0,0 +1,465 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+type createOperationState string
+
+const (
+	createOperationStateInProgress createOperationState = "InProgress"
+	createOperationStateSucceeded  createOperationState = "Succeeded"
+	createOperationStateFailed     createOperationState = "Failed"
+
+	DefaultOperationNamespace           = "kube-system"
+	createOperationConfigMapPrefix      = "azurelustre-create-"
+	createOperationConfigMapKey         = "operation"
+	createOperationLabel                = "azurelustre.csi.azure.com/operation"
+	defaultCreateAmlFilesystemWaitTime  = 10 * time.Second
+	createAmlFilesystemInProgressFmt    = "creation of AMLFS cluster %s is in progress"
+	createOperationConfigMapNameMaxBase = 200
+)
+
+var invalidConfigMapNameCharsRegex = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// amlFilesystemCreateOperation is the state of an AMLFS creation that is
+// persisted in a ConfigMap, so that a restarted controller polls the same
+// long-running operation instead of starting a new one
+type amlFilesystemCreateOperation struct {
+	ResourceGroupName string               `json:"resourceGroupName"`
+	AmlFilesystemName string               `json:"amlFilesystemName"`
+	ResumeToken       string               `json:"resumeToken,omitempty"`
+	State             createOperationState `json:"state"`
+	SubnetName        string               `json:"subnetName,omitempty"`
+	SubnetID          string               `json:"subnetID,omitempty"`
+	MgsIPAddress      string               `json:"mgsIPAddress,omitempty"`
+	ErrorCode         codes.Code           `json:"errorCode,omitempty"`
+	ErrorMessage      string               `json:"errorMessage,omitempty"`
+	// PVCName and PVCNamespace are the PVC the progress of the creation is
+	// recorded on when it is resumed
+	PVCName      string `json:"pvcName,omitempty"`
+	PVCNamespace string `json:"pvcNamespace,omitempty"`
+	// ProvisionerSecrets is set when the creation was started with the
+	// credential of provisioner secrets, which are only known to CreateVolume
+	ProvisionerSecrets bool `json:"provisionerSecrets,omitempty"`
+}
+
+// runningCreateOperation is a creation polled in the background by this
+// controller, done is closed once mgsIPAddress or err are set
+type runningCreateOperation struct {
+	done chan struct{}
+	// dynamicProvisioner is the provisioner of the credential the creation
+	// was started with
+	dynamicProvisioner DynamicProvisionerInterface
+	subnetInfo         SubnetProperties
+	// pvc is the PVC the progress of the creation is recorded on, if any
+	pvc          runtime.Object
+	mgsIPAddress string
+	err          error
+}
+
+type createOperations struct {
+	operations map[string]*runningCreateOperation
+	mux        sync.Mutex
+}
+
+func newCreateOperations() *createOperations {
+	return &createOperations{
+		operations: make(map[string]*runningCreateOperation),
+	}
+}
+
+func (co *createOperations) get(key string) *runningCreateOperation {
+	co.mux.Lock()
+	defer co.mux.Unlock()
+	return co.operations[key]
+}
+
+// add returns the operation of key, which is a new one if added is true
+func (co *createOperations) add(key string) (operation *runningCreateOperation, added bool) {
+	co.mux.Lock()
+	defer co.mux.Unlock()
+	if operation, ok := co.operations[key]; ok {
+		return operation, false
+	}
+	operation = &runningCreateOperation{done: make(chan struct{})}
+	co.operations[key] = operation
+	return operation, true
+}
+
+func (co *createOperations) remove(key string) {
+	co.mux.Lock()
+	defer co.mux.Unlock()
+	delete(co.operations, key)
+}
+
+func getCreateOperationKey(amlFilesystemProperties *AmlFilesystemProperties) string {
+	return amlFilesystemProperties.ResourceGroupName + "/" + amlFilesystemProperties.AmlFilesystemName
+}
+
+// getCreateOperationConfigMapName returns a valid ConfigMap name for the
+// cluster, with a hash suffix so that names only differing in case or in
+// invalid characters do not collide
+func getCreateOperationConfigMapName(amlFilesystemProperties *AmlFilesystemProperties) string {
+	key := getCreateOperationKey(amlFilesystemProperties)
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+
+	base := strings.Trim(invalidConfigMapNameCharsRegex.ReplaceAllString(strings.ToLower(amlFilesystemProperties.AmlFilesystemName), "-"), "-")
+	if len(base) > createOperationConfigMapNameMaxBase {
+		base = base[:createOperationConfigMapNameMaxBase]
+	}
+	return fmt.Sprintf("%s%s-%08x", createOperationConfigMapPrefix, base, hash.Sum32())
+}
+
+// createAmlFilesystem creates the AMLFS cluster in the background and waits
+// up to createAmlFilesystemWaitTime for it. If the creation takes longer,
+// Aborted is returned so that the provisioner retries CreateVolume, which
+// then waits for the same creation again. The resume token of the creation is
+// persisted, so a restarted controller resumes polling the same creation.
+// The subnet is selected when the creation starts, and SubnetInfo is set to
+// it for every call waiting for the same creation
+func (d *Driver) createAmlFilesystem(ctx context.Context, dynamicProvisioner DynamicProvisionerInterface, amlFilesystemProperties *AmlFilesystemProperties) (string, error) {
+	key := getCreateOperationKey(amlFilesystemProperties)
+
+	operation := d.createOperations.get(key)
+	if operation == nil {
+		pvc := d.getPersistentVolumeClaim(ctx, amlFilesystemProperties)
+		persistedOperation, err := d.getCreateOperation(ctx, amlFilesystemProperties)
+		if err != nil {
+			return "", err
+		}
+		if persistedOperation != nil && persistedOperation.SubnetID != "" {
+			amlFilesystemProperties.SubnetInfo.SubnetName = persistedOperation.SubnetName
+			amlFilesystemProperties.SubnetInfo.SubnetID = persistedOperation.SubnetID
+		}
+
+		resumeToken := ""
+		if persistedOperation == nil || persistedOperation.State == createOperationStateInProgress {
+			// Resumed creations wait for a slot like the new ones, so that
+			// the creations of the controllers before a restart are counted
+			if position := d.amlFilesystemOperations.tryAcquire(createOperationKeyPrefix + key); position > 0 {
+				inProgress := d.amlFilesystemOperations.inProgress()
+				klog.V(2).Infof(amlFilesystemOperationQueuedFmt, "creation", amlFilesystemProperties.AmlFilesystemName, position, inProgress)
+				d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonCreationQueued,
+					amlFilesystemOperationQueuedFmt, "creation", amlFilesystemProperties.AmlFilesystemName, position, inProgress)
+				return "", status.Errorf(codes.Aborted,
+					amlFilesystemOperationQueuedFmt, "creation", amlFilesystemProperties.AmlFilesystemName, position, inProgress)
+			}
+		}
+		switch {
+		case persistedOperation == nil:
+			d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonSkuResolved,
+				"SKU %s is available in location %s for AMLFS cluster %s of %v TiB",
+				amlFilesystemProperties.SKUName, amlFilesystemProperties.Location,
+				amlFilesystemProperties.AmlFilesystemName, amlFilesystemProperties.StorageCapacityTiB)
+			amlFilesystemProperties.SubnetInfo, err = dynamicProvisioner.SelectSubnet(ctx, amlFilesystemProperties)
+			if err != nil {
+				d.amlFilesystemOperations.release(createOperationKeyPrefix + key)
+				return "", err
+			}
+			d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonSubnetSelected,
+				"subnet %s of virtual network %s has enough IP addresses available for AMLFS cluster %s",
+				amlFilesystemProperties.SubnetInfo.SubnetName, amlFilesystemProperties.SubnetInfo.VnetName,
+				amlFilesystemProperties.AmlFilesystemName)
+			resumeToken, err = dynamicProvisioner.BeginCreateAmlFilesystem(ctx, amlFilesystemProperties)
+			if err != nil {
+				d.amlFilesystemOperations.release(createOperationKeyPrefix + key)
+				if isAmlFilesystemCreationRetry(err, amlFilesystemProperties.AmlFilesystemName) {
+					d.recordEvent(pvc, corev1.EventTypeWarning, eventReasonCreationRetrying,
+						"AMLFS cluster %s was in a failed state and was deleted, its creation will be retried",
+						amlFilesystemProperties.AmlFilesystemName)
+				}
+				return "", err
+			}
+			d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonCreationStarted,
+				"started creation of AMLFS cluster %s in resource group %s, which can take 15 minutes or more",
+				amlFilesystemProperties.AmlFilesystemName, amlFilesystemProperties.ResourceGroupName)
+			err = d.saveCreateOperation(ctx, &amlFilesystemCreateOperation{
+				ResourceGroupName:  amlFilesystemProperties.ResourceGroupName,
+				AmlFilesystemName:  amlFilesystemProperties.AmlFilesystemName,
+				ResumeToken:        resumeToken,
+				State:              createOperationStateInProgress,
+				SubnetName:         amlFilesystemProperties.SubnetInfo.SubnetName,
+				SubnetID:           amlFilesystemProperties.SubnetInfo.SubnetID,
+				PVCName:            amlFilesystemProperties.Tags[pvcNameTag],
+				PVCNamespace:       amlFilesystemProperties.Tags[pvcNamespaceTag],
+				ProvisionerSecrets: dynamicProvisioner != d.dynamicProvisioner,
+			})
+			if err != nil {
+				klog.Warningf("failed to persist creation of AMLFS cluster %s, creation will not be resumed after a restart: %v", amlFilesystemProperties.AmlFilesystemName, err)
+			}
+		case persistedOperation.State == createOperationStateInProgress:
+			klog.V(2).Infof("resuming creation of AMLFS cluster %s", amlFilesystemProperties.AmlFilesystemName)
+			d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonCreationResumed,
+				"resumed creation of AMLFS cluster %s after a controller restart", amlFilesystemProperties.AmlFilesystemName)
+			resumeToken = persistedOperation.ResumeToken
+		default:
+			// The creation completed before the controller restarted
+			d.deleteCreateOperation(ctx, amlFilesystemProperties)
+			if persistedOperation.State == createOperationStateFailed {
+				return "", status.Error(persistedOperation.ErrorCode, persistedOperation.ErrorMessage)
+			}
+			return persistedOperation.MgsIPAddress, nil
+		}
+
+		operation = d.startPollCreateAmlFilesystem(dynamicProvisioner, amlFilesystemProperties, pvc, resumeToken)
+		amlFilesystemProperties.SubnetInfo = operation.subnetInfo
+	} else {
+		amlFilesystemProperties.SubnetInfo = operation.subnetInfo
+	}
+
+	waitTimer := time.NewTimer(d.createAmlFilesystemWaitTime)
+	defer waitTimer.Stop()
+
+	select {
+	case <-operation.done:
+		d.createOperations.remove(key)
+		d.deleteCreateOperation(ctx, amlFilesystemProperties)
+		return operation.mgsIPAddress, operation.err
+	case <-waitTimer.C:
+	case <-ctx.Done():
+	}
+
+	klog.V(2).Infof(createAmlFilesystemInProgressFmt, amlFilesystemProperties.AmlFilesystemName)
+	d.recordEvent(operation.pvc, corev1.EventTypeNormal, eventReasonCreationInProgress,
+		createAmlFilesystemInProgressFmt, amlFilesystemProperties.AmlFilesystemName)
+	return "", status.Errorf(codes.Aborted, createAmlFilesystemInProgressFmt, amlFilesystemProperties.AmlFilesystemName)
+}
+
+// startPollCreateAmlFilesystem polls the creation in the background once its
+// slot is acquired. If the creation is already polled, e.g. because it was
+// resumed at the same time, the slot is left to the running poll
+func (d *Driver) startPollCreateAmlFilesystem(dynamicProvisioner DynamicProvisionerInterface, amlFilesystemProperties *AmlFilesystemProperties, pvc runtime.Object, resumeToken string) *runningCreateOperation {
+	operation, added := d.createOperations.add(getCreateOperationKey(amlFilesystemProperties))
+	if !added {
+		return operation
+	}
+	operation.dynamicProvisioner = dynamicProvisioner
+	operation.subnetInfo = amlFilesystemProperties.SubnetInfo
+	operation.pvc = pvc
+	// The poller gets its own copy, as the properties of the request are
+	// updated by the calls waiting for the same creation
+	pollProperties := *amlFilesystemProperties
+	go d.pollCreateAmlFilesystem(operation, &pollProperties, resumeToken)
+	return operation
+}
+
+// runCreateOperationResumer periodically resumes the creations persisted in
+// progress that are not polled by this controller, so that they complete
+// even if CreateVolume is not retried, e.g. because the PVC was deleted
+// while the controller restarted
+func (d *Driver) runCreateOperationResumer(ctx context.Context) {
+	klog.V(2).Infof("resuming persisted AMLFS creations every %v", d.createOperationResumeInterval)
+	wait.UntilWithContext(ctx, d.resumeCreateOperations, d.createOperationResumeInterval)
+}
+
+// resumeCreateOperations resumes the persisted creations in the order they
+// were started. They wait for a slot like new creations, and keep their place
+// in the queue as long as they are resumed again before they expire
+func (d *Driver) resumeCreateOperations(ctx context.Context) {
+	configMaps, err := d.kubeClient.CoreV1().ConfigMaps(d.operationNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: createOperationLabel + "=create",
+	})
+	if err != nil {
+		klog.Errorf("error when listing the ConfigMaps of AMLFS creations in %s: %v", d.operationNamespace, err)
+		return
+	}
+	slices.SortStableFunc(configMaps.Items, func(a, b corev1.ConfigMap) int {
+		return a.CreationTimestamp.Compare(b.CreationTimestamp.Time)
+	})
+
+	for _, configMap := range configMaps.Items {
+		var persistedOperation amlFilesystemCreateOperation
+		if err := json.Unmarshal([]byte(configMap.Data[createOperationConfigMapKey]), &persistedOperation); err != nil {
+			klog.Warningf("ignoring invalid ConfigMap %s/%s of AMLFS creation: %v", configMap.Namespace, configMap.Name, err)
+			continue
+		}
+		if persistedOperation.State != createOperationStateInProgress {
+			continue
+		}
+		if persistedOperation.ProvisionerSecrets {
+			klog.V(4).Infof("creation of AMLFS cluster %s uses provisioner secrets, it is only resumed by CreateVolume", persistedOperation.AmlFilesystemName)
+			continue
+		}
+
+		amlFilesystemProperties := &AmlFilesystemProperties{
+			ResourceGroupName: persistedOperation.ResourceGroupName,
+			AmlFilesystemName: persistedOperation.AmlFilesystemName,
+			SubnetInfo: SubnetProperties{
+				SubnetName: persistedOperation.SubnetName,
+				SubnetID:   persistedOperation.SubnetID,
+			},
+			Tags: map[string]string{
+				pvcNameTag:      persistedOperation.PVCName,
+				pvcNamespaceTag: persistedOperation.PVCNamespace,
+			},
+		}
+		key := getCreateOperationKey(amlFilesystemProperties)
+		if d.createOperations.get(key) != nil {
+			continue
+		}
+		if position := d.amlFilesystemOperations.tryAcquire(createOperationKeyPrefix + key); position > 0 {
+			klog.V(2).Infof(amlFilesystemOperationQueuedFmt, "resumed creation", amlFilesystemProperties.AmlFilesystemName,
+				position, d.amlFilesystemOperations.inProgress())
+			continue
+		}
+
+		klog.V(2).Infof("resuming creation of AMLFS cluster %s", amlFilesystemProperties.AmlFilesystemName)
+		pvc := d.getPersistentVolumeClaim(ctx, amlFilesystemProperties)
+		d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonCreationResumed,
+			"resumed creation of AMLFS cluster %s after a controller restart", amlFilesystemProperties.AmlFilesystemName)
+		d.startPollCreateAmlFilesystem(d.dynamicProvisioner, amlFilesystemProperties, pvc, persistedOperation.ResumeToken)
+	}
+}
+
+func (d *Driver) pollCreateAmlFilesystem(operation *runningCreateOperation, amlFilesystemProperties *AmlFilesystemProperties, resumeToken string) {
+	defer close(operation.done)
+	defer d.amlFilesystemOperations.release(createOperationKeyPrefix + getCreateOperationKey(amlFilesystemProperties))
+
+	// The creation outlives the CreateVolume request that started it
+	ctx := context.Background()
+	operation.mgsIPAddress, operation.err = operation.dynamicProvisioner.PollCreateAmlFilesystem(ctx, amlFilesystemProperties, resumeToken)
+
+	// The result is persisted in case the controller restarts before
+	// CreateVolume is retried
+	persistedOperation := &amlFilesystemCreateOperation{
+		ResourceGroupName: amlFilesystemProperties.ResourceGroupName,
+		AmlFilesystemName: amlFilesystemProperties.AmlFilesystemName,
+		State:             createOperationStateSucceeded,
+		SubnetName:        amlFilesystemProperties.SubnetInfo.SubnetName,
+		SubnetID:          amlFilesystemProperties.SubnetInfo.SubnetID,
+		MgsIPAddress:      operation.mgsIPAddress,
+	}
+	switch {
+	case isAmlFilesystemCreationRetry(operation.err, amlFilesystemProperties.AmlFilesystemName):
+		d.recordEvent(operation.pvc, corev1.EventTypeWarning, eventReasonCreationRetrying,
+			"creation of AMLFS cluster %s failed and the cluster was deleted, its creation will be retried",
+			amlFilesystemProperties.AmlFilesystemName)
+	case operation.err != nil:
+		d.recordEvent(operation.pvc, corev1.EventTypeWarning, eventReasonCreationFailed,
+			"creation of AMLFS cluster %s failed: %s", amlFilesystemProperties.AmlFilesystemName, status.Convert(operation.err).Message())
+	default:
+		d.recordEvent(operation.pvc, corev1.EventTypeNormal, eventReasonCreationSucceeded,
+			"created AMLFS cluster %s with MGS address %s", amlFilesystemProperties.AmlFilesystemName, operation.mgsIPAddress)
+	}
+	if operation.err != nil {
+		klog.Errorf("creation of AMLFS cluster %s failed: %v", amlFilesystemProperties.AmlFilesystemName, operation.err)
+		persistedOperation.State = createOperationStateFailed
+		persistedOperation.ErrorCode = status.Code(operation.err)
+		persistedOperation.ErrorMessage = operation.err.Error()
+		if grpcStatus, ok := status.FromError(operation.err); ok {
+			persistedOperation.ErrorMessage = grpcStatus.Message()
+		}
+	} else {
+		klog.V(2).Infof("creation of AMLFS cluster %s completed", amlFilesystemProperties.AmlFilesystemName)
+	}
+	if err := d.saveCreateOperation(ctx, persistedOperation); err != nil {
+		klog.Warningf("failed to persist result of creation of AMLFS cluster %s: %v", amlFilesystemProperties.AmlFilesystemName, err)
+	}
+}
+
+func (d *Driver) getCreateOperation(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) (*amlFilesystemCreateOperation, error) {
+	if d.kubeClient == nil {
+		return nil, nil
+	}
+
+	configMapName := getCreateOperationConfigMapName(amlFilesystemProperties)
+	configMap, err := d.kubeClient.CoreV1().ConfigMaps(d.operationNamespace).Get(ctx, configMapName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, status.Errorf(codes.Unavailable, "failed to get ConfigMap %s/%s of AMLFS cluster %s creation: %v",
+			d.operationNamespace, configMapName, amlFilesystemProperties.AmlFilesystemName, err)
+	}
+
+	var operation amlFilesystemCreateOperation
+	if err := json.Unmarshal([]byte(configMap.Data[createOperationConfigMapKey]), &operation); err != nil {
+		klog.Warningf("ignoring invalid ConfigMap %s/%s of AMLFS cluster %s creation: %v",
+			d.operationNamespace, configMapName, amlFilesystemProperties.AmlFilesystemName, err)
+		return nil, nil
+	}
+	return &operation, nil
+}
+
+func (d *Driver) saveCreateOperation(ctx context.Context, operation *amlFilesystemCreateOperation) error {
+	if d.kubeClient == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(operation)
+	if err != nil {
+		return err
+	}
+
+	configMapName := getCreateOperationConfigMapName(&AmlFilesystemProperties{
+		ResourceGroupName: operation.ResourceGroupName,
+		AmlFilesystemName: operation.AmlFilesystemName,
+	})
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName,
+			Namespace: d.operationNamespace,
+			Labels: map[string]string{
+				createOperationLabel: "create",
+			},
+		},
+		Data: map[string]string{
+			createOperationConfigMapKey: string(data),
+		},
+	}
+
+	configMaps := d.kubeClient.CoreV1().ConfigMaps(d.operationNamespace)
+	_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	}
+	return err
+}
+
+func (d *Driver) deleteCreateOperation(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) {
+	if d.kubeClient == nil {
+		return
+	}
+
+	configMapName := getCreateOperationConfigMapName(amlFilesystemProperties)
+	err := d.kubeClient.CoreV1().ConfigMaps(d.operationNamespace).Delete(ctx, configMapName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.Warningf("failed to delete ConfigMap %s/%s of AMLFS cluster %s creation: %v",
+			d.operationNamespace, configMapName, amlFilesystemProperties.AmlFilesystemName, err)
+	}
+}
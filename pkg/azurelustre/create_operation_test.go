@@ -0,0 +1,484 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func buildCreateOperationAmlFilesystemProperties(amlFilesystemName string) *AmlFilesystemProperties {
+	return &AmlFilesystemProperties{
+		ResourceGroupName:  "fake-resource-group",
+		AmlFilesystemName:  amlFilesystemName,
+		Location:           "eastus",
+		SKUName:            "AMLFS-Durable-Premium-125",
+		StorageCapacityTiB: 32,
+		SubnetInfo:         SubnetProperties{VnetName: "test-vnet", SubnetName: "test-subnet"},
+		Tags: map[string]string{
+			pvcNameTag:      eventsPVCName,
+			pvcNamespaceTag: eventsPVCNamespace,
+		},
+	}
+}
+
+func getPersistedCreateOperation(t *testing.T, d *Driver, amlFilesystemProperties *AmlFilesystemProperties) *amlFilesystemCreateOperation {
+	t.Helper()
+	configMap, err := d.kubeClient.CoreV1().ConfigMaps(DefaultOperationNamespace).Get(
+		context.Background(), getCreateOperationConfigMapName(amlFilesystemProperties), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	assert.Equal(t, "create", configMap.Labels[createOperationLabel])
+
+	var operation amlFilesystemCreateOperation
+	require.NoError(t, json.Unmarshal([]byte(configMap.Data[createOperationConfigMapKey]), &operation))
+	return &operation
+}
+
+func persistCreateOperation(t *testing.T, d *Driver, operation *amlFilesystemCreateOperation) {
+	t.Helper()
+	require.NoError(t, d.saveCreateOperation(context.Background(), operation))
+}
+
+func TestCreateAmlFilesystem_Success(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties("test_volume")
+
+	mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", mgsIPAddress)
+	assert.Equal(t, map[string]int{"SelectSubnet": 1, "BeginCreateAmlFilesystem": 1, "PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Nil(t, getPersistedCreateOperation(t, d, amlFilesystemProperties))
+	assert.Nil(t, d.createOperations.get(getCreateOperationKey(amlFilesystemProperties)))
+}
+
+func TestCreateAmlFilesystem_Success_NoKubeClient(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	d.kubeClient = nil
+
+	mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, buildCreateOperationAmlFilesystemProperties("test_volume"))
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", mgsIPAddress)
+	assert.Equal(t, map[string]int{"SelectSubnet": 1, "BeginCreateAmlFilesystem": 1, "PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestCreateAmlFilesystem_InProgress(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	fakeDynamicProvisioner.pollCreateRelease = make(chan struct{})
+	d.createAmlFilesystemWaitTime = time.Millisecond
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties("test_volume")
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.ErrorContains(t, err, "creation of AMLFS cluster test_volume is in progress")
+	assert.Equal(t, &amlFilesystemCreateOperation{
+		ResourceGroupName: "fake-resource-group",
+		AmlFilesystemName: "test_volume",
+		ResumeToken:       fakeResumeTokenPrefix + "test_volume",
+		State:             createOperationStateInProgress,
+		SubnetName:        "test-subnet",
+		PVCName:           eventsPVCName,
+		PVCNamespace:      eventsPVCNamespace,
+	}, getPersistedCreateOperation(t, d, amlFilesystemProperties))
+
+	// Retries wait for the same creation instead of starting a new one
+	_, err = d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	close(fakeDynamicProvisioner.pollCreateRelease)
+	d.createAmlFilesystemWaitTime = time.Minute
+
+	mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", mgsIPAddress)
+	assert.Equal(t, map[string]int{"SelectSubnet": 1, "BeginCreateAmlFilesystem": 1, "PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Nil(t, getPersistedCreateOperation(t, d, amlFilesystemProperties))
+}
+
+func TestCreateAmlFilesystem_ResumesAfterRestart(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties("test_volume")
+	persistCreateOperation(t, d, &amlFilesystemCreateOperation{
+		ResourceGroupName: "fake-resource-group",
+		AmlFilesystemName: "test_volume",
+		ResumeToken:       fakeResumeTokenPrefix + "test_volume",
+		State:             createOperationStateInProgress,
+	})
+
+	mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", mgsIPAddress)
+	assert.Equal(t, map[string]int{"PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Nil(t, getPersistedCreateOperation(t, d, amlFilesystemProperties))
+}
+
+func TestCreateAmlFilesystem_CompletedBeforeRestart(t *testing.T) {
+	cases := []struct {
+		desc                 string
+		operation            *amlFilesystemCreateOperation
+		expectedMgsIPAddress string
+		expectedErr          error
+	}{
+		{
+			desc: "succeeded",
+			operation: &amlFilesystemCreateOperation{
+				State:        createOperationStateSucceeded,
+				MgsIPAddress: "127.0.0.3",
+			},
+			expectedMgsIPAddress: "127.0.0.3",
+		},
+		{
+			desc: "failed",
+			operation: &amlFilesystemCreateOperation{
+				State:        createOperationStateFailed,
+				ErrorCode:    codes.ResourceExhausted,
+				ErrorMessage: "not enough IP addresses available",
+			},
+			expectedErr: status.Error(codes.ResourceExhausted, "not enough IP addresses available"),
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+			d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+			amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties("test_volume")
+			test.operation.ResourceGroupName = amlFilesystemProperties.ResourceGroupName
+			test.operation.AmlFilesystemName = amlFilesystemProperties.AmlFilesystemName
+			persistCreateOperation(t, d, test.operation)
+
+			mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+			assert.Equal(t, test.expectedErr, err)
+			assert.Equal(t, test.expectedMgsIPAddress, mgsIPAddress)
+			assert.Empty(t, fakeDynamicProvisioner.fakeCallCount)
+			assert.Nil(t, getPersistedCreateOperation(t, d, amlFilesystemProperties))
+		})
+	}
+}
+
+func TestCreateAmlFilesystem_Err_Begin(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties(clusterRequestFailureName)
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, map[string]int{"SelectSubnet": 1, "BeginCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Nil(t, getPersistedCreateOperation(t, d, amlFilesystemProperties))
+	assert.Zero(t, d.amlFilesystemOperations.inProgress())
+}
+
+func TestCreateAmlFilesystem_Err_Poll(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties(clusterPollFailureName)
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(t, map[string]int{"SelectSubnet": 1, "BeginCreateAmlFilesystem": 1, "PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Nil(t, getPersistedCreateOperation(t, d, amlFilesystemProperties))
+
+	// A failed creation is started again on the next attempt
+	_, err = d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	assert.Equal(t, map[string]int{"SelectSubnet": 2, "BeginCreateAmlFilesystem": 2, "PollCreateAmlFilesystem": 2}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestCreateAmlFilesystem_Queued(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	fakeDynamicProvisioner.pollCreateRelease = make(chan struct{})
+	d.createAmlFilesystemWaitTime = time.Millisecond
+	d.amlFilesystemOperations = newAmlFilesystemOperationQueue(1)
+	firstProperties := buildCreateOperationAmlFilesystemProperties("first_volume")
+	secondProperties := buildCreateOperationAmlFilesystemProperties("second_volume")
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, firstProperties)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "creation of AMLFS cluster first_volume is in progress")
+
+	_, err = d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, secondProperties)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.ErrorContains(t, err, "creation of AMLFS cluster second_volume is queued at position 1, 1 AMLFS creations and deletions are in progress")
+	assert.Nil(t, getPersistedCreateOperation(t, d, secondProperties))
+
+	close(fakeDynamicProvisioner.pollCreateRelease)
+	d.createAmlFilesystemWaitTime = time.Minute
+	_, err = d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, firstProperties)
+	require.NoError(t, err)
+
+	mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, secondProperties)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", mgsIPAddress)
+	assert.Equal(t, map[string]int{"SelectSubnet": 2, "BeginCreateAmlFilesystem": 2, "PollCreateAmlFilesystem": 2}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Zero(t, d.amlFilesystemOperations.inProgress())
+}
+
+func TestCreateAmlFilesystem_ResumedOverLimit(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	d.amlFilesystemOperations = newAmlFilesystemOperationQueue(1)
+	assert.Zero(t, d.amlFilesystemOperations.tryAcquire(createOperationKeyPrefix+"fake-resource-group/other_volume"))
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties("test_volume")
+	persistCreateOperation(t, d, &amlFilesystemCreateOperation{
+		ResourceGroupName: "fake-resource-group",
+		AmlFilesystemName: "test_volume",
+		ResumeToken:       fakeResumeTokenPrefix + "test_volume",
+		State:             createOperationStateInProgress,
+	})
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.ErrorContains(t, err, "creation of AMLFS cluster test_volume is queued at position 1")
+	assert.Empty(t, fakeDynamicProvisioner.fakeCallCount)
+
+	d.amlFilesystemOperations.release(createOperationKeyPrefix + "fake-resource-group/other_volume")
+	mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", mgsIPAddress)
+	assert.Equal(t, map[string]int{"PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Zero(t, d.amlFilesystemOperations.inProgress())
+}
+
+func persistResumedCreateOperation(t *testing.T, d *Driver, amlFilesystemName string, creationTime time.Time, provisionerSecrets bool) {
+	t.Helper()
+	persistCreateOperation(t, d, &amlFilesystemCreateOperation{
+		ResourceGroupName:  "fake-resource-group",
+		AmlFilesystemName:  amlFilesystemName,
+		ResumeToken:        fakeResumeTokenPrefix + amlFilesystemName,
+		State:              createOperationStateInProgress,
+		PVCName:            "pvc-" + amlFilesystemName,
+		PVCNamespace:       "default",
+		ProvisionerSecrets: provisionerSecrets,
+	})
+	// The fake client does not set the creation time used to order the
+	// resumed creations
+	configMaps := d.kubeClient.CoreV1().ConfigMaps(DefaultOperationNamespace)
+	configMap, err := configMaps.Get(context.Background(),
+		getCreateOperationConfigMapName(buildCreateOperationAmlFilesystemProperties(amlFilesystemName)), metav1.GetOptions{})
+	require.NoError(t, err)
+	configMap.CreationTimestamp = metav1.NewTime(creationTime)
+	_, err = configMaps.Update(context.Background(), configMap, metav1.UpdateOptions{})
+	require.NoError(t, err)
+}
+
+func waitForCreateOperation(t *testing.T, d *Driver, amlFilesystemName string) {
+	t.Helper()
+	operation := d.createOperations.get(getCreateOperationKey(buildCreateOperationAmlFilesystemProperties(amlFilesystemName)))
+	require.NotNil(t, operation)
+	select {
+	case <-operation.done:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "creation was not completed", amlFilesystemName)
+	}
+}
+
+func TestResumeCreateOperations(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	now := time.Now()
+	persistResumedCreateOperation(t, d, "test_volume", now, false)
+	persistCreateOperation(t, d, &amlFilesystemCreateOperation{
+		ResourceGroupName: "fake-resource-group",
+		AmlFilesystemName: "completed",
+		State:             createOperationStateSucceeded,
+		MgsIPAddress:      "127.0.0.3",
+	})
+
+	d.resumeCreateOperations(context.Background())
+	waitForCreateOperation(t, d, "test_volume")
+	assert.Nil(t, d.createOperations.get(getCreateOperationKey(buildCreateOperationAmlFilesystemProperties("completed"))))
+	assert.Equal(t, map[string]int{"PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Zero(t, d.amlFilesystemOperations.inProgress())
+	assert.Equal(t, &amlFilesystemCreateOperation{
+		ResourceGroupName: "fake-resource-group",
+		AmlFilesystemName: "test_volume",
+		State:             createOperationStateSucceeded,
+		MgsIPAddress:      "127.0.0.2",
+	}, getPersistedCreateOperation(t, d, buildCreateOperationAmlFilesystemProperties("test_volume")))
+
+	// The resumed creation is not polled again, and its result is returned to
+	// CreateVolume if it is retried
+	d.resumeCreateOperations(context.Background())
+	mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, buildCreateOperationAmlFilesystemProperties("test_volume"))
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", mgsIPAddress)
+	assert.Equal(t, map[string]int{"PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestResumeCreateOperations_Queued(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	fakeDynamicProvisioner.pollCreateRelease = make(chan struct{})
+	d.amlFilesystemOperations = newAmlFilesystemOperationQueue(1)
+	now := time.Now()
+	persistResumedCreateOperation(t, d, "second", now, false)
+	persistResumedCreateOperation(t, d, "first", now.Add(-time.Minute), false)
+
+	// The creations are resumed in the order they were started
+	d.resumeCreateOperations(context.Background())
+	assert.NotNil(t, d.createOperations.get(getCreateOperationKey(buildCreateOperationAmlFilesystemProperties("first"))))
+	assert.Nil(t, d.createOperations.get(getCreateOperationKey(buildCreateOperationAmlFilesystemProperties("second"))))
+	assert.Equal(t, 1, d.amlFilesystemOperations.inProgress())
+
+	// New creations are queued after the resumed ones
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, buildCreateOperationAmlFilesystemProperties("test_volume"))
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.ErrorContains(t, err, "creation of AMLFS cluster test_volume is queued at position 2")
+
+	close(fakeDynamicProvisioner.pollCreateRelease)
+	waitForCreateOperation(t, d, "first")
+	d.resumeCreateOperations(context.Background())
+	waitForCreateOperation(t, d, "second")
+	assert.Equal(t, map[string]int{"PollCreateAmlFilesystem": 2}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestResumeCreateOperations_ProvisionerSecrets(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	persistResumedCreateOperation(t, d, "test_volume", time.Now(), true)
+
+	d.resumeCreateOperations(context.Background())
+	assert.Nil(t, d.createOperations.get(getCreateOperationKey(buildCreateOperationAmlFilesystemProperties("test_volume"))))
+	assert.Empty(t, fakeDynamicProvisioner.fakeCallCount)
+	assert.Zero(t, d.amlFilesystemOperations.inProgress())
+}
+
+func buildSubnetCandidatesAmlFilesystemProperties(amlFilesystemName string, subnetCandidates ...string) *AmlFilesystemProperties {
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties(amlFilesystemName)
+	amlFilesystemProperties.SubnetInfo = SubnetProperties{
+		VnetResourceGroup: "vnet-rg",
+		VnetName:          "vnet",
+		SubnetName:        subnetCandidates[0],
+		SubnetID:          fmt.Sprintf(subnetTemplate, "sub", "vnet-rg", "vnet", subnetCandidates[0]),
+	}
+	amlFilesystemProperties.SubnetCandidates = subnetCandidates
+	return amlFilesystemProperties
+}
+
+func TestCreateAmlFilesystem_SelectsSubnet(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	fakeDynamicProvisioner.pollCreateRelease = make(chan struct{})
+	d.createAmlFilesystemWaitTime = time.Millisecond
+	expectedSubnetID := fmt.Sprintf(subnetTemplate, "sub", "vnet-rg", "vnet", "subnet2")
+
+	amlFilesystemProperties := buildSubnetCandidatesAmlFilesystemProperties("test_volume", fullSubnetName, "subnet2")
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, "subnet2", amlFilesystemProperties.SubnetInfo.SubnetName)
+	assert.Equal(t, expectedSubnetID, amlFilesystemProperties.SubnetInfo.SubnetID)
+	persistedOperation := getPersistedCreateOperation(t, d, amlFilesystemProperties)
+	require.NotNil(t, persistedOperation)
+	assert.Equal(t, "subnet2", persistedOperation.SubnetName)
+	assert.Equal(t, expectedSubnetID, persistedOperation.SubnetID)
+
+	// Retries use the subnet of the running creation instead of selecting again
+	close(fakeDynamicProvisioner.pollCreateRelease)
+	d.createAmlFilesystemWaitTime = time.Minute
+	retryAmlFilesystemProperties := buildSubnetCandidatesAmlFilesystemProperties("test_volume", fullSubnetName, "subnet2")
+	mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, retryAmlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", mgsIPAddress)
+	assert.Equal(t, "subnet2", retryAmlFilesystemProperties.SubnetInfo.SubnetName)
+	assert.Equal(t, map[string]int{"SelectSubnet": 1, "BeginCreateAmlFilesystem": 1, "PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestCreateAmlFilesystem_ResumesAfterRestart_SelectedSubnet(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	amlFilesystemProperties := buildSubnetCandidatesAmlFilesystemProperties("test_volume", "subnet1", "subnet2")
+	persistCreateOperation(t, d, &amlFilesystemCreateOperation{
+		ResourceGroupName: "fake-resource-group",
+		AmlFilesystemName: "test_volume",
+		ResumeToken:       fakeResumeTokenPrefix + "test_volume",
+		State:             createOperationStateInProgress,
+		SubnetName:        "subnet2",
+		SubnetID:          "subnet2-id",
+	})
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, "subnet2", amlFilesystemProperties.SubnetInfo.SubnetName)
+	assert.Equal(t, "subnet2-id", amlFilesystemProperties.SubnetInfo.SubnetID)
+	assert.Equal(t, map[string]int{"PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestCreateAmlFilesystem_Err_NoSubnetWithCapacity(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	amlFilesystemProperties := buildSubnetCandidatesAmlFilesystemProperties("test_volume", fullSubnetName, "other"+fullSubnetName)
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, map[string]int{"SelectSubnet": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Nil(t, getPersistedCreateOperation(t, d, amlFilesystemProperties))
+}
+
+func TestCreateAmlFilesystem_IgnoresInvalidConfigMap(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties("test_volume")
+	_, err := d.kubeClient.CoreV1().ConfigMaps(DefaultOperationNamespace).Create(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getCreateOperationConfigMapName(amlFilesystemProperties),
+			Namespace: DefaultOperationNamespace,
+		},
+		Data: map[string]string{createOperationConfigMapKey: "not json"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	mgsIPAddress, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", mgsIPAddress)
+	assert.Equal(t, map[string]int{"SelectSubnet": 1, "BeginCreateAmlFilesystem": 1, "PollCreateAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestGetCreateOperationConfigMapName(t *testing.T) {
+	name := getCreateOperationConfigMapName(buildCreateOperationAmlFilesystemProperties("Test_Volume"))
+	assert.Regexp(t, `^azurelustre-create-test-volume-[0-9a-f]{8}$`, name)
+	assert.NotEqual(t, name, getCreateOperationConfigMapName(buildCreateOperationAmlFilesystemProperties("test-volume")))
+	assert.NotEqual(t, name, getCreateOperationConfigMapName(&AmlFilesystemProperties{
+		ResourceGroupName: "other-resource-group",
+		AmlFilesystemName: "Test_Volume",
+	}))
+}
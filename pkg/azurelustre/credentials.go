@@ -0,0 +1,282 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/azurelustre-csi-driver/pkg/util"
+)
+
+// Keys of the secret referenced by the csi.storage.k8s.io/provisioner-secret-*
+// parameters of a StorageClass
+const (
+	SecretSubscriptionID        = "subscription-id"
+	SecretNetworkSubscriptionID = "network-subscription-id"
+	SecretTenantID              = "tenant-id"
+	SecretClientID              = "client-id"
+	SecretClientSecret          = "client-secret"
+	SecretFederatedTokenFile    = "federated-token-file"
+
+	federatedTokenFileEnv = "AZURE_FEDERATED_TOKEN_FILE"
+
+	// StorageClass parameters that csi-provisioner resolves to the secrets of
+	// CreateVolume and DeleteVolume
+	provisionerSecretParameterPrefix = csiParameterPrefix + "provisioner-secret-"
+	provisionerSecretNameKey         = provisionerSecretParameterPrefix + "name"
+	provisionerSecretNamespaceKey    = provisionerSecretParameterPrefix + "namespace"
+)
+
+// provisionerCredential is the Azure identity and subscription that clusters
+// are created in for a StorageClass with provisioner secrets
+type provisionerCredential struct {
+	subscriptionID string
+	// networkSubscriptionID is the subscription of the vnet of the clusters,
+	// it is subscriptionID if not set in the secret
+	networkSubscriptionID string
+	tenantID              string
+	clientID              string
+	clientSecret          string
+	federatedTokenFile    string
+}
+
+// parseProvisionerSecrets returns nil if there are no secrets, in which case
+// the identity and subscription of the driver are used
+func parseProvisionerSecrets(secrets map[string]string) (*provisionerCredential, error) {
+	if len(secrets) == 0 {
+		return nil, nil //nolint:nilnil // The driver credential is used without secrets
+	}
+
+	var credential provisionerCredential
+	for key, value := range secrets {
+		switch strings.ToLower(key) {
+		case SecretSubscriptionID:
+			credential.subscriptionID = value
+		case SecretNetworkSubscriptionID:
+			credential.networkSubscriptionID = value
+		case SecretTenantID:
+			credential.tenantID = value
+		case SecretClientID:
+			credential.clientID = value
+		case SecretClientSecret:
+			credential.clientSecret = value
+		case SecretFederatedTokenFile:
+			credential.federatedTokenFile = value
+		}
+	}
+
+	if len(credential.subscriptionID) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "provisioner secrets must contain %s", SecretSubscriptionID)
+	}
+	// The subscription is part of the volume ID
+	if strings.Contains(credential.subscriptionID, separator) {
+		return nil, status.Errorf(codes.InvalidArgument, "provisioner secret %s must not contain '%s'", SecretSubscriptionID, separator)
+	}
+	if len(credential.networkSubscriptionID) == 0 {
+		credential.networkSubscriptionID = credential.subscriptionID
+	}
+	if len(credential.clientID) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "provisioner secrets must contain %s", SecretClientID)
+	}
+	if len(credential.clientSecret) > 0 && len(credential.tenantID) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"provisioner secrets must contain %s when %s is provided", SecretTenantID, SecretClientSecret)
+	}
+	if len(credential.federatedTokenFile) > 0 {
+		if len(credential.clientSecret) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"provisioner secrets cannot contain both %s and %s", SecretClientSecret, SecretFederatedTokenFile)
+		}
+		if len(credential.tenantID) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"provisioner secrets must contain %s when %s is provided", SecretTenantID, SecretFederatedTokenFile)
+		}
+	}
+
+	return &credential, nil
+}
+
+// cacheKey identifies the provisioner of the credential without keeping the
+// client secret in memory in clear
+func (c *provisionerCredential) cacheKey() string {
+	hash := sha256.New()
+	for _, field := range []string{
+		strings.ToLower(c.subscriptionID),
+		strings.ToLower(c.networkSubscriptionID),
+		c.tenantID,
+		c.clientID,
+		c.clientSecret,
+		c.federatedTokenFile,
+	} {
+		_, _ = hash.Write([]byte(field))
+		_, _ = hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// tokenCredential returns a client secret credential if the secret has one.
+// Otherwise it returns a workload identity credential if a federated token
+// file is set in the secret, or in the environment of the controller when
+// the secret has a tenant ID. Otherwise it returns the credential of the
+// user-assigned managed identity with the client ID
+func (c *provisionerCredential) tokenCredential() (azcore.TokenCredential, error) {
+	switch {
+	case len(c.clientSecret) > 0:
+		return azidentity.NewClientSecretCredential(c.tenantID, c.clientID, c.clientSecret, nil)
+	case len(c.tenantID) > 0:
+		tokenFilePath := c.federatedTokenFile
+		if len(tokenFilePath) == 0 {
+			tokenFilePath = os.Getenv(federatedTokenFileEnv)
+		}
+		if len(tokenFilePath) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"provisioner secrets must contain %s or %s when the controller has no federated token",
+				SecretClientSecret, SecretFederatedTokenFile)
+		}
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			TenantID:      c.tenantID,
+			ClientID:      c.clientID,
+			TokenFilePath: tokenFilePath,
+		})
+	default:
+		return azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(c.clientID),
+		})
+	}
+}
+
+func (d *Driver) newCredentialDynamicProvisioner(credential *provisionerCredential) (DynamicProvisionerInterface, error) {
+	tokenCredential, err := credential.tokenCredential()
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "failed to create credential of client %s: %v", credential.clientID, err)
+	}
+	return newDynamicProvisioner(credential.subscriptionID, credential.networkSubscriptionID, tokenCredential, d.skuCacheTTL)
+}
+
+// getDynamicProvisioner returns the provisioner for the credential in the
+// provisioner secrets of a request, or the provisioner of the driver and a nil
+// credential if there are no secrets. The provisioner of a credential is built
+// on first use and cached, so its SKU cache and clients are reused
+func (d *Driver) getDynamicProvisioner(secrets map[string]string) (DynamicProvisionerInterface, *provisionerCredential, error) {
+	credential, err := parseProvisionerSecrets(secrets)
+	if err != nil {
+		return nil, nil, err
+	}
+	if credential == nil {
+		return d.dynamicProvisioner, nil, nil
+	}
+
+	key := credential.cacheKey()
+	d.credentialProvisionersMux.Lock()
+	defer d.credentialProvisionersMux.Unlock()
+	if d.subscriptionProvisioners == nil {
+		d.subscriptionProvisioners = make(map[string]DynamicProvisionerInterface)
+	}
+	if dynamicProvisioner, ok := d.credentialProvisioners[key]; ok {
+		d.subscriptionProvisioners[strings.ToLower(credential.subscriptionID)] = dynamicProvisioner
+		return dynamicProvisioner, credential, nil
+	}
+
+	newProvisioner := d.newCredentialProvisioner
+	if newProvisioner == nil {
+		newProvisioner = d.newCredentialDynamicProvisioner
+	}
+	dynamicProvisioner, err := newProvisioner(credential)
+	if err != nil {
+		return nil, nil, err
+	}
+	klog.V(2).Infof("created dynamic provisioner for client %s in subscription %s", credential.clientID, credential.subscriptionID)
+	if d.credentialProvisioners == nil {
+		d.credentialProvisioners = make(map[string]DynamicProvisionerInterface)
+	}
+	d.credentialProvisioners[key] = dynamicProvisioner
+	d.subscriptionProvisioners[strings.ToLower(credential.subscriptionID)] = dynamicProvisioner
+	return dynamicProvisioner, credential, nil
+}
+
+// getSubscriptionDynamicProvisioner returns the provisioner of the driver
+// for a cluster without subscription, or the provisioner of the credential
+// last used in its subscription. ListVolumes, ControllerGetVolume and
+// GetCapacity receive no secrets, so there is no provisioner for a
+// subscription until a request with its secrets, e.g. after a restart
+func (d *Driver) getSubscriptionDynamicProvisioner(subscriptionID string) (DynamicProvisionerInterface, bool) {
+	if subscriptionID == "" {
+		return d.dynamicProvisioner, true
+	}
+	d.credentialProvisionersMux.Lock()
+	defer d.credentialProvisionersMux.Unlock()
+	dynamicProvisioner, ok := d.subscriptionProvisioners[strings.ToLower(subscriptionID)]
+	return dynamicProvisioner, ok
+}
+
+// hasProvisionerSecrets returns true if the StorageClass parameters have
+// provisioner secrets, which GetCapacity does not receive
+func hasProvisionerSecrets(parameters map[string]string) bool {
+	for key := range parameters {
+		if strings.HasPrefix(strings.ToLower(key), provisionerSecretParameterPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// getStorageClassProvisionerSecrets reads the provisioner secrets referenced
+// by the StorageClass parameters, for the callers that are not given the
+// secrets by csi-provisioner. Secrets templated with the PVC are not known
+// before the PVC exists
+func (d *Driver) getStorageClassProvisionerSecrets(ctx context.Context, parameters map[string]string) (map[string]string, error) {
+	name := util.GetValueInMap(parameters, provisionerSecretNameKey)
+	namespace := util.GetValueInMap(parameters, provisionerSecretNamespaceKey)
+	if name == "" && namespace == "" {
+		return nil, nil
+	}
+	if name == "" || namespace == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "parameters %s and %s must be provided together",
+			provisionerSecretNameKey, provisionerSecretNamespaceKey)
+	}
+	if strings.Contains(name, "${") || strings.Contains(namespace, "${") {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"provisioner secret %s/%s is templated and is only known when a PVC is provisioned", namespace, name)
+	}
+	if d.kubeClient == nil {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"kubernetes client is not available to read provisioner secret %s/%s", namespace, name)
+	}
+
+	secret, err := d.kubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "failed to get provisioner secret %s/%s: %v", namespace, name, err)
+	}
+	secrets := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		secrets[key] = string(value)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,491 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const teamSubscriptionID = "team-subscription-id"
+
+// teamProvisionerSecrets are the provisioner secrets of the credential of
+// the team subscription
+var teamProvisionerSecrets = map[string]string{
+	"subscription-id": teamSubscriptionID,
+	"tenant-id":       "team-tenant-id",
+	"client-id":       "team-client-id",
+	"client-secret":   "team-client-secret",
+}
+
+// teamProvisionerSecret is the Secret of teamProvisionerSecrets referenced
+// by the storage class parameters
+var teamProvisionerSecret = &corev1.Secret{
+	ObjectMeta: metav1.ObjectMeta{Name: "team-secret", Namespace: "team"},
+	Data: map[string][]byte{
+		"subscription-id": []byte(teamSubscriptionID),
+		"tenant-id":       []byte("team-tenant-id"),
+		"client-id":       []byte("team-client-id"),
+		"client-secret":   []byte("team-client-secret"),
+	},
+}
+
+func TestParseProvisionerSecrets(t *testing.T) {
+	testCases := []struct {
+		desc               string
+		secrets            map[string]string
+		expectedCredential *provisionerCredential
+		expectedError      string
+	}{
+		{
+			desc: "No secrets",
+		},
+		{
+			desc:    "Client secret",
+			secrets: teamProvisionerSecrets,
+			expectedCredential: &provisionerCredential{
+				subscriptionID:        teamSubscriptionID,
+				networkSubscriptionID: teamSubscriptionID,
+				tenantID:              "team-tenant-id",
+				clientID:              "team-client-id",
+				clientSecret:          "team-client-secret",
+			},
+		},
+		{
+			desc: "Federated token with network subscription",
+			secrets: map[string]string{
+				"Subscription-ID":         teamSubscriptionID,
+				"network-subscription-id": "network-subscription-id",
+				"tenant-id":               "team-tenant-id",
+				"client-id":               "team-client-id",
+				"federated-token-file":    "/var/run/secrets/azure/tokens/azure-identity-token",
+			},
+			expectedCredential: &provisionerCredential{
+				subscriptionID:        teamSubscriptionID,
+				networkSubscriptionID: "network-subscription-id",
+				tenantID:              "team-tenant-id",
+				clientID:              "team-client-id",
+				federatedTokenFile:    "/var/run/secrets/azure/tokens/azure-identity-token",
+			},
+		},
+		{
+			desc:    "Managed identity",
+			secrets: map[string]string{"subscription-id": teamSubscriptionID, "client-id": "team-client-id"},
+			expectedCredential: &provisionerCredential{
+				subscriptionID:        teamSubscriptionID,
+				networkSubscriptionID: teamSubscriptionID,
+				clientID:              "team-client-id",
+			},
+		},
+		{
+			desc:          "Missing subscription",
+			secrets:       map[string]string{"client-id": "team-client-id"},
+			expectedError: "provisioner secrets must contain subscription-id",
+		},
+		{
+			desc:          "Invalid subscription",
+			secrets:       map[string]string{"subscription-id": "team#subscription", "client-id": "team-client-id"},
+			expectedError: "provisioner secret subscription-id must not contain '#'",
+		},
+		{
+			desc:          "Missing client ID",
+			secrets:       map[string]string{"subscription-id": teamSubscriptionID},
+			expectedError: "provisioner secrets must contain client-id",
+		},
+		{
+			desc: "Client secret without tenant",
+			secrets: map[string]string{
+				"subscription-id": teamSubscriptionID,
+				"client-id":       "team-client-id",
+				"client-secret":   "team-client-secret",
+			},
+			expectedError: "provisioner secrets must contain tenant-id when client-secret is provided",
+		},
+		{
+			desc: "Client secret and federated token",
+			secrets: map[string]string{
+				"subscription-id":      teamSubscriptionID,
+				"tenant-id":            "team-tenant-id",
+				"client-id":            "team-client-id",
+				"client-secret":        "team-client-secret",
+				"federated-token-file": "/token",
+			},
+			expectedError: "provisioner secrets cannot contain both client-secret and federated-token-file",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			credential, err := parseProvisionerSecrets(tC.secrets)
+			if tC.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.ErrorContains(t, err, tC.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tC.expectedCredential, credential)
+		})
+	}
+}
+
+func TestGetDynamicProvisioner(t *testing.T) {
+	d := NewFakeDriver()
+
+	dynamicProvisioner, credential, err := d.getDynamicProvisioner(nil)
+	require.NoError(t, err)
+	assert.Nil(t, credential)
+	assert.Same(t, d.dynamicProvisioner, dynamicProvisioner)
+
+	dynamicProvisioner, credential, err = d.getDynamicProvisioner(teamProvisionerSecrets)
+	require.NoError(t, err)
+	require.NotNil(t, credential)
+	assert.Equal(t, teamSubscriptionID, credential.subscriptionID)
+	assert.Same(t, d.subscriptionProvisioners[teamSubscriptionID], dynamicProvisioner)
+
+	cachedProvisioner, _, err := d.getDynamicProvisioner(teamProvisionerSecrets)
+	require.NoError(t, err)
+	assert.Same(t, dynamicProvisioner, cachedProvisioner)
+
+	rotatedSecrets := maps.Clone(teamProvisionerSecrets)
+	rotatedSecrets["client-secret"] = "rotated-client-secret"
+	rotatedProvisioner, _, err := d.getDynamicProvisioner(rotatedSecrets)
+	require.NoError(t, err)
+	assert.NotSame(t, dynamicProvisioner, rotatedProvisioner)
+	assert.Len(t, d.credentialProvisioners, 2)
+}
+
+func TestGetDynamicProvisioner_Err(t *testing.T) {
+	d := NewFakeDriver()
+	d.newCredentialProvisioner = func(_ *provisionerCredential) (DynamicProvisionerInterface, error) {
+		return nil, status.Error(codes.InvalidArgument, "invalid credential")
+	}
+
+	_, _, err := d.getDynamicProvisioner(teamProvisionerSecrets)
+	require.ErrorContains(t, err, "invalid credential")
+	assert.Empty(t, d.credentialProvisioners)
+}
+
+func TestDynamicCreateVolume_Success_ProvisionerSecrets(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Secrets = maps.Clone(teamProvisionerSecrets)
+	req.Secrets["network-subscription-id"] = "network-subscription-id"
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(volumeIDTemplate, "test_volume", DefaultLustreFsName, "127.0.0.2", "testSubDir", "t", "test-resource-group")+
+		"####"+teamSubscriptionID, rep.GetVolume().GetVolumeId())
+	assert.Equal(t, teamSubscriptionID, rep.GetVolume().GetVolumeContext()[VolumeContextInternalSubscriptionID])
+
+	assert.Empty(t, d.dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount)
+	fakeDynamicProvisioner := d.subscriptionProvisioners[teamSubscriptionID].(*FakeDynamicProvisioner)
+	require.NotNil(t, fakeDynamicProvisioner)
+	require.Len(t, fakeDynamicProvisioner.Filesystems, 1)
+	assert.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["BeginCreateAmlFilesystem"])
+	assert.Equal(t, fmt.Sprintf(subnetTemplate, "network-subscription-id", "test-vnet-rg", "test-vnet-name", "test-subnet-name"),
+		fakeDynamicProvisioner.Filesystems[0].SubnetInfo.SubnetID)
+
+	vol, err := getLustreVolFromID(rep.GetVolume().GetVolumeId())
+	require.NoError(t, err)
+	assert.Equal(t, teamSubscriptionID, vol.subscriptionID)
+	assert.Empty(t, vol.archiveOnDeletePath)
+}
+
+func TestDynamicCreateVolume_Success_ProvisionerSecretsArchiveOnDelete(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Parameters[VolumeContextHsmContainer] = "data-container"
+	req.Parameters[VolumeContextHsmLoggingContainer] = "logging-container"
+	req.Parameters[VolumeContextArchiveOnDelete] = "true"
+	req.Secrets = maps.Clone(teamProvisionerSecrets)
+
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	vol, err := getLustreVolFromID(rep.GetVolume().GetVolumeId())
+	require.NoError(t, err)
+	assert.Equal(t, defaultArchiveOnDeletePath, vol.archiveOnDeletePath)
+	assert.Equal(t, teamSubscriptionID, vol.subscriptionID)
+}
+
+func TestDynamicDeleteVolume_ProvisionerSecrets(t *testing.T) {
+	volumeID := fmt.Sprintf(volumeIDTemplate, "test_volume", DefaultLustreFsName, "127.0.0.2", "testSubDir", "t", "test-resource-group") +
+		"####" + teamSubscriptionID
+	otherSecrets := maps.Clone(teamProvisionerSecrets)
+	otherSecrets["subscription-id"] = "other-subscription-id"
+
+	testCases := []struct {
+		desc          string
+		volumeID      string
+		secrets       map[string]string
+		expectedCode  codes.Code
+		expectedError string
+	}{
+		{
+			desc:     "Matching secrets",
+			volumeID: volumeID,
+			secrets:  teamProvisionerSecrets,
+		},
+		{
+			desc:          "Missing secrets",
+			volumeID:      volumeID,
+			expectedCode:  codes.FailedPrecondition,
+			expectedError: "DeleteVolume AMLFS cluster test_volume was created in subscription team-subscription-id, secrets for that subscription are required",
+		},
+		{
+			desc:          "Secrets of another subscription",
+			volumeID:      volumeID,
+			secrets:       otherSecrets,
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "secrets are for subscription other-subscription-id",
+		},
+		{
+			desc:     "Volume created without secrets",
+			volumeID: fmt.Sprintf(volumeIDTemplate, "test_volume", DefaultLustreFsName, "127.0.0.2", "testSubDir", "t", "test-resource-group"),
+			secrets:  teamProvisionerSecrets,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{
+				VolumeId: tC.volumeID,
+				Secrets:  tC.secrets,
+			})
+			driverCallCount := d.dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount
+			if tC.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, tC.expectedCode, status.Code(err))
+				require.ErrorContains(t, err, tC.expectedError)
+				assert.Empty(t, driverCallCount)
+				for _, dynamicProvisioner := range d.credentialProvisioners {
+					assert.Empty(t, dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount)
+				}
+				return
+			}
+			require.NoError(t, err)
+			deleteCallCount := driverCallCount["DeleteAmlFilesystem"]
+			if dynamicProvisioner, ok := d.subscriptionProvisioners[teamSubscriptionID]; ok {
+				deleteCallCount += dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount["DeleteAmlFilesystem"]
+			}
+			assert.Equal(t, 1, deleteCallCount)
+		})
+	}
+}
+
+func TestDynamicDeleteVolume_ProvisionerSecretsTargetSubscription(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Secrets = maps.Clone(teamProvisionerSecrets)
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{
+		VolumeId: rep.GetVolume().GetVolumeId(),
+		Secrets:  teamProvisionerSecrets,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, d.dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount)
+	fakeDynamicProvisioner := d.subscriptionProvisioners[teamSubscriptionID].(*FakeDynamicProvisioner)
+	assert.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["DeleteAmlFilesystem"])
+	assert.Empty(t, fakeDynamicProvisioner.Filesystems)
+}
+
+func TestGetSubscriptionDynamicProvisioner(t *testing.T) {
+	d := NewFakeDriver()
+
+	dynamicProvisioner, ok := d.getSubscriptionDynamicProvisioner("")
+	assert.True(t, ok)
+	assert.Same(t, d.dynamicProvisioner, dynamicProvisioner)
+	_, ok = d.getSubscriptionDynamicProvisioner(teamSubscriptionID)
+	assert.False(t, ok)
+
+	_, _, err := d.getDynamicProvisioner(teamProvisionerSecrets)
+	require.NoError(t, err)
+	dynamicProvisioner, ok = d.getSubscriptionDynamicProvisioner("TEAM-subscription-id")
+	assert.True(t, ok)
+	assert.Same(t, d.subscriptionProvisioners[teamSubscriptionID], dynamicProvisioner)
+}
+
+func TestGetStorageClassProvisionerSecrets(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		parameters      map[string]string
+		expectedSecrets map[string]string
+		expectedCode    codes.Code
+		expectedError   string
+	}{
+		{
+			desc:       "No secrets",
+			parameters: map[string]string{"sku-name": "AMLFS-Durable-Premium-250"},
+		},
+		{
+			desc: "Secret",
+			parameters: map[string]string{
+				"csi.storage.k8s.io/provisioner-secret-name":      "team-secret",
+				"csi.storage.k8s.io/provisioner-secret-namespace": "team",
+			},
+			expectedSecrets: teamProvisionerSecrets,
+		},
+		{
+			desc: "Missing namespace",
+			parameters: map[string]string{
+				"csi.storage.k8s.io/provisioner-secret-name": "team-secret",
+			},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "must be provided together",
+		},
+		{
+			desc: "Templated secret",
+			parameters: map[string]string{
+				"csi.storage.k8s.io/provisioner-secret-name":      "${pvc.name}",
+				"csi.storage.k8s.io/provisioner-secret-namespace": "team",
+			},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "provisioner secret team/${pvc.name} is templated",
+		},
+		{
+			desc: "Secret not found",
+			parameters: map[string]string{
+				"csi.storage.k8s.io/provisioner-secret-name":      "missing-secret",
+				"csi.storage.k8s.io/provisioner-secret-namespace": "team",
+			},
+			expectedCode:  codes.FailedPrecondition,
+			expectedError: "failed to get provisioner secret team/missing-secret",
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			d := NewFakeDriver(withFakeKubeClient(teamProvisionerSecret))
+
+			secrets, err := d.getStorageClassProvisionerSecrets(context.Background(), tC.parameters)
+			if tC.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, tC.expectedCode, status.Code(err))
+				assert.ErrorContains(t, err, tC.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tC.expectedSecrets, secrets)
+		})
+	}
+}
+
+func TestControllerGetVolume_ProvisionerSecrets(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Secrets = maps.Clone(teamProvisionerSecrets)
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+
+	resp, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{
+		VolumeId: rep.GetVolume().GetVolumeId(),
+	})
+	require.NoError(t, err)
+	assert.False(t, resp.GetStatus().GetVolumeCondition().GetAbnormal())
+	assert.Equal(t, rep.GetVolume().GetCapacityBytes(), resp.GetVolume().GetCapacityBytes())
+	assert.Empty(t, d.dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount)
+	assert.Equal(t, 1, d.subscriptionProvisioners[teamSubscriptionID].(*FakeDynamicProvisioner).fakeCallCount["GetAmlFilesystem"])
+
+	// A restarted controller knows no credential of the subscription until
+	// a request with its secrets
+	restartedDriver := NewFakeDriver()
+	resp, err = restartedDriver.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{
+		VolumeId: rep.GetVolume().GetVolumeId(),
+	})
+	require.NoError(t, err)
+	assert.False(t, resp.GetStatus().GetVolumeCondition().GetAbnormal())
+	assert.Contains(t, resp.GetStatus().GetVolumeCondition().GetMessage(),
+		"is in subscription "+teamSubscriptionID+", its health is not monitored")
+	assert.Empty(t, restartedDriver.dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount)
+}
+
+func TestListVolumes_ProvisionerSecrets(t *testing.T) {
+	d := NewFakeDriver(withFakeKubeClient())
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Secrets = maps.Clone(teamProvisionerSecrets)
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	volumeID := rep.GetVolume().GetVolumeId()
+	deletedVolumeID := strings.Replace(volumeID, req.GetName(), "deleted-cluster", 1)
+	for pvName, volumeHandle := range map[string]string{"pv-team": volumeID, "pv-deleted-cluster": deletedVolumeID} {
+		_, err = d.kubeClient.CoreV1().PersistentVolumes().Create(context.Background(),
+			newListVolumesTestPV(pvName, fakeDriverName, volumeHandle), metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	resp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEntries(), 1)
+	assert.Equal(t, volumeID, resp.GetEntries()[0].GetVolume().GetVolumeId())
+	assert.Equal(t, rep.GetVolume().GetCapacityBytes(), resp.GetEntries()[0].GetVolume().GetCapacityBytes())
+	assert.False(t, resp.GetEntries()[0].GetStatus().GetVolumeCondition().GetAbnormal())
+	assert.Equal(t, 1, d.dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount["ListAmlFilesystems"])
+	assert.Equal(t, 1, d.subscriptionProvisioners[teamSubscriptionID].(*FakeDynamicProvisioner).fakeCallCount["ListAmlFilesystems"])
+
+	// A restarted controller knows no credential of the subscription until
+	// a request with its secrets
+	restartedDriver := NewFakeDriver()
+	restartedDriver.kubeClient = d.kubeClient
+	resp, err = restartedDriver.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEntries(), 2)
+	for _, entry := range resp.GetEntries() {
+		assert.False(t, entry.GetStatus().GetVolumeCondition().GetAbnormal())
+		assert.Contains(t, entry.GetStatus().GetVolumeCondition().GetMessage(),
+			"is in subscription "+teamSubscriptionID+", its health is not monitored")
+	}
+}
+
+func TestGetCapacity_Success_ProvisionerSecrets(t *testing.T) {
+	d := NewFakeDriver()
+	req := buildGetCapacityRequest()
+	req.Parameters["csi.storage.k8s.io/provisioner-secret-name"] = "team-secret"
+	req.Parameters["csi.storage.k8s.io/provisioner-secret-namespace"] = "team"
+
+	resp, err := d.GetCapacity(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), resp.GetAvailableCapacity())
+	assert.Empty(t, d.dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount)
+}
+
+func TestPlanVolume_Success_ProvisionerSecrets(t *testing.T) {
+	d := NewFakeDriver(withFakeKubeClient(teamProvisionerSecret))
+	parameters := buildDynamicProvCreateVolumeRequest().Parameters
+	parameters["csi.storage.k8s.io/provisioner-secret-name"] = "team-secret"
+	parameters["csi.storage.k8s.io/provisioner-secret-namespace"] = "team"
+
+	volumePlan, err := d.PlanVolume(context.Background(), "pvc-plan", 0, parameters)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(subnetTemplate, teamSubscriptionID, "test-vnet-rg", "test-vnet-name", "test-subnet-name"),
+		volumePlan.Subnet.SubnetID)
+	assert.Empty(t, d.dynamicProvisioner.(*FakeDynamicProvisioner).fakeCallCount)
+	assert.Equal(t, map[string]int{"GetSkuValuesForLocation": 1, "CheckSubnetCapacity": 1},
+		d.subscriptionProvisioners[teamSubscriptionID].(*FakeDynamicProvisioner).fakeCallCount)
+}
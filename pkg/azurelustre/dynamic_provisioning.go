@@ -3,19 +3,25 @@ package azurelustre
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 )
 
@@ -29,21 +35,190 @@ const (
 	AmlfsSkuResourceType                       = "amlFilesystems"
 	AmlfsSkuCapacityIncrementName              = "OSS capacity increment (TiB)"
 	AmlfsSkuCapacityMaximumName                = "default maximum capacity (TiB)"
+	AmlfsQuotaUsageName                        = "amlFilesystems"
+	defaultArchivePollFrequency                = 30 * time.Second
+	otherArchiveInProgressMsg                  = "is archived once it completes"
+	otherArchiveInProgressFmt                  = "archive of path %s of AMLFS cluster %s is in progress, path %s " + otherArchiveInProgressMsg
+	amlFilesystemCreationRetryFmt              = "AMLFS cluster %s creation timed out. Deleted failed cluster, retrying cluster creation"
 )
 
 type DynamicProvisionerInterface interface {
 	DeleteAmlFilesystem(ctx context.Context, resourceGroupName, amlFilesystemName string) error
-	CreateAmlFilesystem(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) (string, error)
+	ArchiveAmlFilesystem(ctx context.Context, resourceGroupName, amlFilesystemName, filesystemPath string) error
+	BeginCreateAmlFilesystem(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) (string, error)
+	PollCreateAmlFilesystem(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties, resumeToken string) (string, error)
+	ListAmlFilesystems(ctx context.Context) ([]*AmlFilesystemInfo, error)
+	GetAmlFilesystem(ctx context.Context, resourceGroupName, amlFilesystemName string) (*AmlFilesystemInfo, error)
+	GetAmlFilesystemInSubscription(ctx context.Context, subscriptionID, resourceGroupName, amlFilesystemName string) (*AmlFilesystemInfo, error)
 	GetSkuValuesForLocation(ctx context.Context, location string) (map[string]*LustreSkuValue, error)
+	GetAvailableCapacity(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties, lustreSkuValue *LustreSkuValue) (int64, error)
+	SelectSubnet(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) (SubnetProperties, error)
+	CheckSubnetCapacity(ctx context.Context, subnetInfo SubnetProperties, sku string, clusterSize float32) (bool, error)
+	CreateImportJob(ctx context.Context, importJobProperties *ImportJobProperties) error
+	GetImportJobStatus(ctx context.Context, resourceGroupName, amlFilesystemName, importJobName string) (*ImportJobStatus, error)
 }
 
 type DynamicProvisioner struct {
 	DynamicProvisionerInterface
 	amlFilesystemsClient *armstoragecache.AmlFilesystemsClient
+	ascUsagesClient      *armstoragecache.AscUsagesClient
+	importJobsClient     *armstoragecache.ImportJobsClient
 	mgmtClient           *armstoragecache.ManagementClient
 	skusClient           *armstoragecache.SKUsClient
 	vnetClient           *armnetwork.VirtualNetworksClient
 	pollFrequency        time.Duration
+	// skuCache is nil if SKU values are retrieved for every request
+	skuCache *skuCache
+	// subnetSizes keeps the required subnet size of each SKU and cluster
+	// size, which never changes, so that GetCapacity polls do not repeat
+	// the ARM calls of their search for the largest cluster
+	subnetSizes    map[subnetSizeKey]int
+	subnetSizesMux sync.Mutex
+	// Clusters referenced by name may be in another subscription than
+	// subscriptionID, their clients are created with credential on first use
+	subscriptionID           string
+	credential               azcore.TokenCredential
+	amlFilesystemsClients    map[string]*armstoragecache.AmlFilesystemsClient
+	amlFilesystemsClientsMux sync.Mutex
+}
+
+// newDynamicProvisioner creates the clients of the AMLFS clusters in
+// subscriptionID and of their vnets in networkSubscriptionID
+func newDynamicProvisioner(subscriptionID, networkSubscriptionID string, credential azcore.TokenCredential, skuCacheTTL time.Duration) (*DynamicProvisioner, error) {
+	storageClientFactory, err := armstoragecache.NewClientFactory(subscriptionID, credential, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create storage client factory for subscription %s: %v", subscriptionID, err)
+	}
+	networkClientFactory, err := armnetwork.NewClientFactory(networkSubscriptionID, credential, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create network client factory for subscription %s: %v", networkSubscriptionID, err)
+	}
+
+	dynamicProvisioner := &DynamicProvisioner{
+		amlFilesystemsClient: storageClientFactory.NewAmlFilesystemsClient(),
+		ascUsagesClient:      storageClientFactory.NewAscUsagesClient(),
+		importJobsClient:     storageClientFactory.NewImportJobsClient(),
+		mgmtClient:           storageClientFactory.NewManagementClient(),
+		skusClient:           storageClientFactory.NewSKUsClient(),
+		vnetClient:           networkClientFactory.NewVirtualNetworksClient(),
+		subscriptionID:       subscriptionID,
+		credential:           credential,
+	}
+	if skuCacheTTL > 0 {
+		dynamicProvisioner.skuCache = newSkuCache(skuCacheTTL)
+	}
+	return dynamicProvisioner, nil
+}
+
+// mockDynamicProvisioner is the dynamic provisioner of a driver running with
+// mock dynamic provisioning and no cloud config, only for testing. It has no
+// clients, and no AMLFS cluster to list
+type mockDynamicProvisioner struct {
+	DynamicProvisioner
+}
+
+func (m *mockDynamicProvisioner) ListAmlFilesystems(_ context.Context) ([]*AmlFilesystemInfo, error) {
+	return nil, nil
+}
+
+type AmlFilesystemInfo struct {
+	Name                    string
+	ResourceGroupName       string
+	MgsAddress              string
+	StorageCapacityTiB      float32
+	Tags                    map[string]string
+	ProvisioningState       armstoragecache.AmlFilesystemProvisioningStateType
+	HealthState             armstoragecache.AmlFilesystemHealthStateType
+	HealthStatusDescription string
+	ArchiveState            armstoragecache.ArchiveStatusType
+	ArchiveErrorMessage     string
+	// HsmContainer is the blob container the cluster archives to, it is
+	// empty if HSM is not configured
+	HsmContainer string
+	// ArchiveStartTimes is the start time of the last archive of each
+	// archived path
+	ArchiveStartTimes map[string]time.Time
+}
+
+func newAmlFilesystemInfo(amlFilesystem *armstoragecache.AmlFilesystem, resourceGroupName string) *AmlFilesystemInfo {
+	amlFilesystemInfo := &AmlFilesystemInfo{
+		ResourceGroupName: resourceGroupName,
+		Tags:              make(map[string]string, len(amlFilesystem.Tags)),
+	}
+	if amlFilesystem.Name != nil {
+		amlFilesystemInfo.Name = *amlFilesystem.Name
+	}
+	for key, value := range amlFilesystem.Tags {
+		if value != nil {
+			amlFilesystemInfo.Tags[key] = *value
+		}
+	}
+
+	properties := amlFilesystem.Properties
+	if properties == nil {
+		return amlFilesystemInfo
+	}
+	if properties.ClientInfo != nil && properties.ClientInfo.MgsAddress != nil {
+		amlFilesystemInfo.MgsAddress = *properties.ClientInfo.MgsAddress
+	}
+	if properties.StorageCapacityTiB != nil {
+		amlFilesystemInfo.StorageCapacityTiB = *properties.StorageCapacityTiB
+	}
+	if properties.ProvisioningState != nil {
+		amlFilesystemInfo.ProvisioningState = *properties.ProvisioningState
+	}
+	if properties.Health != nil {
+		if properties.Health.State != nil {
+			amlFilesystemInfo.HealthState = *properties.Health.State
+		}
+		if properties.Health.StatusDescription != nil {
+			amlFilesystemInfo.HealthStatusDescription = *properties.Health.StatusDescription
+		}
+	}
+	if properties.Hsm != nil {
+		if properties.Hsm.Settings != nil && properties.Hsm.Settings.Container != nil {
+			amlFilesystemInfo.HsmContainer = *properties.Hsm.Settings.Container
+		}
+		for _, archive := range properties.Hsm.ArchiveStatus {
+			if archive == nil || archive.FilesystemPath == nil || archive.Status == nil || archive.Status.LastStartedTime == nil {
+				continue
+			}
+			if amlFilesystemInfo.ArchiveStartTimes == nil {
+				amlFilesystemInfo.ArchiveStartTimes = make(map[string]time.Time)
+			}
+			amlFilesystemInfo.ArchiveStartTimes[*archive.FilesystemPath] = *archive.Status.LastStartedTime
+		}
+		for _, archive := range properties.Hsm.ArchiveStatus {
+			if archive == nil || archive.Status == nil || archive.Status.State == nil {
+				continue
+			}
+			amlFilesystemInfo.ArchiveState = *archive.Status.State
+			if archive.Status.ErrorMessage != nil {
+				amlFilesystemInfo.ArchiveErrorMessage = *archive.Status.ErrorMessage
+			}
+			break
+		}
+	}
+	return amlFilesystemInfo
+}
+
+type ImportJobProperties struct {
+	ResourceGroupName      string
+	AmlFilesystemName      string
+	ImportJobName          string
+	Location               string
+	ImportPrefixes         []string
+	ConflictResolutionMode armstoragecache.ConflictResolutionMode
+	MaximumErrors          int32
+}
+
+type ImportJobStatus struct {
+	State              armstoragecache.ImportStatusType
+	StatusMessage      string
+	TotalBlobsWalked   int64
+	TotalBlobsImported int64
+	TotalConflicts     int32
+	TotalErrors        int32
 }
 
 func convertHTTPResponseErrorToGrpcCodeError(err error) error {
@@ -167,7 +342,165 @@ func (d *DynamicProvisioner) DeleteAmlFilesystem(ctx context.Context, resourceGr
 	return nil
 }
 
+// getArchiveStatus returns the status of the last archive of filesystemPath,
+// and the path of the archive running on the cluster if it is another path,
+// as a cluster runs a single archive at a time
+func (d *DynamicProvisioner) getArchiveStatus(ctx context.Context, resourceGroupName, amlFilesystemName, filesystemPath string) (*armstoragecache.AmlFilesystemArchiveStatus, string, error) {
+	resp, err := d.amlFilesystemsClient.Get(ctx, resourceGroupName, amlFilesystemName, nil)
+	if err != nil {
+		if strings.Contains(err.Error(), "ResourceNotFound") {
+			return nil, "", status.Errorf(codes.NotFound, "AMLFS cluster %s not found", amlFilesystemName)
+		}
+		klog.Warningf("error when retrieving the aml filesystem: %v", err)
+		return nil, "", convertHTTPResponseErrorToGrpcCodeError(err)
+	}
+
+	if resp.Properties == nil || resp.Properties.Hsm == nil {
+		return nil, "", nil
+	}
+
+	var archiveStatus *armstoragecache.AmlFilesystemArchiveStatus
+	otherArchivePath := ""
+	for _, archive := range resp.Properties.Hsm.ArchiveStatus {
+		if archive == nil || archive.Status == nil || archive.FilesystemPath == nil {
+			continue
+		}
+		if *archive.FilesystemPath == filesystemPath {
+			archiveStatus = archive.Status
+		} else if isArchiveInProgress(archive.Status) {
+			otherArchivePath = *archive.FilesystemPath
+		}
+	}
+	return archiveStatus, otherArchivePath, nil
+}
+
+// isOtherArchiveInProgress reports whether err was returned by
+// ArchiveAmlFilesystem because an archive of another path is running, in
+// which case the archive can be retried once it completes
+func isOtherArchiveInProgress(err error) bool {
+	return status.Code(err) == codes.Aborted && strings.HasSuffix(status.Convert(err).Message(), otherArchiveInProgressMsg)
+}
+
+func isArchiveInProgress(archiveStatus *armstoragecache.AmlFilesystemArchiveStatus) bool {
+	if archiveStatus == nil || archiveStatus.State == nil {
+		return false
+	}
+	switch *archiveStatus.State { //nolint:exhaustive // All other states mean no archive is running
+	case armstoragecache.ArchiveStatusTypeInProgress,
+		armstoragecache.ArchiveStatusTypeFSScanInProgress,
+		armstoragecache.ArchiveStatusTypeCancelling:
+		return true
+	}
+	return false
+}
+
+// ArchiveAmlFilesystem exports the given filesystem path to the HSM blob
+// container and waits for the archive to finish. If an archive of the path is
+// already running, it waits for that one instead of starting a new one. If an
+// archive of another path is running, Aborted is returned so that the caller
+// retries once it completes.
+func (d *DynamicProvisioner) ArchiveAmlFilesystem(ctx context.Context, resourceGroupName, amlFilesystemName, filesystemPath string) error {
+	if d.amlFilesystemsClient == nil {
+		return status.Error(codes.Internal, "aml filesystem client is nil")
+	}
+
+	archiveStatus, otherArchivePath, err := d.getArchiveStatus(ctx, resourceGroupName, amlFilesystemName, filesystemPath)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			klog.V(2).Infof("AMLFS cluster %s not found, skipping archive", amlFilesystemName)
+			return nil
+		}
+		return err
+	}
+	if otherArchivePath != "" {
+		return status.Errorf(codes.Aborted, otherArchiveInProgressFmt, otherArchivePath, amlFilesystemName, filesystemPath)
+	}
+
+	// Archive status is only replaced once the new archive starts, so the
+	// start time of the previous archive is used to ignore stale results
+	var previousStartTime *time.Time
+	if isArchiveInProgress(archiveStatus) {
+		klog.V(2).Infof("archive already in progress for AMLFS cluster %s, waiting for it to complete", amlFilesystemName)
+	} else {
+		if archiveStatus != nil && archiveStatus.LastStartedTime != nil {
+			previousStartTime = archiveStatus.LastStartedTime
+		}
+
+		klog.V(2).Infof("archiving path %s of AMLFS cluster %s", filesystemPath, amlFilesystemName)
+		_, err = d.amlFilesystemsClient.Archive(ctx, resourceGroupName, amlFilesystemName, &armstoragecache.AmlFilesystemsClientArchiveOptions{
+			ArchiveInfo: &armstoragecache.AmlFilesystemArchiveInfo{
+				FilesystemPath: to.Ptr(filesystemPath),
+			},
+		})
+		if err != nil {
+			klog.Warningf("failed to start archive of AMLFS cluster %s: %v", amlFilesystemName, err)
+			return convertHTTPResponseErrorToGrpcCodeError(err)
+		}
+	}
+
+	pollFrequency := d.pollFrequency
+	if pollFrequency == 0 {
+		pollFrequency = defaultArchivePollFrequency
+	}
+	err = wait.PollUntilContextCancel(ctx, pollFrequency, false, func(ctx context.Context) (bool, error) {
+		archiveStatus, otherArchivePath, err := d.getArchiveStatus(ctx, resourceGroupName, amlFilesystemName, filesystemPath)
+		if err != nil {
+			return false, err
+		}
+		if archiveStatus == nil || archiveStatus.State == nil {
+			if otherArchivePath != "" {
+				// The archive of the path was replaced before it was reported
+				return false, status.Errorf(codes.Aborted, otherArchiveInProgressFmt, otherArchivePath, amlFilesystemName, filesystemPath)
+			}
+			return false, nil
+		}
+		if previousStartTime != nil && (archiveStatus.LastStartedTime == nil || !archiveStatus.LastStartedTime.After(*previousStartTime)) {
+			return false, nil
+		}
+
+		switch *archiveStatus.State { //nolint:exhaustive // All other states are still in progress
+		case armstoragecache.ArchiveStatusTypeCompleted:
+			return true, nil
+		case armstoragecache.ArchiveStatusTypeFailed,
+			armstoragecache.ArchiveStatusTypeCanceled,
+			armstoragecache.ArchiveStatusTypeNotConfigured:
+			errorMessage := ""
+			if archiveStatus.ErrorMessage != nil {
+				errorMessage = *archiveStatus.ErrorMessage
+			}
+			return false, status.Errorf(codes.Aborted, "archive of AMLFS cluster %s ended in state %s: %s",
+				amlFilesystemName, *archiveStatus.State, errorMessage)
+		}
+
+		if archiveStatus.PercentComplete != nil {
+			klog.V(4).Infof("archive of AMLFS cluster %s is %d%% complete", amlFilesystemName, *archiveStatus.PercentComplete)
+		}
+		return false, nil
+	})
+	if err != nil {
+		klog.Warningf("failed to archive AMLFS cluster %s: %v", amlFilesystemName, err)
+		return convertHTTPResponseErrorToGrpcCodeError(err)
+	}
+
+	klog.V(2).Infof("Successfully archived path %s of AML filesystem: %s", filesystemPath, amlFilesystemName)
+	return nil
+}
+
+// CreateAmlFilesystem creates the AMLFS cluster and waits for the creation to
+// complete, returning the MGS address of the cluster
 func (d *DynamicProvisioner) CreateAmlFilesystem(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) (string, error) {
+	resumeToken, err := d.BeginCreateAmlFilesystem(ctx, amlFilesystemProperties)
+	if err != nil {
+		return "", err
+	}
+	return d.PollCreateAmlFilesystem(ctx, amlFilesystemProperties, resumeToken)
+}
+
+// BeginCreateAmlFilesystem starts the creation of the AMLFS cluster and
+// returns the resume token of the long-running operation, which can be
+// persisted to poll the same operation with PollCreateAmlFilesystem after a
+// restart. The token is empty if the operation completed immediately
+func (d *DynamicProvisioner) BeginCreateAmlFilesystem(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) (string, error) {
 	if d.amlFilesystemsClient == nil {
 		return "", status.Error(codes.Internal, "aml filesystem client is nil")
 	}
@@ -196,6 +529,39 @@ func (d *DynamicProvisioner) CreateAmlFilesystem(ctx context.Context, amlFilesys
 	if amlFilesystemProperties.Zone != "" {
 		amlFilesystem.Zones = []*string{to.Ptr(amlFilesystemProperties.Zone)}
 	}
+	if amlFilesystemProperties.HsmContainer != "" {
+		hsmSettings := &armstoragecache.AmlFilesystemHsmSettings{
+			Container:        to.Ptr(amlFilesystemProperties.HsmContainer),
+			LoggingContainer: to.Ptr(amlFilesystemProperties.HsmLoggingContainer),
+		}
+		if len(amlFilesystemProperties.HsmImportPrefixes) > 0 {
+			hsmSettings.ImportPrefixesInitial = to.SliceOfPtrs(amlFilesystemProperties.HsmImportPrefixes...)
+		}
+		properties.Hsm = &armstoragecache.AmlFilesystemPropertiesHsm{
+			Settings: hsmSettings,
+		}
+	}
+	if amlFilesystemProperties.RootSquashSettings.Mode != "" {
+		rootSquashSettings := &armstoragecache.AmlFilesystemRootSquashSettings{
+			Mode:      to.Ptr(amlFilesystemProperties.RootSquashSettings.Mode),
+			SquashUID: amlFilesystemProperties.RootSquashSettings.SquashUID,
+			SquashGID: amlFilesystemProperties.RootSquashSettings.SquashGID,
+		}
+		if amlFilesystemProperties.RootSquashSettings.NoSquashNidLists != "" {
+			rootSquashSettings.NoSquashNidLists = to.Ptr(amlFilesystemProperties.RootSquashSettings.NoSquashNidLists)
+		}
+		properties.RootSquashSettings = rootSquashSettings
+	}
+	if amlFilesystemProperties.EncryptionKeyURL != "" {
+		properties.EncryptionSettings = &armstoragecache.AmlFilesystemEncryptionSettings{
+			KeyEncryptionKey: &armstoragecache.KeyVaultKeyReference{
+				KeyURL: to.Ptr(amlFilesystemProperties.EncryptionKeyURL),
+				SourceVault: &armstoragecache.KeyVaultKeyReferenceSourceVault{
+					ID: to.Ptr(amlFilesystemProperties.EncryptionKeyVaultID),
+				},
+			},
+		}
+	}
 	if amlFilesystemProperties.Identities != nil {
 		userAssignedIdentities := make(map[string]*armstoragecache.UserAssignedIdentitiesValue, len(amlFilesystemProperties.Identities))
 		for _, identity := range amlFilesystemProperties.Identities {
@@ -252,6 +618,51 @@ func (d *DynamicProvisioner) CreateAmlFilesystem(ctx context.Context, amlFilesys
 		return "", convertHTTPResponseErrorToGrpcCodeError(err)
 	}
 
+	if poller.Done() {
+		klog.V(2).Infof("creation of AMLFS cluster %s completed immediately", amlFilesystemProperties.AmlFilesystemName)
+		return "", nil
+	}
+
+	resumeToken, err := poller.ResumeToken()
+	if err != nil {
+		klog.Errorf("failed to get resume token for creation of AMLFS cluster %s: %v", amlFilesystemProperties.AmlFilesystemName, err)
+		return "", status.Errorf(codes.Internal, "failed to get resume token for creation of AMLFS cluster %s: %v", amlFilesystemProperties.AmlFilesystemName, err)
+	}
+
+	return resumeToken, nil
+}
+
+// PollCreateAmlFilesystem waits for the creation started by
+// BeginCreateAmlFilesystem to complete and returns the MGS address of the
+// cluster. Without a resume token, the current cluster is used instead
+func (d *DynamicProvisioner) PollCreateAmlFilesystem(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties, resumeToken string) (string, error) {
+	if d.amlFilesystemsClient == nil {
+		return "", status.Error(codes.Internal, "aml filesystem client is nil")
+	}
+
+	if resumeToken == "" {
+		amlFilesystem, err := d.GetAmlFilesystem(ctx, amlFilesystemProperties.ResourceGroupName, amlFilesystemProperties.AmlFilesystemName)
+		if err != nil {
+			return "", err
+		}
+		if amlFilesystem.MgsAddress == "" {
+			return "", status.Errorf(codes.Unavailable, "AMLFS cluster %s has no MGS address, provisioning state: %s",
+				amlFilesystemProperties.AmlFilesystemName, amlFilesystem.ProvisioningState)
+		}
+		return amlFilesystem.MgsAddress, nil
+	}
+
+	poller, err := d.amlFilesystemsClient.BeginCreateOrUpdate(
+		ctx,
+		amlFilesystemProperties.ResourceGroupName,
+		amlFilesystemProperties.AmlFilesystemName,
+		armstoragecache.AmlFilesystem{},
+		&armstoragecache.AmlFilesystemsClientBeginCreateOrUpdateOptions{ResumeToken: resumeToken})
+	if err != nil {
+		klog.Errorf("failed to resume creation of AMLFS cluster %s: %v", amlFilesystemProperties.AmlFilesystemName, err)
+		return "", status.Errorf(codes.Internal, "failed to resume creation of AMLFS cluster %s: %v", amlFilesystemProperties.AmlFilesystemName, err)
+	}
+
 	pollerOptions := &runtime.PollUntilDoneOptions{
 		Frequency: d.pollFrequency,
 	}
@@ -281,7 +692,14 @@ func (d *DynamicProvisioner) tryDeleteBeforeRetry(ctx context.Context, amlFilesy
 		klog.Errorf("error attempting to delete AMLFS cluster %s for creation retry: %v", amlFilesystemProperties.AmlFilesystemName, err)
 		return convertHTTPResponseErrorToGrpcCodeError(err)
 	}
-	return status.Errorf(codes.Aborted, "AMLFS cluster %s creation timed out. Deleted failed cluster, retrying cluster creation", amlFilesystemProperties.AmlFilesystemName)
+	return status.Errorf(codes.Aborted, amlFilesystemCreationRetryFmt, amlFilesystemProperties.AmlFilesystemName)
+}
+
+// isAmlFilesystemCreationRetry returns whether err was returned by
+// tryDeleteBeforeRetry after a failed cluster was deleted
+func isAmlFilesystemCreationRetry(err error, amlFilesystemName string) bool {
+	return status.Code(err) == codes.Aborted &&
+		status.Convert(err).Message() == fmt.Sprintf(amlFilesystemCreationRetryFmt, amlFilesystemName)
 }
 
 func (d *DynamicProvisioner) checkErrorForRetry(ctx context.Context, err error, amlFilesystemProperties *AmlFilesystemProperties) (bool, error) {
@@ -307,7 +725,201 @@ func (d *DynamicProvisioner) checkErrorForRetry(ctx context.Context, err error,
 	return false, nil
 }
 
+// ListAmlFilesystems lists the AMLFS clusters in the subscription that were
+// created by this driver and have finished provisioning
+func (d *DynamicProvisioner) ListAmlFilesystems(ctx context.Context) ([]*AmlFilesystemInfo, error) {
+	if d.amlFilesystemsClient == nil {
+		return nil, status.Error(codes.Internal, "aml filesystem client is nil")
+	}
+
+	var amlFilesystems []*AmlFilesystemInfo
+	amlFilesystemsPager := d.amlFilesystemsClient.NewListPager(nil)
+	for amlFilesystemsPager.More() {
+		page, err := amlFilesystemsPager.NextPage(ctx)
+		if err != nil {
+			klog.Errorf("error listing AMLFS clusters: %v", err)
+			return nil, convertHTTPResponseErrorToGrpcCodeError(err)
+		}
+
+		for _, amlFilesystem := range page.Value {
+			if amlFilesystem == nil || amlFilesystem.Name == nil || amlFilesystem.ID == nil {
+				continue
+			}
+			createdBy, ok := amlFilesystem.Tags[createdByTag]
+			if !ok || createdBy == nil || *createdBy != azureLustreDriverTag {
+				continue
+			}
+			if amlFilesystem.Properties == nil || amlFilesystem.Properties.ClientInfo == nil || amlFilesystem.Properties.ClientInfo.MgsAddress == nil {
+				klog.V(4).Infof("skipping AMLFS cluster %s, MGS address is not available yet", *amlFilesystem.Name)
+				continue
+			}
+
+			resourceID, err := arm.ParseResourceID(*amlFilesystem.ID)
+			if err != nil {
+				klog.Warningf("skipping AMLFS cluster %s, could not parse resource ID %s: %v", *amlFilesystem.Name, *amlFilesystem.ID, err)
+				continue
+			}
+
+			amlFilesystems = append(amlFilesystems, newAmlFilesystemInfo(amlFilesystem, resourceID.ResourceGroupName))
+		}
+	}
+
+	sort.Slice(amlFilesystems, func(i, j int) bool {
+		if amlFilesystems[i].ResourceGroupName != amlFilesystems[j].ResourceGroupName {
+			return amlFilesystems[i].ResourceGroupName < amlFilesystems[j].ResourceGroupName
+		}
+		return amlFilesystems[i].Name < amlFilesystems[j].Name
+	})
+
+	return amlFilesystems, nil
+}
+
+func (d *DynamicProvisioner) GetAmlFilesystem(ctx context.Context, resourceGroupName, amlFilesystemName string) (*AmlFilesystemInfo, error) {
+	if d.amlFilesystemsClient == nil {
+		return nil, status.Error(codes.Internal, "aml filesystem client is nil")
+	}
+
+	return getAmlFilesystem(ctx, d.amlFilesystemsClient, resourceGroupName, amlFilesystemName)
+}
+
+// GetAmlFilesystemInSubscription gets an AMLFS cluster that may be in another
+// subscription than the one of the driver, if subscriptionID is not empty
+func (d *DynamicProvisioner) GetAmlFilesystemInSubscription(ctx context.Context, subscriptionID, resourceGroupName, amlFilesystemName string) (*AmlFilesystemInfo, error) {
+	if subscriptionID == "" || strings.EqualFold(subscriptionID, d.subscriptionID) {
+		return d.GetAmlFilesystem(ctx, resourceGroupName, amlFilesystemName)
+	}
+
+	amlFilesystemsClient, err := d.getAmlFilesystemsClient(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return getAmlFilesystem(ctx, amlFilesystemsClient, resourceGroupName, amlFilesystemName)
+}
+
+func (d *DynamicProvisioner) getAmlFilesystemsClient(subscriptionID string) (*armstoragecache.AmlFilesystemsClient, error) {
+	d.amlFilesystemsClientsMux.Lock()
+	defer d.amlFilesystemsClientsMux.Unlock()
+
+	subscriptionID = strings.ToLower(subscriptionID)
+	if amlFilesystemsClient, ok := d.amlFilesystemsClients[subscriptionID]; ok {
+		return amlFilesystemsClient, nil
+	}
+	if d.credential == nil {
+		return nil, status.Errorf(codes.Internal, "no credential to access AMLFS clusters in subscription %s", subscriptionID)
+	}
+
+	amlFilesystemsClient, err := armstoragecache.NewAmlFilesystemsClient(subscriptionID, d.credential, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create aml filesystem client for subscription %s: %v", subscriptionID, err)
+	}
+	if d.amlFilesystemsClients == nil {
+		d.amlFilesystemsClients = make(map[string]*armstoragecache.AmlFilesystemsClient)
+	}
+	d.amlFilesystemsClients[subscriptionID] = amlFilesystemsClient
+	return amlFilesystemsClient, nil
+}
+
+func getAmlFilesystem(ctx context.Context, amlFilesystemsClient *armstoragecache.AmlFilesystemsClient, resourceGroupName, amlFilesystemName string) (*AmlFilesystemInfo, error) {
+	resp, err := amlFilesystemsClient.Get(ctx, resourceGroupName, amlFilesystemName, nil)
+	if err != nil {
+		if strings.Contains(err.Error(), "ResourceNotFound") {
+			return nil, status.Errorf(codes.NotFound, "AMLFS cluster %s not found in resource group %s", amlFilesystemName, resourceGroupName)
+		}
+		klog.Warningf("error when retrieving the aml filesystem: %v", err)
+		return nil, convertHTTPResponseErrorToGrpcCodeError(err)
+	}
+
+	return newAmlFilesystemInfo(&resp.AmlFilesystem, resourceGroupName), nil
+}
+
+func (d *DynamicProvisioner) CreateImportJob(ctx context.Context, importJobProperties *ImportJobProperties) error {
+	if d.importJobsClient == nil {
+		return status.Error(codes.Internal, "import jobs client is nil")
+	}
+
+	importJob := armstoragecache.ImportJob{
+		Location: to.Ptr(importJobProperties.Location),
+		Properties: &armstoragecache.ImportJobProperties{
+			ConflictResolutionMode: to.Ptr(importJobProperties.ConflictResolutionMode),
+			MaximumErrors:          to.Ptr(importJobProperties.MaximumErrors),
+		},
+	}
+	if len(importJobProperties.ImportPrefixes) > 0 {
+		importJob.Properties.ImportPrefixes = to.SliceOfPtrs(importJobProperties.ImportPrefixes...)
+	}
+
+	klog.V(2).Infof("creating import job %s on AMLFS cluster %s: %#v", importJobProperties.ImportJobName, importJobProperties.AmlFilesystemName, importJobProperties)
+	poller, err := d.importJobsClient.BeginCreateOrUpdate(
+		ctx,
+		importJobProperties.ResourceGroupName,
+		importJobProperties.AmlFilesystemName,
+		importJobProperties.ImportJobName,
+		importJob,
+		nil)
+	if err != nil {
+		klog.Warningf("failed to create import job %s: %v", importJobProperties.ImportJobName, err)
+		return convertHTTPResponseErrorToGrpcCodeError(err)
+	}
+
+	pollerOptions := &runtime.PollUntilDoneOptions{
+		Frequency: d.pollFrequency,
+	}
+	_, err = poller.PollUntilDone(ctx, pollerOptions)
+	if err != nil {
+		klog.Warningf("failed to poll the result: %v", err)
+		return convertHTTPResponseErrorToGrpcCodeError(err)
+	}
+
+	klog.V(2).Infof("Successfully created import job %s on AMLFS cluster %s", importJobProperties.ImportJobName, importJobProperties.AmlFilesystemName)
+	return nil
+}
+
+func (d *DynamicProvisioner) GetImportJobStatus(ctx context.Context, resourceGroupName, amlFilesystemName, importJobName string) (*ImportJobStatus, error) {
+	if d.importJobsClient == nil {
+		return nil, status.Error(codes.Internal, "import jobs client is nil")
+	}
+
+	resp, err := d.importJobsClient.Get(ctx, resourceGroupName, amlFilesystemName, importJobName, nil)
+	if err != nil {
+		klog.Warningf("error when retrieving import job %s: %v", importJobName, err)
+		return nil, convertHTTPResponseErrorToGrpcCodeError(err)
+	}
+
+	importJobStatus := &ImportJobStatus{}
+	if resp.Properties == nil || resp.Properties.Status == nil {
+		return importJobStatus, nil
+	}
+	jobStatus := resp.Properties.Status
+	if jobStatus.State != nil {
+		importJobStatus.State = *jobStatus.State
+	}
+	if jobStatus.StatusMessage != nil {
+		importJobStatus.StatusMessage = *jobStatus.StatusMessage
+	}
+	if jobStatus.TotalBlobsWalked != nil {
+		importJobStatus.TotalBlobsWalked = *jobStatus.TotalBlobsWalked
+	}
+	if jobStatus.TotalBlobsImported != nil {
+		importJobStatus.TotalBlobsImported = *jobStatus.TotalBlobsImported
+	}
+	if jobStatus.TotalConflicts != nil {
+		importJobStatus.TotalConflicts = *jobStatus.TotalConflicts
+	}
+	if jobStatus.TotalErrors != nil {
+		importJobStatus.TotalErrors = *jobStatus.TotalErrors
+	}
+	return importJobStatus, nil
+}
+
 func (d *DynamicProvisioner) GetSkuValuesForLocation(ctx context.Context, location string) (map[string]*LustreSkuValue, error) {
+	if d.skuCache == nil {
+		return d.listSkuValuesForLocation(ctx, location)
+	}
+	return d.skuCache.get(ctx, location, d.listSkuValuesForLocation)
+}
+
+func (d *DynamicProvisioner) listSkuValuesForLocation(ctx context.Context, location string) (map[string]*LustreSkuValue, error) {
 	if d.skusClient == nil {
 		klog.Error("skus client is nil")
 		return nil, status.Error(codes.Internal, "skus client is nil")
@@ -409,11 +1021,24 @@ func (d *DynamicProvisioner) GetSkuValuesForLocation(ctx context.Context, locati
 	return skuValues, nil
 }
 
+type subnetSizeKey struct {
+	sku         string
+	clusterSize float32
+}
+
 func (d *DynamicProvisioner) getAmlfsSubnetSize(ctx context.Context, sku string, clusterSize float32) (int, error) {
 	if d.mgmtClient == nil {
 		return 0, status.Error(codes.Internal, "storage management client is nil")
 	}
 
+	key := subnetSizeKey{sku: sku, clusterSize: clusterSize}
+	d.subnetSizesMux.Lock()
+	subnetSize, ok := d.subnetSizes[key]
+	d.subnetSizesMux.Unlock()
+	if ok {
+		return subnetSize, nil
+	}
+
 	reqSize, err := d.mgmtClient.GetRequiredAmlFSSubnetsSize(ctx, &armstoragecache.ManagementClientGetRequiredAmlFSSubnetsSizeOptions{
 		RequiredAMLFilesystemSubnetsSizeInfo: &armstoragecache.RequiredAmlFilesystemSubnetsSizeInfo{
 			SKU: &armstoragecache.SKUName{
@@ -430,7 +1055,15 @@ func (d *DynamicProvisioner) getAmlfsSubnetSize(ctx context.Context, sku string,
 		klog.Errorf("received nil FilesystemSubnetSize from GetRequiredAmlFSSubnetsSize for SKU: %s, cluster size: %f", sku, clusterSize)
 		return 0, status.Error(codes.Internal, "received nil FilesystemSubnetSize from storage management client")
 	}
-	return int(*reqSize.FilesystemSubnetSize), nil
+
+	subnetSize = int(*reqSize.FilesystemSubnetSize)
+	d.subnetSizesMux.Lock()
+	if d.subnetSizes == nil {
+		d.subnetSizes = make(map[subnetSizeKey]int)
+	}
+	d.subnetSizes[key] = subnetSize
+	d.subnetSizesMux.Unlock()
+	return subnetSize, nil
 }
 
 func (d *DynamicProvisioner) checkSubnetAddresses(ctx context.Context, vnetResourceGroup, vnetName, subnetID string) (int, error) {
@@ -479,3 +1112,191 @@ func (d *DynamicProvisioner) CheckSubnetCapacity(ctx context.Context, subnetInfo
 	klog.V(2).Infof("There is enough room in the %s subnet to fit a %s SKU cluster: %v needed, %v available", subnetInfo.SubnetID, sku, requiredSubnetIPSize, availableIPs)
 	return true, nil
 }
+
+// getSubnetCandidates returns the subnets the cluster may be created in, in
+// order of preference: the subnets of SubnetCandidates, the subnets of the
+// vnet whose name starts with SubnetNamePrefix sorted by name, or otherwise
+// only SubnetInfo
+func (d *DynamicProvisioner) getSubnetCandidates(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) ([]SubnetProperties, error) {
+	subnetInfo := amlFilesystemProperties.SubnetInfo
+	if len(amlFilesystemProperties.SubnetCandidates) > 0 {
+		candidates := make([]SubnetProperties, 0, len(amlFilesystemProperties.SubnetCandidates))
+		for _, subnetName := range amlFilesystemProperties.SubnetCandidates {
+			candidate := subnetInfo
+			candidate.SubnetName = subnetName
+			candidate.SubnetID = subnetInfo.SubnetID[:strings.LastIndex(subnetInfo.SubnetID, "/")+1] + subnetName
+			candidates = append(candidates, candidate)
+		}
+		return candidates, nil
+	}
+	if amlFilesystemProperties.SubnetNamePrefix == "" {
+		return []SubnetProperties{subnetInfo}, nil
+	}
+
+	if d.vnetClient == nil {
+		return nil, status.Error(codes.Internal, "vnet client is nil")
+	}
+	var candidates []SubnetProperties
+	usagesPager := d.vnetClient.NewListUsagePager(subnetInfo.VnetResourceGroup, subnetInfo.VnetName, nil)
+	for usagesPager.More() {
+		page, err := usagesPager.NextPage(ctx)
+		if err != nil {
+			klog.Errorf("error getting next page: %v", err)
+			return nil, convertHTTPResponseErrorToGrpcCodeError(err)
+		}
+		for _, usageValue := range page.Value {
+			if usageValue == nil || usageValue.ID == nil {
+				continue
+			}
+			_, subnetName, found := strings.Cut(*usageValue.ID, "/subnets/")
+			if !found || !strings.HasPrefix(subnetName, amlFilesystemProperties.SubnetNamePrefix) {
+				continue
+			}
+			candidate := subnetInfo
+			candidate.SubnetName = subnetName
+			candidate.SubnetID = *usageValue.ID
+			candidates = append(candidates, candidate)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "no subnet with prefix %s found in vnet %s, resource group %s. Ensure permissions are correct for configuration.",
+			amlFilesystemProperties.SubnetNamePrefix, subnetInfo.VnetName, subnetInfo.VnetResourceGroup)
+	}
+	slices.SortFunc(candidates, func(a, b SubnetProperties) int {
+		return strings.Compare(a.SubnetName, b.SubnetName)
+	})
+	return candidates, nil
+}
+
+// SelectSubnet returns the first candidate subnet with enough free IP
+// addresses for the cluster. Without candidates, SubnetInfo is returned as is
+// and its capacity is checked when the cluster is created
+func (d *DynamicProvisioner) SelectSubnet(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) (SubnetProperties, error) {
+	if len(amlFilesystemProperties.SubnetCandidates) == 0 && amlFilesystemProperties.SubnetNamePrefix == "" {
+		return amlFilesystemProperties.SubnetInfo, nil
+	}
+
+	candidates, err := d.getSubnetCandidates(ctx, amlFilesystemProperties)
+	if err != nil {
+		return SubnetProperties{}, err
+	}
+	requiredSubnetIPSize, err := d.getAmlfsSubnetSize(ctx, amlFilesystemProperties.SKUName, amlFilesystemProperties.StorageCapacityTiB)
+	if err != nil {
+		klog.Errorf("error getting required subnet size: %v", err)
+		return SubnetProperties{}, err
+	}
+
+	candidateNames := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		availableIPs, err := d.checkSubnetAddresses(ctx, candidate.VnetResourceGroup, candidate.VnetName, candidate.SubnetID)
+		if err != nil {
+			klog.Errorf("error getting available IPs: %v", err)
+			return SubnetProperties{}, err
+		}
+		if requiredSubnetIPSize <= availableIPs {
+			klog.V(2).Infof("selected subnet %s for AMLFS cluster %s: %v needed, %v available",
+				candidate.SubnetID, amlFilesystemProperties.AmlFilesystemName, requiredSubnetIPSize, availableIPs)
+			return candidate, nil
+		}
+		klog.V(2).Infof("There is not enough room in the %s subnet to fit a %s SKU cluster: %v needed, %v available",
+			candidate.SubnetID, amlFilesystemProperties.SKUName, requiredSubnetIPSize, availableIPs)
+		candidateNames = append(candidateNames, candidate.SubnetName)
+	}
+
+	return SubnetProperties{}, status.Errorf(codes.ResourceExhausted, "cannot create AMLFS cluster %s in any of the subnets %v, not enough IP addresses available",
+		amlFilesystemProperties.AmlFilesystemName, candidateNames)
+}
+
+func (d *DynamicProvisioner) getRemainingAmlFilesystemQuota(ctx context.Context, location string) (int, error) {
+	if d.ascUsagesClient == nil {
+		return 0, status.Error(codes.Internal, "asc usages client is nil")
+	}
+
+	usagesPager := d.ascUsagesClient.NewListPager(location, nil)
+	for usagesPager.More() {
+		page, err := usagesPager.NextPage(ctx)
+		if err != nil {
+			klog.Errorf("error retrieving AMLFS quota usage for location %s: %v", location, err)
+			return 0, convertHTTPResponseErrorToGrpcCodeError(err)
+		}
+
+		for _, usage := range page.Value {
+			if usage == nil || usage.Name == nil || usage.Name.Value == nil || usage.Limit == nil || usage.CurrentValue == nil {
+				continue
+			}
+			if strings.EqualFold(*usage.Name.Value, AmlfsQuotaUsageName) {
+				return max(int(*usage.Limit)-int(*usage.CurrentValue), 0), nil
+			}
+		}
+	}
+
+	klog.V(2).Infof("no AMLFS quota usage reported for location %s, assuming quota is not limited", location)
+	return math.MaxInt, nil
+}
+
+// GetAvailableCapacity returns the size in TiB of the largest AMLFS cluster
+// that could currently be created with the given properties. Each volume is a
+// new cluster, so this is limited by the SKU maximum, the free addresses in the
+// subnet and the remaining AMLFS quota, not by the clusters that already exist.
+// With several candidate subnets, the subnet with the most room is used.
+func (d *DynamicProvisioner) GetAvailableCapacity(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties, lustreSkuValue *LustreSkuValue) (int64, error) {
+	if lustreSkuValue.IncrementInTib <= 0 {
+		return 0, status.Errorf(codes.Internal, "invalid capacity increment %d for SKU %s", lustreSkuValue.IncrementInTib, amlFilesystemProperties.SKUName)
+	}
+
+	remainingQuota, err := d.getRemainingAmlFilesystemQuota(ctx, amlFilesystemProperties.Location)
+	if err != nil {
+		return 0, err
+	}
+	if remainingQuota == 0 {
+		klog.Warningf("no AMLFS quota remaining in location %s", amlFilesystemProperties.Location)
+		return 0, nil
+	}
+
+	candidates, err := d.getSubnetCandidates(ctx, amlFilesystemProperties)
+	if err != nil {
+		return 0, err
+	}
+	subnetInfo := candidates[0]
+	availableIPs := 0
+	for _, candidate := range candidates {
+		candidateAvailableIPs, err := d.checkSubnetAddresses(ctx, candidate.VnetResourceGroup, candidate.VnetName, candidate.SubnetID)
+		if err != nil {
+			klog.Errorf("error getting available IPs: %v", err)
+			return 0, err
+		}
+		if candidateAvailableIPs > availableIPs {
+			subnetInfo, availableIPs = candidate, candidateAvailableIPs
+		}
+	}
+
+	// The required subnet size only grows with the cluster size, so search
+	// for the largest number of increments that still fits in the subnet
+	fitsInSubnet := func(increments int64) (bool, error) {
+		clusterSize := float32(increments * lustreSkuValue.IncrementInTib)
+		requiredSubnetIPSize, err := d.getAmlfsSubnetSize(ctx, amlFilesystemProperties.SKUName, clusterSize)
+		if err != nil {
+			return false, err
+		}
+		return requiredSubnetIPSize <= availableIPs, nil
+	}
+
+	lowIncrements, highIncrements := int64(0), lustreSkuValue.MaximumInTib/lustreSkuValue.IncrementInTib
+	for lowIncrements < highIncrements {
+		increments := (lowIncrements + highIncrements + 1) / 2
+		fits, err := fitsInSubnet(increments)
+		if err != nil {
+			klog.Errorf("error getting required subnet size: %v", err)
+			return 0, err
+		}
+		if fits {
+			lowIncrements = increments
+		} else {
+			highIncrements = increments - 1
+		}
+	}
+
+	availableCapacityTiB := lowIncrements * lustreSkuValue.IncrementInTib
+	klog.V(2).Infof("largest %s SKU cluster that fits in the %s subnet: %d TiB, %v IPs available", amlFilesystemProperties.SKUName, subnetInfo.SubnetID, availableCapacityTiB, availableIPs)
+	return availableCapacityTiB, nil
+}
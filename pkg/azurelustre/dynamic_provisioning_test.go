@@ -27,6 +27,7 @@ import (
 
 type mockAmlfsRecorder struct {
 	recordedAmlfsConfigurations map[string]armstoragecache.AmlFilesystem
+	recordedImportJobs          map[string]armstoragecache.ImportJob
 	failureBehaviors            []string
 	fakeCallCount               []string
 }
@@ -75,6 +76,20 @@ const (
 	eventualClusterCreateTimeoutFailureName     = "testClusterShouldEventuallyTimeout"
 	clusterRequestRetryDeleteFailureName        = "testClusterShouldFailRetryDelete"
 	clusterIsDeleting                           = "testClusterDeleting"
+	expectedImportJobName                       = "fake-import-job"
+	expectedArchivePath                         = "/results"
+	immediateArchiveFailureName                 = "immediate-archive-failure"
+	eventualArchiveFailureName                  = "eventual-archive-failure"
+	archiveCompletes                            = "testArchiveCompletes"
+	clusterListFailure                          = "testClusterListFailure"
+	immediateImportJobFailureName               = "immediate-import-job-failure"
+	eventualImportJobFailureName                = "eventual-import-job-failure"
+	scalingSubnetSizeSku                        = "scaling-subnet-size-sku"
+	quotaExhaustedLocation                      = "quota-exhausted-location"
+	quotaErrorLocation                          = "quota-error-location"
+	noQuotaUsageLocation                        = "no-quota-usage-location"
+	candidateSubnetsVnetName                    = "candidate-subnets-vnet"
+	candidateSubnetIDPrefix                     = "/subscriptions/fake-subscription-id/resourceGroups/fake-resource-group/providers/Microsoft.Network/virtualNetworks/candidate-subnets-vnet/subnets/"
 
 	quickPollFrequency = 1 * time.Millisecond
 )
@@ -114,6 +129,7 @@ func getNextFailureBehavior(recorder *mockAmlfsRecorder) string {
 func newMockAmlfsRecorder(failureBehaviors []string) *mockAmlfsRecorder {
 	return &mockAmlfsRecorder{
 		recordedAmlfsConfigurations: make(map[string]armstoragecache.AmlFilesystem),
+		recordedImportJobs:          make(map[string]armstoragecache.ImportJob),
 		failureBehaviors:            failureBehaviors,
 		fakeCallCount:               []string{},
 	}
@@ -122,6 +138,8 @@ func newMockAmlfsRecorder(failureBehaviors []string) *mockAmlfsRecorder {
 func newTestDynamicProvisioner(t *testing.T, recorder *mockAmlfsRecorder) *DynamicProvisioner {
 	dynamicProvisioner := &DynamicProvisioner{
 		amlFilesystemsClient: newFakeAmlFilesystemsClient(t, recorder),
+		ascUsagesClient:      newFakeAscUsagesClient(t, recorder),
+		importJobsClient:     newFakeImportJobsClient(t, recorder),
 		vnetClient:           newFakeVnetClient(t, recorder),
 		mgmtClient:           newFakeMgmtClient(t, recorder),
 		skusClient:           newFakeSkusClient(t, recorder),
@@ -360,6 +378,37 @@ func newFakeVnetServer(_ *testing.T, recorder *mockAmlfsRecorder) *networkfake.V
 			return resp
 		}
 
+		if vnetName == candidateSubnetsVnetName {
+			// amlfs-a and amlfs-b are too full for a cluster
+			resp.AddPage(http.StatusOK, armnetwork.VirtualNetworksClientListUsageResponse{
+				VirtualNetworkListUsageResult: armnetwork.VirtualNetworkListUsageResult{
+					Value: []*armnetwork.VirtualNetworkUsage{
+						{
+							ID:           to.Ptr(candidateSubnetIDPrefix + "amlfs-c"),
+							CurrentValue: to.Ptr(float64(expectedUsedIPCount)),
+							Limit:        to.Ptr(float64(expectedTotalIPCount)),
+						},
+						{
+							ID:           to.Ptr(candidateSubnetIDPrefix + "amlfs-b"),
+							CurrentValue: to.Ptr(float64(expectedFullIPCount)),
+							Limit:        to.Ptr(float64(expectedTotalIPCount)),
+						},
+						{
+							ID:           to.Ptr(candidateSubnetIDPrefix + "other"),
+							CurrentValue: to.Ptr(float64(expectedUsedIPCount)),
+							Limit:        to.Ptr(float64(expectedTotalIPCount)),
+						},
+						{
+							ID:           to.Ptr(candidateSubnetIDPrefix + "amlfs-a"),
+							CurrentValue: to.Ptr(float64(expectedTotalIPCount - expectedAmlFilesystemSubnetSize + 1)),
+							Limit:        to.Ptr(float64(expectedTotalIPCount)),
+						},
+					},
+				},
+			}, nil)
+			return resp
+		}
+
 		usedIPCount := expectedUsedIPCount
 		if vnetName == fullVnetName {
 			usedIPCount = expectedFullIPCount
@@ -424,9 +473,13 @@ func newFakeManagementServer(_ *testing.T, recorder *mockAmlfsRecorder) *fake.Ma
 			errResp.SetError(errors.New("fake invalid sku error"))
 			return resp, errResp
 		}
+		subnetSize := int32(expectedAmlFilesystemSubnetSize)
+		if *options.RequiredAMLFilesystemSubnetsSizeInfo.SKU.Name == scalingSubnetSizeSku {
+			subnetSize = int32(*options.RequiredAMLFilesystemSubnetsSizeInfo.StorageCapacityTiB / 4)
+		}
 		resp.SetResponse(http.StatusOK, armstoragecache.ManagementClientGetRequiredAmlFSSubnetsSizeResponse{
 			RequiredAmlFilesystemSubnetsSize: armstoragecache.RequiredAmlFilesystemSubnetsSize{
-				FilesystemSubnetSize: to.Ptr(int32(expectedAmlFilesystemSubnetSize)),
+				FilesystemSubnetSize: to.Ptr(subnetSize),
 			},
 		}, nil)
 		return resp, errResp
@@ -434,6 +487,64 @@ func newFakeManagementServer(_ *testing.T, recorder *mockAmlfsRecorder) *fake.Ma
 	return &fakeMgmtServer
 }
 
+func newFakeAscUsagesClient(t *testing.T, recorder *mockAmlfsRecorder) *armstoragecache.AscUsagesClient {
+	ascUsagesClientFactory, err := armstoragecache.NewClientFactory("fake-subscription-id", &azfake.TokenCredential{},
+		&arm.ClientOptions{
+			ClientOptions: azcore.ClientOptions{
+				Transport: fake.NewAscUsagesServerTransport(newFakeAscUsagesServer(t, recorder)),
+			},
+		},
+	)
+	require.NoError(t, err)
+	require.NotNil(t, ascUsagesClientFactory)
+
+	fakeAscUsagesClient := ascUsagesClientFactory.NewAscUsagesClient()
+	require.NotNil(t, fakeAscUsagesClient)
+
+	return fakeAscUsagesClient
+}
+
+func newFakeAscUsagesServer(_ *testing.T, recorder *mockAmlfsRecorder) *fake.AscUsagesServer {
+	fakeAscUsagesServer := fake.AscUsagesServer{}
+
+	fakeAscUsagesServer.NewListPager = func(location string, _ *armstoragecache.AscUsagesClientListOptions) azfake.PagerResponder[armstoragecache.AscUsagesClientListResponse] {
+		recorder.recordFakeCall()
+		resp := azfake.PagerResponder[armstoragecache.AscUsagesClientListResponse]{}
+
+		if location == quotaErrorLocation {
+			resp.AddError(errors.New("fake asc usages list error"))
+			return resp
+		}
+
+		usages := []*armstoragecache.ResourceUsage{
+			{
+				Name:         &armstoragecache.ResourceUsageName{Value: to.Ptr("Cache")},
+				CurrentValue: to.Ptr[int32](0),
+				Limit:        to.Ptr[int32](4),
+			},
+		}
+		if location != noQuotaUsageLocation {
+			amlFilesystemUsage := &armstoragecache.ResourceUsage{
+				Name:         &armstoragecache.ResourceUsageName{Value: to.Ptr("AmlFilesystems")},
+				CurrentValue: to.Ptr[int32](1),
+				Limit:        to.Ptr[int32](4),
+			}
+			if location == quotaExhaustedLocation {
+				amlFilesystemUsage.CurrentValue = to.Ptr[int32](4)
+			}
+			usages = append(usages, amlFilesystemUsage)
+		}
+
+		resp.AddPage(http.StatusOK, armstoragecache.AscUsagesClientListResponse{
+			ResourceUsagesListResult: armstoragecache.ResourceUsagesListResult{
+				Value: usages,
+			},
+		}, nil)
+		return resp
+	}
+	return &fakeAscUsagesServer
+}
+
 func createTimeoutErrorResponse() *azcore.ResponseError {
 	e := &azcore.ResponseError{}
 	err := e.UnmarshalJSON([]byte(
@@ -591,6 +702,69 @@ func newFakeAmlFilesystemsServer(_ *testing.T, recorder *mockAmlfsRecorder) *fak
 		return resp, errResp
 	}
 
+	fakeAmlfsServer.Archive = func(_ context.Context, _, amlFilesystemName string, options *armstoragecache.AmlFilesystemsClientArchiveOptions) (azfake.Responder[armstoragecache.AmlFilesystemsClientArchiveResponse], azfake.ErrorResponder) {
+		recorder.recordFakeCall()
+		errResp := azfake.ErrorResponder{}
+		resp := azfake.Responder[armstoragecache.AmlFilesystemsClientArchiveResponse]{}
+		if amlFilesystemName == immediateArchiveFailureName {
+			errResp.SetError(&azcore.ResponseError{StatusCode: http.StatusConflict})
+			return resp, errResp
+		}
+
+		amlFilesystem, ok := recorder.recordedAmlfsConfigurations[amlFilesystemName]
+		if !ok {
+			errResp.SetResponseError(http.StatusNotFound, "ResourceNotFound")
+			return resp, errResp
+		}
+
+		archiveStatus := &armstoragecache.AmlFilesystemArchiveStatus{
+			State:           to.Ptr(armstoragecache.ArchiveStatusTypeCompleted),
+			LastStartedTime: to.Ptr(time.Now()),
+			PercentComplete: to.Ptr(int32(100)),
+		}
+		if amlFilesystemName == eventualArchiveFailureName {
+			archiveStatus.State = to.Ptr(armstoragecache.ArchiveStatusTypeFailed)
+			archiveStatus.ErrorMessage = to.Ptr(eventualArchiveFailureName)
+			archiveStatus.PercentComplete = to.Ptr(int32(50))
+		}
+		amlFilesystem.Properties.Hsm.ArchiveStatus = []*armstoragecache.AmlFilesystemArchive{
+			{
+				FilesystemPath: options.ArchiveInfo.FilesystemPath,
+				Status:         archiveStatus,
+			},
+		}
+
+		resp.SetResponse(http.StatusOK, armstoragecache.AmlFilesystemsClientArchiveResponse{}, nil)
+		return resp, errResp
+	}
+
+	fakeAmlfsServer.NewListPager = func(_ *armstoragecache.AmlFilesystemsClientListOptions) azfake.PagerResponder[armstoragecache.AmlFilesystemsClientListResponse] {
+		recorder.recordFakeCall()
+		resp := azfake.PagerResponder[armstoragecache.AmlFilesystemsClientListResponse]{}
+		if getNextFailureBehavior(recorder) == clusterListFailure {
+			resp.AddResponseError(http.StatusServiceUnavailable, clusterListFailure)
+			return resp
+		}
+
+		if len(recorder.recordedAmlfsConfigurations) == 0 {
+			resp.AddPage(http.StatusOK, armstoragecache.AmlFilesystemsClientListResponse{}, nil)
+			return resp
+		}
+
+		// Return each cluster on its own page to exercise paging
+		for _, amlFilesystem := range recorder.recordedAmlfsConfigurations {
+			if amlFilesystem.ID == nil {
+				amlFilesystem.ID = to.Ptr("/subscriptions/fake-subscription-id/resourceGroups/" + expectedResourceGroupName + "/providers/Microsoft.StorageCache/amlFilesystems/" + *amlFilesystem.Name)
+			}
+			resp.AddPage(http.StatusOK, armstoragecache.AmlFilesystemsClientListResponse{
+				AmlFilesystemsListResult: armstoragecache.AmlFilesystemsListResult{
+					Value: []*armstoragecache.AmlFilesystem{&amlFilesystem},
+				},
+			}, nil)
+		}
+		return resp
+	}
+
 	fakeAmlfsServer.Get = func(_ context.Context, _, amlFilesystemName string, _ *armstoragecache.AmlFilesystemsClientGetOptions) (azfake.Responder[armstoragecache.AmlFilesystemsClientGetResponse], azfake.ErrorResponder) {
 		recorder.recordFakeCall()
 		var amlFilesystem *armstoragecache.AmlFilesystem
@@ -614,6 +788,11 @@ func newFakeAmlFilesystemsServer(_ *testing.T, recorder *mockAmlfsRecorder) *fak
 
 		nextFailureBehavior := getNextFailureBehavior(recorder)
 		switch nextFailureBehavior {
+		case archiveCompletes:
+			for _, archive := range amlFilesystem.Properties.Hsm.ArchiveStatus {
+				archive.Status.State = to.Ptr(armstoragecache.ArchiveStatusTypeCompleted)
+				archive.Status.PercentComplete = to.Ptr(int32(100))
+			}
 		case clusterIsDeleting:
 			amlFilesystem.Properties.ProvisioningState = to.Ptr(armstoragecache.AmlFilesystemProvisioningStateTypeDeleting)
 		case clusterIsFailed:
@@ -634,6 +813,74 @@ func newFakeAmlFilesystemsServer(_ *testing.T, recorder *mockAmlfsRecorder) *fak
 	return &fakeAmlfsServer
 }
 
+func newFakeImportJobsClient(t *testing.T, recorder *mockAmlfsRecorder) *armstoragecache.ImportJobsClient {
+	importJobsClientFactory, err := armstoragecache.NewClientFactory("fake-subscription-id", &azfake.TokenCredential{},
+		&arm.ClientOptions{
+			ClientOptions: azcore.ClientOptions{
+				Transport: fake.NewImportJobsServerTransport(newFakeImportJobsServer(t, recorder)),
+			},
+		},
+	)
+	require.NoError(t, err)
+	require.NotNil(t, importJobsClientFactory)
+
+	fakeImportJobsClient := importJobsClientFactory.NewImportJobsClient()
+	require.NotNil(t, fakeImportJobsClient)
+
+	return fakeImportJobsClient
+}
+
+func newFakeImportJobsServer(_ *testing.T, recorder *mockAmlfsRecorder) *fake.ImportJobsServer {
+	fakeImportJobsServer := fake.ImportJobsServer{}
+
+	fakeImportJobsServer.BeginCreateOrUpdate = func(_ context.Context, _, _, importJobName string, importJob armstoragecache.ImportJob, _ *armstoragecache.ImportJobsClientBeginCreateOrUpdateOptions) (azfake.PollerResponder[armstoragecache.ImportJobsClientCreateOrUpdateResponse], azfake.ErrorResponder) {
+		recorder.recordFakeCall()
+		importJob.Name = to.Ptr(importJobName)
+
+		errResp := azfake.ErrorResponder{}
+		resp := azfake.PollerResponder[armstoragecache.ImportJobsClientCreateOrUpdateResponse]{}
+		if importJobName == immediateImportJobFailureName {
+			errResp.SetError(&azcore.ResponseError{StatusCode: http.StatusBadRequest})
+			return resp, errResp
+		}
+
+		resp.AddNonTerminalResponse(http.StatusCreated, nil)
+		resp.AddNonTerminalResponse(http.StatusOK, nil)
+		if importJobName == eventualImportJobFailureName {
+			resp.SetTerminalError(http.StatusInternalServerError, eventualImportJobFailureName)
+			return resp, errResp
+		}
+
+		importJob.Properties.Status = &armstoragecache.ImportJobPropertiesStatus{
+			State: to.Ptr(armstoragecache.ImportStatusTypeInProgress),
+		}
+		recorder.recordedImportJobs[importJobName] = importJob
+		resp.SetTerminalResponse(http.StatusOK, armstoragecache.ImportJobsClientCreateOrUpdateResponse{
+			ImportJob: importJob,
+		}, nil)
+		return resp, errResp
+	}
+
+	fakeImportJobsServer.Get = func(_ context.Context, _, _, importJobName string, _ *armstoragecache.ImportJobsClientGetOptions) (azfake.Responder[armstoragecache.ImportJobsClientGetResponse], azfake.ErrorResponder) {
+		recorder.recordFakeCall()
+		errResp := azfake.ErrorResponder{}
+		resp := azfake.Responder[armstoragecache.ImportJobsClientGetResponse]{}
+
+		importJob, ok := recorder.recordedImportJobs[importJobName]
+		if !ok {
+			errResp.SetResponseError(http.StatusNotFound, "ResourceNotFound")
+			return resp, errResp
+		}
+
+		resp.SetResponse(http.StatusOK,
+			armstoragecache.ImportJobsClientGetResponse{
+				ImportJob: importJob,
+			}, nil)
+		return resp, errResp
+	}
+	return &fakeImportJobsServer
+}
+
 func TestDynamicProvisioner_CreateAmlFilesystem_Success(t *testing.T) {
 	expectedLocation := "fake-location"
 	expectedMaintenanceDayOfWeek := armstoragecache.MaintenanceDayOfWeekTypeSaturday
@@ -678,6 +925,60 @@ func TestDynamicProvisioner_CreateAmlFilesystem_Success(t *testing.T) {
 	assert.Equal(t, expectedCreateCalls, recorder.fakeCallCount)
 }
 
+func TestDynamicProvisioner_BeginCreateAmlFilesystem_Success_Resume(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+	amlFilesystemProperties := &AmlFilesystemProperties{
+		ResourceGroupName:  expectedResourceGroupName,
+		AmlFilesystemName:  expectedAmlFilesystemName,
+		SKUName:            expectedSku,
+		StorageCapacityTiB: 48,
+		SubnetInfo:         buildExpectedSubnetInfo(),
+	}
+
+	resumeToken, err := dynamicProvisioner.BeginCreateAmlFilesystem(context.Background(), amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.NotEmpty(t, resumeToken)
+
+	// A restarted controller only has the properties identifying the cluster
+	mgsIPAddress, err := dynamicProvisioner.PollCreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName: expectedResourceGroupName,
+		AmlFilesystemName: expectedAmlFilesystemName,
+	}, resumeToken)
+	require.NoError(t, err)
+	assert.Equal(t, expectedMgsAddress, mgsIPAddress)
+	require.Len(t, recorder.recordedAmlfsConfigurations, 1)
+}
+
+func TestDynamicProvisioner_PollCreateAmlFilesystem_Success_NoResumeToken(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	amlFilesystem := newHsmAmlFilesystem(expectedAmlFilesystemName)
+	amlFilesystem.Properties.ClientInfo = &armstoragecache.AmlFilesystemClientInfo{MgsAddress: to.Ptr(expectedMgsAddress)}
+	recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName] = amlFilesystem
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	mgsIPAddress, err := dynamicProvisioner.PollCreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName: expectedResourceGroupName,
+		AmlFilesystemName: expectedAmlFilesystemName,
+	}, "")
+	require.NoError(t, err)
+	assert.Equal(t, expectedMgsAddress, mgsIPAddress)
+	assert.Equal(t, []string{"AmlFilesystemsServerTransport.Get"}, recorder.fakeCallCount)
+}
+
+func TestDynamicProvisioner_PollCreateAmlFilesystem_Err_NoMgsAddress(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName] = newHsmAmlFilesystem(expectedAmlFilesystemName)
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.PollCreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName: expectedResourceGroupName,
+		AmlFilesystemName: expectedAmlFilesystemName,
+	}, "")
+	require.Error(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
 func TestDynamicProvisioner_CreateAmlFilesystem_Success_Tags(t *testing.T) {
 	expectedTags := map[string]string{"tag1": "value1", "tag2": "value2"}
 
@@ -782,6 +1083,143 @@ func TestDynamicProvisioner_CreateAmlFilesystem_Success_Identities(t *testing.T)
 	}
 }
 
+func TestDynamicProvisioner_CreateAmlFilesystem_Success_Hsm(t *testing.T) {
+	expectedContainer := "fake-hsm-container"
+	expectedLoggingContainer := "fake-hsm-logging-container"
+	expectedImportPrefixes := []string{"/prefix1", "/prefix2"}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.CreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName:   expectedResourceGroupName,
+		AmlFilesystemName:   expectedAmlFilesystemName,
+		HsmContainer:        expectedContainer,
+		HsmLoggingContainer: expectedLoggingContainer,
+		HsmImportPrefixes:   expectedImportPrefixes,
+		SubnetInfo:          buildExpectedSubnetInfo(),
+	})
+	require.NoError(t, err)
+	require.Len(t, recorder.recordedAmlfsConfigurations, 1)
+	hsm := recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName].Properties.Hsm
+	require.NotNil(t, hsm)
+	require.NotNil(t, hsm.Settings)
+	assert.Equal(t, expectedContainer, *hsm.Settings.Container)
+	assert.Equal(t, expectedLoggingContainer, *hsm.Settings.LoggingContainer)
+	assert.Equal(t, to.SliceOfPtrs(expectedImportPrefixes...), hsm.Settings.ImportPrefixesInitial)
+}
+
+func TestDynamicProvisioner_CreateAmlFilesystem_Success_NoHsm(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.CreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName: expectedResourceGroupName,
+		AmlFilesystemName: expectedAmlFilesystemName,
+		SubnetInfo:        buildExpectedSubnetInfo(),
+	})
+	require.NoError(t, err)
+	require.Len(t, recorder.recordedAmlfsConfigurations, 1)
+	assert.Nil(t, recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName].Properties.Hsm)
+}
+
+func TestDynamicProvisioner_CreateAmlFilesystem_Success_RootSquash(t *testing.T) {
+	expectedRootSquashSettings := RootSquashProperties{
+		Mode:             armstoragecache.AmlFilesystemSquashModeAll,
+		NoSquashNidLists: "10.0.0.4@tcp",
+		SquashUID:        to.Ptr(int64(1000)),
+		SquashGID:        to.Ptr(int64(1001)),
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.CreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName:  expectedResourceGroupName,
+		AmlFilesystemName:  expectedAmlFilesystemName,
+		RootSquashSettings: expectedRootSquashSettings,
+		SubnetInfo:         buildExpectedSubnetInfo(),
+	})
+	require.NoError(t, err)
+	require.Len(t, recorder.recordedAmlfsConfigurations, 1)
+	rootSquashSettings := recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName].Properties.RootSquashSettings
+	require.NotNil(t, rootSquashSettings)
+	assert.Equal(t, expectedRootSquashSettings.Mode, *rootSquashSettings.Mode)
+	assert.Equal(t, expectedRootSquashSettings.NoSquashNidLists, *rootSquashSettings.NoSquashNidLists)
+	assert.Equal(t, *expectedRootSquashSettings.SquashUID, *rootSquashSettings.SquashUID)
+	assert.Equal(t, *expectedRootSquashSettings.SquashGID, *rootSquashSettings.SquashGID)
+}
+
+func TestDynamicProvisioner_CreateAmlFilesystem_Success_NoRootSquash(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.CreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName: expectedResourceGroupName,
+		AmlFilesystemName: expectedAmlFilesystemName,
+		SubnetInfo:        buildExpectedSubnetInfo(),
+	})
+	require.NoError(t, err)
+	require.Len(t, recorder.recordedAmlfsConfigurations, 1)
+	assert.Nil(t, recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName].Properties.RootSquashSettings)
+}
+
+func TestDynamicProvisioner_CreateAmlFilesystem_Success_Encryption(t *testing.T) {
+	expectedKeyURL := "https://fake-vault.vault.azure.net/keys/fake-key/fake-version"
+	expectedKeyVaultID := "/subscriptions/fake-subscription-id/resourceGroups/fake-resource-group/providers/Microsoft.KeyVault/vaults/fake-vault"
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.CreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName:    expectedResourceGroupName,
+		AmlFilesystemName:    expectedAmlFilesystemName,
+		Identities:           []string{"identity1"},
+		EncryptionKeyURL:     expectedKeyURL,
+		EncryptionKeyVaultID: expectedKeyVaultID,
+		SubnetInfo:           buildExpectedSubnetInfo(),
+	})
+	require.NoError(t, err)
+	require.Len(t, recorder.recordedAmlfsConfigurations, 1)
+	encryptionSettings := recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName].Properties.EncryptionSettings
+	require.NotNil(t, encryptionSettings)
+	require.NotNil(t, encryptionSettings.KeyEncryptionKey)
+	assert.Equal(t, expectedKeyURL, *encryptionSettings.KeyEncryptionKey.KeyURL)
+	assert.Equal(t, expectedKeyVaultID, *encryptionSettings.KeyEncryptionKey.SourceVault.ID)
+}
+
+func TestDynamicProvisioner_CreateAmlFilesystem_Success_NoEncryption(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.CreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName: expectedResourceGroupName,
+		AmlFilesystemName: expectedAmlFilesystemName,
+		SubnetInfo:        buildExpectedSubnetInfo(),
+	})
+	require.NoError(t, err)
+	require.Len(t, recorder.recordedAmlfsConfigurations, 1)
+	assert.Nil(t, recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName].Properties.EncryptionSettings)
+}
+
 func TestDynamicProvisioner_CreateAmlFilesystem_Aborted_TriesDeleteOnImmediateClusterTimeout(t *testing.T) {
 	expectedCreateCalls := []string{
 		"AmlFilesystemsServerTransport.Get",
@@ -1336,27 +1774,545 @@ func TestDynamicProvisioner_DeleteAmlFilesystem_Success_DeletesCorrectCluster(t
 	assert.Equal(t, otherAmlFilesystemName, *recorder.recordedAmlfsConfigurations[otherAmlFilesystemName].Name)
 }
 
-func TestDynamicProvisioner_CurrentClusterState_Success(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
+func newHsmAmlFilesystem(amlFilesystemName string, archiveStatus ...*armstoragecache.AmlFilesystemArchive) armstoragecache.AmlFilesystem {
+	return armstoragecache.AmlFilesystem{
+		Name: to.Ptr(amlFilesystemName),
+		Properties: &armstoragecache.AmlFilesystemProperties{
+			Hsm: &armstoragecache.AmlFilesystemPropertiesHsm{
+				Settings: &armstoragecache.AmlFilesystemHsmSettings{
+					Container:        to.Ptr("data-container"),
+					LoggingContainer: to.Ptr("logging-container"),
+				},
+				ArchiveStatus: archiveStatus,
+			},
+		},
+	}
+}
 
+func TestDynamicProvisioner_ArchiveAmlFilesystem_Success(t *testing.T) {
 	recorder := newMockAmlfsRecorder([]string{})
+	recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName] = newHsmAmlFilesystem(expectedAmlFilesystemName,
+		&armstoragecache.AmlFilesystemArchive{
+			FilesystemPath: to.Ptr(expectedArchivePath),
+			Status: &armstoragecache.AmlFilesystemArchiveStatus{
+				State:           to.Ptr(armstoragecache.ArchiveStatusTypeCompleted),
+				LastStartedTime: to.Ptr(time.Now().Add(-time.Hour)),
+			},
+		},
+	)
 	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
-	require.Empty(t, recorder.recordedAmlfsConfigurations)
-	_, err := dynamicProvisioner.CreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
-		ResourceGroupName: expectedResourceGroupName,
-		AmlFilesystemName: expectedAmlFilesystemName,
-		SubnetInfo:        buildExpectedSubnetInfo(),
-	})
-	require.NoError(t, err)
-	require.Len(t, recorder.recordedAmlfsConfigurations, 1)
 
-	currentClusterState, err := dynamicProvisioner.currentClusterState(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName)
+	err := dynamicProvisioner.ArchiveAmlFilesystem(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedArchivePath)
 	require.NoError(t, err)
-	require.Equal(t, ClusterStateExists, currentClusterState)
-}
-
-func TestDynamicProvisioner_CurrentClusterState_SuccessNotFound(t *testing.T) {
+	expectedCalls := []string{
+		"AmlFilesystemsServerTransport.Get",
+		"AmlFilesystemsServerTransport.Archive",
+		"AmlFilesystemsServerTransport.Get",
+	}
+	assert.Equal(t, expectedCalls, recorder.fakeCallCount)
+	assert.Len(t, recorder.recordedAmlfsConfigurations, 1)
+}
+
+func TestDynamicProvisioner_ArchiveAmlFilesystem_Success_WaitsForArchiveInProgress(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{"", "", archiveCompletes})
+	recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName] = newHsmAmlFilesystem(expectedAmlFilesystemName,
+		&armstoragecache.AmlFilesystemArchive{
+			FilesystemPath: to.Ptr(expectedArchivePath),
+			Status: &armstoragecache.AmlFilesystemArchiveStatus{
+				State:           to.Ptr(armstoragecache.ArchiveStatusTypeInProgress),
+				LastStartedTime: to.Ptr(time.Now()),
+				PercentComplete: to.Ptr(int32(10)),
+			},
+		},
+	)
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	err := dynamicProvisioner.ArchiveAmlFilesystem(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedArchivePath)
+	require.NoError(t, err)
+	expectedCalls := []string{
+		"AmlFilesystemsServerTransport.Get",
+		"AmlFilesystemsServerTransport.Get",
+		"AmlFilesystemsServerTransport.Get",
+	}
+	assert.Equal(t, expectedCalls, recorder.fakeCallCount)
+}
+
+func TestDynamicProvisioner_ArchiveAmlFilesystem_Success_ArchivesPathAfterOtherPath(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName] = newHsmAmlFilesystem(expectedAmlFilesystemName,
+		&armstoragecache.AmlFilesystemArchive{
+			FilesystemPath: to.Ptr("/other"),
+			Status: &armstoragecache.AmlFilesystemArchiveStatus{
+				State:           to.Ptr(armstoragecache.ArchiveStatusTypeCompleted),
+				LastStartedTime: to.Ptr(time.Now().Add(-time.Hour)),
+			},
+		},
+	)
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	err := dynamicProvisioner.ArchiveAmlFilesystem(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedArchivePath)
+	require.NoError(t, err)
+	expectedCalls := []string{
+		"AmlFilesystemsServerTransport.Get",
+		"AmlFilesystemsServerTransport.Archive",
+		"AmlFilesystemsServerTransport.Get",
+	}
+	assert.Equal(t, expectedCalls, recorder.fakeCallCount)
+	archiveStatus := recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName].Properties.Hsm.ArchiveStatus
+	require.Len(t, archiveStatus, 1)
+	assert.Equal(t, expectedArchivePath, *archiveStatus[0].FilesystemPath)
+}
+
+func TestDynamicProvisioner_ArchiveAmlFilesystem_Err_OtherPathInProgress(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName] = newHsmAmlFilesystem(expectedAmlFilesystemName,
+		&armstoragecache.AmlFilesystemArchive{
+			FilesystemPath: to.Ptr("/other"),
+			Status: &armstoragecache.AmlFilesystemArchiveStatus{
+				State:           to.Ptr(armstoragecache.ArchiveStatusTypeInProgress),
+				LastStartedTime: to.Ptr(time.Now()),
+				PercentComplete: to.Ptr(int32(10)),
+			},
+		},
+	)
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	err := dynamicProvisioner.ArchiveAmlFilesystem(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedArchivePath)
+	require.ErrorContains(t, err, "archive of path /other of AMLFS cluster "+expectedAmlFilesystemName+" is in progress")
+	grpcStatus, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.Aborted, grpcStatus.Code())
+	assert.Equal(t, []string{"AmlFilesystemsServerTransport.Get"}, recorder.fakeCallCount, "path must not be archived while another archive runs")
+}
+
+func TestDynamicProvisioner_ArchiveAmlFilesystem_Success_ClusterNotFound(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	err := dynamicProvisioner.ArchiveAmlFilesystem(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedArchivePath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AmlFilesystemsServerTransport.Get"}, recorder.fakeCallCount)
+}
+
+func TestDynamicProvisioner_ArchiveAmlFilesystem_Err_NilClient(t *testing.T) {
+	dynamicProvisioner := &DynamicProvisioner{}
+
+	err := dynamicProvisioner.ArchiveAmlFilesystem(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedArchivePath)
+	require.ErrorContains(t, err, "aml filesystem client is nil")
+	grpcStatus, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.Internal, grpcStatus.Code())
+}
+
+func TestDynamicProvisioner_ArchiveAmlFilesystem_Err(t *testing.T) {
+	tests := []struct {
+		desc              string
+		amlFilesystemName string
+		expectedCode      codes.Code
+		expectedErr       string
+	}{
+		{
+			desc:              "immediate failure",
+			amlFilesystemName: immediateArchiveFailureName,
+			expectedCode:      codes.InvalidArgument,
+		},
+		{
+			desc:              "archive failed",
+			amlFilesystemName: eventualArchiveFailureName,
+			expectedCode:      codes.Aborted,
+			expectedErr:       eventualArchiveFailureName,
+		},
+		{
+			desc:              "cluster get failure",
+			amlFilesystemName: clusterGetRetryCheckFailureName,
+			expectedCode:      codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := newMockAmlfsRecorder([]string{})
+			recorder.recordedAmlfsConfigurations[test.amlFilesystemName] = newHsmAmlFilesystem(test.amlFilesystemName)
+			dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+			err := dynamicProvisioner.ArchiveAmlFilesystem(context.Background(), expectedResourceGroupName, test.amlFilesystemName, expectedArchivePath)
+			require.Error(t, err)
+			grpcStatus, ok := status.FromError(err)
+			require.True(t, ok)
+			assert.Equal(t, test.expectedCode, grpcStatus.Code())
+			require.ErrorContains(t, err, test.expectedErr)
+			assert.Len(t, recorder.recordedAmlfsConfigurations, 1, "cluster must not be deleted by archive")
+		})
+	}
+}
+
+func TestDynamicProvisioner_ListAmlFilesystems_Success(t *testing.T) {
+	newListedAmlFilesystem := func(name, id string, tags map[string]*string, clientInfo *armstoragecache.AmlFilesystemClientInfo) armstoragecache.AmlFilesystem {
+		amlFilesystem := armstoragecache.AmlFilesystem{
+			Name: to.Ptr(name),
+			Tags: tags,
+			Properties: &armstoragecache.AmlFilesystemProperties{
+				ClientInfo:         clientInfo,
+				StorageCapacityTiB: to.Ptr(float32(expectedClusterSize)),
+			},
+		}
+		if id != "" {
+			amlFilesystem.ID = to.Ptr(id)
+		}
+		return amlFilesystem
+	}
+	driverTags := map[string]*string{createdByTag: to.Ptr(azureLustreDriverTag)}
+	clientInfo := &armstoragecache.AmlFilesystemClientInfo{MgsAddress: to.Ptr(expectedMgsAddress)}
+
+	recorder := newMockAmlfsRecorder([]string{})
+	recorder.recordedAmlfsConfigurations["driver-b"] = newListedAmlFilesystem("driver-b", "", map[string]*string{
+		createdByTag: to.Ptr(azureLustreDriverTag),
+		subDirTag:    to.Ptr("testSubDir"),
+	}, clientInfo)
+	recorder.recordedAmlfsConfigurations["driver-a"] = newListedAmlFilesystem("driver-a", "", driverTags, clientInfo)
+	recorder.recordedAmlfsConfigurations["driver-other-rg"] = newListedAmlFilesystem("driver-other-rg",
+		"/subscriptions/fake-subscription-id/resourceGroups/another-resource-group/providers/Microsoft.StorageCache/amlFilesystems/driver-other-rg",
+		driverTags, clientInfo)
+	recorder.recordedAmlfsConfigurations["not-driver"] = newListedAmlFilesystem("not-driver", "", map[string]*string{
+		createdByTag: to.Ptr("someone-else"),
+	}, clientInfo)
+	recorder.recordedAmlfsConfigurations["untagged"] = newListedAmlFilesystem("untagged", "", nil, clientInfo)
+	recorder.recordedAmlfsConfigurations["still-creating"] = newListedAmlFilesystem("still-creating", "", driverTags, nil)
+	recorder.recordedAmlfsConfigurations["invalid-id"] = newListedAmlFilesystem("invalid-id", "invalid-resource-id", driverTags, clientInfo)
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	amlFilesystems, err := dynamicProvisioner.ListAmlFilesystems(context.Background())
+	require.NoError(t, err)
+	require.Len(t, amlFilesystems, 3)
+	assert.Equal(t, &AmlFilesystemInfo{
+		Name:               "driver-other-rg",
+		ResourceGroupName:  "another-resource-group",
+		MgsAddress:         expectedMgsAddress,
+		StorageCapacityTiB: expectedClusterSize,
+		Tags:               map[string]string{createdByTag: azureLustreDriverTag},
+	}, amlFilesystems[0])
+	assert.Equal(t, "driver-a", amlFilesystems[1].Name)
+	assert.Equal(t, expectedResourceGroupName, amlFilesystems[1].ResourceGroupName)
+	assert.Equal(t, "driver-b", amlFilesystems[2].Name)
+	assert.Equal(t, "testSubDir", amlFilesystems[2].Tags[subDirTag])
+}
+
+func TestDynamicProvisioner_ListAmlFilesystems_Success_NoClusters(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	amlFilesystems, err := dynamicProvisioner.ListAmlFilesystems(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, amlFilesystems)
+}
+
+func TestDynamicProvisioner_ListAmlFilesystems_Err(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{clusterListFailure})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.ListAmlFilesystems(context.Background())
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.Unavailable, grpcStatus.Code())
+}
+
+func TestDynamicProvisioner_ListAmlFilesystems_Err_NilClient(t *testing.T) {
+	dynamicProvisioner := &DynamicProvisioner{}
+
+	_, err := dynamicProvisioner.ListAmlFilesystems(context.Background())
+	require.ErrorContains(t, err, "aml filesystem client is nil")
+	grpcStatus, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.Internal, grpcStatus.Code())
+}
+
+func TestDynamicProvisioner_GetAmlFilesystem_Success(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	amlFilesystem := newHsmAmlFilesystem(expectedAmlFilesystemName,
+		&armstoragecache.AmlFilesystemArchive{
+			FilesystemPath: to.Ptr(expectedArchivePath),
+			Status: &armstoragecache.AmlFilesystemArchiveStatus{
+				State:           to.Ptr(armstoragecache.ArchiveStatusTypeFailed),
+				ErrorMessage:    to.Ptr("container not found"),
+				LastStartedTime: to.Ptr(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)),
+			},
+		},
+	)
+	amlFilesystem.Tags = map[string]*string{createdByTag: to.Ptr(azureLustreDriverTag)}
+	amlFilesystem.Properties.StorageCapacityTiB = to.Ptr[float32](8)
+	amlFilesystem.Properties.ClientInfo = &armstoragecache.AmlFilesystemClientInfo{MgsAddress: to.Ptr(expectedMgsAddress)}
+	amlFilesystem.Properties.ProvisioningState = to.Ptr(armstoragecache.AmlFilesystemProvisioningStateTypeSucceeded)
+	amlFilesystem.Properties.Health = &armstoragecache.AmlFilesystemHealth{
+		State:             to.Ptr(armstoragecache.AmlFilesystemHealthStateTypeDegraded),
+		StatusDescription: to.Ptr("OSS is unreachable"),
+	}
+	recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName] = amlFilesystem
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	amlFilesystemInfo, err := dynamicProvisioner.GetAmlFilesystem(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName)
+	require.NoError(t, err)
+	assert.Equal(t, &AmlFilesystemInfo{
+		Name:                    expectedAmlFilesystemName,
+		ResourceGroupName:       expectedResourceGroupName,
+		MgsAddress:              expectedMgsAddress,
+		StorageCapacityTiB:      8,
+		Tags:                    map[string]string{createdByTag: azureLustreDriverTag},
+		ProvisioningState:       armstoragecache.AmlFilesystemProvisioningStateTypeSucceeded,
+		HealthState:             armstoragecache.AmlFilesystemHealthStateTypeDegraded,
+		HealthStatusDescription: "OSS is unreachable",
+		ArchiveState:            armstoragecache.ArchiveStatusTypeFailed,
+		ArchiveErrorMessage:     "container not found",
+		HsmContainer:            "data-container",
+		ArchiveStartTimes:       map[string]time.Time{expectedArchivePath: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}, amlFilesystemInfo)
+}
+
+func TestDynamicProvisioner_GetAmlFilesystem_Err_NotFound(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.GetAmlFilesystem(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.NotFound, grpcStatus.Code())
+}
+
+func TestDynamicProvisioner_GetAmlFilesystem_Err(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.GetAmlFilesystem(context.Background(), expectedResourceGroupName, clusterGetImmediateFailureName)
+	assert.ErrorContains(t, err, clusterGetImmediateFailureName)
+}
+
+func TestDynamicProvisioner_GetAmlFilesystem_Err_NilClient(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+	dynamicProvisioner.amlFilesystemsClient = nil
+
+	_, err := dynamicProvisioner.GetAmlFilesystem(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName)
+	assert.ErrorContains(t, err, "aml filesystem client is nil")
+}
+
+func TestDynamicProvisioner_GetAmlFilesystemInSubscription_Success(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	amlFilesystem := newHsmAmlFilesystem(expectedAmlFilesystemName)
+	amlFilesystem.Properties.ClientInfo = &armstoragecache.AmlFilesystemClientInfo{MgsAddress: to.Ptr(expectedMgsAddress)}
+	recorder.recordedAmlfsConfigurations[expectedAmlFilesystemName] = amlFilesystem
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+	dynamicProvisioner.subscriptionID = "driver-subscription"
+	otherSubscriptionRecorder := newMockAmlfsRecorder([]string{})
+	otherSubscriptionRecorder.recordedAmlfsConfigurations[expectedAmlFilesystemName] = amlFilesystem
+	dynamicProvisioner.amlFilesystemsClients = map[string]*armstoragecache.AmlFilesystemsClient{
+		"other-subscription": newFakeAmlFilesystemsClient(t, otherSubscriptionRecorder),
+	}
+
+	for _, subscriptionID := range []string{"", "Driver-Subscription"} {
+		amlFilesystemInfo, err := dynamicProvisioner.GetAmlFilesystemInSubscription(context.Background(), subscriptionID, expectedResourceGroupName, expectedAmlFilesystemName)
+		require.NoError(t, err)
+		assert.Equal(t, expectedMgsAddress, amlFilesystemInfo.MgsAddress)
+	}
+	assert.Equal(t, []string{"AmlFilesystemsServerTransport.Get", "AmlFilesystemsServerTransport.Get"}, recorder.fakeCallCount)
+
+	amlFilesystemInfo, err := dynamicProvisioner.GetAmlFilesystemInSubscription(context.Background(), "Other-Subscription", expectedResourceGroupName, expectedAmlFilesystemName)
+	require.NoError(t, err)
+	assert.Equal(t, expectedMgsAddress, amlFilesystemInfo.MgsAddress)
+	assert.Equal(t, []string{"AmlFilesystemsServerTransport.Get"}, otherSubscriptionRecorder.fakeCallCount)
+}
+
+func TestDynamicProvisioner_GetAmlFilesystemInSubscription_Err_NoCredential(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+	dynamicProvisioner.subscriptionID = "driver-subscription"
+
+	_, err := dynamicProvisioner.GetAmlFilesystemInSubscription(context.Background(), "other-subscription", expectedResourceGroupName, expectedAmlFilesystemName)
+	require.Error(t, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.ErrorContains(t, err, "no credential to access AMLFS clusters in subscription other-subscription")
+	assert.Empty(t, recorder.fakeCallCount)
+}
+
+func TestDynamicProvisioner_CreateImportJob_Success(t *testing.T) {
+	expectedImportPrefixes := []string{"/training", "/validation"}
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	err := dynamicProvisioner.CreateImportJob(context.Background(), &ImportJobProperties{
+		ResourceGroupName:      expectedResourceGroupName,
+		AmlFilesystemName:      expectedAmlFilesystemName,
+		ImportJobName:          expectedImportJobName,
+		Location:               expectedLocation,
+		ImportPrefixes:         expectedImportPrefixes,
+		ConflictResolutionMode: armstoragecache.ConflictResolutionModeSkip,
+		MaximumErrors:          -1,
+	})
+	require.NoError(t, err)
+	require.Len(t, recorder.recordedImportJobs, 1)
+	actualImportJob := recorder.recordedImportJobs[expectedImportJobName]
+	assert.Equal(t, expectedLocation, *actualImportJob.Location)
+	assert.Equal(t, armstoragecache.ConflictResolutionModeSkip, *actualImportJob.Properties.ConflictResolutionMode)
+	assert.Equal(t, int32(-1), *actualImportJob.Properties.MaximumErrors)
+	require.Len(t, actualImportJob.Properties.ImportPrefixes, len(expectedImportPrefixes))
+	for i, prefix := range actualImportJob.Properties.ImportPrefixes {
+		assert.Equal(t, expectedImportPrefixes[i], *prefix)
+	}
+}
+
+func TestDynamicProvisioner_CreateImportJob_Success_NoImportPrefixes(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	err := dynamicProvisioner.CreateImportJob(context.Background(), &ImportJobProperties{
+		ResourceGroupName:      expectedResourceGroupName,
+		AmlFilesystemName:      expectedAmlFilesystemName,
+		ImportJobName:          expectedImportJobName,
+		Location:               expectedLocation,
+		ConflictResolutionMode: armstoragecache.ConflictResolutionModeFail,
+	})
+	require.NoError(t, err)
+	require.Len(t, recorder.recordedImportJobs, 1)
+	assert.Nil(t, recorder.recordedImportJobs[expectedImportJobName].Properties.ImportPrefixes)
+}
+
+func TestDynamicProvisioner_CreateImportJob_Err_NilClient(t *testing.T) {
+	dynamicProvisioner := &DynamicProvisioner{}
+
+	err := dynamicProvisioner.CreateImportJob(context.Background(), &ImportJobProperties{
+		ResourceGroupName: expectedResourceGroupName,
+		AmlFilesystemName: expectedAmlFilesystemName,
+		ImportJobName:     expectedImportJobName,
+	})
+	require.ErrorContains(t, err, "import jobs client is nil")
+	grpcStatus, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.Internal, grpcStatus.Code())
+}
+
+func TestDynamicProvisioner_CreateImportJob_Err(t *testing.T) {
+	tests := []struct {
+		desc          string
+		importJobName string
+		expectedCode  codes.Code
+	}{
+		{
+			desc:          "immediate failure",
+			importJobName: immediateImportJobFailureName,
+			expectedCode:  codes.InvalidArgument,
+		},
+		{
+			desc:          "eventual failure",
+			importJobName: eventualImportJobFailureName,
+			expectedCode:  codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := newMockAmlfsRecorder([]string{})
+			dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+			err := dynamicProvisioner.CreateImportJob(context.Background(), &ImportJobProperties{
+				ResourceGroupName:      expectedResourceGroupName,
+				AmlFilesystemName:      expectedAmlFilesystemName,
+				ImportJobName:          test.importJobName,
+				Location:               expectedLocation,
+				ConflictResolutionMode: armstoragecache.ConflictResolutionModeFail,
+			})
+			require.Error(t, err)
+			grpcStatus, ok := status.FromError(err)
+			require.True(t, ok)
+			assert.Equal(t, test.expectedCode, grpcStatus.Code())
+			assert.Empty(t, recorder.recordedImportJobs)
+		})
+	}
+}
+
+func TestDynamicProvisioner_GetImportJobStatus_Success(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	recorder.recordedImportJobs[expectedImportJobName] = armstoragecache.ImportJob{
+		Name: to.Ptr(expectedImportJobName),
+		Properties: &armstoragecache.ImportJobProperties{
+			Status: &armstoragecache.ImportJobPropertiesStatus{
+				State:              to.Ptr(armstoragecache.ImportStatusTypeCompletedPartial),
+				StatusMessage:      to.Ptr("import completed with conflicts"),
+				TotalBlobsWalked:   to.Ptr(int64(10)),
+				TotalBlobsImported: to.Ptr(int64(8)),
+				TotalConflicts:     to.Ptr(int32(2)),
+				TotalErrors:        to.Ptr(int32(0)),
+			},
+		},
+	}
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	importJobStatus, err := dynamicProvisioner.GetImportJobStatus(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedImportJobName)
+	require.NoError(t, err)
+	assert.Equal(t, &ImportJobStatus{
+		State:              armstoragecache.ImportStatusTypeCompletedPartial,
+		StatusMessage:      "import completed with conflicts",
+		TotalBlobsWalked:   10,
+		TotalBlobsImported: 8,
+		TotalConflicts:     2,
+		TotalErrors:        0,
+	}, importJobStatus)
+}
+
+func TestDynamicProvisioner_GetImportJobStatus_Success_NoStatus(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	recorder.recordedImportJobs[expectedImportJobName] = armstoragecache.ImportJob{
+		Name:       to.Ptr(expectedImportJobName),
+		Properties: &armstoragecache.ImportJobProperties{},
+	}
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	importJobStatus, err := dynamicProvisioner.GetImportJobStatus(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedImportJobName)
+	require.NoError(t, err)
+	assert.Equal(t, &ImportJobStatus{}, importJobStatus)
+}
+
+func TestDynamicProvisioner_GetImportJobStatus_Err_NotFound(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.GetImportJobStatus(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedImportJobName)
+	require.Error(t, err)
+	grpcStatus, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.NotFound, grpcStatus.Code())
+}
+
+func TestDynamicProvisioner_GetImportJobStatus_Err_NilClient(t *testing.T) {
+	dynamicProvisioner := &DynamicProvisioner{}
+
+	_, err := dynamicProvisioner.GetImportJobStatus(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName, expectedImportJobName)
+	require.ErrorContains(t, err, "import jobs client is nil")
+	grpcStatus, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.Internal, grpcStatus.Code())
+}
+
+func TestDynamicProvisioner_CurrentClusterState_Success(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+	require.Empty(t, recorder.recordedAmlfsConfigurations)
+	_, err := dynamicProvisioner.CreateAmlFilesystem(context.Background(), &AmlFilesystemProperties{
+		ResourceGroupName: expectedResourceGroupName,
+		AmlFilesystemName: expectedAmlFilesystemName,
+		SubnetInfo:        buildExpectedSubnetInfo(),
+	})
+	require.NoError(t, err)
+	require.Len(t, recorder.recordedAmlfsConfigurations, 1)
+
+	currentClusterState, err := dynamicProvisioner.currentClusterState(context.Background(), expectedResourceGroupName, expectedAmlFilesystemName)
+	require.NoError(t, err)
+	require.Equal(t, ClusterStateExists, currentClusterState)
+}
+
+func TestDynamicProvisioner_CurrentClusterState_SuccessNotFound(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
@@ -1394,6 +2350,272 @@ func TestDynamicProvisioner_CurrentClusterState_ErrNilClient(t *testing.T) {
 	assert.ErrorContains(t, err, "aml filesystem client is nil")
 }
 
+func buildGetAvailableCapacityProperties(location, sku string) *AmlFilesystemProperties {
+	return &AmlFilesystemProperties{
+		Location:   location,
+		SKUName:    sku,
+		SubnetInfo: buildExpectedSubnetInfo(),
+	}
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Success(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	availableCapacityTiB, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		buildGetAvailableCapacityProperties(expectedLocation, expectedSku),
+		&LustreSkuValue{IncrementInTib: 4, MaximumInTib: 128},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, int64(128), availableCapacityTiB)
+	expectedCalls := []string{
+		"AscUsagesServerTransport.NewListPager",
+		"VirtualNetworksServerTransport.NewListUsagePager",
+	}
+	assert.Equal(t, expectedCalls, recorder.fakeCallCount[:2])
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Success_LimitedBySubnet(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	// The fake requires one IP for every 4 TiB, with 246 IPs available in the subnet
+	availableCapacityTiB, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		buildGetAvailableCapacityProperties(expectedLocation, scalingSubnetSizeSku),
+		&LustreSkuValue{IncrementInTib: 8, MaximumInTib: 2048},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, int64(984), availableCapacityTiB)
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Success_CachesSubnetSizes(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+	amlFilesystemProperties := buildGetAvailableCapacityProperties(expectedLocation, scalingSubnetSizeSku)
+	lustreSkuValue := &LustreSkuValue{IncrementInTib: 8, MaximumInTib: 2048}
+
+	subnetSizeCalls := func() int {
+		calls := 0
+		for _, call := range recorder.fakeCallCount {
+			if call == "ManagementServerTransport.GetRequiredAmlFSSubnetsSize" {
+				calls++
+			}
+		}
+		return calls
+	}
+
+	_, err := dynamicProvisioner.GetAvailableCapacity(context.Background(), amlFilesystemProperties, lustreSkuValue)
+	require.NoError(t, err)
+	firstPollCalls := subnetSizeCalls()
+	assert.Positive(t, firstPollCalls)
+
+	availableCapacityTiB, err := dynamicProvisioner.GetAvailableCapacity(context.Background(), amlFilesystemProperties, lustreSkuValue)
+	require.NoError(t, err)
+	assert.Equal(t, int64(984), availableCapacityTiB)
+	assert.Equal(t, firstPollCalls, subnetSizeCalls())
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Success_FullVnet(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	amlFilesystemProperties := buildGetAvailableCapacityProperties(expectedLocation, expectedSku)
+	amlFilesystemProperties.SubnetInfo.VnetName = fullVnetName
+	availableCapacityTiB, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		amlFilesystemProperties,
+		&LustreSkuValue{IncrementInTib: 4, MaximumInTib: 128},
+	)
+	require.NoError(t, err)
+	assert.Zero(t, availableCapacityTiB)
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Success_QuotaExhausted(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	availableCapacityTiB, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		buildGetAvailableCapacityProperties(quotaExhaustedLocation, expectedSku),
+		&LustreSkuValue{IncrementInTib: 4, MaximumInTib: 128},
+	)
+	require.NoError(t, err)
+	assert.Zero(t, availableCapacityTiB)
+	assert.Equal(t, []string{"AscUsagesServerTransport.NewListPager"}, recorder.fakeCallCount)
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Success_NoQuotaUsage(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	availableCapacityTiB, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		buildGetAvailableCapacityProperties(noQuotaUsageLocation, expectedSku),
+		&LustreSkuValue{IncrementInTib: 4, MaximumInTib: 128},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, int64(128), availableCapacityTiB)
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Err_Quota(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		buildGetAvailableCapacityProperties(quotaErrorLocation, expectedSku),
+		&LustreSkuValue{IncrementInTib: 4, MaximumInTib: 128},
+	)
+	assert.ErrorContains(t, err, "fake asc usages list error")
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Err_SubnetSize(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		buildGetAvailableCapacityProperties(expectedLocation, invalidSku),
+		&LustreSkuValue{IncrementInTib: 4, MaximumInTib: 128},
+	)
+	assert.ErrorContains(t, err, "fake invalid sku error")
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Err_InvalidIncrement(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	_, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		buildGetAvailableCapacityProperties(expectedLocation, expectedSku),
+		&LustreSkuValue{IncrementInTib: 0, MaximumInTib: 128},
+	)
+	assert.ErrorContains(t, err, "invalid capacity increment")
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Err_NilClient(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+	dynamicProvisioner.ascUsagesClient = nil
+
+	_, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		buildGetAvailableCapacityProperties(expectedLocation, expectedSku),
+		&LustreSkuValue{IncrementInTib: 4, MaximumInTib: 128},
+	)
+	assert.ErrorContains(t, err, "asc usages client is nil")
+}
+
+func buildSubnetCandidatesProperties(subnetCandidates []string, subnetNamePrefix string) *AmlFilesystemProperties {
+	return &AmlFilesystemProperties{
+		AmlFilesystemName: expectedAmlFilesystemName,
+		Location:          expectedLocation,
+		SKUName:           expectedSku,
+		SubnetInfo: SubnetProperties{
+			VnetResourceGroup: expectedResourceGroupName,
+			VnetName:          candidateSubnetsVnetName,
+			SubnetName:        "default",
+			SubnetID:          candidateSubnetIDPrefix + "default",
+		},
+		SubnetCandidates: subnetCandidates,
+		SubnetNamePrefix: subnetNamePrefix,
+	}
+}
+
+func TestDynamicProvisioner_SelectSubnet_Success(t *testing.T) {
+	testCases := []struct {
+		desc               string
+		subnetCandidates   []string
+		subnetNamePrefix   string
+		expectedSubnetName string
+	}{
+		{
+			desc:               "First candidate with room",
+			subnetCandidates:   []string{"amlfs-b", "other", "amlfs-c"},
+			expectedSubnetName: "other",
+		},
+		{
+			desc:               "First subnet with prefix and room by name",
+			subnetNamePrefix:   "amlfs-",
+			expectedSubnetName: "amlfs-c",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			recorder := newMockAmlfsRecorder([]string{})
+			dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+			subnetInfo, err := dynamicProvisioner.SelectSubnet(context.Background(), buildSubnetCandidatesProperties(tC.subnetCandidates, tC.subnetNamePrefix))
+			require.NoError(t, err)
+			assert.Equal(t, SubnetProperties{
+				VnetResourceGroup: expectedResourceGroupName,
+				VnetName:          candidateSubnetsVnetName,
+				SubnetName:        tC.expectedSubnetName,
+				SubnetID:          candidateSubnetIDPrefix + tC.expectedSubnetName,
+			}, subnetInfo)
+		})
+	}
+}
+
+func TestDynamicProvisioner_SelectSubnet_Success_NoCandidates(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	amlFilesystemProperties := buildSubnetCandidatesProperties(nil, "")
+	subnetInfo, err := dynamicProvisioner.SelectSubnet(context.Background(), amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, amlFilesystemProperties.SubnetInfo, subnetInfo)
+	assert.Empty(t, recorder.fakeCallCount)
+}
+
+func TestDynamicProvisioner_SelectSubnet_Err(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		subnetCandidates []string
+		subnetNamePrefix string
+		expectedCode     codes.Code
+		expectedError    string
+	}{
+		{
+			desc:             "No candidate with room",
+			subnetCandidates: []string{"amlfs-a", "amlfs-b"},
+			expectedCode:     codes.ResourceExhausted,
+			expectedError:    "not enough IP addresses available",
+		},
+		{
+			desc:             "Candidate not found",
+			subnetCandidates: []string{"amlfs-a", "missing"},
+			expectedCode:     codes.FailedPrecondition,
+			expectedError:    "missing not found in vnet",
+		},
+		{
+			desc:             "No subnet with prefix",
+			subnetNamePrefix: "missing-",
+			expectedCode:     codes.FailedPrecondition,
+			expectedError:    "no subnet with prefix missing- found in vnet",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			recorder := newMockAmlfsRecorder([]string{})
+			dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+			_, err := dynamicProvisioner.SelectSubnet(context.Background(), buildSubnetCandidatesProperties(tC.subnetCandidates, tC.subnetNamePrefix))
+			require.Error(t, err)
+			assert.Equal(t, tC.expectedCode, status.Code(err))
+			require.ErrorContains(t, err, tC.expectedError)
+		})
+	}
+}
+
+func TestDynamicProvisioner_GetAvailableCapacity_Success_SubnetCandidates(t *testing.T) {
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+
+	// The fake requires one IP for every 4 TiB, amlfs-c has 246 IPs available
+	amlFilesystemProperties := buildSubnetCandidatesProperties([]string{"amlfs-a", "amlfs-c"}, "")
+	amlFilesystemProperties.SKUName = scalingSubnetSizeSku
+	availableCapacityTiB, err := dynamicProvisioner.GetAvailableCapacity(context.Background(),
+		amlFilesystemProperties,
+		&LustreSkuValue{IncrementInTib: 8, MaximumInTib: 2048},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, int64(984), availableCapacityTiB)
+}
+
 func TestDynamicProvisioner_CheckSubnetCapacity_Success(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -1511,6 +2733,25 @@ func TestDynamicProvisioner_GetSkuValuesForLocation_Success(t *testing.T) {
 	assert.Equal(t, expectedSkuValues, skuValues)
 }
 
+func TestDynamicProvisioner_GetSkuValuesForLocation_Success_Cached(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := newMockAmlfsRecorder([]string{})
+	dynamicProvisioner := newTestDynamicProvisioner(t, recorder)
+	dynamicProvisioner.skuCache = newSkuCache(time.Hour)
+
+	skuValues, err := dynamicProvisioner.GetSkuValuesForLocation(context.Background(), expectedLocation)
+	require.NoError(t, err)
+	require.Len(t, skuValues, 2)
+
+	// Served from the cache without calling the client
+	dynamicProvisioner.skusClient = nil
+	cachedSkuValues, err := dynamicProvisioner.GetSkuValuesForLocation(context.Background(), expectedLocation)
+	require.NoError(t, err)
+	assert.Equal(t, skuValues, cachedSkuValues)
+}
+
 func TestDynamicProvisioner_GetSkuValuesForLocation_Err_NilClient(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
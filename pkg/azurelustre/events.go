@@ -0,0 +1,124 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Reasons of the events recorded on the PVC of a volume while its AMLFS
+// cluster is created, restored from a snapshot, populated from blobs or
+// deleted, or while its sub-dir is cloned
+const (
+	eventReasonCreationQueued          = "AmlfsCreationQueued"
+	eventReasonSkuResolved             = "AmlfsSkuResolved"
+	eventReasonSubnetSelected          = "AmlfsSubnetSelected"
+	eventReasonCreationStarted         = "AmlfsCreationStarted"
+	eventReasonCreationResumed         = "AmlfsCreationResumed"
+	eventReasonCreationInProgress      = "AmlfsCreationInProgress"
+	eventReasonCreationRetrying        = "AmlfsCreationRetrying"
+	eventReasonCreationSucceeded       = "AmlfsCreationSucceeded"
+	eventReasonCreationFailed          = "AmlfsCreationFailed"
+	eventReasonSnapshotImportStarted   = "AmlfsSnapshotImportStarted"
+	eventReasonSnapshotImportSucceeded = "AmlfsSnapshotImportSucceeded"
+	eventReasonSnapshotImportFailed    = "AmlfsSnapshotImportFailed"
+	eventReasonBlobImportProvisioning  = "AmlfsBlobImportProvisioning"
+	eventReasonBlobImportStarted       = "AmlfsBlobImportStarted"
+	eventReasonBlobImportInProgress    = "AmlfsBlobImportInProgress"
+	eventReasonBlobImportSucceeded     = "AmlfsBlobImportSucceeded"
+	eventReasonBlobImportPartial       = "AmlfsBlobImportPartial"
+	eventReasonBlobImportFailed        = "AmlfsBlobImportFailed"
+	eventReasonSubDirCloneStarted      = "SubDirCloneStarted"
+	eventReasonSubDirCloneInProgress   = "SubDirCloneInProgress"
+	eventReasonSubDirCloneSucceeded    = "SubDirCloneSucceeded"
+	eventReasonSubDirCloneFailed       = "SubDirCloneFailed"
+	eventReasonDeletionQueued          = "AmlfsDeletionQueued"
+	eventReasonArchiveStarted          = "AmlfsArchiveStarted"
+	eventReasonDeletionStarted         = "AmlfsDeletionStarted"
+	eventReasonDeletionSucceeded       = "AmlfsDeletionSucceeded"
+	eventReasonDeletionFailed          = "AmlfsDeletionFailed"
+)
+
+func newEventRecorder(kubeClient kubernetes.Interface, driverName string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartStructuredLogging(4)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: driverName})
+}
+
+// getPersistentVolumeClaim returns the PVC to record the events of a volume
+// creation on, or nil if it is unknown. The PVC name and namespace are passed
+// to CreateVolume by csi-provisioner with --extra-create-metadata, and are
+// recorded in the tags of the cluster
+func (d *Driver) getPersistentVolumeClaim(ctx context.Context, amlFilesystemProperties *AmlFilesystemProperties) runtime.Object {
+	namespace := amlFilesystemProperties.Tags[pvcNamespaceTag]
+	name := amlFilesystemProperties.Tags[pvcNameTag]
+	if d.eventRecorder == nil || d.kubeClient == nil || namespace == "" || name == "" {
+		return nil
+	}
+
+	pvc, err := d.kubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		klog.V(4).Infof("not recording events for AMLFS cluster %s, failed to get PVC %s/%s: %v",
+			amlFilesystemProperties.AmlFilesystemName, namespace, name, err)
+		return nil
+	}
+	return pvc
+}
+
+// getDeletedVolumeObject returns the PVC of the PV to record the events of
+// its deletion on, or the PV itself if the PVC was already deleted, which is
+// the case when the PV is deleted because of its reclaim policy
+func (d *Driver) getDeletedVolumeObject(ctx context.Context, pvName string) runtime.Object {
+	if d.eventRecorder == nil || d.kubeClient == nil || pvName == "" {
+		return nil
+	}
+
+	pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	if err != nil {
+		klog.V(4).Infof("not recording events for volume %s, failed to get PV: %v", pvName, err)
+		return nil
+	}
+	claimRef := pv.Spec.ClaimRef
+	if claimRef == nil {
+		return pv
+	}
+
+	pvc, err := d.kubeClient.CoreV1().PersistentVolumeClaims(claimRef.Namespace).Get(ctx, claimRef.Name, metav1.GetOptions{})
+	if err != nil || pvc.UID != claimRef.UID {
+		return pv
+	}
+	return pvc
+}
+
+// recordEvent records an event on object, which is nil if the events of the
+// volume are not recorded
+func (d *Driver) recordEvent(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if object == nil || d.eventRecorder == nil {
+		return
+	}
+	d.eventRecorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventsPVCName      = "pvc-lustre-dynprov"
+	eventsPVCNamespace = "default"
+	eventsPVCUID       = types.UID("pvc-uid")
+)
+
+// eventsPVC is the PVC of the clusters created in the tests
+var eventsPVC = &corev1.PersistentVolumeClaim{
+	ObjectMeta: metav1.ObjectMeta{Name: eventsPVCName, Namespace: eventsPVCNamespace, UID: eventsPVCUID},
+}
+
+func receiveEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestCreateAmlFilesystem_Events(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeKubeClient(eventsPVC), withFakeEventRecorder(recorder))
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, buildCreateOperationAmlFilesystemProperties("test_volume"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Normal AmlfsSkuResolved SKU AMLFS-Durable-Premium-125 is available in location eastus for AMLFS cluster test_volume of 32 TiB",
+		"Normal AmlfsSubnetSelected subnet test-subnet of virtual network test-vnet has enough IP addresses available for AMLFS cluster test_volume",
+		"Normal AmlfsCreationStarted started creation of AMLFS cluster test_volume in resource group fake-resource-group, which can take 15 minutes or more",
+		"Normal AmlfsCreationSucceeded created AMLFS cluster test_volume with MGS address 127.0.0.2",
+	}, receiveEvents(recorder))
+}
+
+func TestCreateAmlFilesystem_Events_InProgress(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient(eventsPVC), withFakeEventRecorder(recorder))
+	fakeDynamicProvisioner.pollCreateRelease = make(chan struct{})
+	d.createAmlFilesystemWaitTime = time.Millisecond
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties("test_volume")
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	_, err = d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.Error(t, err)
+	events := receiveEvents(recorder)
+	require.Len(t, events, 5)
+	assert.Equal(t, []string{
+		"Normal AmlfsCreationInProgress creation of AMLFS cluster test_volume is in progress",
+		"Normal AmlfsCreationInProgress creation of AMLFS cluster test_volume is in progress",
+	}, events[3:])
+
+	close(fakeDynamicProvisioner.pollCreateRelease)
+	d.createAmlFilesystemWaitTime = time.Minute
+	_, err = d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Normal AmlfsCreationSucceeded created AMLFS cluster test_volume with MGS address 127.0.0.2",
+	}, receiveEvents(recorder))
+}
+
+func TestCreateAmlFilesystem_Events_Resumed(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeKubeClient(eventsPVC), withFakeEventRecorder(recorder))
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties("test_volume")
+	persistCreateOperation(t, d, &amlFilesystemCreateOperation{
+		ResourceGroupName: amlFilesystemProperties.ResourceGroupName,
+		AmlFilesystemName: amlFilesystemProperties.AmlFilesystemName,
+		ResumeToken:       fakeResumeTokenPrefix + amlFilesystemProperties.AmlFilesystemName,
+		State:             createOperationStateInProgress,
+	})
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Normal AmlfsCreationResumed resumed creation of AMLFS cluster test_volume after a controller restart",
+		"Normal AmlfsCreationSucceeded created AMLFS cluster test_volume with MGS address 127.0.0.2",
+	}, receiveEvents(recorder))
+}
+
+func TestCreateAmlFilesystem_Events_Err(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		name          string
+		expectedEvent string
+	}{
+		{
+			desc:          "Retry after failed state",
+			name:          clusterPollRetryName,
+			expectedEvent: "Warning AmlfsCreationRetrying creation of AMLFS cluster " + clusterPollRetryName + " failed and the cluster was deleted, its creation will be retried",
+		},
+		{
+			desc:          "Failed creation",
+			name:          clusterPollFailureName,
+			expectedEvent: "Warning AmlfsCreationFailed creation of AMLFS cluster " + clusterPollFailureName + " failed: error occurred calling API: " + clusterPollFailureName,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(20)
+			d := NewFakeDriver(withFakeKubeClient(eventsPVC), withFakeEventRecorder(recorder))
+
+			_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, buildCreateOperationAmlFilesystemProperties(tC.name))
+			require.Error(t, err)
+			events := receiveEvents(recorder)
+			require.NotEmpty(t, events)
+			assert.Equal(t, tC.expectedEvent, events[len(events)-1])
+		})
+	}
+}
+
+func TestCreateAmlFilesystem_Events_NoPVC(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeKubeClient(eventsPVC), withFakeEventRecorder(recorder))
+	amlFilesystemProperties := buildCreateOperationAmlFilesystemProperties("test_volume")
+	amlFilesystemProperties.Tags[pvcNameTag] = "missing-pvc"
+
+	_, err := d.createAmlFilesystem(context.Background(), d.dynamicProvisioner, amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Empty(t, receiveEvents(recorder))
+}
+
+func TestGetDeletedVolumeObject(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		claimRef     *corev1.ObjectReference
+		pvName       string
+		expectedKind string
+	}{
+		{
+			desc:         "PVC exists",
+			claimRef:     &corev1.ObjectReference{Name: eventsPVCName, Namespace: eventsPVCNamespace, UID: eventsPVCUID},
+			pvName:       "pvc-1234",
+			expectedKind: "PersistentVolumeClaim",
+		},
+		{
+			desc:         "PVC deleted",
+			claimRef:     &corev1.ObjectReference{Name: "deleted-pvc", Namespace: eventsPVCNamespace, UID: "deleted-uid"},
+			pvName:       "pvc-1234",
+			expectedKind: "PersistentVolume",
+		},
+		{
+			desc:         "PVC recreated",
+			claimRef:     &corev1.ObjectReference{Name: eventsPVCName, Namespace: eventsPVCNamespace, UID: "previous-uid"},
+			pvName:       "pvc-1234",
+			expectedKind: "PersistentVolume",
+		},
+		{
+			desc:         "No claim",
+			pvName:       "pvc-1234",
+			expectedKind: "PersistentVolume",
+		},
+		{
+			desc:   "PV not found",
+			pvName: "pvc-5678",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			d := NewFakeDriver(withFakeKubeClient(eventsPVC), withFakeEventRecorder(record.NewFakeRecorder(20)))
+			_, err := d.kubeClient.CoreV1().PersistentVolumes().Create(context.Background(), &corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pvc-1234"},
+				Spec:       corev1.PersistentVolumeSpec{ClaimRef: tC.claimRef},
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			object := d.getDeletedVolumeObject(context.Background(), tC.pvName)
+			switch tC.expectedKind {
+			case "PersistentVolumeClaim":
+				require.IsType(t, &corev1.PersistentVolumeClaim{}, object)
+			case "PersistentVolume":
+				require.IsType(t, &corev1.PersistentVolume{}, object)
+			default:
+				assert.Nil(t, object)
+			}
+		})
+	}
+}
+
+func TestDynamicDeleteVolume_Events(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		name           string
+		archivePath    string
+		expectedEvents []string
+	}{
+		{
+			desc:        "Archived and deleted",
+			name:        "pvc-1234",
+			archivePath: "/archive",
+			expectedEvents: []string{
+				"Normal AmlfsArchiveStarted archiving path /archive of AMLFS cluster pvc-1234 before deleting it",
+				"Normal AmlfsDeletionStarted deleting AMLFS cluster pvc-1234 in resource group test-rg",
+				"Normal AmlfsDeletionSucceeded deleted AMLFS cluster pvc-1234 in resource group test-rg",
+			},
+		},
+		{
+			desc:        "Archive failed",
+			name:        archiveRequestFailureName,
+			archivePath: "/archive",
+			expectedEvents: []string{
+				"Normal AmlfsArchiveStarted archiving path /archive of AMLFS cluster " + archiveRequestFailureName + " before deleting it",
+				"Warning AmlfsDeletionFailed AMLFS cluster " + archiveRequestFailureName + " will not be deleted until archiving path /archive succeeds: archive of AMLFS cluster " + archiveRequestFailureName + " ended in state Failed",
+			},
+		},
+		{
+			desc: "Deletion failed",
+			name: clusterRequestFailureName,
+			expectedEvents: []string{
+				"Normal AmlfsDeletionStarted deleting AMLFS cluster " + clusterRequestFailureName + " in resource group test-rg",
+				"Warning AmlfsDeletionFailed failed to delete AMLFS cluster " + clusterRequestFailureName + " in resource group test-rg: error occurred calling API: " + clusterRequestFailureName,
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(20)
+			d := NewFakeDriver(withFakeKubeClient(eventsPVC), withFakeEventRecorder(recorder))
+			_, err := d.kubeClient.CoreV1().PersistentVolumes().Create(context.Background(), &corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: tC.name},
+				Spec: corev1.PersistentVolumeSpec{
+					ClaimRef: &corev1.ObjectReference{Name: eventsPVCName, Namespace: eventsPVCNamespace, UID: eventsPVCUID},
+				},
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			_, _ = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{
+				VolumeId: fmt.Sprintf(volumeIDTemplate+"#%s", tC.name, DefaultLustreFsName, "127.0.0.1", "", "t", "test-rg", tC.archivePath),
+			})
+			assert.Equal(t, tC.expectedEvents, receiveEvents(recorder))
+		})
+	}
+}
@@ -81,4 +81,9 @@ func TestGetPluginCapabilities(t *testing.T) {
 	require.NoError(t, err)
 	assert.NotNil(t, resp)
 	assert.NotEmpty(t, resp.GetCapabilities())
+	serviceTypes := []csi.PluginCapability_Service_Type{}
+	for _, capability := range resp.GetCapabilities() {
+		serviceTypes = append(serviceTypes, capability.GetService().GetType())
+	}
+	assert.Contains(t, serviceTypes, csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS)
 }
@@ -0,0 +1,127 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	leaderElectionLeaseDuration = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 2 * time.Second
+)
+
+// startLeaderLoops runs the background loops of the controller, which act on
+// clusters shared by all the controller replicas, in the replica holding the
+// lease of the driver only. A replica that loses the lease stops its loops
+// and waits for the lease again
+func (d *Driver) startLeaderLoops() {
+	var loops []func(ctx context.Context)
+	if d.orphanedAmlFilesystemCheckInterval > 0 {
+		loops = append(loops, d.runOrphanedAmlFilesystemCollector)
+	}
+	if d.createOperationResumeInterval > 0 {
+		loops = append(loops, d.runCreateOperationResumer)
+	}
+	if d.blobImportPopulatorInterval > 0 {
+		loops = append(loops, d.runBlobImportPopulator)
+	}
+	if len(loops) == 0 {
+		return
+	}
+	if d.kubeClient == nil {
+		klog.Warningf("kubernetes client is not available, the background loops of the controller will not run")
+		return
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		klog.Errorf("failed to get the identity of the controller for leader election, the background loops of the controller will not run: %v", err)
+		return
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      getLeaderElectionLeaseName(d.Name),
+			Namespace: d.operationNamespace,
+		},
+		Client:     d.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	go func() {
+		for {
+			d.runAsLeader(context.Background(), lock, loops)
+		}
+	}()
+}
+
+// runAsLeader waits for the lease and runs the loops until the lease is lost,
+// it returns once all the loops have stopped
+func (d *Driver) runAsLeader(ctx context.Context, lock resourcelock.Interface, loops []func(ctx context.Context)) {
+	var running sync.WaitGroup
+	running.Add(1)
+	var leading atomic.Bool
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            lock.Describe(),
+		LeaseDuration:   leaderElectionLeaseDuration,
+		RenewDeadline:   leaderElectionRenewDeadline,
+		RetryPeriod:     leaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				defer running.Done()
+				leading.Store(true)
+				klog.V(2).Infof("%s acquired lease %s, starting the background loops of the controller", lock.Identity(), lock.Describe())
+				var loopsRunning sync.WaitGroup
+				for _, loop := range loops {
+					loopsRunning.Add(1)
+					go func() {
+						defer loopsRunning.Done()
+						loop(ctx)
+					}()
+				}
+				loopsRunning.Wait()
+			},
+			OnStoppedLeading: func() {
+				if !leading.Load() {
+					return
+				}
+				klog.Warningf("%s lost lease %s, stopping the background loops of the controller", lock.Identity(), lock.Describe())
+			},
+		},
+	})
+	// RunOrDie only returns before leading if ctx is done
+	if ctx.Err() == nil {
+		running.Wait()
+	}
+}
+
+func getLeaderElectionLeaseName(driverName string) string {
+	return strings.ReplaceAll(driverName, ".", "-") + "-controller"
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/utils/ptr"
+)
+
+func newTestLeaseLock(d *Driver, identity string) *resourcelock.LeaseLock {
+	return &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      getLeaderElectionLeaseName(d.Name),
+			Namespace: d.operationNamespace,
+		},
+		Client:     d.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+}
+
+func TestRunAsLeader(t *testing.T) {
+	d := NewFakeDriver()
+	d.kubeClient = kubefake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		d.runAsLeader(ctx, newTestLeaseLock(d, "controller-0"), []func(ctx context.Context){
+			func(ctx context.Context) {
+				close(started)
+				<-ctx.Done()
+			},
+		})
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "loop was not started")
+	}
+	lease, err := d.kubeClient.CoordinationV1().Leases(DefaultOperationNamespace).Get(
+		context.Background(), getLeaderElectionLeaseName(d.Name), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "controller-0", ptr.Deref(lease.Spec.HolderIdentity, ""))
+
+	cancel()
+	<-stopped
+}
+
+func TestGetLeaderElectionLeaseName(t *testing.T) {
+	assert.Equal(t, "azurelustre-csi-azure-com-controller", getLeaderElectionLeaseName(DefaultDriverName))
+}
+
+func TestRunAsLeader_WaitsForLease(t *testing.T) {
+	d := NewFakeDriver()
+	d.kubeClient = kubefake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getLeaderElectionLeaseName(fakeDriverName),
+			Namespace: DefaultOperationNamespace,
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To("controller-1"),
+			LeaseDurationSeconds: ptr.To(int32(3600)),
+			AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+			RenewTime:            &metav1.MicroTime{Time: time.Now()},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	d.runAsLeader(ctx, newTestLeaseLock(d, "controller-0"), []func(ctx context.Context){
+		func(_ context.Context) {
+			assert.Fail(t, "loop started without the lease")
+		},
+	})
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultLNetName is the LNet network of the MGS addresses without one,
+	// which is the network of AMLFS clusters
+	defaultLNetName = "tcp"
+	// mgsFailoverSeparator separates the addresses of the failover MGS
+	// nodes, mgsNidSeparator the addresses of a single MGS node
+	mgsFailoverSeparator = ":"
+	mgsNidSeparator      = ","
+	mgsHostLookupTimeout = 10 * time.Second
+)
+
+var (
+	lnetNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	// fsNameRegexp matches the names Lustre accepts for a filesystem
+	fsNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,8}$`)
+)
+
+// mgsNid is the address of an MGS, the host is an IPv4 address or a host
+// name resolved when the filesystem is mounted
+type mgsNid struct {
+	host     string
+	lnetName string
+}
+
+func (n mgsNid) String() string {
+	return n.host + "@" + n.lnetName
+}
+
+// parseMgsNids parses the mgs-ip-address of a volume, the addresses of the
+// failover MGS nodes separated by ':', each with the addresses of the node
+// separated by ',', e.g. 10.0.0.4@tcp1,10.1.0.4@o2ib:mgs2.example.com
+func parseMgsNids(mgsAddress string) ([][]mgsNid, error) {
+	var nodes [][]mgsNid
+	for _, node := range strings.Split(mgsAddress, mgsFailoverSeparator) {
+		var nids []mgsNid
+		for _, address := range strings.Split(node, mgsNidSeparator) {
+			nid, err := parseMgsNid(strings.TrimSpace(address))
+			if err != nil {
+				return nil, fmt.Errorf("%v, was: '%s'", err, mgsAddress)
+			}
+			nids = append(nids, nid)
+		}
+		nodes = append(nodes, nids)
+	}
+	return nodes, nil
+}
+
+func parseMgsNid(address string) (mgsNid, error) {
+	if address == "" {
+		return mgsNid{}, fmt.Errorf("must be MGS addresses separated by '%s' or '%s' without empty addresses",
+			mgsFailoverSeparator, mgsNidSeparator)
+	}
+
+	host, lnetName, hasLNetName := strings.Cut(address, "@")
+	if !hasLNetName {
+		lnetName = defaultLNetName
+	}
+	if !lnetNameRegexp.MatchString(lnetName) {
+		return mgsNid{}, fmt.Errorf("must have valid LNet network names such as tcp or tcp1, %q is invalid", lnetName)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return mgsNid{}, fmt.Errorf("must have IPv4 addresses, %q is invalid", host)
+		}
+	} else if errs := validation.IsDNS1123Subdomain(strings.ToLower(host)); len(errs) > 0 {
+		return mgsNid{}, fmt.Errorf("must have IPv4 addresses or host names, %q is invalid", host)
+	}
+
+	return mgsNid{host: host, lnetName: lnetName}, nil
+}
+
+// getFsName returns the fs-name of a volume, which defaults to the
+// filesystem name of AMLFS clusters
+func getFsName(fsName string) string {
+	fsName = strings.Trim(fsName, "/")
+	if fsName == "" {
+		return DefaultLustreFsName
+	}
+	return fsName
+}
+
+func validateFsName(fsName string) error {
+	if !fsNameRegexp.MatchString(fsName) {
+		return fmt.Errorf("must be 1 to 8 letters, digits, '_' or '-', was: '%s'", fsName)
+	}
+	return nil
+}
+
+// getMountSource returns the source to mount the volume from, with the host
+// names of the MGS addresses resolved to their IPv4 addresses, as Lustre
+// only mounts from the NIDs of the MGS
+func (d *Driver) getMountSource(ctx context.Context, vol *lustreVolume) (string, error) {
+	nodes, err := parseMgsNids(vol.mgsIPAddress)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "Context %s %v", VolumeContextMGSIPAddress, err)
+	}
+
+	nodeNids := make([]string, 0, len(nodes))
+	for _, nids := range nodes {
+		var resolvedNids []string
+		for _, nid := range nids {
+			resolved, err := d.resolveMgsNid(ctx, nid)
+			if err != nil {
+				return "", err
+			}
+			resolvedNids = append(resolvedNids, resolved...)
+		}
+		nodeNids = append(nodeNids, strings.Join(resolvedNids, mgsNidSeparator))
+	}
+
+	return getSourceString(strings.Join(nodeNids, mgsFailoverSeparator), vol.azureLustreName), nil
+}
+
+// resolveMgsNid returns the NIDs of an MGS address, one for each IPv4 address
+// of its host name
+func (d *Driver) resolveMgsNid(ctx context.Context, nid mgsNid) ([]string, error) {
+	if net.ParseIP(nid.host) != nil {
+		return []string{nid.String()}, nil
+	}
+
+	lookupHost := d.lookupHost
+	if lookupHost == nil {
+		lookupHost = net.DefaultResolver.LookupHost
+	}
+	ctx, cancel := context.WithTimeout(ctx, mgsHostLookupTimeout)
+	defer cancel()
+	addresses, err := lookupHost(ctx, nid.host)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to resolve MGS host %s: %v", nid.host, err)
+	}
+
+	var nids []string
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			nids = append(nids, mgsNid{host: ip.String(), lnetName: nid.lnetName}.String())
+		}
+	}
+	if len(nids) == 0 {
+		return nil, status.Errorf(codes.Unavailable, "MGS host %s has no IPv4 address, found: %v", nid.host, addresses)
+	}
+	klog.V(4).Infof("resolved MGS host %s to %v", nid.host, nids)
+	return nids, nil
+}
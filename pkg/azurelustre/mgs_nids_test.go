@@ -0,0 +1,183 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var fakeHostAddresses = map[string][]string{
+	"mgs1.example.com": {"10.0.0.4", "fd00::4", "10.1.0.4"},
+	"mgs2.example.com": {"10.0.0.5"},
+	"ipv6.example.com": {"fd00::6"},
+}
+
+func fakeLookupHost(_ context.Context, host string) ([]string, error) {
+	addresses, ok := fakeHostAddresses[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addresses, nil
+}
+
+func TestParseMgsNids(t *testing.T) {
+	tests := []struct {
+		desc          string
+		mgsAddress    string
+		expectedNids  [][]mgsNid
+		expectedError string
+	}{
+		{
+			desc:         "IP address",
+			mgsAddress:   "10.0.0.4",
+			expectedNids: [][]mgsNid{{{host: "10.0.0.4", lnetName: "tcp"}}},
+		},
+		{
+			desc:         "LNet network names",
+			mgsAddress:   "10.0.0.4@tcp1,10.1.0.4@o2ib",
+			expectedNids: [][]mgsNid{{{host: "10.0.0.4", lnetName: "tcp1"}, {host: "10.1.0.4", lnetName: "o2ib"}}},
+		},
+		{
+			desc:       "failover MGS nodes",
+			mgsAddress: "10.0.0.4:mgs2.example.com@tcp2, 10.0.0.6",
+			expectedNids: [][]mgsNid{
+				{{host: "10.0.0.4", lnetName: "tcp"}},
+				{{host: "mgs2.example.com", lnetName: "tcp2"}, {host: "10.0.0.6", lnetName: "tcp"}},
+			},
+		},
+		{
+			desc:          "empty address",
+			mgsAddress:    "10.0.0.4::10.0.0.5",
+			expectedError: "must be MGS addresses separated by ':' or ',' without empty addresses, was: '10.0.0.4::10.0.0.5'",
+		},
+		{
+			desc:          "invalid LNet network name",
+			mgsAddress:    "10.0.0.4@TCP",
+			expectedError: `must have valid LNet network names such as tcp or tcp1, "TCP" is invalid`,
+		},
+		{
+			desc:          "invalid host name",
+			mgsAddress:    "mgs_1.example.com",
+			expectedError: `must have IPv4 addresses or host names, "mgs_1.example.com" is invalid`,
+		},
+		{
+			desc:          "IPv6 address",
+			mgsAddress:    "10.0.0.4,[fd00::4]",
+			expectedError: `must have IPv4 addresses or host names, "[fd00" is invalid`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			nids, err := parseMgsNids(test.mgsAddress)
+			if test.expectedError != "" {
+				require.ErrorContains(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedNids, nids)
+		})
+	}
+}
+
+func TestGetFsName(t *testing.T) {
+	assert.Equal(t, "lustrefs", getFsName(""))
+	assert.Equal(t, "lustrefs", getFsName("/"))
+	assert.Equal(t, "scratch", getFsName("scratch/"))
+
+	require.NoError(t, validateFsName("fs_1-a"))
+	require.ErrorContains(t, validateFsName("toolongfs"), "must be 1 to 8 letters, digits, '_' or '-', was: 'toolongfs'")
+	require.Error(t, validateFsName("fs.1"))
+}
+
+func TestGetMountSource(t *testing.T) {
+	tests := []struct {
+		desc           string
+		mgsIPAddress   string
+		fsName         string
+		expectedSource string
+		expectedCode   codes.Code
+		expectedError  string
+	}{
+		{
+			desc:           "AMLFS cluster",
+			mgsIPAddress:   "10.0.0.4",
+			fsName:         "lustrefs",
+			expectedSource: "10.0.0.4@tcp:/lustrefs",
+		},
+		{
+			desc:           "failover MGS nodes",
+			mgsIPAddress:   "10.0.0.4@tcp1:10.0.0.5@tcp1",
+			fsName:         "scratch",
+			expectedSource: "10.0.0.4@tcp1:10.0.0.5@tcp1:/scratch",
+		},
+		{
+			desc:           "host names",
+			mgsIPAddress:   "mgs1.example.com@o2ib:mgs2.example.com,10.0.0.6",
+			fsName:         "scratch",
+			expectedSource: "10.0.0.4@o2ib,10.1.0.4@o2ib:10.0.0.5@tcp,10.0.0.6@tcp:/scratch",
+		},
+		{
+			desc:          "unknown host name",
+			mgsIPAddress:  "10.0.0.4:missing.example.com",
+			fsName:        "lustrefs",
+			expectedCode:  codes.Unavailable,
+			expectedError: "failed to resolve MGS host missing.example.com: lookup missing.example.com: no such host",
+		},
+		{
+			desc:          "host name without IPv4 address",
+			mgsIPAddress:  "ipv6.example.com",
+			fsName:        "lustrefs",
+			expectedCode:  codes.Unavailable,
+			expectedError: "MGS host ipv6.example.com has no IPv4 address, found: [fd00::6]",
+		},
+		{
+			desc:          "invalid MGS address",
+			mgsIPAddress:  "10.0.0.4,",
+			fsName:        "lustrefs",
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "Context mgs-ip-address must be MGS addresses separated by ':' or ','",
+		},
+	}
+
+	d := NewFakeDriver()
+	d.lookupHost = fakeLookupHost
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			source, err := d.getMountSource(context.Background(), &lustreVolume{
+				mgsIPAddress:    test.mgsIPAddress,
+				azureLustreName: test.fsName,
+			})
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedCode, status.Code(err))
+				assert.ErrorContains(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedSource, source)
+		})
+	}
+}
@@ -19,6 +19,7 @@ package azurelustre
 import (
 	"context"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"strings"
@@ -27,6 +28,7 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume"
 	mount "k8s.io/mount-utils"
@@ -36,7 +38,7 @@ import (
 
 // NodePublishVolume mount the volume from staging to target path
 func (d *Driver) NodePublishVolume(
-	_ context.Context,
+	ctx context.Context,
 	req *csi.NodePublishVolumeRequest,
 ) (*csi.NodePublishVolumeResponse, error) {
 	mc := metrics.NewMetricContext(azureLustreCSIDriverName,
@@ -70,6 +72,11 @@ func (d *Driver) NodePublishVolume(
 			"Volume context must be provided")
 	}
 
+	context, err := d.resolveMgsIPAddress(ctx, context)
+	if err != nil {
+		return nil, err
+	}
+
 	vol, err := getVolume(volumeID, context)
 	if err != nil {
 		return nil, err
@@ -88,7 +95,10 @@ func (d *Driver) NodePublishVolume(
 		mc.ObserveOperationWithResult(isOperationSucceeded)
 	}()
 
-	source := getSourceString(vol.mgsIPAddress, vol.azureLustreName)
+	source, err := d.getMountSource(ctx, vol)
+	if err != nil {
+		return nil, err
+	}
 
 	mountOptions, readOnly := getMountOptions(req, userMountFlags)
 
@@ -110,7 +120,7 @@ func (d *Driver) NodePublishVolume(
 				interpolatedSubDir,
 			)
 
-			if err = d.createSubDir(vol, target, interpolatedSubDir, mountOptions); err != nil {
+			if err = d.createSubDir(vol, target, interpolatedSubDir, mountOptions, nil); err != nil {
 				return nil, err
 			}
 		}
@@ -249,6 +259,42 @@ func getVolume(volumeID string, context map[string]string) (*lustreVolume, error
 	return vol, nil
 }
 
+// resolveMgsIPAddress returns a copy of the volume context with the current
+// MGS address of the cluster referenced by amlfs-name, so that a recreated
+// cluster is mounted at its new address. The MGS address resolved when the
+// volume was created is used if the cluster cannot be retrieved
+func (d *Driver) resolveMgsIPAddress(ctx context.Context, volumeContext map[string]string) (map[string]string, error) {
+	existingProperties, err := parseExistingAmlFilesystemProperties(volumeContext)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Context %s", status.Convert(err).Message())
+	}
+	if existingProperties == nil {
+		return volumeContext, nil
+	}
+
+	mgsIPAddress := volumehelper.GetValueInMap(volumeContext, VolumeContextMGSIPAddress)
+	amlFilesystem, err := d.getExistingAmlFilesystem(ctx, d.dynamicProvisioner, existingProperties)
+	if err != nil {
+		if len(mgsIPAddress) == 0 {
+			return nil, err
+		}
+		klog.Warningf("could not resolve MGS address of AMLFS cluster %s, using %s: %v",
+			existingProperties.amlFilesystemName, mgsIPAddress, err)
+		return volumeContext, nil
+	}
+	if amlFilesystem.MgsAddress == mgsIPAddress {
+		return volumeContext, nil
+	}
+
+	if len(mgsIPAddress) > 0 {
+		klog.Warningf("MGS address of AMLFS cluster %s changed from %s to %s",
+			existingProperties.amlFilesystemName, mgsIPAddress, amlFilesystem.MgsAddress)
+	}
+	resolvedContext := maps.Clone(volumeContext)
+	volumehelper.SetKeyValueInMap(resolvedContext, VolumeContextMGSIPAddress, amlFilesystem.MgsAddress)
+	return resolvedContext, nil
+}
+
 func mountVolumeAtPath(d *Driver, source, target string, mountOptions []string) error {
 	d.kernelModuleLock.Lock()
 	defer d.kernelModuleLock.Unlock()
@@ -432,14 +478,47 @@ func (d *Driver) NodeGetCapabilities(
 
 // NodeGetInfo return info of the node on which this plugin is running
 func (d *Driver) NodeGetInfo(
-	_ context.Context,
+	ctx context.Context,
 	_ *csi.NodeGetInfoRequest,
 ) (*csi.NodeGetInfoResponse, error) {
+	zone, err := d.getNodeZone(ctx)
+	if err != nil {
+		// The node plugin must still register when the API server is not
+		// reachable, the zone is only needed for zonal AMLFS clusters
+		klog.Warningf("failed to get zone of node %s, reporting it without topology: %v", d.NodeID, err)
+		return &csi.NodeGetInfoResponse{
+			NodeId: d.NodeID,
+		}, nil
+	}
+
 	return &csi.NodeGetInfoResponse{
 		NodeId: d.NodeID,
+		AccessibleTopology: &csi.Topology{
+			Segments: map[string]string{topologyZoneKey: zone},
+		},
 	}, nil
 }
 
+// getNodeZone returns the zone label of the node, nodes without one are
+// reported the same way as nodes that are not in a zone so that all nodes
+// share the same topology keys
+func (d *Driver) getNodeZone(ctx context.Context) (string, error) {
+	if d.kubeClient == nil || d.NodeID == "" {
+		return nonZonalTopologyZone, nil
+	}
+
+	node, err := d.kubeClient.CoreV1().Nodes().Get(ctx, d.NodeID, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	zone, ok := node.Labels[topologyZoneKey]
+	if !ok || zone == "" {
+		klog.V(2).Infof("node %s has no %s label, reporting it as not in a zone", d.NodeID, topologyZoneKey)
+		return nonZonalTopologyZone, nil
+	}
+	return zone, nil
+}
+
 // NodeGetVolumeStats get volume stats
 func (d *Driver) NodeGetVolumeStats(
 	_ context.Context,
@@ -506,7 +585,7 @@ func (d *Driver) NodeGetVolumeStats(
 			volumeMetrics.InodesUsed)
 	}
 
-	return &csi.NodeGetVolumeStatsResponse{
+	response := &csi.NodeGetVolumeStatsResponse{
 		Usage: []*csi.VolumeUsage{
 			{
 				Unit:      csi.VolumeUsage_BYTES,
@@ -521,7 +600,55 @@ func (d *Driver) NodeGetVolumeStats(
 				Used:      inodesUsed,
 			},
 		},
-	}, nil
+	}
+
+	if vol, err := getLustreVolFromID(req.GetVolumeId()); err == nil && vol.projectID != 0 {
+		d.setProjectQuotaVolumeStats(response, volumePath, vol)
+	}
+
+	return response, nil
+}
+
+// setProjectQuotaVolumeStats reports the usage of a provisioned sub-dir
+// against its project quota instead of the whole filesystem, and marks the
+// volume as abnormal once writes are failing because the quota is exceeded
+func (d *Driver) setProjectQuotaVolumeStats(response *csi.NodeGetVolumeStatsResponse, volumePath string, vol *lustreVolume) {
+	quotaUsage, err := d.getProjectQuotaUsage(volumePath, vol.projectID)
+	if err != nil {
+		klog.Warningf("failed to get quota of project %d for volume %s, reporting filesystem usage: %v", vol.projectID, vol.id, err)
+		return
+	}
+
+	if quotaUsage.blockLimitKiB > 0 {
+		response.Usage[0] = &csi.VolumeUsage{
+			Unit:      csi.VolumeUsage_BYTES,
+			Available: max(quotaUsage.blockLimitKiB-quotaUsage.usedKiB, 0) * 1024,
+			Total:     quotaUsage.blockLimitKiB * 1024,
+			Used:      quotaUsage.usedKiB * 1024,
+		}
+	}
+	if quotaUsage.inodeLimit > 0 {
+		response.Usage[1] = &csi.VolumeUsage{
+			Unit:      csi.VolumeUsage_INODES,
+			Available: max(quotaUsage.inodeLimit-quotaUsage.usedInodes, 0),
+			Total:     quotaUsage.inodeLimit,
+			Used:      quotaUsage.usedInodes,
+		}
+	}
+
+	if quotaUsage.isExceeded() {
+		response.VolumeCondition = &csi.VolumeCondition{
+			Abnormal: true,
+			Message: fmt.Sprintf("project quota of volume is exceeded, writes fail with 'Disk quota exceeded' until files are removed: %d of %d KiB and %d of %d inodes used",
+				quotaUsage.usedKiB, quotaUsage.blockLimitKiB, quotaUsage.usedInodes, quotaUsage.inodeLimit),
+		}
+		return
+	}
+
+	response.VolumeCondition = &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  "project quota of volume is not exceeded",
+	}
 }
 
 // ensureMountPoint: create mount point if not exists
@@ -563,7 +690,7 @@ func (d *Driver) ensureMountPoint(target string) (bool, error) {
 	return !notMnt, nil
 }
 
-func (d *Driver) createSubDir(vol *lustreVolume, mountPath, subDirPath string, mountOptions []string) error {
+func (d *Driver) createSubDir(vol *lustreVolume, mountPath, subDirPath string, mountOptions []string, quota *projectQuota) error {
 	if err := d.internalMount(vol, mountPath, mountOptions); err != nil {
 		return err
 	}
@@ -585,11 +712,58 @@ func (d *Driver) createSubDir(vol *lustreVolume, mountPath, subDirPath string, m
 		return status.Errorf(codes.Internal, "failed to make subdirectory: %v", err.Error())
 	}
 
+	if quota != nil {
+		internalMountPath, err := getInternalMountPath(d.workingMountDir, mountPath)
+		if err != nil {
+			return err
+		}
+		if err := d.setProjectQuota(internalMountPath, internalVolumePath, quota); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Driver) removeSubDir(vol *lustreVolume, mountPath, subDirPath string, projectID uint32) error {
+	if err := d.internalMount(vol, mountPath, nil); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := d.internalUnmount(mountPath); err != nil {
+			klog.Warningf("failed to unmount lustre server: %v", err.Error())
+		}
+	}()
+
+	internalVolumePath, err := getInternalVolumePath(d.workingMountDir, mountPath, subDirPath)
+	if err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("Removing subdirectory at %q", internalVolumePath)
+
+	if err := os.RemoveAll(internalVolumePath); err != nil {
+		return status.Errorf(codes.Internal, "failed to remove subdirectory: %v", err.Error())
+	}
+
+	if projectID != 0 {
+		internalMountPath, err := getInternalMountPath(d.workingMountDir, mountPath)
+		if err != nil {
+			return err
+		}
+		if err := d.clearProjectQuota(internalMountPath, projectID); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func getSourceString(mgsIPAddress, azureLustreName string) string {
-	return fmt.Sprintf("%s@tcp:/%s", mgsIPAddress, azureLustreName)
+// getSourceString returns the Lustre mount source of a filesystem from the
+// NIDs of its MGS nodes
+func getSourceString(mgsNids, fsName string) string {
+	return fmt.Sprintf("%s:/%s", mgsNids, fsName)
 }
 
 func getInternalMountPath(workingMountDir, mountPath string) (string, error) {
@@ -624,7 +798,10 @@ func getInternalVolumePath(workingMountDir, mountPath, subDirPath string) (strin
 }
 
 func (d *Driver) internalMount(vol *lustreVolume, mountPath string, mountOptions []string) error {
-	source := getSourceString(vol.mgsIPAddress, vol.azureLustreName)
+	source, err := d.getMountSource(context.Background(), vol)
+	if err != nil {
+		return err
+	}
 
 	target, err := getInternalMountPath(d.workingMountDir, mountPath)
 	if err != nil {
@@ -706,7 +883,8 @@ func ensureStrictSubpath(subPath string) bool {
 
 // Convert context parameters to a lustreVolume
 func newLustreVolume(volumeID, volumeName string, params map[string]string) (*lustreVolume, error) {
-	var mgsIPAddress, subDir, resourceGroupName string
+	var mgsIPAddress, subDir, resourceGroupName, subDirOnDelete string
+	fsName := DefaultLustreFsName
 	createdByDynamicProvisioning := false
 
 	// validate parameters (case-insensitive).
@@ -714,6 +892,8 @@ func newLustreVolume(volumeID, volumeName string, params map[string]string) (*lu
 		switch strings.ToLower(k) {
 		case VolumeContextMGSIPAddress:
 			mgsIPAddress = v
+		case VolumeContextFSName:
+			fsName = getFsName(v)
 		case VolumeContextSubDir:
 			subDir = v
 			subDir = strings.Trim(subDir, "/")
@@ -733,6 +913,8 @@ func newLustreVolume(volumeID, volumeName string, params map[string]string) (*lu
 			}
 		case VolumeContextResourceGroupName:
 			resourceGroupName = v
+		case VolumeContextSubDirOnDelete:
+			subDirOnDelete = v
 		}
 	}
 
@@ -742,15 +924,22 @@ func newLustreVolume(volumeID, volumeName string, params map[string]string) (*lu
 			"Context mgs-ip-address must be provided",
 		)
 	}
+	if _, err := parseMgsNids(mgsIPAddress); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Context %s %v", VolumeContextMGSIPAddress, err)
+	}
+	if err := validateFsName(fsName); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Context %s %v", VolumeContextFSName, err)
+	}
 
 	vol := &lustreVolume{
 		name:                         volumeName,
 		mgsIPAddress:                 mgsIPAddress,
-		azureLustreName:              DefaultLustreFsName,
+		azureLustreName:              fsName,
 		subDir:                       subDir,
 		id:                           volumeID,
 		createdByDynamicProvisioning: createdByDynamicProvisioning,
 		resourceGroupName:            resourceGroupName,
+		subDirOnDelete:               subDirOnDelete,
 	}
 
 	return vol, nil
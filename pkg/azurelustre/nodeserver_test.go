@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -31,6 +32,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
 	mount "k8s.io/mount-utils"
 	testingexec "k8s.io/utils/exec/testing"
 )
@@ -48,6 +52,58 @@ func TestNodeGetInfo(t *testing.T) {
 	resp, err := d.NodeGetInfo(context.Background(), &req)
 	require.NoError(t, err)
 	assert.Equal(t, fakeNodeID, resp.GetNodeId())
+	assert.Equal(t, map[string]string{topologyZoneKey: "0"}, resp.GetAccessibleTopology().GetSegments())
+}
+
+func TestNodeGetInfo_Topology(t *testing.T) {
+	tests := []struct {
+		desc         string
+		nodeLabels   map[string]string
+		expectedZone string
+	}{
+		{
+			desc:         "zonal node",
+			nodeLabels:   map[string]string{topologyZoneKey: "eastus-1"},
+			expectedZone: "eastus-1",
+		},
+		{
+			desc:         "non-zonal node",
+			nodeLabels:   map[string]string{topologyZoneKey: "0"},
+			expectedZone: "0",
+		},
+		{
+			desc:         "node without zone label",
+			nodeLabels:   map[string]string{},
+			expectedZone: "0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			d.kubeClient = kubefake.NewSimpleClientset(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   fakeNodeID,
+					Labels: test.nodeLabels,
+				},
+			})
+
+			resp, err := d.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, fakeNodeID, resp.GetNodeId())
+			assert.Equal(t, map[string]string{topologyZoneKey: test.expectedZone}, resp.GetAccessibleTopology().GetSegments())
+		})
+	}
+}
+
+func TestNodeGetInfo_NodeNotFound(t *testing.T) {
+	d := NewFakeDriver()
+	d.kubeClient = kubefake.NewSimpleClientset()
+
+	resp, err := d.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, fakeNodeID, resp.GetNodeId())
+	assert.Nil(t, resp.GetAccessibleTopology())
 }
 
 func TestNodeGetCapabilities(t *testing.T) {
@@ -223,6 +279,28 @@ func TestNodePublishVolume(t *testing.T) {
 			expectedMountpoints:  []mount.MountPoint{{Device: "1.1.1.1@tcp:/lustrefs", Path: "target_test", Type: "lustre", Opts: []string{"noatime", "flock"}}},
 			expectedMountActions: []mount.FakeAction{{Action: "mount", Target: "target_test", Source: "1.1.1.1@tcp:/lustrefs", FSType: "lustre"}},
 		},
+		{
+			desc: "Valid request with amlfs-name uses current MGS address",
+			setup: func(d *Driver) {
+				d.dynamicProvisioner = newExistingAmlfsFakeDynamicProvisioner()
+			},
+			req: csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:         "vol_1#lustrefs#1.1.1.1##f#existing-resource-group",
+				TargetPath:       targetTest,
+				VolumeContext: map[string]string{
+					"mgs-ip-address":      "1.1.1.1",
+					"amlfs-name":          "existing-cluster",
+					"resource-group-name": "existing-resource-group",
+				},
+			},
+			expectedErr:          nil,
+			expectedMountpoints:  []mount.MountPoint{{Device: "127.0.0.2@tcp:/lustrefs", Path: "target_test", Type: "lustre", Opts: []string{}}},
+			expectedMountActions: []mount.FakeAction{{Action: "mount", Target: "target_test", Source: "127.0.0.2@tcp:/lustrefs", FSType: "lustre"}},
+			cleanup: func(d *Driver) {
+				d.dynamicProvisioner = &FakeDynamicProvisioner{}
+			},
+		},
 		{
 			desc: "Valid request with old ID",
 			req: csi.NodePublishVolumeRequest{
@@ -237,6 +315,78 @@ func TestNodePublishVolume(t *testing.T) {
 			expectedMountpoints:  []mount.MountPoint{{Device: "1.1.1.1@tcp:/lustrefs", Path: "target_test", Type: "lustre", Opts: []string{"noatime", "flock"}}},
 			expectedMountActions: []mount.FakeAction{{Action: "mount", Target: "target_test", Source: "1.1.1.1@tcp:/lustrefs", FSType: "lustre"}},
 		},
+		{
+			desc: "Valid request with fs name, LNet names and failover MGS nodes",
+			req: csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:         "vol_1#scratch#10.0.0.4@tcp1,10.1.0.4@o2ib:10.0.0.5@tcp1",
+				TargetPath:       targetTest,
+				VolumeContext:    map[string]string{"mgs-ip-address": "10.0.0.4@tcp1,10.1.0.4@o2ib:10.0.0.5@tcp1", "fs-name": "scratch"},
+			},
+			expectedErr:          nil,
+			expectedMountpoints:  []mount.MountPoint{{Device: "10.0.0.4@tcp1,10.1.0.4@o2ib:10.0.0.5@tcp1:/scratch", Path: "target_test", Type: "lustre", Opts: []string{}}},
+			expectedMountActions: []mount.FakeAction{{Action: "mount", Target: "target_test", Source: "10.0.0.4@tcp1,10.1.0.4@o2ib:10.0.0.5@tcp1:/scratch", FSType: "lustre"}},
+		},
+		{
+			desc: "Valid request with MGS host names",
+			setup: func(d *Driver) {
+				d.lookupHost = fakeLookupHost
+			},
+			req: csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:         "vol_1#lustrefs#mgs1.example.com@tcp1:mgs2.example.com",
+				TargetPath:       targetTest,
+				VolumeContext:    map[string]string{"mgs-ip-address": "mgs1.example.com@tcp1:mgs2.example.com"},
+			},
+			expectedErr:          nil,
+			expectedMountpoints:  []mount.MountPoint{{Device: "10.0.0.4@tcp1,10.1.0.4@tcp1:10.0.0.5@tcp:/lustrefs", Path: "target_test", Type: "lustre", Opts: []string{}}},
+			expectedMountActions: []mount.FakeAction{{Action: "mount", Target: "target_test", Source: "10.0.0.4@tcp1,10.1.0.4@tcp1:10.0.0.5@tcp:/lustrefs", FSType: "lustre"}},
+			cleanup: func(d *Driver) {
+				d.lookupHost = nil
+			},
+		},
+		{
+			desc: "MGS host name not resolved",
+			setup: func(d *Driver) {
+				d.lookupHost = fakeLookupHost
+			},
+			req: csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:         "vol_1#lustrefs#missing.example.com",
+				TargetPath:       targetTest,
+				VolumeContext:    map[string]string{"mgs-ip-address": "missing.example.com"},
+			},
+			expectedErr:          status.Error(codes.Unavailable, "failed to resolve MGS host missing.example.com: lookup missing.example.com: no such host"),
+			expectedMountpoints:  nil,
+			expectedMountActions: []mount.FakeAction{},
+			cleanup: func(d *Driver) {
+				d.lookupHost = nil
+			},
+		},
+		{
+			desc: "Invalid LNet network name",
+			req: csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:         "vol_1#lustrefs#1.1.1.1@tcp-1",
+				TargetPath:       targetTest,
+				VolumeContext:    map[string]string{"mgs-ip-address": "1.1.1.1@tcp-1"},
+			},
+			expectedErr:          status.Error(codes.InvalidArgument, "Context mgs-ip-address must have valid LNet network names such as tcp or tcp1, \"tcp-1\" is invalid, was: '1.1.1.1@tcp-1'"),
+			expectedMountpoints:  nil,
+			expectedMountActions: []mount.FakeAction{},
+		},
+		{
+			desc: "Invalid fs name",
+			req: csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap},
+				VolumeId:         "vol_1#too_long_name#1.1.1.1",
+				TargetPath:       targetTest,
+				VolumeContext:    map[string]string{"mgs-ip-address": "1.1.1.1", "fs-name": "too_long_name"},
+			},
+			expectedErr:          status.Error(codes.InvalidArgument, "Context fs-name must be 1 to 8 letters, digits, '_' or '-', was: 'too_long_name'"),
+			expectedMountpoints:  nil,
+			expectedMountActions: []mount.FakeAction{},
+		},
 		{
 			desc: "Empty sub-dir",
 			req: csi.NodePublishVolumeRequest{
@@ -595,6 +745,64 @@ func TestNodePublishVolume(t *testing.T) {
 	}
 }
 
+func TestResolveMgsIPAddress(t *testing.T) {
+	cases := []struct {
+		desc            string
+		volumeContext   map[string]string
+		expectedContext map[string]string
+		expectedErr     error
+	}{
+		{
+			desc:            "no amlfs-name",
+			volumeContext:   map[string]string{"mgs-ip-address": "1.1.1.1"},
+			expectedContext: map[string]string{"mgs-ip-address": "1.1.1.1"},
+		},
+		{
+			desc:            "unchanged MGS address",
+			volumeContext:   map[string]string{"mgs-ip-address": "127.0.0.2", "amlfs-name": "existing-cluster", "resource-group-name": "existing-resource-group"},
+			expectedContext: map[string]string{"mgs-ip-address": "127.0.0.2", "amlfs-name": "existing-cluster", "resource-group-name": "existing-resource-group"},
+		},
+		{
+			desc:            "changed MGS address",
+			volumeContext:   map[string]string{"MGS-IP-Address": "1.1.1.1", "amlfs-name": "existing-cluster", "resource-group-name": "existing-resource-group"},
+			expectedContext: map[string]string{"MGS-IP-Address": "127.0.0.2", "amlfs-name": "existing-cluster", "resource-group-name": "existing-resource-group"},
+		},
+		{
+			desc:            "no MGS address resolved by CreateVolume",
+			volumeContext:   map[string]string{"amlfs-name": "existing-cluster", "resource-group-name": "existing-resource-group"},
+			expectedContext: map[string]string{"mgs-ip-address": "127.0.0.2", "amlfs-name": "existing-cluster", "resource-group-name": "existing-resource-group"},
+		},
+		{
+			desc:            "cluster not found uses MGS address resolved by CreateVolume",
+			volumeContext:   map[string]string{"mgs-ip-address": "1.1.1.1", "amlfs-name": "missing-cluster", "resource-group-name": "existing-resource-group"},
+			expectedContext: map[string]string{"mgs-ip-address": "1.1.1.1", "amlfs-name": "missing-cluster", "resource-group-name": "existing-resource-group"},
+		},
+		{
+			desc:          "cluster not found",
+			volumeContext: map[string]string{"amlfs-name": "missing-cluster", "resource-group-name": "existing-resource-group"},
+			expectedErr: status.Error(codes.NotFound, "error when getting AMLFS cluster missing-cluster in resource group existing-resource-group: "+
+				"rpc error: code = NotFound desc = AMLFS cluster missing-cluster not found in resource group existing-resource-group"),
+		},
+		{
+			desc:          "subscription-id without amlfs-name",
+			volumeContext: map[string]string{"mgs-ip-address": "1.1.1.1", "subscription-id": "other-subscription"},
+			expectedErr:   status.Error(codes.InvalidArgument, "Context Parameter subscription-id can only be used with amlfs-name"),
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			d.dynamicProvisioner = newExistingAmlfsFakeDynamicProvisioner()
+			original := maps.Clone(test.volumeContext)
+
+			volumeContext, err := d.resolveMgsIPAddress(context.Background(), test.volumeContext)
+			assert.Equal(t, test.expectedErr, err)
+			assert.Equal(t, test.expectedContext, volumeContext)
+			assert.Equal(t, original, test.volumeContext, "volume context of the request must not be modified")
+		})
+	}
+}
+
 func TestNodeUnpublishVolume(t *testing.T) {
 	workingDirectory, err := os.Getwd()
 	if err != nil {
@@ -876,6 +1084,74 @@ func TestNodeGetVolumeStats(t *testing.T) {
 	}
 }
 
+func TestNodeGetVolumeStats_ProjectQuota(t *testing.T) {
+	cases := []struct {
+		desc              string
+		command           fakeLfsCommand
+		expectedUsage     []*csi.VolumeUsage
+		expectedCondition *csi.VolumeCondition
+	}{
+		{
+			desc:    "usage within quota",
+			command: fakeLfsCommand{output: "/tmp/fake-volume-path 512 0 1024 - 10 0 100 -\n"},
+			expectedUsage: []*csi.VolumeUsage{
+				{Unit: csi.VolumeUsage_BYTES, Available: 512 * 1024, Total: 1024 * 1024, Used: 512 * 1024},
+				{Unit: csi.VolumeUsage_INODES, Available: 90, Total: 100, Used: 10},
+			},
+			expectedCondition: &csi.VolumeCondition{Abnormal: false, Message: "project quota of volume is not exceeded"},
+		},
+		{
+			desc:    "quota exceeded",
+			command: fakeLfsCommand{output: "/tmp/fake-volume-path 1028* 0 1024 - 10 0 100 -\n"},
+			expectedUsage: []*csi.VolumeUsage{
+				{Unit: csi.VolumeUsage_BYTES, Available: 0, Total: 1024 * 1024, Used: 1028 * 1024},
+				{Unit: csi.VolumeUsage_INODES, Available: 90, Total: 100, Used: 10},
+			},
+			expectedCondition: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  "project quota of volume is exceeded, writes fail with 'Disk quota exceeded' until files are removed: 1028 of 1024 KiB and 10 of 100 inodes used",
+			},
+		},
+	}
+
+	fakePath := filepath.Join(t.TempDir(), "fake-volume-path")
+	require.NoError(t, makeDir(fakePath))
+
+	for _, test := range cases {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			setSubDirProvisioningFakeMounter(t, d)
+			commandLines := addFakeLfsCommands(t, d, test.command)
+
+			resp, err := d.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumePath: fakePath,
+				VolumeId:   "vol_1#lustrefs#1.1.1.1#testSubDir#f###delete#42",
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"lfs quota -q -p 42 " + fakePath}, *commandLines)
+			assert.Equal(t, test.expectedUsage, resp.GetUsage())
+			assert.Equal(t, test.expectedCondition, resp.GetVolumeCondition())
+		})
+	}
+}
+
+func TestNodeGetVolumeStats_ProjectQuotaError(t *testing.T) {
+	fakePath := filepath.Join(t.TempDir(), "fake-volume-path")
+	require.NoError(t, makeDir(fakePath))
+
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	addFakeLfsCommands(t, d, fakeLfsCommand{output: "quota not enabled", err: errors.New("exit status 1")})
+
+	resp, err := d.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+		VolumePath: fakePath,
+		VolumeId:   "vol_1#lustrefs#1.1.1.1#testSubDir#f###delete#42",
+	})
+	require.NoError(t, err)
+	assert.Len(t, resp.GetUsage(), 2)
+	assert.Nil(t, resp.GetVolumeCondition())
+}
+
 func TestEnsureStrictSubpath(t *testing.T) {
 	cases := []struct {
 		desc           string
@@ -1079,6 +1355,25 @@ func TestNewLustreVolume(t *testing.T) {
 				subDir:          "testSubDir",
 			},
 		},
+		{
+			desc:    "valid context with provisioned sub-dir",
+			id:      "vol_1#lustrefs#1.1.1.1#testSubDir#f###delete",
+			volName: "vol_1",
+			params: map[string]string{
+				"mgs-ip-address":    "1.1.1.1",
+				"fs-name":           "lustrefs",
+				"sub-dir":           "testSubDir",
+				"sub-dir-on-delete": "delete",
+			},
+			expectedLustreVolume: &lustreVolume{
+				id:              "vol_1#lustrefs#1.1.1.1#testSubDir#f###delete",
+				name:            "vol_1",
+				azureLustreName: "lustrefs",
+				mgsIPAddress:    "1.1.1.1",
+				subDir:          "testSubDir",
+				subDirOnDelete:  "delete",
+			},
+		},
 		{
 			desc:    "invalid parameter is ignored",
 			id:      "vol_1#lustrefs#1.1.1.1#",
@@ -0,0 +1,137 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	// queuedOperationExpiry is how long an operation stays queued without
+	// being requested again, it is longer than the maximum retry interval
+	// of csi-provisioner so that only abandoned requests expire
+	queuedOperationExpiry = 10 * time.Minute
+
+	amlFilesystemOperationQueuedFmt = "%s of AMLFS cluster %s is queued at position %d, %d AMLFS creations and deletions are in progress"
+
+	createOperationKeyPrefix = "create/"
+	deleteOperationKeyPrefix = "delete/"
+)
+
+var amlFilesystemOperationQueueDepth = metrics.NewGauge(
+	&metrics.GaugeOpts{
+		Namespace:      "azurelustre_csi",
+		Name:           "amlfs_operation_queue_depth",
+		Help:           "Number of AMLFS creations and deletions waiting for one of the max-concurrent-amlfs-operations to complete",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(amlFilesystemOperationQueueDepth)
+}
+
+type queuedOperation struct {
+	key      string
+	lastSeen time.Time
+}
+
+// amlFilesystemOperationQueue limits the AMLFS creations and deletions in
+// progress in the controller, across all the credentials they are made with.
+// CreateVolume and DeleteVolume do not wait for a free slot, they return
+// Aborted and are retried by csi-provisioner. Their place in the queue is
+// kept between retries, so that slots are given in the order the operations
+// were first requested
+type amlFilesystemOperationQueue struct {
+	// limit is the maximum number of operations in progress, 0 is unlimited
+	limit   int
+	now     func() time.Time
+	running sets.Set[string]
+	waiting []*queuedOperation
+	mux     sync.Mutex
+}
+
+func newAmlFilesystemOperationQueue(limit int) *amlFilesystemOperationQueue {
+	return &amlFilesystemOperationQueue{
+		limit:   limit,
+		now:     time.Now,
+		running: sets.New[string](),
+	}
+}
+
+// tryAcquire starts the operation if there is a slot free for it, and returns
+// 0. Otherwise the operation is queued, and its position in the queue,
+// starting at 1, is returned
+func (q *amlFilesystemOperationQueue) tryAcquire(key string) int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	defer q.updateQueueDepth()
+
+	if q.running.Has(key) {
+		return 0
+	}
+	now := q.now()
+	q.waiting = slices.DeleteFunc(q.waiting, func(operation *queuedOperation) bool {
+		return operation.key != key && now.Sub(operation.lastSeen) > queuedOperationExpiry
+	})
+
+	index := slices.IndexFunc(q.waiting, func(operation *queuedOperation) bool {
+		return operation.key == key
+	})
+	if index < 0 {
+		index = len(q.waiting)
+		q.waiting = append(q.waiting, &queuedOperation{key: key})
+	}
+	q.waiting[index].lastSeen = now
+
+	// Free slots are kept for the operations queued first
+	if q.limit > 0 && index >= q.limit-q.running.Len() {
+		return index + 1
+	}
+	q.waiting = slices.Delete(q.waiting, index, index+1)
+	q.running.Insert(key)
+	return 0
+}
+
+// release frees the slot of the operation, or removes it from the queue
+func (q *amlFilesystemOperationQueue) release(key string) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	defer q.updateQueueDepth()
+
+	q.running.Delete(key)
+	q.waiting = slices.DeleteFunc(q.waiting, func(operation *queuedOperation) bool {
+		return operation.key == key
+	})
+}
+
+// inProgress returns the number of operations holding a slot
+func (q *amlFilesystemOperationQueue) inProgress() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.running.Len()
+}
+
+func (q *amlFilesystemOperationQueue) updateQueueDepth() {
+	amlFilesystemOperationQueueDepth.Set(float64(len(q.waiting)))
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+func newTestOperationQueue(limit int, now *time.Time) *amlFilesystemOperationQueue {
+	queue := newAmlFilesystemOperationQueue(limit)
+	queue.now = func() time.Time { return *now }
+	return queue
+}
+
+func getOperationQueueDepth(t *testing.T) float64 {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == "azurelustre_csi_amlfs_operation_queue_depth" {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestOperationQueue_Unlimited(t *testing.T) {
+	now := time.Now()
+	queue := newTestOperationQueue(0, &now)
+
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Zero(t, queue.tryAcquire(key))
+	}
+	assert.Equal(t, 3, queue.inProgress())
+}
+
+func TestOperationQueue_QueuedInOrder(t *testing.T) {
+	now := time.Now()
+	queue := newTestOperationQueue(2, &now)
+
+	assert.Zero(t, queue.tryAcquire("a"))
+	assert.Zero(t, queue.tryAcquire("b"))
+	assert.Equal(t, 1, queue.tryAcquire("c"))
+	assert.Equal(t, 2, queue.tryAcquire("d"))
+	assert.InDelta(t, 2, getOperationQueueDepth(t), 0)
+
+	// Retries of an operation in progress or queued keep their place
+	assert.Zero(t, queue.tryAcquire("a"))
+	assert.Equal(t, 2, queue.tryAcquire("d"))
+	assert.Equal(t, 1, queue.tryAcquire("c"))
+
+	// The free slot is kept for the first queued operation, even if another
+	// one is retried first
+	queue.release("a")
+	assert.Equal(t, 2, queue.tryAcquire("d"))
+	assert.Equal(t, 3, queue.tryAcquire("e"))
+	assert.Zero(t, queue.tryAcquire("c"))
+	assert.Equal(t, 1, queue.tryAcquire("d"))
+	assert.Equal(t, 2, queue.tryAcquire("e"))
+	assert.Equal(t, 2, queue.inProgress())
+	assert.InDelta(t, 2, getOperationQueueDepth(t), 0)
+
+	queue.release("b")
+	queue.release("c")
+	assert.Zero(t, queue.tryAcquire("e"))
+	assert.Zero(t, queue.tryAcquire("d"))
+	assert.InDelta(t, 0, getOperationQueueDepth(t), 0)
+}
+
+func TestOperationQueue_Release_Queued(t *testing.T) {
+	now := time.Now()
+	queue := newTestOperationQueue(1, &now)
+
+	assert.Zero(t, queue.tryAcquire("a"))
+	assert.Equal(t, 1, queue.tryAcquire("b"))
+	assert.Equal(t, 2, queue.tryAcquire("c"))
+
+	queue.release("b")
+	assert.Equal(t, 1, queue.tryAcquire("c"))
+	assert.Equal(t, 1, queue.inProgress())
+}
+
+func TestOperationQueue_ExpiresAbandonedOperations(t *testing.T) {
+	now := time.Now()
+	queue := newTestOperationQueue(1, &now)
+
+	assert.Zero(t, queue.tryAcquire("a"))
+	assert.Equal(t, 1, queue.tryAcquire("b"))
+	assert.Equal(t, 2, queue.tryAcquire("c"))
+
+	now = now.Add(queuedOperationExpiry)
+	assert.Equal(t, 2, queue.tryAcquire("c"))
+
+	// b was not retried since it was queued
+	now = now.Add(time.Second)
+	assert.Equal(t, 1, queue.tryAcquire("c"))
+
+	// In progress operations do not expire
+	now = now.Add(2 * queuedOperationExpiry)
+	assert.Equal(t, 1, queue.tryAcquire("c"))
+	assert.Equal(t, 1, queue.inProgress())
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const DefaultOrphanedAmlFilesystemGracePeriod = 24 * time.Hour
+
+// runOrphanedAmlFilesystemCollector periodically looks for AMLFS clusters
+// created by the driver whose PV no longer exists, e.g. because the PV was
+// force-deleted or DeleteVolume failed permanently. It only runs in the
+// controller replica holding the lease, and the grace period of the clusters
+// starts again when another replica takes over
+func (d *Driver) runOrphanedAmlFilesystemCollector(ctx context.Context) {
+	klog.V(2).Infof("checking for orphaned AMLFS clusters every %v, deleting orphaned clusters: %t",
+		d.orphanedAmlFilesystemCheckInterval, d.deleteOrphanedAmlFilesystems)
+	d.orphanedAmlFilesystems = make(map[string]time.Time)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		d.collectOrphanedAmlFilesystems(ctx, time.Now())
+	}, d.orphanedAmlFilesystemCheckInterval)
+}
+
+// collectOrphanedAmlFilesystems reports the orphaned AMLFS clusters, and
+// deletes the ones that have been orphaned for longer than the grace period
+// if deleteOrphanedAmlFilesystems is set. The grace period starts when a
+// cluster is first found orphaned by this controller
+func (d *Driver) collectOrphanedAmlFilesystems(ctx context.Context, now time.Time) {
+	amlFilesystems, err := d.dynamicProvisioner.ListAmlFilesystems(ctx)
+	if err != nil {
+		klog.Errorf("error when listing AMLFS clusters to check for orphaned clusters: %v", err)
+		return
+	}
+
+	orphanedSince := make(map[string]time.Time, len(d.orphanedAmlFilesystems))
+	for _, amlFilesystem := range amlFilesystems {
+		amlFilesystemProperties := &AmlFilesystemProperties{
+			ResourceGroupName: amlFilesystem.ResourceGroupName,
+			AmlFilesystemName: amlFilesystem.Name,
+		}
+		key := getCreateOperationKey(amlFilesystemProperties)
+		orphaned, err := d.isOrphanedAmlFilesystem(ctx, amlFilesystem, amlFilesystemProperties)
+		if err != nil {
+			klog.Errorf("error when checking if AMLFS cluster %s in resource group %s is orphaned: %v",
+				amlFilesystem.Name, amlFilesystem.ResourceGroupName, err)
+			if firstSeen, ok := d.orphanedAmlFilesystems[key]; ok {
+				orphanedSince[key] = firstSeen
+			}
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+
+		firstSeen, ok := d.orphanedAmlFilesystems[key]
+		if !ok {
+			firstSeen = now
+		}
+		orphanedSince[key] = firstSeen
+
+		pvName := amlFilesystem.Tags[pvNameTag]
+		if !d.deleteOrphanedAmlFilesystems {
+			klog.Warningf("AMLFS cluster %s in resource group %s is orphaned, PV %s no longer exists. The cluster must be deleted manually",
+				amlFilesystem.Name, amlFilesystem.ResourceGroupName, pvName)
+			continue
+		}
+		if deleteAfter := firstSeen.Add(d.orphanedAmlFilesystemGracePeriod); now.Before(deleteAfter) {
+			klog.Warningf("AMLFS cluster %s in resource group %s is orphaned, PV %s no longer exists. The cluster will be deleted after %s",
+				amlFilesystem.Name, amlFilesystem.ResourceGroupName, pvName, deleteAfter.Format(time.RFC3339))
+			continue
+		}
+
+		if err := d.deleteOrphanedAmlFilesystem(ctx, amlFilesystem); err != nil {
+			klog.Errorf("error when deleting orphaned AMLFS cluster %s in resource group %s: %v",
+				amlFilesystem.Name, amlFilesystem.ResourceGroupName, err)
+			continue
+		}
+		delete(orphanedSince, key)
+		// The result of the creation is no longer needed, as CreateVolume is
+		// not retried for a deleted PVC
+		d.createOperations.remove(key)
+		d.deleteCreateOperation(ctx, amlFilesystemProperties)
+	}
+
+	d.orphanedAmlFilesystems = orphanedSince
+}
+
+// isOrphanedAmlFilesystem only considers clusters tagged with both the driver
+// and the PV they were created for, so that clusters created by other means
+// are never deleted. Clusters still being created have no PV yet, whether
+// the creation is polled by this controller or was persisted by another one,
+// and neither have the clusters of a PVC that is still unbound
+func (d *Driver) isOrphanedAmlFilesystem(ctx context.Context, amlFilesystem *AmlFilesystemInfo, amlFilesystemProperties *AmlFilesystemProperties) (bool, error) {
+	if amlFilesystem.Tags[createdByTag] != azureLustreDriverTag {
+		return false, nil
+	}
+	pvName := amlFilesystem.Tags[pvNameTag]
+	if pvName == "" {
+		return false, nil
+	}
+	if amlFilesystem.ProvisioningState == armstoragecache.AmlFilesystemProvisioningStateTypeDeleting {
+		return false, nil
+	}
+	// The PV is only created once CreateVolume returns
+	if operation := d.createOperations.get(getCreateOperationKey(amlFilesystemProperties)); operation != nil {
+		select {
+		case <-operation.done:
+		default:
+			return false, nil
+		}
+	}
+	persistedOperation, err := d.getCreateOperation(ctx, amlFilesystemProperties)
+	if err != nil {
+		return false, err
+	}
+	if persistedOperation != nil && persistedOperation.State == createOperationStateInProgress {
+		return false, nil
+	}
+
+	_, err = d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	if err == nil {
+		return false, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	// CreateVolume returns Aborted after the creation while the cluster is
+	// populated, e.g. from a snapshot, so the PVC stays unbound until the PV
+	// is created
+	pvcName, pvcNamespace := amlFilesystem.Tags[pvcNameTag], amlFilesystem.Tags[pvcNamespaceTag]
+	if pvcName == "" || pvcNamespace == "" {
+		return true, nil
+	}
+	pvc, err := d.kubeClient.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return pvc.Spec.VolumeName != "", nil
+}
+
+func (d *Driver) deleteOrphanedAmlFilesystem(ctx context.Context, amlFilesystem *AmlFilesystemInfo) error {
+	// The deletion is not kept in the queue, it is tried again on the next
+	// check, so that it never holds back the deletions of DeleteVolume
+	operationKey := deleteOperationKeyPrefix + amlFilesystem.ResourceGroupName + "/" + amlFilesystem.Name
+	defer d.amlFilesystemOperations.release(operationKey)
+	if position := d.amlFilesystemOperations.tryAcquire(operationKey); position > 0 {
+		return status.Errorf(codes.Aborted, amlFilesystemOperationQueuedFmt,
+			"deletion", amlFilesystem.Name, position, d.amlFilesystemOperations.inProgress())
+	}
+
+	if archiveOnDeletePath := amlFilesystem.Tags[archiveOnDeletePathTag]; archiveOnDeletePath != "" {
+		klog.V(2).Infof("archiving orphaned AMLFS cluster %s in resource group %s to %s before deletion",
+			amlFilesystem.Name, amlFilesystem.ResourceGroupName, archiveOnDeletePath)
+		if err := d.dynamicProvisioner.ArchiveAmlFilesystem(ctx, amlFilesystem.ResourceGroupName, amlFilesystem.Name, archiveOnDeletePath); err != nil {
+			return err
+		}
+	}
+
+	klog.Warningf("deleting orphaned AMLFS cluster %s in resource group %s, PV %s no longer exists",
+		amlFilesystem.Name, amlFilesystem.ResourceGroupName, amlFilesystem.Tags[pvNameTag])
+	return d.dynamicProvisioner.DeleteAmlFilesystem(ctx, amlFilesystem.ResourceGroupName, amlFilesystem.Name)
+}
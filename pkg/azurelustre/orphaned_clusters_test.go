@@ -0,0 +1,284 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const orphanedTestResourceGroup = "fake-resource-group"
+
+func newOrphanedTestFilesystem(amlFilesystemName string, tags map[string]string) *AmlFilesystemProperties {
+	return &AmlFilesystemProperties{
+		ResourceGroupName: orphanedTestResourceGroup,
+		AmlFilesystemName: amlFilesystemName,
+		Tags:              tags,
+	}
+}
+
+func getOrphanedTestKey(amlFilesystemName string) string {
+	return orphanedTestResourceGroup + "/" + amlFilesystemName
+}
+
+func getFilesystemNames(filesystems []*AmlFilesystemProperties) []string {
+	names := make([]string, 0, len(filesystems))
+	for _, filesystem := range filesystems {
+		names = append(names, filesystem.AmlFilesystemName)
+	}
+	return names
+}
+
+func TestCollectOrphanedAmlFilesystems_Report(t *testing.T) {
+	now := time.Now()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem("orphaned", map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pv-deleted"}),
+		newOrphanedTestFilesystem("bound", map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pv-exists"}),
+		newOrphanedTestFilesystem("no-pv-tag", map[string]string{createdByTag: azureLustreDriverTag}),
+		newOrphanedTestFilesystem("other-creator", map[string]string{createdByTag: "someone-else", pvNameTag: "pv-deleted"}),
+		newOrphanedTestFilesystem("no-tags", nil),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner),
+		withFakeKubeClient(&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-exists"}}))
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now)
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(48*time.Hour))
+
+	assert.Equal(t, map[string]time.Time{getOrphanedTestKey("orphaned"): now}, d.orphanedAmlFilesystems)
+	assert.Equal(t, map[string]int{"ListAmlFilesystems": 2}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Len(t, fakeDynamicProvisioner.Filesystems, 5)
+}
+
+func TestCollectOrphanedAmlFilesystems_DeleteAfterGracePeriod(t *testing.T) {
+	now := time.Now()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem("orphaned", map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pv-deleted"}),
+		newOrphanedTestFilesystem("bound", map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pv-exists"}),
+		newOrphanedTestFilesystem("no-pv-tag", map[string]string{createdByTag: azureLustreDriverTag}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner),
+		withFakeKubeClient(&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-exists"}}))
+	d.deleteOrphanedAmlFilesystems = true
+	d.orphanedAmlFilesystemGracePeriod = time.Hour
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now)
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(59*time.Minute))
+	assert.Equal(t, []string{"orphaned", "bound", "no-pv-tag"}, getFilesystemNames(fakeDynamicProvisioner.Filesystems))
+	assert.Equal(t, map[string]time.Time{getOrphanedTestKey("orphaned"): now}, d.orphanedAmlFilesystems)
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(time.Hour))
+	assert.Equal(t, []string{"bound", "no-pv-tag"}, getFilesystemNames(fakeDynamicProvisioner.Filesystems))
+	assert.Empty(t, d.orphanedAmlFilesystems)
+	assert.Equal(t, map[string]int{"ListAmlFilesystems": 3, "DeleteAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestCollectOrphanedAmlFilesystems_ArchivesBeforeDelete(t *testing.T) {
+	now := time.Now()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem("orphaned", map[string]string{
+			createdByTag:           azureLustreDriverTag,
+			pvNameTag:              "pv-deleted",
+			archiveOnDeletePathTag: "/",
+		}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	d.deleteOrphanedAmlFilesystems = true
+	d.orphanedAmlFilesystemGracePeriod = time.Hour
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now)
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(time.Hour))
+	assert.Empty(t, fakeDynamicProvisioner.Filesystems)
+	assert.Equal(t, map[string]int{"ListAmlFilesystems": 2, "ArchiveAmlFilesystem": 1, "DeleteAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestCollectOrphanedAmlFilesystems_Err_Archive(t *testing.T) {
+	now := time.Now()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem(archiveRequestFailureName, map[string]string{
+			createdByTag:           azureLustreDriverTag,
+			pvNameTag:              "pv-deleted",
+			archiveOnDeletePathTag: "/",
+		}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	d.deleteOrphanedAmlFilesystems = true
+	d.orphanedAmlFilesystemGracePeriod = time.Hour
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now)
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(time.Hour))
+	assert.Len(t, fakeDynamicProvisioner.Filesystems, 1)
+	assert.Equal(t, map[string]time.Time{getOrphanedTestKey(archiveRequestFailureName): now}, d.orphanedAmlFilesystems)
+	assert.Equal(t, map[string]int{"ListAmlFilesystems": 2, "ArchiveAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestCollectOrphanedAmlFilesystems_SkipsWhenOperationsAtLimit(t *testing.T) {
+	now := time.Now()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem("orphaned", map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pv-deleted"}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	d.deleteOrphanedAmlFilesystems = true
+	d.orphanedAmlFilesystemGracePeriod = time.Hour
+	d.amlFilesystemOperations = newAmlFilesystemOperationQueue(1)
+	otherOperationKey := deleteOperationKeyPrefix + "fake-resource-group/other"
+	assert.Zero(t, d.amlFilesystemOperations.tryAcquire(otherOperationKey))
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now)
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(time.Hour))
+	assert.Len(t, fakeDynamicProvisioner.Filesystems, 1)
+	assert.Equal(t, map[string]time.Time{getOrphanedTestKey("orphaned"): now}, d.orphanedAmlFilesystems)
+
+	// The skipped deletion does not hold a place in the queue
+	d.amlFilesystemOperations.release(otherOperationKey)
+	assert.Zero(t, d.amlFilesystemOperations.tryAcquire(otherOperationKey))
+	d.amlFilesystemOperations.release(otherOperationKey)
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(2*time.Hour))
+	assert.Empty(t, fakeDynamicProvisioner.Filesystems)
+	assert.Equal(t, map[string]int{"ListAmlFilesystems": 3, "DeleteAmlFilesystem": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
+
+func TestCollectOrphanedAmlFilesystems_ResetsWhenPVExists(t *testing.T) {
+	now := time.Now()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem("orphaned", map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pv-restored"}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now)
+	assert.Len(t, d.orphanedAmlFilesystems, 1)
+
+	_, err := d.kubeClient.CoreV1().PersistentVolumes().Create(context.Background(),
+		&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-restored"}}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(time.Minute))
+	assert.Empty(t, d.orphanedAmlFilesystems)
+}
+
+func TestCollectOrphanedAmlFilesystems_SkipsCreationInProgress(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem("creating", map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pv-not-created-yet"}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	d.createOperations.add(getOrphanedTestKey("creating"))
+
+	d.collectOrphanedAmlFilesystems(context.Background(), time.Now())
+	assert.Empty(t, d.orphanedAmlFilesystems)
+}
+
+func TestCollectOrphanedAmlFilesystems_SkipsPersistedCreationInProgress(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem("creating", map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pv-not-created-yet"}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	// The creation was started by the other controller replica
+	require.NoError(t, d.saveCreateOperation(context.Background(), &amlFilesystemCreateOperation{
+		ResourceGroupName: orphanedTestResourceGroup,
+		AmlFilesystemName: "creating",
+		State:             createOperationStateInProgress,
+	}))
+
+	d.collectOrphanedAmlFilesystems(context.Background(), time.Now())
+	assert.Empty(t, d.orphanedAmlFilesystems)
+}
+
+func TestCollectOrphanedAmlFilesystems_SkipsUnboundPVC(t *testing.T) {
+	now := time.Now()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem("restoring", map[string]string{
+			createdByTag:    azureLustreDriverTag,
+			pvNameTag:       "pv-not-created-yet",
+			pvcNameTag:      "pvc-pending",
+			pvcNamespaceTag: "default",
+		}),
+		newOrphanedTestFilesystem("rebound", map[string]string{
+			createdByTag:    azureLustreDriverTag,
+			pvNameTag:       "pv-deleted",
+			pvcNameTag:      "pvc-bound",
+			pvcNamespaceTag: "default",
+		}),
+		newOrphanedTestFilesystem("pvc-deleted", map[string]string{
+			createdByTag:    azureLustreDriverTag,
+			pvNameTag:       "pv-deleted",
+			pvcNameTag:      "pvc-deleted",
+			pvcNamespaceTag: "default",
+		}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient(
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc-pending", Namespace: "default"}},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-bound", Namespace: "default"},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-other"},
+		},
+	))
+	d.deleteOrphanedAmlFilesystems = true
+	d.orphanedAmlFilesystemGracePeriod = time.Hour
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now)
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(2*time.Hour))
+	assert.Equal(t, []string{"restoring"}, getFilesystemNames(fakeDynamicProvisioner.Filesystems))
+	assert.Empty(t, d.orphanedAmlFilesystems)
+}
+
+func TestCollectOrphanedAmlFilesystems_DeletesCompletedCreation(t *testing.T) {
+	now := time.Now()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem("created", map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pvc-deleted-during-creation"}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	d.deleteOrphanedAmlFilesystems = true
+	d.orphanedAmlFilesystemGracePeriod = time.Hour
+	operation, _ := d.createOperations.add(getOrphanedTestKey("created"))
+	close(operation.done)
+	amlFilesystemProperties := newOrphanedTestFilesystem("created", nil)
+	require.NoError(t, d.saveCreateOperation(context.Background(), &amlFilesystemCreateOperation{
+		ResourceGroupName: orphanedTestResourceGroup,
+		AmlFilesystemName: "created",
+		State:             createOperationStateSucceeded,
+		MgsIPAddress:      "127.0.0.2",
+	}))
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now)
+	assert.Equal(t, map[string]time.Time{getOrphanedTestKey("created"): now}, d.orphanedAmlFilesystems)
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(2*time.Hour))
+	assert.Empty(t, fakeDynamicProvisioner.Filesystems)
+	assert.Nil(t, d.createOperations.get(getOrphanedTestKey("created")))
+	persistedOperation, err := d.getCreateOperation(context.Background(), amlFilesystemProperties)
+	require.NoError(t, err)
+	assert.Nil(t, persistedOperation)
+}
+
+func TestCollectOrphanedAmlFilesystems_Err_List(t *testing.T) {
+	now := time.Now()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{Filesystems: []*AmlFilesystemProperties{
+		newOrphanedTestFilesystem(clusterRequestFailureName, map[string]string{createdByTag: azureLustreDriverTag, pvNameTag: "pv-deleted"}),
+	}}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	d.orphanedAmlFilesystems[getOrphanedTestKey("orphaned")] = now
+
+	d.collectOrphanedAmlFilesystems(context.Background(), now.Add(time.Hour))
+	assert.Equal(t, map[string]time.Time{getOrphanedTestKey("orphaned"): now}, d.orphanedAmlFilesystems)
+	assert.Equal(t, map[string]int{"ListAmlFilesystems": 1}, fakeDynamicProvisioner.fakeCallCount)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"maps"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/azurelustre-csi-driver/pkg/util"
+)
+
+// VolumePlan is the AMLFS cluster that CreateVolume would create for a
+// StorageClass
+type VolumePlan struct {
+	AmlFilesystemName  string            `json:"amlFilesystemName"`
+	ResourceGroupName  string            `json:"resourceGroupName"`
+	Location           string            `json:"location"`
+	Zone               string            `json:"zone,omitempty"`
+	SKU                VolumePlanSKU     `json:"sku"`
+	CapacityBytes      int64             `json:"capacityBytes"`
+	StorageCapacityTiB float32           `json:"storageCapacityTiB"`
+	Subnet             VolumePlanSubnet  `json:"subnet"`
+	Tags               map[string]string `json:"tags"`
+}
+
+type VolumePlanSKU struct {
+	Name           string   `json:"name"`
+	IncrementTiB   int64    `json:"incrementTiB"`
+	MaximumTiB     int64    `json:"maximumTiB"`
+	AvailableZones []string `json:"availableZones,omitempty"`
+}
+
+type VolumePlanSubnet struct {
+	VnetResourceGroup string `json:"vnetResourceGroup"`
+	VnetName          string `json:"vnetName"`
+	SubnetName        string `json:"subnetName"`
+	SubnetID          string `json:"subnetID"`
+	// HasSufficientCapacity is false if the subnet, or none of the candidate
+	// subnets, has enough free IP addresses for the cluster
+	HasSufficientCapacity bool `json:"hasSufficientCapacity"`
+}
+
+// PlanVolume resolves the AMLFS cluster that CreateVolume would create for a
+// volume with the given name, capacity and StorageClass parameters, without
+// creating it. The provisioner secret of the StorageClass is read to plan the
+// cluster in its subscription, as csi-provisioner would pass it
+func (d *Driver) PlanVolume(ctx context.Context, volName string, capacityInBytes int64, parameters map[string]string) (*VolumePlan, error) {
+	if util.GetValueInMap(parameters, VolumeContextMGSIPAddress) != "" || util.GetValueInMap(parameters, VolumeContextAmlfsName) != "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"a plan can only be made for dynamically provisioned AMLFS clusters, remove parameters %s and %s",
+			VolumeContextMGSIPAddress, VolumeContextAmlfsName)
+	}
+	if !isValidVolumeName(volName) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"invalid volume name %s, cannot create valid AMLFS name. Check length and characters", volName)
+	}
+
+	secrets, err := d.getStorageClassProvisionerSecrets(ctx, parameters)
+	if err != nil {
+		return nil, err
+	}
+	dynamicProvisioner, credential, err := d.getDynamicProvisioner(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	// csi-provisioner removes its parameters before calling CreateVolume
+	parameters = maps.Clone(parameters)
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+	maps.DeleteFunc(parameters, func(key, _ string) bool {
+		return strings.HasPrefix(strings.ToLower(key), csiParameterPrefix)
+	})
+	if util.GetValueInMap(parameters, pvNameKey) == "" {
+		util.SetKeyValueInMap(parameters, pvNameKey, volName)
+	}
+
+	amlFilesystemProperties, err := parseAmlFilesystemProperties(parameters)
+	if err != nil {
+		return nil, err
+	}
+	amlFilesystemProperties.AmlFilesystemName = volName
+	if len(amlFilesystemProperties.Location) == 0 {
+		amlFilesystemProperties.Location = d.location
+	}
+	if len(amlFilesystemProperties.ResourceGroupName) == 0 {
+		amlFilesystemProperties.ResourceGroupName = d.resourceGroup
+	}
+	amlFilesystemProperties.SubnetInfo = d.populateSubnetProperties(amlFilesystemProperties.SubnetInfo, credential)
+
+	lustreSkuValue, err := d.getSkuValuesForLocation(ctx, dynamicProvisioner, amlFilesystemProperties.SKUName, amlFilesystemProperties.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	capacityInBytes, err = d.roundToAmlfsBlockSize(capacityInBytes, lustreSkuValue.IncrementInTib*util.TiB, lustreSkuValue.MaximumInTib*util.TiB)
+	if err != nil {
+		return nil, err
+	}
+	amlFilesystemProperties.StorageCapacityTiB = float32(capacityInBytes) / util.TiB
+
+	if err := selectZone(amlFilesystemProperties, lustreSkuValue.AvailableZones, nil); err != nil {
+		return nil, err
+	}
+
+	hasSufficientCapacity := true
+	if len(amlFilesystemProperties.SubnetCandidates) > 0 || amlFilesystemProperties.SubnetNamePrefix != "" {
+		subnetInfo, err := dynamicProvisioner.SelectSubnet(ctx, amlFilesystemProperties)
+		switch {
+		case status.Code(err) == codes.ResourceExhausted:
+			klog.V(2).Info(err)
+			hasSufficientCapacity = false
+		case err != nil:
+			return nil, err
+		default:
+			amlFilesystemProperties.SubnetInfo = subnetInfo
+		}
+	} else {
+		hasSufficientCapacity, err = dynamicProvisioner.CheckSubnetCapacity(ctx, amlFilesystemProperties.SubnetInfo,
+			amlFilesystemProperties.SKUName, amlFilesystemProperties.StorageCapacityTiB)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	subnetInfo := amlFilesystemProperties.SubnetInfo
+	return &VolumePlan{
+		AmlFilesystemName: amlFilesystemProperties.AmlFilesystemName,
+		ResourceGroupName: amlFilesystemProperties.ResourceGroupName,
+		Location:          amlFilesystemProperties.Location,
+		Zone:              amlFilesystemProperties.Zone,
+		SKU: VolumePlanSKU{
+			Name:           amlFilesystemProperties.SKUName,
+			IncrementTiB:   lustreSkuValue.IncrementInTib,
+			MaximumTiB:     lustreSkuValue.MaximumInTib,
+			AvailableZones: lustreSkuValue.AvailableZones,
+		},
+		CapacityBytes:      capacityInBytes,
+		StorageCapacityTiB: amlFilesystemProperties.StorageCapacityTiB,
+		Subnet: VolumePlanSubnet{
+			VnetResourceGroup:     subnetInfo.VnetResourceGroup,
+			VnetName:              subnetInfo.VnetName,
+			SubnetName:            subnetInfo.SubnetName,
+			SubnetID:              subnetInfo.SubnetID,
+			HasSufficientCapacity: hasSufficientCapacity,
+		},
+		Tags: amlFilesystemProperties.Tags,
+	}, nil
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/azurelustre-csi-driver/pkg/util"
+)
+
+func TestPlanVolume_Success(t *testing.T) {
+	d := NewFakeDriver()
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d.dynamicProvisioner = fakeDynamicProvisioner
+	parameters := buildDynamicProvCreateVolumeRequest().Parameters
+
+	volumePlan, err := d.PlanVolume(context.Background(), "pvc-plan", 20*util.TiB, parameters)
+	require.NoError(t, err)
+	assert.Equal(t, &VolumePlan{
+		AmlFilesystemName: "pvc-plan",
+		ResourceGroupName: "test-resource-group",
+		Location:          "test-location",
+		Zone:              "zone1",
+		SKU: VolumePlanSKU{
+			Name:           "AMLFS-Durable-Premium-250",
+			IncrementTiB:   8,
+			MaximumTiB:     128,
+			AvailableZones: []string{"zone1", "zone2", "zone3"},
+		},
+		CapacityBytes:      int64(24 * util.TiB),
+		StorageCapacityTiB: 24,
+		Subnet: VolumePlanSubnet{
+			VnetResourceGroup:     "test-vnet-rg",
+			VnetName:              "test-vnet-name",
+			SubnetName:            "test-subnet-name",
+			SubnetID:              fmt.Sprintf(subnetTemplate, "defaultFakeSubID", "test-vnet-rg", "test-vnet-name", "test-subnet-name"),
+			HasSufficientCapacity: true,
+		},
+		Tags: map[string]string{
+			createdByTag: azureLustreDriverTag,
+			pvNameTag:    "pvc-plan",
+			subDirTag:    "testSubDir",
+			"key1":       "value1",
+			"key2":       "value2",
+		},
+	}, volumePlan)
+	assert.Equal(t, map[string]int{"GetSkuValuesForLocation": 1, "CheckSubnetCapacity": 1}, fakeDynamicProvisioner.fakeCallCount)
+	assert.Equal(t, "pv_name", parameters[pvNameKey])
+}
+
+func TestPlanVolume_Success_SubnetCapacity(t *testing.T) {
+	testCases := []struct {
+		desc                          string
+		subnetName                    string
+		expectedSubnetName            string
+		expectedHasSufficientCapacity bool
+	}{
+		{
+			desc:                          "Full subnet",
+			subnetName:                    fullSubnetName,
+			expectedSubnetName:            fullSubnetName,
+			expectedHasSufficientCapacity: false,
+		},
+		{
+			desc:                          "Selected candidate",
+			subnetName:                    fullSubnetName + ",test-subnet-2",
+			expectedSubnetName:            "test-subnet-2",
+			expectedHasSufficientCapacity: true,
+		},
+		{
+			desc:                          "No candidate with room",
+			subnetName:                    fullSubnetName + ",other" + fullSubnetName,
+			expectedSubnetName:            fullSubnetName,
+			expectedHasSufficientCapacity: false,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			parameters := buildDynamicProvCreateVolumeRequest().Parameters
+			parameters["subnet-name"] = tC.subnetName
+
+			volumePlan, err := d.PlanVolume(context.Background(), "pvc-plan", 0, parameters)
+			require.NoError(t, err)
+			assert.Equal(t, tC.expectedSubnetName, volumePlan.Subnet.SubnetName)
+			assert.Equal(t, tC.expectedHasSufficientCapacity, volumePlan.Subnet.HasSufficientCapacity)
+			assert.Equal(t, int64(8*util.TiB), volumePlan.CapacityBytes)
+		})
+	}
+}
+
+func TestPlanVolume_Err(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		volumeName    string
+		parameters    map[string]string
+		capacity      int64
+		expectedCode  codes.Code
+		expectedError string
+	}{
+		{
+			desc:          "Existing cluster",
+			parameters:    map[string]string{"mgs-ip-address": "127.0.0.1"},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "a plan can only be made for dynamically provisioned AMLFS clusters",
+		},
+		{
+			desc:          "Invalid volume name",
+			volumeName:    "-invalid",
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "invalid volume name -invalid",
+		},
+		{
+			desc:          "Invalid SKU",
+			parameters:    map[string]string{"sku-name": "bad-sku"},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "sku-name must be one of",
+		},
+		{
+			desc:          "Capacity over SKU maximum",
+			capacity:      512 * util.TiB,
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "exceeds maximum capacity",
+		},
+		{
+			desc:          "Zone not available",
+			parameters:    map[string]string{"zone": "zone4"},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "zone zone4 must be one of",
+		},
+		{
+			desc:          "Subnet capacity error",
+			parameters:    map[string]string{"subnet-name": clusterRequestFailureName},
+			expectedCode:  codes.Unavailable,
+			expectedError: "error occurred calling API",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			parameters := buildDynamicProvCreateVolumeRequest().Parameters
+			for key, value := range tC.parameters {
+				parameters[key] = value
+			}
+			volumeName := tC.volumeName
+			if volumeName == "" {
+				volumeName = "pvc-plan"
+			}
+
+			_, err := d.PlanVolume(context.Background(), volumeName, tC.capacity, parameters)
+			require.Error(t, err)
+			assert.Equal(t, tC.expectedCode, status.Code(err))
+			require.ErrorContains(t, err, tC.expectedError)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	lfsCommand = "lfs"
+	// Every 16 KiB of requested capacity allows one more file or directory
+	// in the sub-dir, so that small volumes cannot exhaust the MDT inodes
+	subDirQuotaBytesPerInode = 16 * 1024
+	minSubDirQuotaInodes     = 1024
+	// maxProjectIDProbes is the number of project IDs tried from the ID
+	// derived from the volume name before giving up on finding a free one
+	maxProjectIDProbes = 16
+)
+
+type projectQuota struct {
+	projectID     uint32
+	blockLimitKiB int64
+	inodeLimit    int64
+}
+
+type projectQuotaUsage struct {
+	usedKiB       int64
+	blockLimitKiB int64
+	usedInodes    int64
+	inodeLimit    int64
+}
+
+func newProjectQuota(volName string, capacityInBytes int64) *projectQuota {
+	return &projectQuota{
+		projectID:     getProjectID(volName),
+		blockLimitKiB: (capacityInBytes + 1023) / 1024,
+		inodeLimit:    max(capacityInBytes/subDirQuotaBytesPerInode, minSubDirQuotaInodes),
+	}
+}
+
+// getProjectID derives the Lustre project ID from the volume name, so that a
+// retried CreateVolume tries the same IDs. Project 0 is used by every file
+// that has not been assigned a project, so it is never returned
+func getProjectID(volName string) uint32 {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(volName))
+	return nextProjectID(hash.Sum32() - 1)
+}
+
+func nextProjectID(projectID uint32) uint32 {
+	projectID++
+	if projectID == 0 {
+		projectID = 1
+	}
+	return projectID
+}
+
+func (quotaUsage *projectQuotaUsage) isExceeded() bool {
+	return (quotaUsage.blockLimitKiB > 0 && quotaUsage.usedKiB >= quotaUsage.blockLimitKiB) ||
+		(quotaUsage.inodeLimit > 0 && quotaUsage.usedInodes >= quotaUsage.inodeLimit)
+}
+
+// isUnused returns whether no file is accounted to the project and it has no
+// limits, so that it can be assigned to a new sub-dir
+func (quotaUsage *projectQuotaUsage) isUnused() bool {
+	return quotaUsage.usedKiB == 0 && quotaUsage.blockLimitKiB == 0 &&
+		quotaUsage.usedInodes == 0 && quotaUsage.inodeLimit == 0
+}
+
+func (d *Driver) runLfsCommand(args ...string) (string, error) {
+	klog.V(4).Infof("running %s %s", lfsCommand, strings.Join(args, " "))
+	output, err := d.mounter.Exec.Command(lfsCommand, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %w, output: %q", lfsCommand, strings.Join(args, " "), err, string(output))
+	}
+	return string(output), nil
+}
+
+// setProjectQuota assigns a free project to the sub-dir, which is inherited by
+// everything created in it, and limits the blocks and inodes of the project.
+// The project ID of quota is updated to the one assigned
+func (d *Driver) setProjectQuota(mountPath, subDirPath string, quota *projectQuota) error {
+	d.projectQuotaLock.Lock()
+	defer d.projectQuotaLock.Unlock()
+
+	freeProjectID, err := d.getFreeProjectID(mountPath, subDirPath, quota.projectID)
+	if err != nil {
+		return err
+	}
+	quota.projectID = freeProjectID
+	projectID := strconv.FormatUint(uint64(quota.projectID), 10)
+
+	klog.V(2).Infof("assigning project %s to %q", projectID, subDirPath)
+	if _, err := d.runLfsCommand("project", "-p", projectID, "-s", "-r", subDirPath); err != nil {
+		return status.Errorf(codes.Internal, "failed to assign project %s to sub-dir: %v", projectID, err)
+	}
+
+	klog.V(2).Infof("setting quota of project %s to %d KiB and %d inodes", projectID, quota.blockLimitKiB, quota.inodeLimit)
+	_, err = d.runLfsCommand("setquota", "-p", projectID,
+		"-B", strconv.FormatInt(quota.blockLimitKiB, 10),
+		"-I", strconv.FormatInt(quota.inodeLimit, 10),
+		mountPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to set quota of project %s, project quotas must be enabled on the Lustre filesystem: %v", projectID, err)
+	}
+
+	return nil
+}
+
+// getFreeProjectID returns the first project ID from projectID that is not
+// used by another sub-dir, as the IDs derived from the volume names of
+// different volumes may be the same. If the sub-dir already has one of these
+// projects, it was assigned by an earlier attempt and is kept
+func (d *Driver) getFreeProjectID(mountPath, subDirPath string, projectID uint32) (uint32, error) {
+	subDirProjectID, err := d.getSubDirProjectID(subDirPath)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to get project of sub-dir: %v", err)
+	}
+
+	candidate := projectID
+	for range maxProjectIDProbes {
+		if candidate == subDirProjectID {
+			return candidate, nil
+		}
+		quotaUsage, err := d.getProjectQuotaUsage(mountPath, candidate)
+		if err != nil {
+			return 0, status.Errorf(codes.Internal, "failed to get quota of project %d, project quotas must be enabled on the Lustre filesystem: %v", candidate, err)
+		}
+		if quotaUsage.isUnused() {
+			return candidate, nil
+		}
+		klog.Warningf("project %d is already in use, trying the next project ID", candidate)
+		candidate = nextProjectID(candidate)
+	}
+
+	return 0, status.Errorf(codes.Internal, "failed to find a free project ID, projects %d to %d are in use", projectID, candidate-1)
+}
+
+// getSubDirProjectID parses the output of "lfs project -d", which is the
+// project ID followed by the inherit flag and the path
+func (d *Driver) getSubDirProjectID(subDirPath string) (uint32, error) {
+	output, err := d.runLfsCommand("project", "-d", subDirPath)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("could not parse project from %q", output)
+	}
+	projectID, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse project from %q: %w", output, err)
+	}
+	return uint32(projectID), nil
+}
+
+func (d *Driver) clearProjectQuota(mountPath string, projectID uint32) error {
+	projectIDString := strconv.FormatUint(uint64(projectID), 10)
+
+	klog.V(2).Infof("clearing quota of project %s", projectIDString)
+	if _, err := d.runLfsCommand("setquota", "-p", projectIDString, "-B", "0", "-I", "0", mountPath); err != nil {
+		return status.Errorf(codes.Internal, "failed to clear quota of project %s: %v", projectIDString, err)
+	}
+
+	return nil
+}
+
+func (d *Driver) getProjectQuotaUsage(path string, projectID uint32) (*projectQuotaUsage, error) {
+	output, err := d.runLfsCommand("quota", "-q", "-p", strconv.FormatUint(uint64(projectID), 10), path)
+	if err != nil {
+		return nil, err
+	}
+	return parseProjectQuotaUsage(output)
+}
+
+// parseProjectQuotaUsage parses the output of "lfs quota -q", which is the
+// filesystem followed by the used, soft limit, hard limit and grace columns
+// for blocks and then for inodes. Long filesystem paths are printed on their
+// own line, so only the last columns are used. Exceeded limits are marked
+// with a '*' after the used value
+func parseProjectQuotaUsage(output string) (*projectQuotaUsage, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("could not parse project quota from %q", output)
+	}
+	fields = fields[len(fields)-8:]
+
+	values := make([]int64, 0, 4)
+	for _, field := range []string{fields[0], fields[2], fields[4], fields[6]} {
+		value, err := strconv.ParseInt(strings.TrimSuffix(field, "*"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse project quota from %q: %w", output, err)
+		}
+		values = append(values, value)
+	}
+
+	return &projectQuotaUsage{
+		usedKiB:       values[0],
+		blockLimitKiB: values[1],
+		usedInodes:    values[2],
+		inodeLimit:    values[3],
+	}, nil
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+	"sigs.k8s.io/azurelustre-csi-driver/pkg/util"
+)
+
+type fakeLfsCommand struct {
+	output string
+	err    error
+}
+
+// addFakeLfsCommands fakes the results of the next commands run by the driver
+// and returns the command lines that were run
+func addFakeLfsCommands(t *testing.T, d *Driver, commands ...fakeLfsCommand) *[]string {
+	t.Helper()
+	fakeExec, ok := d.mounter.Exec.(*testingexec.FakeExec)
+	require.True(t, ok, "Exec should be a FakeExec")
+
+	var commandLines []string
+	for _, command := range commands {
+		fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) utilexec.Cmd {
+			commandLines = append(commandLines, strings.Join(append([]string{cmd}, args...), " "))
+			fakeCmd := &testingexec.FakeCmd{
+				CombinedOutputScript: []testingexec.FakeAction{
+					func() ([]byte, []byte, error) { return []byte(command.output), nil, command.err },
+				},
+			}
+			return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+		})
+	}
+	return &commandLines
+}
+
+// withFreeProject fakes the results of the commands that find the sub-dir
+// without a project and the first project ID tried unused, followed by
+// commands
+func withFreeProject(commands ...fakeLfsCommand) []fakeLfsCommand {
+	return append([]fakeLfsCommand{
+		{output: "0 - /mnt/lustre/sub-dir\n"},
+		{output: "/mnt/lustre 0 0 0 - 0 0 0 -\n"},
+	}, commands...)
+}
+
+func TestNewProjectQuota(t *testing.T) {
+	cases := []struct {
+		desc            string
+		capacityInBytes int64
+		expectedQuota   *projectQuota
+	}{
+		{
+			desc:            "limits derived from capacity",
+			capacityInBytes: util.GiB,
+			expectedQuota: &projectQuota{
+				projectID:     1498690128,
+				blockLimitKiB: 1024 * 1024,
+				inodeLimit:    64 * 1024,
+			},
+		},
+		{
+			desc:            "block limit rounded up to KiB",
+			capacityInBytes: 100*1024 + 1,
+			expectedQuota: &projectQuota{
+				projectID:     1498690128,
+				blockLimitKiB: 101,
+				inodeLimit:    minSubDirQuotaInodes,
+			},
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expectedQuota, newProjectQuota("test_volume", test.capacityInBytes))
+		})
+	}
+}
+
+func TestGetProjectID(t *testing.T) {
+	assert.Equal(t, getProjectID("test_volume"), getProjectID("test_volume"))
+	assert.NotEqual(t, getProjectID("test_volume"), getProjectID("test_volume_2"))
+	assert.NotZero(t, getProjectID(""))
+}
+
+func TestParseProjectQuotaUsage(t *testing.T) {
+	cases := []struct {
+		desc          string
+		output        string
+		expectedUsage *projectQuotaUsage
+		expectedError string
+	}{
+		{
+			desc:   "within limits",
+			output: "/mnt/lustre 512 0 1024 - 10 0 100 -\n",
+			expectedUsage: &projectQuotaUsage{
+				usedKiB:       512,
+				blockLimitKiB: 1024,
+				usedInodes:    10,
+				inodeLimit:    100,
+			},
+		},
+		{
+			desc:   "exceeded with long filesystem on its own line",
+			output: "/mnt/a/very/long/path/to/the/lustre/filesystem\n 1028* 0 1024 - 10 0 100 -\n",
+			expectedUsage: &projectQuotaUsage{
+				usedKiB:       1028,
+				blockLimitKiB: 1024,
+				usedInodes:    10,
+				inodeLimit:    100,
+			},
+		},
+		{
+			desc:          "missing columns",
+			output:        "/mnt/lustre 512 0 1024 -\n",
+			expectedError: "could not parse project quota",
+		},
+		{
+			desc:          "invalid value",
+			output:        "/mnt/lustre 512 0 none - 10 0 100 -\n",
+			expectedError: "could not parse project quota",
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.desc, func(t *testing.T) {
+			quotaUsage, err := parseProjectQuotaUsage(test.output)
+			if test.expectedError != "" {
+				require.ErrorContains(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedUsage, quotaUsage)
+		})
+	}
+}
+
+func TestProjectQuotaUsageIsExceeded(t *testing.T) {
+	assert.False(t, (&projectQuotaUsage{usedKiB: 10, blockLimitKiB: 20, usedInodes: 1, inodeLimit: 2}).isExceeded())
+	assert.True(t, (&projectQuotaUsage{usedKiB: 20, blockLimitKiB: 20, usedInodes: 1, inodeLimit: 2}).isExceeded())
+	assert.True(t, (&projectQuotaUsage{usedKiB: 10, blockLimitKiB: 20, usedInodes: 2, inodeLimit: 2}).isExceeded())
+	assert.False(t, (&projectQuotaUsage{usedKiB: 10, usedInodes: 2}).isExceeded())
+}
+
+func TestSetProjectQuota(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	commandLines := addFakeLfsCommands(t, d, withFreeProject(fakeLfsCommand{}, fakeLfsCommand{})...)
+
+	quota := &projectQuota{projectID: 42, blockLimitKiB: 1024, inodeLimit: 64}
+	err := d.setProjectQuota("/mnt/lustre", "/mnt/lustre/sub-dir", quota)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(42), quota.projectID)
+	assert.Equal(t, []string{
+		"lfs project -d /mnt/lustre/sub-dir",
+		"lfs quota -q -p 42 /mnt/lustre",
+		"lfs project -p 42 -s -r /mnt/lustre/sub-dir",
+		"lfs setquota -p 42 -B 1024 -I 64 /mnt/lustre",
+	}, *commandLines)
+}
+
+func TestSetProjectQuota_ProjectInUse(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	commandLines := addFakeLfsCommands(t, d,
+		fakeLfsCommand{output: "0 - /mnt/lustre/sub-dir\n"},
+		fakeLfsCommand{output: "/mnt/lustre 4 0 1024 - 1 0 64 -\n"},
+		fakeLfsCommand{output: "/mnt/lustre 0 0 0 - 0 0 0 -\n"},
+		fakeLfsCommand{},
+		fakeLfsCommand{},
+	)
+
+	quota := &projectQuota{projectID: 42, blockLimitKiB: 1024, inodeLimit: 64}
+	err := d.setProjectQuota("/mnt/lustre", "/mnt/lustre/sub-dir", quota)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(43), quota.projectID)
+	assert.Equal(t, []string{
+		"lfs project -d /mnt/lustre/sub-dir",
+		"lfs quota -q -p 42 /mnt/lustre",
+		"lfs quota -q -p 43 /mnt/lustre",
+		"lfs project -p 43 -s -r /mnt/lustre/sub-dir",
+		"lfs setquota -p 43 -B 1024 -I 64 /mnt/lustre",
+	}, *commandLines)
+}
+
+func TestSetProjectQuota_KeepsProjectOfEarlierAttempt(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	commandLines := addFakeLfsCommands(t, d,
+		fakeLfsCommand{output: "43 P /mnt/lustre/sub-dir\n"},
+		fakeLfsCommand{output: "/mnt/lustre 4 0 1024 - 1 0 64 -\n"},
+		fakeLfsCommand{},
+		fakeLfsCommand{},
+	)
+
+	quota := &projectQuota{projectID: 42, blockLimitKiB: 1024, inodeLimit: 64}
+	err := d.setProjectQuota("/mnt/lustre", "/mnt/lustre/sub-dir", quota)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(43), quota.projectID)
+	assert.Equal(t, []string{
+		"lfs project -d /mnt/lustre/sub-dir",
+		"lfs quota -q -p 42 /mnt/lustre",
+		"lfs project -p 43 -s -r /mnt/lustre/sub-dir",
+		"lfs setquota -p 43 -B 1024 -I 64 /mnt/lustre",
+	}, *commandLines)
+}
+
+func TestSetProjectQuota_Err_NoFreeProject(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	commands := []fakeLfsCommand{{output: "0 - /mnt/lustre/sub-dir\n"}}
+	for range maxProjectIDProbes {
+		commands = append(commands, fakeLfsCommand{output: "/mnt/lustre 4 0 0 - 1 0 0 -\n"})
+	}
+	addFakeLfsCommands(t, d, commands...)
+
+	err := d.setProjectQuota("/mnt/lustre", "/mnt/lustre/sub-dir", &projectQuota{projectID: 42, blockLimitKiB: 1024, inodeLimit: 64})
+	require.Error(t, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.ErrorContains(t, err, "failed to find a free project ID, projects 42 to 57 are in use")
+}
+
+func TestSetProjectQuota_Err(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	addFakeLfsCommands(t, d, withFreeProject(fakeLfsCommand{}, fakeLfsCommand{output: "quota not enabled", err: errors.New("exit status 1")})...)
+
+	err := d.setProjectQuota("/mnt/lustre", "/mnt/lustre/sub-dir", &projectQuota{projectID: 42, blockLimitKiB: 1024, inodeLimit: 64})
+	require.Error(t, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.ErrorContains(t, err, "project quotas must be enabled")
+	assert.ErrorContains(t, err, "quota not enabled")
+}
+
+func TestClearProjectQuota(t *testing.T) {
+	d := NewFakeDriver()
+	setSubDirProvisioningFakeMounter(t, d)
+	commandLines := addFakeLfsCommands(t, d, fakeLfsCommand{})
+
+	require.NoError(t, d.clearProjectQuota("/mnt/lustre", 42))
+	assert.Equal(t, []string{"lfs setquota -p 42 -B 0 -I 0 /mnt/lustre"}, *commandLines)
+}
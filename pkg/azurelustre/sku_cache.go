@@ -0,0 +1,146 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultSkuCacheTTL = time.Hour
+
+	skuCacheResultHit   = "hit"
+	skuCacheResultMiss  = "miss"
+	skuCacheResultStale = "stale"
+)
+
+var skuCacheRequests = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      "azurelustre_csi",
+		Name:           "sku_cache_requests_total",
+		Help:           "Number of AMLFS SKU lookups by result: hit, miss, or stale when the SKUs could not be retrieved and expired values were used",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"location", "result"},
+)
+
+func init() {
+	legacyregistry.MustRegister(skuCacheRequests)
+}
+
+type skuCacheEntry struct {
+	skuValues map[string]*LustreSkuValue
+	fetchedAt time.Time
+}
+
+// skuFetch is a retrieval of the SKUs of a location shared by all the
+// concurrent lookups, done is closed once skuValues or err are set
+type skuFetch struct {
+	done      chan struct{}
+	skuValues map[string]*LustreSkuValue
+	err       error
+}
+
+// skuCache keeps the SKU values of each location for ttl, so that creating
+// many volumes at once does not page through the global SKU list every time
+type skuCache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]*skuCacheEntry
+	fetches map[string]*skuFetch
+	mux     sync.Mutex
+}
+
+func newSkuCache(ttl time.Duration) *skuCache {
+	return &skuCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*skuCacheEntry),
+		fetches: make(map[string]*skuFetch),
+	}
+}
+
+// get returns the SKU values of location, calling fetch if they are not
+// cached or expired. Concurrent lookups of the same location share a single
+// fetch, and expired values are returned if fetch fails
+func (c *skuCache) get(ctx context.Context, location string,
+	fetch func(ctx context.Context, location string) (map[string]*LustreSkuValue, error),
+) (map[string]*LustreSkuValue, error) {
+	key := strings.ToLower(location)
+
+	c.mux.Lock()
+	entry := c.entries[key]
+	if entry != nil && c.now().Sub(entry.fetchedAt) < c.ttl {
+		c.mux.Unlock()
+		skuCacheRequests.WithLabelValues(key, skuCacheResultHit).Inc()
+		return maps.Clone(entry.skuValues), nil
+	}
+	skuCacheRequests.WithLabelValues(key, skuCacheResultMiss).Inc()
+
+	running, ok := c.fetches[key]
+	if !ok {
+		running = &skuFetch{done: make(chan struct{})}
+		c.fetches[key] = running
+		// The fetch is shared, so it must not be canceled with the request
+		// that happened to start it
+		go c.fetch(context.WithoutCancel(ctx), key, location, running, fetch)
+	}
+	c.mux.Unlock()
+
+	select {
+	case <-running.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if running.err == nil {
+		return maps.Clone(running.skuValues), nil
+	}
+
+	c.mux.Lock()
+	entry = c.entries[key]
+	c.mux.Unlock()
+	if entry == nil {
+		return nil, running.err
+	}
+	klog.Warningf("using SKU values for location %s retrieved at %s, error when retrieving them again: %v",
+		location, entry.fetchedAt.Format(time.RFC3339), running.err)
+	skuCacheRequests.WithLabelValues(key, skuCacheResultStale).Inc()
+	return maps.Clone(entry.skuValues), nil
+}
+
+func (c *skuCache) fetch(ctx context.Context, key, location string, running *skuFetch,
+	fetch func(ctx context.Context, location string) (map[string]*LustreSkuValue, error),
+) {
+	skuValues, err := fetch(ctx, location)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err == nil {
+		c.entries[key] = &skuCacheEntry{skuValues: skuValues, fetchedAt: c.now()}
+	}
+	running.skuValues, running.err = skuValues, err
+	delete(c.fetches, key)
+	close(running.done)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+type fakeSkuFetcher struct {
+	calls     atomic.Int32
+	skuValues map[string]*LustreSkuValue
+	err       error
+	release   chan struct{}
+}
+
+func (f *fakeSkuFetcher) fetch(_ context.Context, _ string) (map[string]*LustreSkuValue, error) {
+	f.calls.Add(1)
+	if f.release != nil {
+		<-f.release
+	}
+	return f.skuValues, f.err
+}
+
+func newTestSkuCache(now *time.Time) *skuCache {
+	cache := newSkuCache(time.Hour)
+	cache.now = func() time.Time { return *now }
+	return cache
+}
+
+func getSkuCacheRequests(t *testing.T, location, result string) float64 {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "azurelustre_csi_sku_cache_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["location"] == location && labels["result"] == result {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestSkuCache_Get_CachedForTTL(t *testing.T) {
+	now := time.Now()
+	cache := newTestSkuCache(&now)
+	fetcher := &fakeSkuFetcher{skuValues: map[string]*LustreSkuValue{expectedSku: {IncrementInTib: 4, MaximumInTib: 128}}}
+	hits := getSkuCacheRequests(t, "cachedlocation", skuCacheResultHit)
+	misses := getSkuCacheRequests(t, "cachedlocation", skuCacheResultMiss)
+
+	for range 3 {
+		skuValues, err := cache.get(context.Background(), "CachedLocation", fetcher.fetch)
+		require.NoError(t, err)
+		assert.Equal(t, fetcher.skuValues, skuValues)
+	}
+	assert.Equal(t, int32(1), fetcher.calls.Load())
+
+	now = now.Add(time.Hour)
+	_, err := cache.get(context.Background(), "cachedlocation", fetcher.fetch)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), fetcher.calls.Load())
+
+	assert.InDelta(t, 2, getSkuCacheRequests(t, "cachedlocation", skuCacheResultHit)-hits, 0)
+	assert.InDelta(t, 2, getSkuCacheRequests(t, "cachedlocation", skuCacheResultMiss)-misses, 0)
+}
+
+func TestSkuCache_Get_StaleOnError(t *testing.T) {
+	now := time.Now()
+	cache := newTestSkuCache(&now)
+	expectedSkuValues := map[string]*LustreSkuValue{expectedSku: {IncrementInTib: 4, MaximumInTib: 128}}
+	fetcher := &fakeSkuFetcher{skuValues: expectedSkuValues}
+	stale := getSkuCacheRequests(t, "stalelocation", skuCacheResultStale)
+
+	_, err := cache.get(context.Background(), "stalelocation", fetcher.fetch)
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Hour)
+	fetcher.skuValues = nil
+	fetcher.err = status.Error(codes.Unavailable, "throttled")
+	skuValues, err := cache.get(context.Background(), "stalelocation", fetcher.fetch)
+	require.NoError(t, err)
+	assert.Equal(t, expectedSkuValues, skuValues)
+	assert.Equal(t, int32(2), fetcher.calls.Load())
+	assert.InDelta(t, 1, getSkuCacheRequests(t, "stalelocation", skuCacheResultStale)-stale, 0)
+
+	fetcher.skuValues = map[string]*LustreSkuValue{otherSkuForLocation: {IncrementInTib: 8, MaximumInTib: 256}}
+	fetcher.err = nil
+	skuValues, err = cache.get(context.Background(), "stalelocation", fetcher.fetch)
+	require.NoError(t, err)
+	assert.Equal(t, fetcher.skuValues, skuValues)
+}
+
+func TestSkuCache_Get_Err_NotCached(t *testing.T) {
+	now := time.Now()
+	cache := newTestSkuCache(&now)
+	fetcher := &fakeSkuFetcher{err: status.Error(codes.Internal, "error retrieving SKUs")}
+
+	for range 2 {
+		skuValues, err := cache.get(context.Background(), "errorlocation", fetcher.fetch)
+		require.ErrorContains(t, err, "error retrieving SKUs")
+		assert.Nil(t, skuValues)
+	}
+	assert.Equal(t, int32(2), fetcher.calls.Load())
+}
+
+func TestSkuCache_Get_SingleFetchForConcurrentLookups(t *testing.T) {
+	now := time.Now()
+	cache := newTestSkuCache(&now)
+	fetcher := &fakeSkuFetcher{
+		skuValues: map[string]*LustreSkuValue{expectedSku: {IncrementInTib: 4, MaximumInTib: 128}},
+		release:   make(chan struct{}),
+	}
+
+	const lookups = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, lookups)
+	for range lookups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.get(context.Background(), "concurrentlocation", fetcher.fetch)
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return fetcher.calls.Load() == 1
+	}, time.Second, time.Millisecond)
+	close(fetcher.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), fetcher.calls.Load())
+}
+
+func TestSkuCache_Get_Err_Canceled(t *testing.T) {
+	now := time.Now()
+	cache := newTestSkuCache(&now)
+	fetcher := &fakeSkuFetcher{
+		skuValues: map[string]*LustreSkuValue{expectedSku: {IncrementInTib: 4, MaximumInTib: 128}},
+		release:   make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cache.get(ctx, "canceledlocation", fetcher.fetch)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// The fetch started by the canceled lookup still populates the cache
+	close(fetcher.release)
+	require.Eventually(t, func() bool {
+		_, err := cache.get(context.Background(), "canceledlocation", fetcher.fetch)
+		return err == nil
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), fetcher.calls.Load())
+}
@@ -0,0 +1,670 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/azurelustre-csi-driver/pkg/util"
+	"sigs.k8s.io/cloud-provider-azure/pkg/metrics"
+)
+
+type snapshotState string
+
+const (
+	snapshotStateArchiving snapshotState = "Archiving"
+	snapshotStateReady     snapshotState = "Ready"
+	snapshotStateFailed    snapshotState = "Failed"
+
+	snapshotConfigMapPrefix = "azurelustre-snapshot-"
+	snapshotConfigMapKey    = "snapshot"
+	snapshotOperationLabel  = "snapshot"
+	// snapshotIDTemplate is the snapshot name, the resource group and name
+	// of the cluster, the archived path, which is the prefix of the blobs
+	// in the HSM container, and the Unix time of the snapshot
+	snapshotIDTemplate = "%s#%s#%s#%s#%d"
+	// snapshotRestoreImportJobName is the import job of the snapshot of a
+	// restored volume, each restored volume has its own cluster
+	snapshotRestoreImportJobName = "restore-snapshot"
+	snapshotImportInProgressFmt  = "import of snapshot %s into AMLFS cluster %s is in progress, %d of %d blobs imported"
+)
+
+// amlFilesystemSnapshot is a snapshot of a volume, which is an archive of
+// the sub-dir of the volume to the HSM container of its cluster. It is
+// recorded in a ConfigMap, from which ListSnapshots lists the snapshots
+type amlFilesystemSnapshot struct {
+	Name              string `json:"name"`
+	SourceVolumeID    string `json:"sourceVolumeID"`
+	ResourceGroupName string `json:"resourceGroupName"`
+	AmlFilesystemName string `json:"amlFilesystemName"`
+	// SubscriptionID is the subscription of a cluster created with
+	// provisioner secrets
+	SubscriptionID string `json:"subscriptionID,omitempty"`
+	// FilesystemPath is the archived path, the blobs of the snapshot are
+	// the blobs of the HSM container with this prefix
+	FilesystemPath string        `json:"filesystemPath"`
+	HsmContainer   string        `json:"hsmContainer"`
+	CreationTime   time.Time     `json:"creationTime"`
+	State          snapshotState `json:"state"`
+	ErrorCode      codes.Code    `json:"errorCode,omitempty"`
+	ErrorMessage   string        `json:"errorMessage,omitempty"`
+	// ArchiveStartTime is the start time of the archive of the snapshot,
+	// which is after CreationTime unless an archive of the path was
+	// already running. A later archive of the path overwrites the blobs
+	ArchiveStartTime time.Time `json:"archiveStartTime"`
+}
+
+func (s *amlFilesystemSnapshot) id() string {
+	snapshotID := fmt.Sprintf(snapshotIDTemplate, s.Name, s.ResourceGroupName, s.AmlFilesystemName, s.FilesystemPath, s.CreationTime.Unix())
+	if s.SubscriptionID != "" {
+		snapshotID += separator + s.SubscriptionID
+	}
+	return snapshotID
+}
+
+func (s *amlFilesystemSnapshot) csiSnapshot() *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     s.id(),
+		SourceVolumeId: s.SourceVolumeID,
+		CreationTime:   timestamppb.New(s.CreationTime),
+		ReadyToUse:     s.State == snapshotStateReady,
+	}
+}
+
+// getSnapshotNameFromID returns the name of the snapshot, the other segments
+// of the ID are only informative as the snapshot is recorded
+func getSnapshotNameFromID(snapshotID string) (string, error) {
+	segments := strings.Split(snapshotID, separator)
+	if len(segments) < 5 || segments[0] == "" {
+		return "", fmt.Errorf("could not split snapshot ID %q into name, cluster, path and time", snapshotID)
+	}
+	if _, err := strconv.ParseInt(segments[4], 10, 64); err != nil {
+		return "", fmt.Errorf("could not parse time of snapshot ID %q: %v", snapshotID, err)
+	}
+	return segments[0], nil
+}
+
+// getSnapshotConfigMapName returns a valid ConfigMap name for the snapshot,
+// with a hash suffix so that names only differing in case or in invalid
+// characters do not collide
+func getSnapshotConfigMapName(snapshotName string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(snapshotName))
+
+	base := strings.Trim(invalidConfigMapNameCharsRegex.ReplaceAllString(strings.ToLower(snapshotName), "-"), "-")
+	if len(base) > createOperationConfigMapNameMaxBase {
+		base = base[:createOperationConfigMapNameMaxBase]
+	}
+	return fmt.Sprintf("%s%s-%08x", snapshotConfigMapPrefix, base, hash.Sum32())
+}
+
+// CreateSnapshot archives the sub-dir of a dynamically provisioned volume to
+// the HSM container of its cluster. The archive runs in the background, the
+// snapshot is not ready to use until it completes, and csi-snapshotter calls
+// CreateSnapshot again until it is
+func (d *Driver) CreateSnapshot(
+	ctx context.Context,
+	req *csi.CreateSnapshotRequest,
+) (*csi.CreateSnapshotResponse, error) {
+	mc := metrics.NewMetricContext(azureLustreCSIDriverName,
+		"controller_create_snapshot",
+		d.resourceGroup,
+		d.cloud.SubscriptionID,
+		d.Name)
+
+	snapshotName := req.GetName()
+	if len(snapshotName) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot Name must be provided")
+	}
+	if strings.Contains(snapshotName, separator) {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot Name must not contain '%s'", separator)
+	}
+	sourceVolumeID := req.GetSourceVolumeId()
+	if len(sourceVolumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot Source volume ID must be provided")
+	}
+	if d.kubeClient == nil {
+		return nil, status.Error(codes.FailedPrecondition,
+			"CreateSnapshot snapshots are recorded in ConfigMaps, which requires a kubernetes client")
+	}
+
+	if acquired := d.volumeLocks.TryAcquire(snapshotName); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, snapshotName)
+	}
+	defer d.volumeLocks.Release(snapshotName)
+
+	isOperationSucceeded := false
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded)
+	}()
+
+	snapshot, err := d.getSnapshot(ctx, snapshotName)
+	if err != nil {
+		return nil, status.Errorf(status.Code(err), "CreateSnapshot %s", status.Convert(err).Message())
+	}
+	if snapshot != nil && snapshot.SourceVolumeID != sourceVolumeID {
+		return nil, status.Errorf(codes.AlreadyExists,
+			"CreateSnapshot snapshot %s already exists for volume %s", snapshotName, snapshot.SourceVolumeID)
+	}
+
+	if snapshot != nil && snapshot.State == snapshotStateFailed {
+		// The archive is started again on the next attempt
+		if err := d.deleteSnapshot(ctx, snapshotName); err != nil {
+			klog.Warningf("failed to delete failed snapshot %s: %v", snapshotName, err)
+		}
+		return nil, status.Errorf(snapshot.ErrorCode, "CreateSnapshot archive of snapshot %s failed: %s", snapshotName, snapshot.ErrorMessage)
+	}
+
+	if snapshot == nil || snapshot.State == snapshotStateArchiving {
+		vol, err := getLustreVolFromID(sourceVolumeID)
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "CreateSnapshot source volume %s not found: %v", sourceVolumeID, err)
+		}
+		if !vol.createdByDynamicProvisioning || vol.resourceGroupName == "" {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"CreateSnapshot source volume %s was not dynamically provisioned, only the AMLFS clusters created by the driver can be snapshotted",
+				sourceVolumeID)
+		}
+		dynamicProvisioner, err := d.getVolumeDynamicProvisioner(vol, req.GetSecrets())
+		if err != nil {
+			return nil, status.Errorf(status.Code(err), "CreateSnapshot %s", status.Convert(err).Message())
+		}
+
+		if snapshot == nil {
+			snapshot, err = d.newSnapshot(ctx, dynamicProvisioner, snapshotName, vol)
+			if err != nil {
+				return nil, err
+			}
+		}
+		d.startSnapshotArchive(dynamicProvisioner, snapshot)
+	}
+
+	klog.V(2).Infof("snapshot %s of volume %s is %s", snapshotName, sourceVolumeID, snapshot.State)
+	isOperationSucceeded = true
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot.csiSnapshot()}, nil
+}
+
+// newSnapshot records a new snapshot of the volume, if its cluster has an
+// HSM container
+func (d *Driver) newSnapshot(ctx context.Context, dynamicProvisioner DynamicProvisionerInterface, snapshotName string, vol *lustreVolume) (*amlFilesystemSnapshot, error) {
+	amlFilesystem, err := dynamicProvisioner.GetAmlFilesystem(ctx, vol.resourceGroupName, vol.name)
+	if err != nil {
+		klog.Errorf("error when getting AMLFS cluster %s of volume %s: %v", vol.name, vol.id, err)
+		return nil, status.Errorf(status.Code(err), "CreateSnapshot error when getting AMLFS cluster %s: %v", vol.name, err)
+	}
+	if amlFilesystem.HsmContainer == "" {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"CreateSnapshot AMLFS cluster %s has no %s, snapshots are archived to the HSM container of the cluster",
+			vol.name, VolumeContextHsmContainer)
+	}
+
+	snapshot := &amlFilesystemSnapshot{
+		Name:              snapshotName,
+		SourceVolumeID:    vol.id,
+		ResourceGroupName: vol.resourceGroupName,
+		AmlFilesystemName: vol.name,
+		SubscriptionID:    vol.subscriptionID,
+		FilesystemPath:    "/" + vol.subDir,
+		HsmContainer:      amlFilesystem.HsmContainer,
+		CreationTime:      time.Now().UTC().Truncate(time.Second),
+		State:             snapshotStateArchiving,
+	}
+	if err := d.saveSnapshot(ctx, snapshot, true); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "CreateSnapshot failed to record snapshot %s: %v", snapshotName, err)
+	}
+	klog.V(2).Infof("archiving path %s of AMLFS cluster %s for snapshot %s", snapshot.FilesystemPath, snapshot.AmlFilesystemName, snapshotName)
+	return snapshot, nil
+}
+
+// startSnapshotArchive archives the snapshot in the background, unless it is
+// already archived by this controller. A controller that restarted during
+// the archive starts it again, which waits for the archive in progress. If
+// an archive of another path is running, the snapshot stays Archiving and
+// the archive is started again on the next CreateSnapshot call
+func (d *Driver) startSnapshotArchive(dynamicProvisioner DynamicProvisionerInterface, snapshot *amlFilesystemSnapshot) {
+	if acquired := d.snapshotArchives.TryAcquire(snapshot.Name); !acquired {
+		return
+	}
+
+	archivedSnapshot := *snapshot
+	go func() {
+		defer d.snapshotArchives.Release(archivedSnapshot.Name)
+
+		// The archive outlives the CreateSnapshot request that started it
+		ctx := context.Background()
+		err := dynamicProvisioner.ArchiveAmlFilesystem(ctx, archivedSnapshot.ResourceGroupName, archivedSnapshot.AmlFilesystemName, archivedSnapshot.FilesystemPath)
+		if isOtherArchiveInProgress(err) {
+			klog.V(2).Infof("archive of snapshot %s is not started: %v", archivedSnapshot.Name, err)
+			return
+		}
+		if err == nil {
+			archivedSnapshot.ArchiveStartTime, err = getSnapshotArchiveStartTime(ctx, dynamicProvisioner, &archivedSnapshot)
+		}
+		if err != nil {
+			klog.Errorf("archive of snapshot %s failed: %v", archivedSnapshot.Name, err)
+			archivedSnapshot.State = snapshotStateFailed
+			archivedSnapshot.ErrorCode = status.Code(err)
+			archivedSnapshot.ErrorMessage = status.Convert(err).Message()
+		} else {
+			klog.V(2).Infof("archive of snapshot %s completed", archivedSnapshot.Name)
+			archivedSnapshot.State = snapshotStateReady
+		}
+		// The snapshot is not recorded again if it was deleted meanwhile
+		if err := d.saveSnapshot(ctx, &archivedSnapshot, false); err != nil && !apierrors.IsNotFound(err) {
+			klog.Warningf("failed to record result of archive of snapshot %s: %v", archivedSnapshot.Name, err)
+		}
+	}()
+}
+
+// getSnapshotArchiveStartTime returns the start time of the last archive of
+// the path of the snapshot, once its archive completed
+func getSnapshotArchiveStartTime(ctx context.Context, dynamicProvisioner DynamicProvisionerInterface, snapshot *amlFilesystemSnapshot) (time.Time, error) {
+	amlFilesystem, err := dynamicProvisioner.GetAmlFilesystem(ctx, snapshot.ResourceGroupName, snapshot.AmlFilesystemName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	archiveStartTime, ok := amlFilesystem.ArchiveStartTimes[snapshot.FilesystemPath]
+	if !ok {
+		return time.Time{}, status.Errorf(codes.Internal, "AMLFS cluster %s reports no archive of path %s",
+			snapshot.AmlFilesystemName, snapshot.FilesystemPath)
+	}
+	return archiveStartTime, nil
+}
+
+// DeleteSnapshot deletes the record of the snapshot. The archived blobs are
+// kept, as they are also the HSM archive of the files of the cluster
+func (d *Driver) DeleteSnapshot(
+	ctx context.Context,
+	req *csi.DeleteSnapshotRequest,
+) (*csi.DeleteSnapshotResponse, error) {
+	mc := metrics.NewMetricContext(azureLustreCSIDriverName,
+		"controller_delete_snapshot",
+		d.resourceGroup,
+		d.cloud.SubscriptionID,
+		d.Name)
+
+	snapshotID := req.GetSnapshotId()
+	if len(snapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteSnapshot Snapshot ID must be provided")
+	}
+
+	snapshotName, err := getSnapshotNameFromID(snapshotID)
+	if err != nil {
+		// An invalid snapshot ID was never created by the driver
+		klog.Warningf("error parsing snapshot ID '%v'", err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	if acquired := d.volumeLocks.TryAcquire(snapshotName); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, snapshotName)
+	}
+	defer d.volumeLocks.Release(snapshotName)
+
+	isOperationSucceeded := false
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded)
+	}()
+
+	klog.V(2).Infof("deleting snapshot %s", snapshotID)
+	if err := d.deleteSnapshot(ctx, snapshotName); err != nil {
+		klog.Errorf("error when deleting snapshot %s: %v", snapshotID, err)
+		return nil, status.Errorf(codes.Unavailable, "DeleteSnapshot error when deleting snapshot %s: %v", snapshotID, err)
+	}
+
+	isOperationSucceeded = true
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// ListSnapshots lists the recorded snapshots
+//
+// The starting token is the index of the next entry in the list of
+// snapshots, sorted by name.
+func (d *Driver) ListSnapshots(
+	ctx context.Context,
+	req *csi.ListSnapshotsRequest,
+) (*csi.ListSnapshotsResponse, error) {
+	mc := metrics.NewMetricContext(azureLustreCSIDriverName,
+		"controller_list_snapshots",
+		d.resourceGroup,
+		d.cloud.SubscriptionID,
+		d.Name)
+
+	isOperationSucceeded := false
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded)
+	}()
+
+	maxEntries := int(req.GetMaxEntries())
+	if maxEntries < 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"ListSnapshots max_entries must not be negative, was: %d", maxEntries)
+	}
+
+	start := 0
+	if startingToken := req.GetStartingToken(); startingToken != "" {
+		var err error
+		start, err = strconv.Atoi(startingToken)
+		if err != nil || start < 0 {
+			return nil, status.Errorf(codes.Aborted,
+				"ListSnapshots starting_token %q is not valid", startingToken)
+		}
+	}
+
+	snapshots, err := d.listSnapshots(ctx)
+	if err != nil {
+		klog.Errorf("error when listing snapshots: %v", err)
+		return nil, status.Errorf(codes.Unavailable, "ListSnapshots error when listing snapshots: %v", err)
+	}
+	snapshots = slices.DeleteFunc(snapshots, func(snapshot *amlFilesystemSnapshot) bool {
+		if req.GetSnapshotId() != "" && snapshot.id() != req.GetSnapshotId() {
+			return true
+		}
+		return req.GetSourceVolumeId() != "" && snapshot.SourceVolumeID != req.GetSourceVolumeId()
+	})
+
+	if start > len(snapshots) {
+		return nil, status.Errorf(codes.Aborted,
+			"ListSnapshots starting_token %d is greater than the number of snapshots %d", start, len(snapshots))
+	}
+
+	end := len(snapshots)
+	if maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	entries := make([]*csi.ListSnapshotsResponse_Entry, 0, end-start)
+	for _, snapshot := range snapshots[start:end] {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot.csiSnapshot()})
+	}
+
+	nextToken := ""
+	if end < len(snapshots) {
+		nextToken = strconv.Itoa(end)
+	}
+
+	isOperationSucceeded = true
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+// getSnapshotToRestore returns the snapshot of the content source of
+// CreateVolume. Its files are imported at the path they were archived from,
+// so a snapshot can only be restored into a new cluster with the same HSM
+// container, and the sub-dir of the volume is set to the archived path.
+// Restoring into a sub-dir of an existing cluster is not supported, the
+// import job of a cluster imports the blobs at the path of their name
+func (d *Driver) getSnapshotToRestore(ctx context.Context, snapshotID string, shouldCreateAmlfsCluster bool, amlFilesystemProperties *AmlFilesystemProperties, parameters map[string]string) (*amlFilesystemSnapshot, error) {
+	snapshotName, err := getSnapshotNameFromID(snapshotID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "CreateVolume snapshot %s not found: %v", snapshotID, err)
+	}
+	snapshot, err := d.getSnapshot(ctx, snapshotName)
+	if err != nil {
+		return nil, status.Errorf(status.Code(err), "CreateVolume %s", status.Convert(err).Message())
+	}
+	if snapshot == nil || snapshot.id() != snapshotID {
+		return nil, status.Errorf(codes.NotFound, "CreateVolume snapshot %s not found", snapshotID)
+	}
+	if snapshot.State != snapshotStateReady {
+		return nil, status.Errorf(codes.Unavailable, "CreateVolume snapshot %s is not ready to use", snapshotID)
+	}
+
+	if !shouldCreateAmlfsCluster {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume snapshots can only be restored into new AMLFS clusters, restoring into a sub-dir of an existing cluster with %s or %s is not supported",
+			VolumeContextMGSIPAddress, VolumeContextAmlfsName)
+	}
+	if !strings.EqualFold(amlFilesystemProperties.HsmContainer, snapshot.HsmContainer) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s must be %s to restore snapshot %s",
+			VolumeContextHsmContainer, snapshot.HsmContainer, snapshotName)
+	}
+
+	snapshotSubDir := strings.Trim(snapshot.FilesystemPath, "/")
+	subDir := strings.Trim(util.GetValueInMap(parameters, VolumeContextSubDir), "/")
+	if subDir != "" && subDir != snapshotSubDir {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameter %s cannot be used to restore snapshot %s, its files are restored at %s",
+			VolumeContextSubDir, snapshotName, snapshot.FilesystemPath)
+	}
+	if err := d.checkSnapshotArchive(ctx, snapshot); err != nil {
+		return nil, err
+	}
+	if snapshotSubDir != "" {
+		util.SetKeyValueInMap(parameters, VolumeContextSubDir, snapshotSubDir)
+	}
+	return snapshot, nil
+}
+
+// checkSnapshotArchive returns FailedPrecondition if the blobs of the
+// snapshot may have been overwritten, which is when the source cluster
+// archived its path, or a path containing or contained in it, after the
+// snapshot, or when the cluster was deleted with such an archive-on-delete
+func (d *Driver) checkSnapshotArchive(ctx context.Context, snapshot *amlFilesystemSnapshot) error {
+	dynamicProvisioner, ok := d.getSubscriptionDynamicProvisioner(snapshot.SubscriptionID)
+	if !ok {
+		return status.Errorf(codes.FailedPrecondition,
+			"CreateVolume archives of AMLFS cluster %s of snapshot %s cannot be checked, secrets for subscription %s are required",
+			snapshot.AmlFilesystemName, snapshot.Name, snapshot.SubscriptionID)
+	}
+
+	amlFilesystem, err := dynamicProvisioner.GetAmlFilesystem(ctx, snapshot.ResourceGroupName, snapshot.AmlFilesystemName)
+	if status.Code(err) == codes.NotFound {
+		vol, err := getLustreVolFromID(snapshot.SourceVolumeID)
+		if err == nil && vol.archiveOnDeletePath != "" && isOverlappingPath(vol.archiveOnDeletePath, snapshot.FilesystemPath) {
+			return status.Errorf(codes.FailedPrecondition,
+				"CreateVolume snapshot %s cannot be restored, path %s was archived when AMLFS cluster %s was deleted",
+				snapshot.Name, vol.archiveOnDeletePath, snapshot.AmlFilesystemName)
+		}
+		return nil
+	}
+	if err != nil {
+		klog.Errorf("error when getting AMLFS cluster %s of snapshot %s: %v", snapshot.AmlFilesystemName, snapshot.Name, err)
+		return status.Errorf(status.Code(err), "CreateVolume error when getting AMLFS cluster %s of snapshot %s: %v",
+			snapshot.AmlFilesystemName, snapshot.Name, err)
+	}
+
+	for _, archivedPath := range slices.Sorted(maps.Keys(amlFilesystem.ArchiveStartTimes)) {
+		archiveStartTime := amlFilesystem.ArchiveStartTimes[archivedPath]
+		if isOverlappingPath(archivedPath, snapshot.FilesystemPath) && archiveStartTime.After(snapshot.ArchiveStartTime) {
+			return status.Errorf(codes.FailedPrecondition,
+				"CreateVolume snapshot %s cannot be restored, path %s of AMLFS cluster %s was archived at %s, after the snapshot",
+				snapshot.Name, archivedPath, snapshot.AmlFilesystemName, archiveStartTime.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// isOverlappingPath returns true if one of the paths contains the other
+func isOverlappingPath(a, b string) bool {
+	a = strings.TrimSuffix(path.Clean("/"+a), "/") + "/"
+	b = strings.TrimSuffix(path.Clean("/"+b), "/") + "/"
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// restoreSnapshot imports the blobs of the snapshot into the created cluster.
+// If the import takes longer than the request, Aborted is returned so that
+// the provisioner retries CreateVolume, which then checks the same import
+func (d *Driver) restoreSnapshot(ctx context.Context, dynamicProvisioner DynamicProvisionerInterface, amlFilesystemProperties *AmlFilesystemProperties, snapshot *amlFilesystemSnapshot) error {
+	amlFilesystemName := amlFilesystemProperties.AmlFilesystemName
+	pvc := d.getPersistentVolumeClaim(ctx, amlFilesystemProperties)
+
+	importJobStatus, err := dynamicProvisioner.GetImportJobStatus(ctx, amlFilesystemProperties.ResourceGroupName, amlFilesystemName, snapshotRestoreImportJobName)
+	if status.Code(err) == codes.NotFound {
+		klog.V(2).Infof("importing path %s of snapshot %s into AMLFS cluster %s", snapshot.FilesystemPath, snapshot.Name, amlFilesystemName)
+		err = dynamicProvisioner.CreateImportJob(ctx, &ImportJobProperties{
+			ResourceGroupName: amlFilesystemProperties.ResourceGroupName,
+			AmlFilesystemName: amlFilesystemName,
+			ImportJobName:     snapshotRestoreImportJobName,
+			Location:          amlFilesystemProperties.Location,
+			ImportPrefixes:    []string{snapshot.FilesystemPath},
+			// The blobs imported when the cluster was created are the same
+			ConflictResolutionMode: armstoragecache.ConflictResolutionModeSkip,
+		})
+		if err == nil {
+			d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonSnapshotImportStarted,
+				"importing path %s of snapshot %s into AMLFS cluster %s", snapshot.FilesystemPath, snapshot.Name, amlFilesystemName)
+			importJobStatus, err = dynamicProvisioner.GetImportJobStatus(ctx, amlFilesystemProperties.ResourceGroupName, amlFilesystemName, snapshotRestoreImportJobName)
+		}
+	}
+	if err != nil {
+		klog.Errorf("error when importing snapshot %s into AMLFS cluster %s: %v", snapshot.Name, amlFilesystemName, err)
+		return status.Errorf(status.Code(err), "CreateVolume error when importing snapshot %s into AMLFS cluster %s: %v", snapshot.Name, amlFilesystemName, err)
+	}
+
+	switch importJobStatus.State { //nolint:exhaustive // All other states are still in progress
+	case armstoragecache.ImportStatusTypeCompleted:
+		klog.V(2).Infof("imported snapshot %s into AMLFS cluster %s", snapshot.Name, amlFilesystemName)
+		d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonSnapshotImportSucceeded,
+			"imported %d blobs of snapshot %s into AMLFS cluster %s", importJobStatus.TotalBlobsImported, snapshot.Name, amlFilesystemName)
+		return nil
+	case armstoragecache.ImportStatusTypeCompletedPartial,
+		armstoragecache.ImportStatusTypeFailed,
+		armstoragecache.ImportStatusTypeCanceled:
+		klog.Errorf("import of snapshot %s into AMLFS cluster %s ended in state %s: %s",
+			snapshot.Name, amlFilesystemName, importJobStatus.State, importJobStatus.StatusMessage)
+		d.recordEvent(pvc, corev1.EventTypeWarning, eventReasonSnapshotImportFailed,
+			"import of snapshot %s into AMLFS cluster %s ended in state %s with %d errors: %s",
+			snapshot.Name, amlFilesystemName, importJobStatus.State, importJobStatus.TotalErrors, importJobStatus.StatusMessage)
+		return status.Errorf(codes.Internal, "CreateVolume import of snapshot %s into AMLFS cluster %s ended in state %s: %s",
+			snapshot.Name, amlFilesystemName, importJobStatus.State, importJobStatus.StatusMessage)
+	}
+
+	klog.V(2).Infof(snapshotImportInProgressFmt, snapshot.Name, amlFilesystemName, importJobStatus.TotalBlobsImported, importJobStatus.TotalBlobsWalked)
+	return status.Errorf(codes.Aborted, snapshotImportInProgressFmt, snapshot.Name, amlFilesystemName, importJobStatus.TotalBlobsImported, importJobStatus.TotalBlobsWalked)
+}
+
+func (d *Driver) getSnapshot(ctx context.Context, snapshotName string) (*amlFilesystemSnapshot, error) {
+	if d.kubeClient == nil {
+		return nil, nil
+	}
+
+	configMapName := getSnapshotConfigMapName(snapshotName)
+	configMap, err := d.kubeClient.CoreV1().ConfigMaps(d.operationNamespace).Get(ctx, configMapName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, status.Errorf(codes.Unavailable, "failed to get ConfigMap %s/%s of snapshot %s: %v",
+			d.operationNamespace, configMapName, snapshotName, err)
+	}
+
+	snapshot, err := getSnapshotFromConfigMap(configMap)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid ConfigMap %s/%s of snapshot %s: %v",
+			d.operationNamespace, configMapName, snapshotName, err)
+	}
+	return snapshot, nil
+}
+
+func getSnapshotFromConfigMap(configMap *corev1.ConfigMap) (*amlFilesystemSnapshot, error) {
+	var snapshot amlFilesystemSnapshot
+	if err := json.Unmarshal([]byte(configMap.Data[snapshotConfigMapKey]), &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// listSnapshots returns the recorded snapshots sorted by name
+func (d *Driver) listSnapshots(ctx context.Context) ([]*amlFilesystemSnapshot, error) {
+	if d.kubeClient == nil {
+		return nil, nil
+	}
+
+	configMaps, err := d.kubeClient.CoreV1().ConfigMaps(d.operationNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: createOperationLabel + "=" + snapshotOperationLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*amlFilesystemSnapshot, 0, len(configMaps.Items))
+	for i := range configMaps.Items {
+		snapshot, err := getSnapshotFromConfigMap(&configMaps.Items[i])
+		if err != nil {
+			klog.Warningf("ignoring invalid ConfigMap %s/%s of snapshot: %v", d.operationNamespace, configMaps.Items[i].Name, err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	slices.SortFunc(snapshots, func(a, b *amlFilesystemSnapshot) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return snapshots, nil
+}
+
+// saveSnapshot records the snapshot, a new ConfigMap is only created if
+// create is set
+func (d *Driver) saveSnapshot(ctx context.Context, snapshot *amlFilesystemSnapshot, create bool) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getSnapshotConfigMapName(snapshot.Name),
+			Namespace: d.operationNamespace,
+			Labels: map[string]string{
+				createOperationLabel: snapshotOperationLabel,
+			},
+		},
+		Data: map[string]string{
+			snapshotConfigMapKey: string(data),
+		},
+	}
+
+	configMaps := d.kubeClient.CoreV1().ConfigMaps(d.operationNamespace)
+	_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	if create && apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	}
+	return err
+}
+
+func (d *Driver) deleteSnapshot(ctx context.Context, snapshotName string) error {
+	if d.kubeClient == nil {
+		return nil
+	}
+
+	configMapName := getSnapshotConfigMapName(snapshotName)
+	err := d.kubeClient.CoreV1().ConfigMaps(d.operationNamespace).Delete(ctx, configMapName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete ConfigMap %s/%s: %w", d.operationNamespace, configMapName, err)
+	}
+	return nil
+}
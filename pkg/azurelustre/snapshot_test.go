@@ -0,0 +1,684 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storagecache/armstoragecache/v4"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	testSnapshotHsmContainer   = "https://account.blob.core.windows.net/data"
+	testSnapshotSourceVolumeID = "test_volume#lustrefs#127.0.0.2#testSubDir#t#test-resource-group"
+)
+
+func addSnapshotSourceFilesystem(fakeDynamicProvisioner *FakeDynamicProvisioner, amlFilesystemName, hsmContainer string) {
+	fakeDynamicProvisioner.Filesystems = append(fakeDynamicProvisioner.Filesystems, &AmlFilesystemProperties{
+		ResourceGroupName: "test-resource-group",
+		AmlFilesystemName: amlFilesystemName,
+		HsmContainer:      hsmContainer,
+	})
+}
+
+func waitForSnapshotState(t *testing.T, d *Driver, snapshotName string, state snapshotState) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		snapshot, err := d.getSnapshot(context.Background(), snapshotName)
+		return err == nil && snapshot != nil && snapshot.State == state
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func createReadySnapshot(t *testing.T, d *Driver, snapshotName, sourceVolumeID string) *csi.Snapshot {
+	t.Helper()
+	req := &csi.CreateSnapshotRequest{Name: snapshotName, SourceVolumeId: sourceVolumeID}
+	_, err := d.CreateSnapshot(context.Background(), req)
+	require.NoError(t, err)
+	waitForSnapshotState(t, d, snapshotName, snapshotStateReady)
+
+	rep, err := d.CreateSnapshot(context.Background(), req)
+	require.NoError(t, err)
+	require.True(t, rep.GetSnapshot().GetReadyToUse())
+	return rep.GetSnapshot()
+}
+
+func TestGetSnapshotNameFromID(t *testing.T) {
+	tests := []struct {
+		desc         string
+		snapshotID   string
+		expectedName string
+		expectedErr  bool
+	}{
+		{
+			desc:         "valid snapshot ID",
+			snapshotID:   "snapshot#rg#amlfs#/subDir#1700000000",
+			expectedName: "snapshot",
+		},
+		{
+			desc:         "valid snapshot ID with subscription",
+			snapshotID:   "snapshot#rg#amlfs#/#1700000000#subscription",
+			expectedName: "snapshot",
+		},
+		{
+			desc:        "too few segments",
+			snapshotID:  "snapshot#rg#amlfs",
+			expectedErr: true,
+		},
+		{
+			desc:        "empty name",
+			snapshotID:  "#rg#amlfs#/#1700000000",
+			expectedErr: true,
+		},
+		{
+			desc:        "invalid time",
+			snapshotID:  "snapshot#rg#amlfs#/#yesterday",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			name, err := getSnapshotNameFromID(test.snapshotID)
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedName, name)
+		})
+	}
+}
+
+func TestGetSnapshotConfigMapName(t *testing.T) {
+	name := getSnapshotConfigMapName("Snapshot_A")
+	assert.True(t, strings.HasPrefix(name, snapshotConfigMapPrefix+"snapshot-a-"))
+	assert.NotEqual(t, name, getSnapshotConfigMapName("snapshot-a"))
+	assert.LessOrEqual(t, len(getSnapshotConfigMapName(strings.Repeat("a", 300))), 253)
+}
+
+func TestCreateSnapshot_Success(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+	sourceVolumeID := testSnapshotSourceVolumeID
+
+	rep, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "test_snapshot",
+		SourceVolumeId: sourceVolumeID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, sourceVolumeID, rep.GetSnapshot().GetSourceVolumeId())
+	assert.True(t, strings.HasPrefix(rep.GetSnapshot().GetSnapshotId(), "test_snapshot#test-resource-group#test_volume#/testSubDir#"))
+	assert.NotNil(t, rep.GetSnapshot().GetCreationTime())
+
+	waitForSnapshotState(t, d, "test_snapshot", snapshotStateReady)
+
+	// Retries return the same snapshot, ready to use once archived
+	retryRep, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "test_snapshot",
+		SourceVolumeId: sourceVolumeID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rep.GetSnapshot().GetSnapshotId(), retryRep.GetSnapshot().GetSnapshotId())
+	assert.True(t, retryRep.GetSnapshot().GetReadyToUse())
+	assert.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["ArchiveAmlFilesystem"])
+
+	snapshot, err := d.getSnapshot(context.Background(), "test_snapshot")
+	require.NoError(t, err)
+	assert.Equal(t, testSnapshotHsmContainer, snapshot.HsmContainer)
+	assert.Equal(t, "/testSubDir", snapshot.FilesystemPath)
+}
+
+func TestCreateSnapshot_Err_AlreadyExistsForOtherVolume(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+	createReadySnapshot(t, d, "test_snapshot", testSnapshotSourceVolumeID)
+
+	_, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "test_snapshot",
+		SourceVolumeId: fmt.Sprintf(volumeIDTemplate, "other_volume", "lustrefs", "127.0.0.2", "testSubDir", "t", "test-resource-group"),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestCreateSnapshot_Err_InvalidRequest(t *testing.T) {
+	tests := []struct {
+		desc          string
+		req           *csi.CreateSnapshotRequest
+		noKubeClient  bool
+		expectedCode  codes.Code
+		expectedError string
+	}{
+		{
+			desc:          "no name",
+			req:           &csi.CreateSnapshotRequest{SourceVolumeId: testSnapshotSourceVolumeID},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "Name must be provided",
+		},
+		{
+			desc:          "name with separator",
+			req:           &csi.CreateSnapshotRequest{Name: "test#snapshot", SourceVolumeId: testSnapshotSourceVolumeID},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "Name must not contain",
+		},
+		{
+			desc:          "no source volume",
+			req:           &csi.CreateSnapshotRequest{Name: "test_snapshot"},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "Source volume ID must be provided",
+		},
+		{
+			desc:          "invalid source volume",
+			req:           &csi.CreateSnapshotRequest{Name: "test_snapshot", SourceVolumeId: "invalid"},
+			expectedCode:  codes.NotFound,
+			expectedError: "source volume invalid not found",
+		},
+		{
+			desc: "static source volume",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test_snapshot",
+				SourceVolumeId: fmt.Sprintf(volumeIDTemplate, "test_volume", "lustrefs", "127.0.0.2", "testSubDir", "f", ""),
+			},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "was not dynamically provisioned",
+		},
+		{
+			desc:          "cluster without HSM container",
+			req:           &csi.CreateSnapshotRequest{Name: "test_snapshot", SourceVolumeId: fmt.Sprintf(volumeIDTemplate, "no_hsm_volume", "lustrefs", "127.0.0.2", "testSubDir", "t", "test-resource-group")},
+			expectedCode:  codes.FailedPrecondition,
+			expectedError: "has no hsm-container",
+		},
+		{
+			desc:          "cluster not found",
+			req:           &csi.CreateSnapshotRequest{Name: "test_snapshot", SourceVolumeId: fmt.Sprintf(volumeIDTemplate, "missing_volume", "lustrefs", "127.0.0.2", "testSubDir", "t", "test-resource-group")},
+			expectedCode:  codes.NotFound,
+			expectedError: "error when getting AMLFS cluster missing_volume",
+		},
+		{
+			desc:          "no kubernetes client",
+			req:           &csi.CreateSnapshotRequest{Name: "test_snapshot", SourceVolumeId: testSnapshotSourceVolumeID},
+			noKubeClient:  true,
+			expectedCode:  codes.FailedPrecondition,
+			expectedError: "requires a kubernetes client",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+			d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+			addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+			addSnapshotSourceFilesystem(fakeDynamicProvisioner, "no_hsm_volume", "")
+			if test.noKubeClient {
+				d.kubeClient = nil
+			}
+
+			_, err := d.CreateSnapshot(context.Background(), test.req)
+			require.Error(t, err)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+			assert.ErrorContains(t, err, test.expectedError)
+			assert.Zero(t, fakeDynamicProvisioner.fakeCallCount["ArchiveAmlFilesystem"])
+		})
+	}
+}
+
+func TestCreateSnapshot_Err_ArchiveFailed(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, archiveRequestFailureName, testSnapshotHsmContainer)
+	req := &csi.CreateSnapshotRequest{
+		Name:           "test_snapshot",
+		SourceVolumeId: fmt.Sprintf(volumeIDTemplate, archiveRequestFailureName, "lustrefs", "127.0.0.2", "testSubDir", "t", "test-resource-group"),
+	}
+
+	rep, err := d.CreateSnapshot(context.Background(), req)
+	require.NoError(t, err)
+	assert.False(t, rep.GetSnapshot().GetReadyToUse())
+	waitForSnapshotState(t, d, "test_snapshot", snapshotStateFailed)
+
+	_, err = d.CreateSnapshot(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.ErrorContains(t, err, "archive of snapshot test_snapshot failed")
+
+	// The failed snapshot is forgotten, the next attempt archives again
+	snapshot, err := d.getSnapshot(context.Background(), "test_snapshot")
+	require.NoError(t, err)
+	assert.Nil(t, snapshot)
+
+	_, err = d.CreateSnapshot(context.Background(), req)
+	require.NoError(t, err)
+	waitForSnapshotState(t, d, "test_snapshot", snapshotStateFailed)
+	assert.Equal(t, 2, fakeDynamicProvisioner.fakeCallCount["ArchiveAmlFilesystem"])
+}
+
+func TestCreateSnapshot_OtherArchiveInProgress(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, otherArchiveRunningName, testSnapshotHsmContainer)
+	req := &csi.CreateSnapshotRequest{
+		Name:           "test_snapshot",
+		SourceVolumeId: fmt.Sprintf(volumeIDTemplate, otherArchiveRunningName, "lustrefs", "127.0.0.2", "testSubDir", "t", "test-resource-group"),
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		rep, err := d.CreateSnapshot(context.Background(), req)
+		require.NoError(t, err)
+		assert.False(t, rep.GetSnapshot().GetReadyToUse())
+		require.Eventually(t, func() bool {
+			if !d.snapshotArchives.TryAcquire("test_snapshot") {
+				return false
+			}
+			d.snapshotArchives.Release("test_snapshot")
+			return true
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// The snapshot is archived again on the next attempt
+		snapshot, err := d.getSnapshot(context.Background(), "test_snapshot")
+		require.NoError(t, err)
+		assert.Equal(t, snapshotStateArchiving, snapshot.State)
+		assert.Equal(t, attempt, fakeDynamicProvisioner.fakeCallCount["ArchiveAmlFilesystem"])
+	}
+}
+
+func TestDeleteSnapshot_Success(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+	csiSnapshot := createReadySnapshot(t, d, "test_snapshot", testSnapshotSourceVolumeID)
+
+	_, err := d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: csiSnapshot.GetSnapshotId()})
+	require.NoError(t, err)
+
+	snapshot, err := d.getSnapshot(context.Background(), "test_snapshot")
+	require.NoError(t, err)
+	assert.Nil(t, snapshot)
+
+	// Deleting again is successful
+	_, err = d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: csiSnapshot.GetSnapshotId()})
+	require.NoError(t, err)
+}
+
+func TestDeleteSnapshot_InvalidID(t *testing.T) {
+	d := NewFakeDriver(withFakeKubeClient())
+
+	_, err := d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "invalid"})
+	require.NoError(t, err)
+}
+
+func TestListSnapshots(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, "other_volume", testSnapshotHsmContainer)
+	snapshotA := createReadySnapshot(t, d, "snapshot_a", testSnapshotSourceVolumeID)
+	snapshotB := createReadySnapshot(t, d, "snapshot_b", fmt.Sprintf(volumeIDTemplate, "other_volume", "lustrefs", "127.0.0.2", "testSubDir", "t", "test-resource-group"))
+	snapshotC := createReadySnapshot(t, d, "snapshot_c", testSnapshotSourceVolumeID)
+
+	// ConfigMaps of other operations are not listed
+	persistCreateOperation(t, d, &amlFilesystemCreateOperation{
+		ResourceGroupName: "test-resource-group",
+		AmlFilesystemName: "creating_volume",
+		State:             createOperationStateInProgress,
+	})
+
+	getSnapshotIDs := func(rep *csi.ListSnapshotsResponse) []string {
+		snapshotIDs := []string{}
+		for _, entry := range rep.GetEntries() {
+			snapshotIDs = append(snapshotIDs, entry.GetSnapshot().GetSnapshotId())
+		}
+		return snapshotIDs
+	}
+
+	rep, err := d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{snapshotA.GetSnapshotId(), snapshotB.GetSnapshotId(), snapshotC.GetSnapshotId()}, getSnapshotIDs(rep))
+	assert.Empty(t, rep.GetNextToken())
+
+	rep, err = d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SourceVolumeId: testSnapshotSourceVolumeID})
+	require.NoError(t, err)
+	assert.Equal(t, []string{snapshotA.GetSnapshotId(), snapshotC.GetSnapshotId()}, getSnapshotIDs(rep))
+
+	rep, err = d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SnapshotId: snapshotB.GetSnapshotId()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{snapshotB.GetSnapshotId()}, getSnapshotIDs(rep))
+
+	rep, err = d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SnapshotId: "unknown#rg#amlfs#/#1700000000"})
+	require.NoError(t, err)
+	assert.Empty(t, rep.GetEntries())
+
+	rep, err = d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{MaxEntries: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{snapshotA.GetSnapshotId(), snapshotB.GetSnapshotId()}, getSnapshotIDs(rep))
+	assert.Equal(t, "2", rep.GetNextToken())
+
+	rep, err = d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{MaxEntries: 2, StartingToken: rep.GetNextToken()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{snapshotC.GetSnapshotId()}, getSnapshotIDs(rep))
+	assert.Empty(t, rep.GetNextToken())
+
+	_, err = d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{StartingToken: "4"})
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	_, err = d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{MaxEntries: -1})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCreateVolume_RestoreSnapshot_Success(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeEventRecorder(recorder),
+		withFakeKubeClient(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc_name", Namespace: "pvc_namespace"}}))
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+	csiSnapshot := createReadySnapshot(t, d, "test_snapshot", testSnapshotSourceVolumeID)
+
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Name = "restored_volume"
+	req.Parameters["hsm-container"] = testSnapshotHsmContainer
+	req.Parameters["hsm-logging-container"] = testSnapshotHsmContainer + "-logging"
+	req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: csiSnapshot.GetSnapshotId()},
+	}}
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, req.GetVolumeContentSource(), rep.GetVolume().GetContentSource())
+	assert.Equal(t, "testSubDir", rep.GetVolume().GetVolumeContext()["sub-dir"])
+
+	importJob := fakeDynamicProvisioner.ImportJobs["restored_volume/"+snapshotRestoreImportJobName]
+	require.NotNil(t, importJob)
+	assert.Equal(t, []string{"/testSubDir"}, importJob.ImportPrefixes)
+	assert.Equal(t, armstoragecache.ConflictResolutionModeSkip, importJob.ConflictResolutionMode)
+
+	events := receiveEvents(recorder)
+	assert.Contains(t, events, "Normal AmlfsSnapshotImportStarted importing path /testSubDir of snapshot test_snapshot into AMLFS cluster restored_volume")
+	assert.Contains(t, events, "Normal AmlfsSnapshotImportSucceeded imported 10 blobs of snapshot test_snapshot into AMLFS cluster restored_volume")
+}
+
+func TestCreateVolume_RestoreSnapshot_ArchivedAgain(t *testing.T) {
+	tests := []struct {
+		desc          string
+		archivedPath  string
+		deleteCluster bool
+		sourceVolume  string
+		expectedError string
+	}{
+		{
+			desc:          "path archived again",
+			archivedPath:  "/testSubDir",
+			expectedError: "path /testSubDir of AMLFS cluster test_volume was archived at",
+		},
+		{
+			desc:          "parent path archived",
+			archivedPath:  "/",
+			expectedError: "path / of AMLFS cluster test_volume was archived at",
+		},
+		{
+			desc:          "child path archived",
+			archivedPath:  "/testSubDir/child",
+			expectedError: "path /testSubDir/child of AMLFS cluster test_volume was archived at",
+		},
+		{
+			desc:         "other path archived",
+			archivedPath: "/testSubDir2",
+		},
+		{
+			desc:          "cluster deleted with archive-on-delete",
+			deleteCluster: true,
+			sourceVolume:  testSnapshotSourceVolumeID + "#/",
+			expectedError: "path / was archived when AMLFS cluster test_volume was deleted",
+		},
+		{
+			desc:          "cluster deleted without archive-on-delete",
+			deleteCluster: true,
+			sourceVolume:  testSnapshotSourceVolumeID,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+			d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+			addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+			sourceVolumeID := testSnapshotSourceVolumeID
+			if test.sourceVolume != "" {
+				sourceVolumeID = test.sourceVolume
+			}
+			csiSnapshot := createReadySnapshot(t, d, "test_snapshot", sourceVolumeID)
+
+			if test.archivedPath != "" {
+				fakeDynamicProvisioner.setArchiveStartTime("test_volume", test.archivedPath, time.Now().Add(time.Minute))
+			}
+			if test.deleteCluster {
+				fakeDynamicProvisioner.Filesystems = nil
+			}
+
+			req := buildDynamicProvCreateVolumeRequest()
+			req.Name = "restored_volume"
+			req.Parameters["hsm-container"] = testSnapshotHsmContainer
+			req.Parameters["hsm-logging-container"] = testSnapshotHsmContainer + "-logging"
+			req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: csiSnapshot.GetSnapshotId()},
+			}}
+			_, err := d.CreateVolume(context.Background(), req)
+			if test.expectedError == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+			assert.ErrorContains(t, err, test.expectedError)
+			assert.Zero(t, fakeDynamicProvisioner.fakeCallCount["BeginCreateAmlFilesystem"])
+		})
+	}
+}
+
+func TestCreateVolume_RestoreSnapshot_ImportInProgress(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+	csiSnapshot := createReadySnapshot(t, d, "test_snapshot", testSnapshotSourceVolumeID)
+
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Name = importInProgressName
+	req.Parameters["hsm-container"] = testSnapshotHsmContainer
+	req.Parameters["hsm-logging-container"] = testSnapshotHsmContainer + "-logging"
+	req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: csiSnapshot.GetSnapshotId()},
+	}}
+	_, err := d.CreateVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.ErrorContains(t, err, "4 of 10 blobs imported")
+
+	// Retries check the same import
+	_, err = d.CreateVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, 1, fakeDynamicProvisioner.fakeCallCount["CreateImportJob"])
+}
+
+func TestCreateVolume_RestoreSnapshot_ImportFailed(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeEventRecorder(recorder),
+		withFakeKubeClient(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc_name", Namespace: "pvc_namespace"}}))
+	addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+	csiSnapshot := createReadySnapshot(t, d, "test_snapshot", testSnapshotSourceVolumeID)
+
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Name = importFailureName
+	req.Parameters["hsm-container"] = testSnapshotHsmContainer
+	req.Parameters["hsm-logging-container"] = testSnapshotHsmContainer + "-logging"
+	req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: csiSnapshot.GetSnapshotId()},
+	}}
+	_, err := d.CreateVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.ErrorContains(t, err, "container not found")
+
+	assert.Contains(t, receiveEvents(recorder),
+		"Warning AmlfsSnapshotImportFailed import of snapshot test_snapshot into AMLFS cluster testImportShouldFail ended in state Failed with 0 errors: container not found")
+}
+
+func TestCreateVolume_RestoreSnapshot_Err(t *testing.T) {
+	tests := []struct {
+		desc          string
+		updateRequest func(req *csi.CreateVolumeRequest)
+		snapshotID    func(snapshotID string) string
+		expectedCode  codes.Code
+		expectedError string
+	}{
+		{
+			desc:          "unknown snapshot",
+			snapshotID:    func(string) string { return "unknown#rg#amlfs#/#1700000000" },
+			expectedCode:  codes.NotFound,
+			expectedError: "not found",
+		},
+		{
+			desc:          "invalid snapshot ID",
+			snapshotID:    func(string) string { return "invalid" },
+			expectedCode:  codes.NotFound,
+			expectedError: "not found",
+		},
+		{
+			desc: "snapshot ID of a deleted snapshot of the same name",
+			snapshotID: func(snapshotID string) string {
+				return strings.Replace(snapshotID, "#/testSubDir#", "#/otherSubDir#", 1)
+			},
+			expectedCode:  codes.NotFound,
+			expectedError: "not found",
+		},
+		{
+			desc: "different HSM container",
+			updateRequest: func(req *csi.CreateVolumeRequest) {
+				req.Parameters["hsm-container"] = testSnapshotHsmContainer + "-other"
+			},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "hsm-container must be " + testSnapshotHsmContainer,
+		},
+		{
+			desc: "different sub-dir",
+			updateRequest: func(req *csi.CreateVolumeRequest) {
+				req.Parameters["sub-dir"] = "otherSubDir"
+			},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "its files are restored at /testSubDir",
+		},
+		{
+			desc: "existing cluster",
+			updateRequest: func(req *csi.CreateVolumeRequest) {
+				req.Parameters = map[string]string{
+					"mgs-ip-address": "127.0.0.1",
+					"fs-name":        "lustrefs",
+				}
+			},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "can only be restored into new AMLFS clusters",
+		},
+		{
+			desc: "sub-dir of existing cluster",
+			updateRequest: func(req *csi.CreateVolumeRequest) {
+				req.Parameters = map[string]string{
+					"amlfs-name":        "existing_cluster",
+					"provision-sub-dir": "true",
+					"sub-dir":           "testSubDir",
+				}
+			},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "restoring into a sub-dir of an existing cluster",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+			d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+			addSnapshotSourceFilesystem(fakeDynamicProvisioner, "test_volume", testSnapshotHsmContainer)
+			csiSnapshot := createReadySnapshot(t, d, "test_snapshot", testSnapshotSourceVolumeID)
+
+			snapshotID := csiSnapshot.GetSnapshotId()
+			if test.snapshotID != nil {
+				snapshotID = test.snapshotID(snapshotID)
+			}
+			req := buildDynamicProvCreateVolumeRequest()
+			req.Name = "restored_volume"
+			req.Parameters["hsm-container"] = testSnapshotHsmContainer
+			req.Parameters["hsm-logging-container"] = testSnapshotHsmContainer + "-logging"
+			req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID},
+			}}
+			if test.updateRequest != nil {
+				test.updateRequest(req)
+			}
+
+			_, err := d.CreateVolume(context.Background(), req)
+			require.Error(t, err)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+			assert.ErrorContains(t, err, test.expectedError)
+			assert.Zero(t, fakeDynamicProvisioner.fakeCallCount["BeginCreateAmlFilesystem"])
+		})
+	}
+}
+
+func TestCreateVolume_RestoreSnapshot_Err_NotReady(t *testing.T) {
+	fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+	d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner), withFakeKubeClient())
+	snapshot := &amlFilesystemSnapshot{
+		Name:              "test_snapshot",
+		SourceVolumeID:    testSnapshotSourceVolumeID,
+		ResourceGroupName: "test-resource-group",
+		AmlFilesystemName: "test_volume",
+		FilesystemPath:    "/testSubDir",
+		HsmContainer:      testSnapshotHsmContainer,
+		CreationTime:      time.Now().UTC().Truncate(time.Second),
+		State:             snapshotStateArchiving,
+	}
+	require.NoError(t, d.saveSnapshot(context.Background(), snapshot, true))
+
+	req := buildDynamicProvCreateVolumeRequest()
+	req.Name = "restored_volume"
+	req.Parameters["hsm-container"] = testSnapshotHsmContainer
+	req.Parameters["hsm-logging-container"] = testSnapshotHsmContainer + "-logging"
+	req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshot.id()},
+	}}
+	_, err := d.CreateVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Zero(t, fakeDynamicProvisioner.fakeCallCount["BeginCreateAmlFilesystem"])
+}
@@ -0,0 +1,567 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	volumehelper "sigs.k8s.io/azurelustre-csi-driver/pkg/util"
+)
+
+const (
+	DefaultSubDirCloneParallelism = 16
+	defaultCloneSubDirWaitTime    = 10 * time.Second
+	cloneSubDirInProgressFmt      = "clone of sub-dir %s into sub-dir %s is in progress, %d files and %d directories of %d bytes copied"
+)
+
+// skippedXattrPrefixes are the extended attributes managed by Lustre, the
+// layout is copied with lfs setstripe instead
+var skippedXattrPrefixes = []string{"lustre.", "trusted.lov", "trusted.lma", "trusted.link", "trusted.hsm", "trusted.som"}
+
+// runningSubDirClone is a sub-dir copied in the background by this
+// controller, done is closed once err is set
+type runningSubDirClone struct {
+	done     chan struct{}
+	progress subDirCloneProgress
+	// pvc is the PVC the progress of the clone is recorded on, if any
+	pvc runtime.Object
+	// quota has the project ID assigned to the sub-dir once done is closed
+	quota *projectQuota
+	err   error
+}
+
+type subDirCloneProgress struct {
+	files atomic.Int64
+	dirs  atomic.Int64
+	bytes atomic.Int64
+}
+
+type subDirClones struct {
+	clones map[string]*runningSubDirClone
+	mux    sync.Mutex
+}
+
+func newSubDirClones() *subDirClones {
+	return &subDirClones{
+		clones: make(map[string]*runningSubDirClone),
+	}
+}
+
+func (sc *subDirClones) get(key string) *runningSubDirClone {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	return sc.clones[key]
+}
+
+func (sc *subDirClones) add(key string) *runningSubDirClone {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	clone := &runningSubDirClone{done: make(chan struct{})}
+	sc.clones[key] = clone
+	return clone
+}
+
+func (sc *subDirClones) remove(key string) {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	delete(sc.clones, key)
+}
+
+// getCloneSourceVolume returns the volume of the content source of
+// CreateVolume. Only sub-dir volumes can be cloned, into a provisioned sub-dir
+// of the same filesystem, so that the copy is made through a single mount
+func getCloneSourceVolume(sourceVolumeID string, subDirProperties *subDirProvisioningProperties, mgsIPAddress, fsName string) (*lustreVolume, error) {
+	sourceVolume, err := getLustreVolFromID(sourceVolumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "CreateVolume source volume %s not found: %v", sourceVolumeID, err)
+	}
+	if subDirProperties == nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume volumes can only be cloned into a sub-dir provisioned with %s",
+			VolumeContextProvisionSubDir)
+	}
+	if sourceVolume.subDir == "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume source volume %s has no %s, only sub-dir volumes can be cloned",
+			sourceVolumeID, VolumeContextSubDir)
+	}
+	if strings.Contains(sourceVolume.subDir, "${") {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume source volume %s cannot be cloned, its %s %q depends on the pod it is mounted in",
+			sourceVolumeID, VolumeContextSubDir, sourceVolume.subDir)
+	}
+	if sourceVolume.mgsIPAddress != mgsIPAddress {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume source volume %s is on MGS %s, volumes can only be cloned on the same MGS %s",
+			sourceVolumeID, sourceVolume.mgsIPAddress, mgsIPAddress)
+	}
+	if sourceVolume.azureLustreName != fsName {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume source volume %s is on filesystem %s, volumes can only be cloned on the same filesystem %s",
+			sourceVolumeID, sourceVolume.azureLustreName, fsName)
+	}
+	return sourceVolume, nil
+}
+
+// isNestedSubDir returns whether one of the sub-dirs contains the other
+func isNestedSubDir(subDir, otherSubDir string) bool {
+	subDir = filepath.Clean(subDir)
+	otherSubDir = filepath.Clean(otherSubDir)
+	return subDir == otherSubDir ||
+		strings.HasPrefix(subDir, otherSubDir+"/") ||
+		strings.HasPrefix(otherSubDir, subDir+"/")
+}
+
+// cloneSubDir copies the source sub-dir into the sub-dir of the volume in
+// the background, and waits up to cloneSubDirWaitTime for the copy. If it
+// takes longer, Aborted is returned so that the provisioner retries
+// CreateVolume, which then waits for the same copy again. A copy interrupted
+// by a restart of the controller is started again, and skips the files that
+// were already copied
+func (d *Driver) cloneSubDir(ctx context.Context, vol *lustreVolume, mountPath, sourceSubDir, subDirPath string, mountOptions []string, quota *projectQuota, pvc runtime.Object) error {
+	clone := d.subDirClones.get(mountPath)
+	if clone == nil {
+		clone = d.subDirClones.add(mountPath)
+		clone.pvc = pvc
+		clone.quota = quota
+		d.recordEvent(pvc, corev1.EventTypeNormal, eventReasonSubDirCloneStarted,
+			"started clone of sub-dir %s into sub-dir %s on %s", sourceSubDir, subDirPath, vol.mgsIPAddress)
+		go d.runSubDirClone(clone, vol, mountPath, sourceSubDir, subDirPath, mountOptions, clone.quota)
+	}
+
+	waitTimer := time.NewTimer(d.cloneSubDirWaitTime)
+	defer waitTimer.Stop()
+
+	select {
+	case <-clone.done:
+		d.subDirClones.remove(mountPath)
+		if quota != nil && clone.quota != nil {
+			quota.projectID = clone.quota.projectID
+		}
+		return clone.err
+	case <-waitTimer.C:
+	case <-ctx.Done():
+	}
+
+	files, dirs, bytes := clone.progress.files.Load(), clone.progress.dirs.Load(), clone.progress.bytes.Load()
+	klog.V(2).Infof(cloneSubDirInProgressFmt, sourceSubDir, subDirPath, files, dirs, bytes)
+	d.recordEvent(clone.pvc, corev1.EventTypeNormal, eventReasonSubDirCloneInProgress,
+		cloneSubDirInProgressFmt, sourceSubDir, subDirPath, files, dirs, bytes)
+	return status.Errorf(codes.Aborted, cloneSubDirInProgressFmt, sourceSubDir, subDirPath, files, dirs, bytes)
+}
+
+func (d *Driver) runSubDirClone(clone *runningSubDirClone, vol *lustreVolume, mountPath, sourceSubDir, subDirPath string, mountOptions []string, quota *projectQuota) {
+	defer close(clone.done)
+
+	clone.err = d.copySubDir(vol, mountPath, sourceSubDir, subDirPath, mountOptions, quota, &clone.progress)
+	if clone.err != nil {
+		klog.Errorf("clone of sub-dir %s into sub-dir %s failed: %v", sourceSubDir, subDirPath, clone.err)
+		d.recordEvent(clone.pvc, corev1.EventTypeWarning, eventReasonSubDirCloneFailed,
+			"clone of sub-dir %s into sub-dir %s failed: %s", sourceSubDir, subDirPath, status.Convert(clone.err).Message())
+		return
+	}
+
+	klog.V(2).Infof("clone of sub-dir %s into sub-dir %s completed", sourceSubDir, subDirPath)
+	d.recordEvent(clone.pvc, corev1.EventTypeNormal, eventReasonSubDirCloneSucceeded,
+		"cloned sub-dir %s into sub-dir %s, %d files and %d directories of %d bytes copied",
+		sourceSubDir, subDirPath, clone.progress.files.Load(), clone.progress.dirs.Load(), clone.progress.bytes.Load())
+}
+
+// copySubDir creates the sub-dir of the volume like createSubDir, with the
+// quota of the volume so that the copied files are accounted to it, and
+// copies the source sub-dir into it
+func (d *Driver) copySubDir(vol *lustreVolume, mountPath, sourceSubDir, subDirPath string, mountOptions []string, quota *projectQuota, progress *subDirCloneProgress) error {
+	if err := d.internalMount(vol, mountPath, mountOptions); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := d.internalUnmount(mountPath); err != nil {
+			klog.Warningf("failed to unmount lustre server: %v", err.Error())
+		}
+	}()
+
+	internalSourcePath, err := getInternalVolumePath(d.workingMountDir, mountPath, sourceSubDir)
+	if err != nil {
+		return err
+	}
+	internalVolumePath, err := getInternalVolumePath(d.workingMountDir, mountPath, subDirPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(internalSourcePath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return status.Errorf(codes.NotFound, "source sub-dir %s does not exist", sourceSubDir)
+		}
+		return status.Errorf(codes.Internal, "failed to stat source sub-dir %s: %v", sourceSubDir, err)
+	}
+
+	klog.V(2).Infof("Making subdirectory at %q", internalVolumePath)
+
+	if err := volumehelper.MakeDir(internalVolumePath); err != nil {
+		return status.Errorf(codes.Internal, "failed to make subdirectory: %v", err.Error())
+	}
+
+	if quota != nil {
+		internalMountPath, err := getInternalMountPath(d.workingMountDir, mountPath)
+		if err != nil {
+			return err
+		}
+		if err := d.setProjectQuota(internalMountPath, internalVolumePath, quota); err != nil {
+			return err
+		}
+	}
+
+	klog.V(2).Infof("copying %q to %q with %d workers", internalSourcePath, internalVolumePath, d.subDirCloneParallelism)
+	copier := &subDirCopier{
+		parallelism: d.subDirCloneParallelism,
+		runLfs:      d.runLfsCommand,
+		progress:    progress,
+	}
+	if err := copier.copy(internalSourcePath, internalVolumePath); err != nil {
+		return status.Errorf(codes.Internal, "failed to copy sub-dir %s: %v", sourceSubDir, err)
+	}
+	return nil
+}
+
+type copiedDir struct {
+	source      string
+	destination string
+	info        fs.FileInfo
+}
+
+// subDirCopier copies a directory tree with parallel workers, preserving the
+// ownership, modes, timestamps, extended attributes and Lustre layouts of its
+// entries. Regular files that were already copied, with the same size and
+// modification time, are skipped so that an interrupted copy can be resumed.
+// Hard links are copied as separate files
+type subDirCopier struct {
+	parallelism int
+	// runLfs runs an lfs command and returns its output
+	runLfs   func(args ...string) (string, error)
+	progress *subDirCloneProgress
+
+	group *errgroup.Group
+	ctx   context.Context
+	// dirs are the copied directories, whose metadata is set once all their
+	// entries are copied
+	dirs    []copiedDir
+	dirsMux sync.Mutex
+}
+
+func (c *subDirCopier) copy(source, destination string) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", source)
+	}
+
+	c.group, c.ctx = errgroup.WithContext(context.Background())
+	c.group.SetLimit(max(c.parallelism, 1))
+	c.group.Go(func() error {
+		return c.copyDir(source, destination, info, false)
+	})
+	if err := c.group.Wait(); err != nil {
+		return err
+	}
+
+	// The children are copied before their parents, whose modification time
+	// would otherwise be updated, and whose mode could prevent the copy
+	slices.SortFunc(c.dirs, func(a, b copiedDir) int {
+		return strings.Count(b.destination, "/") - strings.Count(a.destination, "/")
+	})
+	for _, dir := range c.dirs {
+		if err := setCopiedMetadata(dir.source, dir.destination, dir.info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schedule runs the copy on a free worker, or in the calling worker if none
+// is free, so that the workers never wait for each other
+func (c *subDirCopier) schedule(copyEntry func() error) error {
+	if c.group.TryGo(copyEntry) {
+		return nil
+	}
+	return copyEntry()
+}
+
+func (c *subDirCopier) copyDir(source, destination string, info fs.FileInfo, create bool) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+
+	if create {
+		existing, err := os.Lstat(destination)
+		if err == nil && !existing.IsDir() {
+			if err := os.Remove(destination); err != nil {
+				return err
+			}
+		}
+		if err != nil || !existing.IsDir() {
+			// The directory stays writable until its entries are copied
+			if err := os.Mkdir(destination, 0o700); err != nil {
+				return err
+			}
+			if err := c.copyLayout(source, destination, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entrySource := filepath.Join(source, entry.Name())
+		entryDestination := filepath.Join(destination, entry.Name())
+		entryInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if entryInfo.IsDir() {
+			err = c.schedule(func() error {
+				return c.copyDir(entrySource, entryDestination, entryInfo, true)
+			})
+		} else {
+			err = c.schedule(func() error {
+				return c.copyEntry(entrySource, entryDestination, entryInfo)
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	c.dirsMux.Lock()
+	c.dirs = append(c.dirs, copiedDir{source: source, destination: destination, info: info})
+	c.dirsMux.Unlock()
+	c.progress.dirs.Add(1)
+	return nil
+}
+
+func (c *subDirCopier) copyEntry(source, destination string, info fs.FileInfo) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+
+	existing, err := os.Lstat(destination)
+	exists := err == nil
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		if exists && existing.Mode().IsRegular() && existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime()) {
+			c.progress.files.Add(1)
+			c.progress.bytes.Add(info.Size())
+			return nil
+		}
+		if err := removeIfExists(destination, exists); err != nil {
+			return err
+		}
+		if err := c.copyLayout(source, destination, false); err != nil {
+			return err
+		}
+		if err := copyFileContent(source, destination); err != nil {
+			return err
+		}
+		c.progress.bytes.Add(info.Size())
+	case mode&fs.ModeSymlink != 0:
+		target, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		if err := removeIfExists(destination, exists); err != nil {
+			return err
+		}
+		if err := os.Symlink(target, destination); err != nil {
+			return err
+		}
+	case mode&(fs.ModeNamedPipe|fs.ModeDevice|fs.ModeCharDevice) != 0:
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("failed to get file type of %q", source)
+		}
+		if err := removeIfExists(destination, exists); err != nil {
+			return err
+		}
+		if err := unix.Mknod(destination, stat.Mode, int(stat.Rdev)); err != nil { //nolint:gosec // Device numbers fit in an int
+			return fmt.Errorf("failed to create %q: %w", destination, err)
+		}
+	default:
+		klog.Warningf("skipping %q, files of type %s cannot be copied", source, mode.Type())
+		return nil
+	}
+
+	if err := setCopiedMetadata(source, destination, info); err != nil {
+		return err
+	}
+	c.progress.files.Add(1)
+	return nil
+}
+
+// copyLayout creates the file with the layout of the source file, or sets
+// the default layout of the source directory on the directory. Nothing is
+// done if the source has no layout, e.g. a directory without a default one
+func (c *subDirCopier) copyLayout(source, destination string, isDir bool) error {
+	args := []string{"getstripe", "--yaml"}
+	if isDir {
+		args = append(args, "-d")
+	}
+	layout, err := c.runLfs(append(args, source)...)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(layout) == "" {
+		return nil
+	}
+
+	template, err := os.CreateTemp("", "azurelustre-layout-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(template.Name()); err != nil {
+			klog.Warningf("failed to remove layout template %q: %v", template.Name(), err)
+		}
+	}()
+	if _, err := template.WriteString(layout); err != nil {
+		return errors.Join(err, template.Close())
+	}
+	if err := template.Close(); err != nil {
+		return err
+	}
+
+	_, err = c.runLfs("setstripe", "--yaml", template.Name(), destination)
+	return err
+}
+
+func removeIfExists(path string, exists bool) error {
+	if !exists {
+		return nil
+	}
+	return os.RemoveAll(path)
+}
+
+// copyFileContent copies the content of the source file into the
+// destination, which is created if copyLayout did not create it
+func copyFileContent(source, destination string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destinationFile, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destinationFile, sourceFile); err != nil {
+		return errors.Join(fmt.Errorf("failed to copy %q: %w", source, err), destinationFile.Close())
+	}
+	return destinationFile.Close()
+}
+
+// setCopiedMetadata sets the ownership, mode, extended attributes and
+// timestamps of the source on the copy. The mode is set after the ownership,
+// which clears the setuid and setgid bits
+func setCopiedMetadata(source, destination string, info fs.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("failed to get ownership of %q", source)
+	}
+	if err := os.Lchown(destination, int(stat.Uid), int(stat.Gid)); err != nil {
+		return err
+	}
+
+	if info.Mode()&fs.ModeSymlink == 0 {
+		if err := os.Chmod(destination, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return err
+		}
+		if err := copyXattrs(source, destination); err != nil {
+			return err
+		}
+	}
+
+	times := []unix.Timespec{unix.NsecToTimespec(stat.Atim.Nano()), unix.NsecToTimespec(stat.Mtim.Nano())}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, destination, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("failed to set timestamps of %q: %w", destination, err)
+	}
+	return nil
+}
+
+func copyXattrs(source, destination string) error {
+	size, err := unix.Llistxattr(source, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return fmt.Errorf("failed to list extended attributes of %q: %w", source, err)
+	}
+	if size == 0 {
+		return nil
+	}
+	names := make([]byte, size)
+	size, err = unix.Llistxattr(source, names)
+	if err != nil {
+		return fmt.Errorf("failed to list extended attributes of %q: %w", source, err)
+	}
+
+	for _, name := range strings.Split(strings.TrimRight(string(names[:size]), "\x00"), "\x00") {
+		if name == "" || slices.ContainsFunc(skippedXattrPrefixes, func(prefix string) bool {
+			return strings.HasPrefix(name, prefix)
+		}) {
+			continue
+		}
+
+		valueSize, err := unix.Lgetxattr(source, name, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get extended attribute %s of %q: %w", name, source, err)
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Lgetxattr(source, name, value)
+		if err != nil {
+			return fmt.Errorf("failed to get extended attribute %s of %q: %w", name, source, err)
+		}
+		if err := unix.Lsetxattr(destination, name, value[:valueSize], 0); err != nil {
+			return fmt.Errorf("failed to set extended attribute %s of %q: %w", name, destination, err)
+		}
+	}
+	return nil
+}
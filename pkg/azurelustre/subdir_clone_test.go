@@ -0,0 +1,465 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+var testCloneTime = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// fakeLfsLayouts fakes lfs for subDirCopier, getstripe returns the layout of
+// the path if it has one, and setstripe records the template it was given
+type fakeLfsLayouts struct {
+	layouts map[string]string
+	err     error
+	calls   []string
+	mux     sync.Mutex
+}
+
+func (f *fakeLfsLayouts) run(args ...string) (string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.err != nil {
+		return "", f.err
+	}
+	path := args[len(args)-1]
+	if args[0] == "setstripe" {
+		template, err := os.ReadFile(args[2])
+		if err != nil {
+			return "", err
+		}
+		f.calls = append(f.calls, fmt.Sprintf("setstripe %s %s", path, template))
+		return "", nil
+	}
+	f.calls = append(f.calls, strings.Join(args, " "))
+	return f.layouts[path], nil
+}
+
+func writeCloneSourceFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), mode))
+	require.NoError(t, os.Chmod(path, mode))
+	require.NoError(t, os.Chtimes(path, testCloneTime, testCloneTime))
+}
+
+func newTestSubDirCopier(runLfs func(args ...string) (string, error)) *subDirCopier {
+	return &subDirCopier{
+		parallelism: 4,
+		runLfs:      runLfs,
+		progress:    &subDirCloneProgress{},
+	}
+}
+
+func TestGetCloneSourceVolume(t *testing.T) {
+	subDirProperties := &subDirProvisioningProperties{onDelete: subDirOnDeleteDelete}
+	tests := []struct {
+		desc             string
+		sourceVolumeID   string
+		subDirProperties *subDirProvisioningProperties
+		expectedSubDir   string
+		expectedCode     codes.Code
+		expectedError    string
+	}{
+		{
+			desc:             "sub-dir volume",
+			sourceVolumeID:   "source#lustrefs#127.0.0.1#/source/dir/#f###delete#42",
+			subDirProperties: subDirProperties,
+			expectedSubDir:   "source/dir",
+		},
+		{
+			desc:             "invalid volume ID",
+			sourceVolumeID:   "invalid",
+			subDirProperties: subDirProperties,
+			expectedCode:     codes.NotFound,
+			expectedError:    "source volume invalid not found",
+		},
+		{
+			desc:           "not a provisioned sub-dir",
+			sourceVolumeID: "source#lustrefs#127.0.0.1#source",
+			expectedCode:   codes.InvalidArgument,
+			expectedError:  "can only be cloned into a sub-dir provisioned with provision-sub-dir",
+		},
+		{
+			desc:             "source without sub-dir",
+			sourceVolumeID:   "source#lustrefs#127.0.0.1",
+			subDirProperties: subDirProperties,
+			expectedCode:     codes.InvalidArgument,
+			expectedError:    "only sub-dir volumes can be cloned",
+		},
+		{
+			desc:             "source sub-dir with pod metadata",
+			sourceVolumeID:   "source#lustrefs#127.0.0.1#${pod.metadata.name}",
+			subDirProperties: subDirProperties,
+			expectedCode:     codes.InvalidArgument,
+			expectedError:    "depends on the pod it is mounted in",
+		},
+		{
+			desc:             "source on another MGS",
+			sourceVolumeID:   "source#lustrefs#127.0.0.2#source",
+			subDirProperties: subDirProperties,
+			expectedCode:     codes.InvalidArgument,
+			expectedError:    "is on MGS 127.0.0.2, volumes can only be cloned on the same MGS 127.0.0.1",
+		},
+		{
+			desc:             "source on another filesystem",
+			sourceVolumeID:   "source#otherfs#127.0.0.1#source",
+			subDirProperties: subDirProperties,
+			expectedCode:     codes.InvalidArgument,
+			expectedError:    "is on filesystem otherfs, volumes can only be cloned on the same filesystem lustrefs",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			sourceVolume, err := getCloneSourceVolume(test.sourceVolumeID, test.subDirProperties, "127.0.0.1", "lustrefs")
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedCode, status.Code(err))
+				assert.ErrorContains(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedSubDir, sourceVolume.subDir)
+		})
+	}
+}
+
+func TestIsNestedSubDir(t *testing.T) {
+	assert.True(t, isNestedSubDir("a/b", "a/b"))
+	assert.True(t, isNestedSubDir("a/b/c", "a/b"))
+	assert.True(t, isNestedSubDir("a", "a/b/"))
+	assert.False(t, isNestedSubDir("a/bc", "a/b"))
+	assert.False(t, isNestedSubDir("a/b", "c/b"))
+}
+
+func TestSubDirCopier_Copy(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source")
+	destination := filepath.Join(t.TempDir(), "destination")
+	// A file, a read-only directory with a file, a symlink and a named pipe
+	writeCloneSourceFile(t, filepath.Join(source, "file"), "content", 0o640)
+	writeCloneSourceFile(t, filepath.Join(source, "dir", "nested"), "nested content", 0o600)
+	require.NoError(t, os.Symlink("dir/nested", filepath.Join(source, "link")))
+	require.NoError(t, unix.Mkfifo(filepath.Join(source, "pipe"), 0o600))
+	require.NoError(t, os.Chmod(filepath.Join(source, "dir"), 0o555))
+	require.NoError(t, os.Chtimes(filepath.Join(source, "dir"), testCloneTime, testCloneTime))
+	t.Cleanup(func() {
+		_ = os.Chmod(filepath.Join(source, "dir"), 0o755)
+	})
+	require.NoError(t, os.Mkdir(destination, 0o755))
+	require.NoError(t, os.Chmod(source, 0o750))
+
+	xattrsSupported := unix.Setxattr(filepath.Join(source, "file"), "user.test", []byte("value"), 0) == nil
+	require.NoError(t, os.Chtimes(filepath.Join(source, "file"), testCloneTime, testCloneTime))
+
+	fakeLfs := &fakeLfsLayouts{}
+	copier := newTestSubDirCopier(fakeLfs.run)
+	require.NoError(t, copier.copy(source, destination))
+
+	content, err := os.ReadFile(filepath.Join(destination, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	content, err = os.ReadFile(filepath.Join(destination, "dir", "nested"))
+	require.NoError(t, err)
+	assert.Equal(t, "nested content", string(content))
+
+	for path, expectedMode := range map[string]fs.FileMode{
+		"":           fs.ModeDir | 0o750,
+		"file":       0o640,
+		"dir":        fs.ModeDir | 0o555,
+		"dir/nested": 0o600,
+		"pipe":       fs.ModeNamedPipe | 0o600,
+		"link":       fs.ModeSymlink,
+	} {
+		info, err := os.Lstat(filepath.Join(destination, path))
+		require.NoError(t, err)
+		if expectedMode&fs.ModeSymlink != 0 {
+			assert.Equal(t, fs.ModeSymlink, info.Mode().Type(), path)
+			continue
+		}
+		assert.Equal(t, expectedMode, info.Mode(), path)
+	}
+
+	for _, path := range []string{"file", "dir", "dir/nested"} {
+		info, err := os.Lstat(filepath.Join(destination, path))
+		require.NoError(t, err)
+		assert.True(t, testCloneTime.Equal(info.ModTime()), "modification time of %s is %v", path, info.ModTime())
+	}
+
+	target, err := os.Readlink(filepath.Join(destination, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "dir/nested", target)
+
+	if xattrsSupported {
+		value := make([]byte, 16)
+		size, err := unix.Getxattr(filepath.Join(destination, "file"), "user.test", value)
+		require.NoError(t, err)
+		assert.Equal(t, "value", string(value[:size]))
+	}
+
+	assert.Equal(t, int64(4), copier.progress.files.Load())
+	assert.Equal(t, int64(2), copier.progress.dirs.Load())
+	assert.Equal(t, int64(len("content")+len("nested content")), copier.progress.bytes.Load())
+	assert.ElementsMatch(t, []string{
+		"getstripe --yaml " + filepath.Join(source, "file"),
+		"getstripe --yaml -d " + filepath.Join(source, "dir"),
+		"getstripe --yaml " + filepath.Join(source, "dir", "nested"),
+	}, fakeLfs.calls)
+}
+
+func TestSubDirCopier_CopiesLayouts(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source")
+	destination := filepath.Join(t.TempDir(), "destination")
+	writeCloneSourceFile(t, filepath.Join(source, "file"), "content", 0o644)
+	writeCloneSourceFile(t, filepath.Join(source, "dir", "nested"), "nested content", 0o644)
+	require.NoError(t, os.Mkdir(destination, 0o755))
+
+	fakeLfs := &fakeLfsLayouts{
+		layouts: map[string]string{
+			filepath.Join(source, "file"): "lmm_stripe_count: 4",
+			filepath.Join(source, "dir"):  "stripe_count: 2",
+		},
+	}
+	copier := newTestSubDirCopier(fakeLfs.run)
+	require.NoError(t, copier.copy(source, destination))
+
+	assert.ElementsMatch(t, []string{
+		"getstripe --yaml " + filepath.Join(source, "file"),
+		"setstripe " + filepath.Join(destination, "file") + " lmm_stripe_count: 4",
+		"getstripe --yaml -d " + filepath.Join(source, "dir"),
+		"setstripe " + filepath.Join(destination, "dir") + " stripe_count: 2",
+		"getstripe --yaml " + filepath.Join(source, "dir", "nested"),
+	}, fakeLfs.calls)
+
+	// The templates are removed once the layouts are set
+	templates, err := filepath.Glob(filepath.Join(os.TempDir(), "azurelustre-layout-*.yaml"))
+	require.NoError(t, err)
+	assert.Empty(t, templates)
+}
+
+func TestSubDirCopier_SkipsCopiedFiles(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source")
+	destination := filepath.Join(t.TempDir(), "destination")
+	writeCloneSourceFile(t, filepath.Join(source, "copied"), "content", 0o644)
+	writeCloneSourceFile(t, filepath.Join(source, "changed"), "content", 0o644)
+	require.NoError(t, os.Mkdir(destination, 0o755))
+
+	fakeLfs := &fakeLfsLayouts{}
+	require.NoError(t, newTestSubDirCopier(fakeLfs.run).copy(source, destination))
+
+	// A file with the same size and modification time is not copied again,
+	// a partially copied file is
+	writeCloneSourceFile(t, filepath.Join(destination, "copied"), "CONTENT", 0o644)
+	require.NoError(t, os.WriteFile(filepath.Join(destination, "changed"), []byte("cont"), 0o644))
+
+	fakeLfs = &fakeLfsLayouts{}
+	copier := newTestSubDirCopier(fakeLfs.run)
+	require.NoError(t, copier.copy(source, destination))
+
+	content, err := os.ReadFile(filepath.Join(destination, "copied"))
+	require.NoError(t, err)
+	assert.Equal(t, "CONTENT", string(content))
+	content, err = os.ReadFile(filepath.Join(destination, "changed"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	assert.Equal(t, []string{"getstripe --yaml " + filepath.Join(source, "changed")}, fakeLfs.calls)
+	assert.Equal(t, int64(2), copier.progress.files.Load())
+}
+
+func TestSubDirCopier_Err(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source")
+	destination := filepath.Join(t.TempDir(), "destination")
+	writeCloneSourceFile(t, filepath.Join(source, "file"), "content", 0o644)
+	require.NoError(t, os.Mkdir(destination, 0o755))
+
+	fakeLfs := &fakeLfsLayouts{err: errors.New("lfs getstripe failed")}
+	err := newTestSubDirCopier(fakeLfs.run).copy(source, destination)
+	require.ErrorContains(t, err, "lfs getstripe failed")
+
+	err = newTestSubDirCopier(fakeLfs.run).copy(filepath.Join(source, "file"), destination)
+	require.ErrorContains(t, err, "is not a directory")
+}
+
+func TestCreateVolume_Success_CloneSubDir(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeEventRecorder(recorder),
+		withFakeKubeClient(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc_name", Namespace: "pvc_namespace"}}))
+	setSubDirProvisioningFakeMounter(t, d)
+	d.subDirCloneParallelism = 1
+	mountTarget := filepath.Join(d.workingMountDir, "test_volume")
+	writeCloneSourceFile(t, filepath.Join(mountTarget, "source", "file"), "content", 0o644)
+	commandLines := addFakeLfsCommands(t, d, fakeLfsCommand{})
+
+	req := buildProvisionSubDirCreateVolumeRequest()
+	req.Parameters["sub-dir"] = "clone-${pvc.metadata.name}"
+	req.Parameters["sub-dir-quota"] = "false"
+	req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+		Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "source_volume#lustrefs#127.0.0.1#source#f###retain"},
+	}}
+	rep, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "test_volume#lustrefs#127.0.0.1#clone-pvc_name#f###delete", rep.GetVolume().GetVolumeId())
+	assert.Equal(t, req.GetVolumeContentSource(), rep.GetVolume().GetContentSource())
+
+	content, err := os.ReadFile(filepath.Join(mountTarget, "clone-pvc_name", "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	assert.Equal(t, []string{"lfs getstripe --yaml " + filepath.Join(mountTarget, "source", "file")}, *commandLines)
+	assert.Equal(t, []string{
+		"Normal SubDirCloneStarted started clone of sub-dir source into sub-dir clone-pvc_name on 127.0.0.1",
+		"Normal SubDirCloneSucceeded cloned sub-dir source into sub-dir clone-pvc_name, 1 files and 1 directories of 7 bytes copied",
+	}, receiveEvents(recorder))
+	assert.Nil(t, d.subDirClones.get("test_volume"))
+}
+
+func TestCreateVolume_CloneSubDir_InProgress(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	d := NewFakeDriver(withFakeEventRecorder(recorder),
+		withFakeKubeClient(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc_name", Namespace: "pvc_namespace"}}))
+	setSubDirProvisioningFakeMounter(t, d)
+	d.subDirCloneParallelism = 1
+	d.cloneSubDirWaitTime = time.Millisecond
+	mountTarget := filepath.Join(d.workingMountDir, "test_volume")
+	writeCloneSourceFile(t, filepath.Join(mountTarget, "source", "file"), "content", 0o644)
+
+	release := make(chan struct{})
+	fakeExec, ok := d.mounter.Exec.(*testingexec.FakeExec)
+	require.True(t, ok, "Exec should be a FakeExec")
+	fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) utilexec.Cmd {
+		return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+			CombinedOutputScript: []testingexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					<-release
+					return nil, nil, nil
+				},
+			},
+		}, cmd, args...)
+	})
+
+	req := buildProvisionSubDirCreateVolumeRequest()
+	req.Parameters["sub-dir"] = "clone-${pvc.metadata.name}"
+	req.Parameters["sub-dir-quota"] = "false"
+	req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+		Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "source_volume#lustrefs#127.0.0.1#source"},
+	}}
+	_, err := d.CreateVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.ErrorContains(t, err, "clone of sub-dir source into sub-dir clone-pvc_name is in progress")
+
+	// Retries wait for the same copy instead of starting a new one
+	_, err = d.CreateVolume(context.Background(), req)
+	require.Error(t, err)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	close(release)
+	d.cloneSubDirWaitTime = time.Minute
+	_, err = d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fakeExec.CommandCalls)
+
+	events := receiveEvents(recorder)
+	assert.Equal(t, "Normal SubDirCloneStarted started clone of sub-dir source into sub-dir clone-pvc_name on 127.0.0.1", events[0])
+	assert.Contains(t, events, "Normal SubDirCloneInProgress clone of sub-dir source into sub-dir clone-pvc_name is in progress, 0 files and 0 directories of 0 bytes copied")
+}
+
+func TestCreateVolume_Err_CloneSubDir(t *testing.T) {
+	tests := []struct {
+		desc           string
+		sourceVolumeID string
+		updateRequest  func(req *csi.CreateVolumeRequest)
+		expectedCode   codes.Code
+		expectedError  string
+	}{
+		{
+			desc:           "source sub-dir does not exist",
+			sourceVolumeID: "source_volume#lustrefs#127.0.0.1#missing",
+			expectedCode:   codes.NotFound,
+			expectedError:  "source sub-dir missing does not exist",
+		},
+		{
+			desc:           "source on another MGS",
+			sourceVolumeID: "source_volume#lustrefs#127.0.0.2#source",
+			expectedCode:   codes.InvalidArgument,
+			expectedError:  "volumes can only be cloned on the same MGS",
+		},
+		{
+			desc:           "clone inside the source",
+			sourceVolumeID: "source_volume#lustrefs#127.0.0.1#source",
+			updateRequest: func(req *csi.CreateVolumeRequest) {
+				req.Parameters["sub-dir"] = "source/${pvc.metadata.name}"
+			},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "one contains the other",
+		},
+		{
+			desc:           "clone into a new cluster",
+			sourceVolumeID: "source_volume#lustrefs#127.0.0.1#source",
+			updateRequest: func(req *csi.CreateVolumeRequest) {
+				req.Parameters = buildDynamicProvCreateVolumeRequest().GetParameters()
+			},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "can only be cloned into a sub-dir provisioned with provision-sub-dir",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeDynamicProvisioner := &FakeDynamicProvisioner{}
+			d := NewFakeDriver(withFakeDynamicProvisioner(fakeDynamicProvisioner))
+			setSubDirProvisioningFakeMounter(t, d)
+			writeCloneSourceFile(t, filepath.Join(d.workingMountDir, "test_volume", "source", "file"), "content", 0o644)
+
+			req := buildProvisionSubDirCreateVolumeRequest()
+			req.Parameters["sub-dir"] = "clone-${pvc.metadata.name}"
+			req.Parameters["sub-dir-quota"] = "false"
+			req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: test.sourceVolumeID},
+			}}
+			if test.updateRequest != nil {
+				test.updateRequest(req)
+			}
+			_, err := d.CreateVolume(context.Background(), req)
+			require.Error(t, err)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+			assert.ErrorContains(t, err, test.expectedError)
+			assert.Empty(t, fakeDynamicProvisioner.fakeCallCount)
+		})
+	}
+}
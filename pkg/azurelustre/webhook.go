@@ -0,0 +1,206 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/azurelustre-csi-driver/pkg/util"
+)
+
+const (
+	// ValidatingWebhookPath is the path the admission webhook is served on
+	ValidatingWebhookPath = "/validate"
+
+	// csi-provisioner removes the parameters with this prefix from the
+	// StorageClass parameters before calling CreateVolume
+	csiParameterPrefix = "csi.storage.k8s.io/"
+
+	// The PV and PVC metadata that csi-provisioner adds to the parameters of
+	// CreateVolume are not known when a StorageClass is created, so these
+	// values are used instead
+	webhookPVName       = "pvc-00000000-0000-0000-0000-000000000000"
+	webhookPVCName      = "pvc"
+	webhookPVCNamespace = "default"
+	// The MGS address of a dynamically provisioned cluster, or of a cluster
+	// referenced by amlfs-name, is only known when the volume is created or
+	// mounted
+	webhookMGSIPAddress = "127.0.0.1"
+
+	maxAdmissionReviewBytes = 3 << 20
+)
+
+// NewValidatingWebhook returns the handler of the validating admission
+// webhook, which rejects the StorageClasses and static PVs of the driver
+// whose parameters would make CreateVolume or NodePublishVolume fail
+func NewValidatingWebhook(driverName string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ValidatingWebhookPath, func(w http.ResponseWriter, r *http.Request) {
+		serveAdmissionReview(w, r, driverName)
+	})
+	return mux
+}
+
+func serveAdmissionReview(w http.ResponseWriter, r *http.Request, driverName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "admission reviews must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAdmissionReviewBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = reviewAdmissionRequest(review.Request, driverName)
+	review.Request = nil
+	response, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode admission review: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(response); err != nil {
+		klog.Warningf("failed to write admission review response: %v", err)
+	}
+}
+
+func reviewAdmissionRequest(request *admissionv1.AdmissionRequest, driverName string) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{UID: request.UID, Allowed: true}
+	if request.Operation != admissionv1.Create {
+		return response
+	}
+
+	var err error
+	switch request.Kind.Kind {
+	case "StorageClass":
+		var storageClass storagev1.StorageClass
+		if err = json.Unmarshal(request.Object.Raw, &storageClass); err != nil {
+			break
+		}
+		if storageClass.Provisioner != driverName {
+			return response
+		}
+		err = validateStorageClassParameters(storageClass.Parameters)
+	case "PersistentVolume":
+		var pv corev1.PersistentVolume
+		if err = json.Unmarshal(request.Object.Raw, &pv); err != nil {
+			break
+		}
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driverName {
+			return response
+		}
+		err = validateVolumeContext(pv.Spec.CSI.VolumeAttributes)
+	default:
+		return response
+	}
+
+	if err != nil {
+		klog.V(2).Infof("rejecting %s %s: %v", request.Kind.Kind, request.Name, err)
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: status.Convert(err).Message(),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+	return response
+}
+
+// validateStorageClassParameters checks the parameters of a StorageClass the
+// same way CreateVolume does, without the checks that call Azure such as the
+// SKU, zone and subnet of a dynamically provisioned cluster
+func validateStorageClassParameters(storageClassParameters map[string]string) error {
+	parameters := map[string]string{}
+	for key, value := range storageClassParameters {
+		if !strings.HasPrefix(strings.ToLower(key), csiParameterPrefix) {
+			parameters[key] = value
+		}
+	}
+	parameters[pvcNameKey] = webhookPVCName
+	parameters[pvcNamespaceKey] = webhookPVCNamespace
+	parameters[pvNameKey] = webhookPVName
+
+	if _, err := parseAmlFilesystemProperties(parameters); err != nil {
+		return err
+	}
+
+	mgsIPAddress := util.GetValueInMap(parameters, VolumeContextMGSIPAddress)
+	existingProperties, err := parseExistingAmlFilesystemProperties(parameters)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "CreateVolume %s", status.Convert(err).Message())
+	}
+	if existingProperties != nil && mgsIPAddress != "" {
+		return status.Errorf(codes.InvalidArgument,
+			"CreateVolume Parameters %s and %s cannot be used together",
+			VolumeContextMGSIPAddress, VolumeContextAmlfsName)
+	}
+
+	subDirProperties, err := parseSubDirProvisioningProperties(parameters)
+	if err != nil {
+		return err
+	}
+	if subDirProperties != nil {
+		_, err := getProvisionedSubDir(webhookPVName, subDirProperties, parameters)
+		return err
+	}
+
+	if mgsIPAddress == "" {
+		util.SetKeyValueInMap(parameters, VolumeContextMGSIPAddress, webhookMGSIPAddress)
+	}
+	return validateVolumeContext(parameters)
+}
+
+// validateVolumeContext checks the volume attributes of a PV the same way
+// NodePublishVolume does
+func validateVolumeContext(volumeContext map[string]string) error {
+	existingProperties, err := parseExistingAmlFilesystemProperties(volumeContext)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Context %s", status.Convert(err).Message())
+	}
+	if existingProperties != nil && util.GetValueInMap(volumeContext, VolumeContextMGSIPAddress) == "" {
+		volumeContext = maps.Clone(volumeContext)
+		util.SetKeyValueInMap(volumeContext, VolumeContextMGSIPAddress, webhookMGSIPAddress)
+	}
+
+	vol, err := newLustreVolume("", "", volumeContext)
+	if err != nil {
+		return err
+	}
+	if len(vol.subDir) > 0 && !ensureStrictSubpath(interpolateSubDirVariables(volumeContext, vol)) {
+		return status.Error(codes.InvalidArgument, "Context sub-dir must be strict subpath")
+	}
+	return nil
+}
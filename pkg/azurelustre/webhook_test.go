@@ -0,0 +1,316 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurelustre
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func buildValidDynamicStorageClassParameters() map[string]string {
+	return map[string]string{
+		"sku-name":                    "AMLFS-Durable-Premium-125",
+		"zone":                        "1",
+		"maintenance-day-of-week":     "Sunday",
+		"maintenance-time-of-day-utc": "22:00",
+		"tags":                        "team=storage",
+		"csi.storage.k8s.io/provisioner-secret-name": "azure-secret",
+	}
+}
+
+func TestValidateStorageClassParameters(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		parameters    map[string]string
+		expectedError string
+	}{
+		{
+			desc:       "Dynamic provisioning",
+			parameters: buildValidDynamicStorageClassParameters(),
+		},
+		{
+			desc: "Existing cluster by MGS IP address",
+			parameters: map[string]string{
+				"mgs-ip-address": "127.0.0.1",
+				"sub-dir":        "${pvc.metadata.namespace}/${pvc.metadata.name}",
+			},
+		},
+		{
+			desc: "Lustre filesystem with failover MGS host names",
+			parameters: map[string]string{
+				"mgs-ip-address": "mgs1.example.com@tcp1:mgs2.example.com@tcp1",
+				"fs-name":        "scratch",
+			},
+		},
+		{
+			desc: "Provisioned sub-dir",
+			parameters: map[string]string{
+				"amlfs-name":        "existing-amlfs",
+				"provision-sub-dir": "true",
+				"sub-dir":           "${pvc.metadata.namespace}-${pvc.metadata.name}",
+			},
+		},
+		{
+			desc: "Invalid maintenance day",
+			parameters: map[string]string{
+				"sku-name":                    "AMLFS-Durable-Premium-125",
+				"maintenance-day-of-week":     "sunday",
+				"maintenance-time-of-day-utc": "22:00",
+			},
+			expectedError: "CreateVolume Parameter maintenance-day-of-week must be one of",
+		},
+		{
+			desc: "Missing SKU",
+			parameters: map[string]string{
+				"maintenance-day-of-week":     "Sunday",
+				"maintenance-time-of-day-utc": "22:00",
+			},
+			expectedError: "CreateVolume sku-name must be provided for dynamically provisioned AMLFS",
+		},
+		{
+			desc: "Invalid tags",
+			parameters: map[string]string{
+				"mgs-ip-address": "127.0.0.1",
+				"tags":           "team",
+			},
+			expectedError: "CreateVolume tags 'team' are invalid",
+		},
+		{
+			desc: "Unknown parameter",
+			parameters: map[string]string{
+				"mgs-ip-address": "127.0.0.1",
+				"sub-directory":  "data",
+			},
+			expectedError: "Invalid parameter(s) {sub-directory = data} in storage class",
+		},
+		{
+			desc: "MGS IP address and AMLFS name",
+			parameters: map[string]string{
+				"mgs-ip-address": "127.0.0.1",
+				"amlfs-name":     "existing-amlfs",
+			},
+			expectedError: "CreateVolume Parameters mgs-ip-address and amlfs-name cannot be used together",
+		},
+		{
+			desc: "Invalid AMLFS name",
+			parameters: map[string]string{
+				"amlfs-name": "-existing-amlfs",
+			},
+			expectedError: "CreateVolume Parameter amlfs-name must be a valid AMLFS cluster name",
+		},
+		{
+			desc: "Invalid MGS address",
+			parameters: map[string]string{
+				"mgs-ip-address": "127.0.0.1,",
+			},
+			expectedError: "CreateVolume Parameter mgs-ip-address must be MGS addresses separated by ':' or ','",
+		},
+		{
+			desc: "Provisioned sub-dir with pod metadata",
+			parameters: map[string]string{
+				"mgs-ip-address":    "127.0.0.1",
+				"provision-sub-dir": "true",
+				"sub-dir":           "${pod.metadata.name}",
+			},
+			expectedError: "CreateVolume Parameter sub-dir can only use",
+		},
+		{
+			desc: "Sub-dir outside of the cluster",
+			parameters: map[string]string{
+				"mgs-ip-address": "127.0.0.1",
+				"sub-dir":        "../${pvc.metadata.name}",
+			},
+			expectedError: "Context sub-dir must be strict subpath",
+		},
+		{
+			desc: "Root sub-dir",
+			parameters: map[string]string{
+				"mgs-ip-address": "127.0.0.1",
+				"sub-dir":        "/",
+			},
+			expectedError: "Context sub-dir must not be empty or root if provided",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			err := validateStorageClassParameters(tC.parameters)
+			if tC.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tC.expectedError)
+			}
+		})
+	}
+}
+
+func TestValidateVolumeContext(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		volumeContext map[string]string
+		expectedError string
+	}{
+		{
+			desc:          "MGS IP address",
+			volumeContext: map[string]string{"mgs-ip-address": "127.0.0.1", "sub-dir": "${pod.metadata.name}"},
+		},
+		{
+			desc:          "AMLFS name",
+			volumeContext: map[string]string{"amlfs-name": "existing-amlfs", "resource-group-name": "rg"},
+		},
+		{
+			desc:          "Missing MGS IP address",
+			volumeContext: map[string]string{"sub-dir": "data"},
+			expectedError: "Context mgs-ip-address must be provided",
+		},
+		{
+			desc:          "Invalid AMLFS name",
+			volumeContext: map[string]string{"amlfs-name": "existing-amlfs-"},
+			expectedError: "Context Parameter amlfs-name must be a valid AMLFS cluster name",
+		},
+		{
+			desc:          "Invalid fs name",
+			volumeContext: map[string]string{"mgs-ip-address": "127.0.0.1", "fs-name": "lustre fs"},
+			expectedError: "Context fs-name must be 1 to 8 letters, digits, '_' or '-'",
+		},
+		{
+			desc:          "Sub-dir outside of the cluster",
+			volumeContext: map[string]string{"mgs-ip-address": "127.0.0.1", "sub-dir": "data/../../other"},
+			expectedError: "Context sub-dir must be strict subpath",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			err := validateVolumeContext(tC.volumeContext)
+			if tC.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tC.expectedError)
+			}
+		})
+	}
+}
+
+func postAdmissionReview(t *testing.T, operation admissionv1.Operation, kind string, object any) *admissionv1.AdmissionResponse {
+	raw, err := json.Marshal(object)
+	require.NoError(t, err)
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("test-uid"),
+			Kind:      metav1.GroupVersionKind{Kind: kind},
+			Operation: operation,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	NewValidatingWebhook(DefaultDriverName).ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodPost, ValidatingWebhookPath, bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var response admissionv1.AdmissionReview
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	require.NotNil(t, response.Response)
+	assert.Equal(t, types.UID("test-uid"), response.Response.UID)
+	return response.Response
+}
+
+func TestValidatingWebhook_StorageClass(t *testing.T) {
+	storageClass := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "azurelustre-dynprov"},
+		Provisioner: DefaultDriverName,
+		Parameters:  buildValidDynamicStorageClassParameters(),
+	}
+	response := postAdmissionReview(t, admissionv1.Create, "StorageClass", storageClass)
+	assert.True(t, response.Allowed)
+
+	storageClass.Parameters["maintenance-day-of-week"] = "sunday"
+	response = postAdmissionReview(t, admissionv1.Create, "StorageClass", storageClass)
+	assert.False(t, response.Allowed)
+	require.NotNil(t, response.Result)
+	assert.Contains(t, response.Result.Message, "CreateVolume Parameter maintenance-day-of-week must be one of")
+	assert.Equal(t, metav1.StatusReasonInvalid, response.Result.Reason)
+
+	response = postAdmissionReview(t, admissionv1.Update, "StorageClass", storageClass)
+	assert.True(t, response.Allowed)
+
+	storageClass.Provisioner = "other.csi.azure.com"
+	response = postAdmissionReview(t, admissionv1.Create, "StorageClass", storageClass)
+	assert.True(t, response.Allowed)
+}
+
+func TestValidatingWebhook_PersistentVolume(t *testing.T) {
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-lustre"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           DefaultDriverName,
+					VolumeHandle:     "pv-lustre",
+					VolumeAttributes: map[string]string{"mgs-ip-address": "127.0.0.1", "sub-dir": "data"},
+				},
+			},
+		},
+	}
+	response := postAdmissionReview(t, admissionv1.Create, "PersistentVolume", pv)
+	assert.True(t, response.Allowed)
+
+	pv.Spec.CSI.VolumeAttributes["sub-dir"] = "../data"
+	response = postAdmissionReview(t, admissionv1.Create, "PersistentVolume", pv)
+	assert.False(t, response.Allowed)
+	require.NotNil(t, response.Result)
+	assert.Equal(t, "Context sub-dir must be strict subpath", response.Result.Message)
+
+	pv.Spec.CSI.Driver = "other.csi.azure.com"
+	response = postAdmissionReview(t, admissionv1.Create, "PersistentVolume", pv)
+	assert.True(t, response.Allowed)
+
+	pv.Spec.CSI = nil
+	pv.Spec.HostPath = &corev1.HostPathVolumeSource{Path: "/data"}
+	response = postAdmissionReview(t, admissionv1.Create, "PersistentVolume", pv)
+	assert.True(t, response.Allowed)
+}
+
+func TestValidatingWebhook_Err_InvalidRequest(t *testing.T) {
+	handler := NewValidatingWebhook(DefaultDriverName)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ValidatingWebhookPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ValidatingWebhookPath, bytes.NewReader([]byte("{"))))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ValidatingWebhookPath, bytes.NewReader([]byte("{}"))))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -17,12 +17,21 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"time"
 
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/azurelustre-csi-driver/pkg/azurelustre"
+	"sigs.k8s.io/yaml"
 )
 
 var (
@@ -34,6 +43,19 @@ var (
 	enableAzureLustreMockDynProv = flag.Bool("enable-azurelustre-mock-dyn-prov", true, "Whether enable mock dynamic provisioning(only for testing)")
 	workingMountDir              = flag.String("working-mount-dir", "/tmp", "working directory for provisioner to mount lustre filesystems temporarily")
 	removeNotReadyTaint          = flag.Bool("remove-not-ready-taint", true, "remove NotReady taint from node when node is ready")
+	operationNamespace           = flag.String("operation-namespace", azurelustre.DefaultOperationNamespace, "namespace of the ConfigMaps used to resume AMLFS creations after a controller restart")
+	resumeAmlfsCreationsInterval = flag.Duration("resume-amlfs-creations-interval", 0, "how often the controller resumes the AMLFS creations persisted in operation-namespace that it does not poll, e.g. after a restart, 0 disables it")
+	orphanedAmlfsCheckInterval   = flag.Duration("orphaned-amlfs-check-interval", 0, "how often the controller checks for AMLFS clusters created by the driver whose PV no longer exists, 0 disables the check")
+	deleteOrphanedAmlfs          = flag.Bool("delete-orphaned-amlfs", false, "delete orphaned AMLFS clusters after orphaned-amlfs-grace-period instead of only reporting them")
+	orphanedAmlfsGracePeriod     = flag.Duration("orphaned-amlfs-grace-period", azurelustre.DefaultOrphanedAmlFilesystemGracePeriod, "how long an AMLFS cluster must be orphaned before it is deleted")
+	blobImportPopulatorInterval  = flag.Duration("blob-import-populator-interval", 0, "how often the controller populates the PVCs with a LustreBlobImport data source, 0 disables the populator")
+	skuCacheTTL                  = flag.Duration("sku-cache-ttl", azurelustre.DefaultSkuCacheTTL, "how long the AMLFS SKUs of a location are cached, 0 disables the cache")
+	maxConcurrentAmlfsOperations = flag.Int("max-concurrent-amlfs-operations", 0, "maximum number of AMLFS creations and deletions in progress in the controller, the others are queued, 0 is unlimited")
+	subDirCloneParallelism       = flag.Int("sub-dir-clone-parallelism", azurelustre.DefaultSubDirCloneParallelism, "number of files and directories copied in parallel when a sub-dir volume is cloned")
+	metricsAddress               = flag.String("metrics-address", "", "address to export the metrics on, e.g. 0.0.0.0:29764, empty disables the metrics endpoint")
+	webhookAddress               = flag.String("webhook-address", "", "address to serve the validating admission webhook on, e.g. 0.0.0.0:29765, empty disables the webhook")
+	webhookTLSCertFile           = flag.String("webhook-tls-cert-file", "", "path of the TLS certificate of the validating admission webhook")
+	webhookTLSKeyFile            = flag.String("webhook-tls-key-file", "", "path of the TLS private key of the validating admission webhook")
 )
 
 func main() {
@@ -56,18 +78,135 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "plan" {
+		if err := plan(flag.Args()[1:]); err != nil {
+			klog.Fatalln(err)
+		}
+		os.Exit(0)
+	}
+
+	exportMetrics()
+	serveWebhook()
 	handle()
 	os.Exit(0)
 }
 
-func handle() {
-	driverOptions := azurelustre.DriverOptions{
+// plan prints the AMLFS cluster that would be created for a volume of a
+// StorageClass, without creating it
+func plan(args []string) error {
+	planFlags := flag.NewFlagSet("plan", flag.ExitOnError)
+	storageClassFile := planFlags.String("storage-class", "", "path of the StorageClass manifest to plan a volume for")
+	capacity := planFlags.String("capacity", "", "requested capacity of the volume, e.g. 16Ti, defaults to the smallest cluster of the SKU")
+	volumeName := planFlags.String("volume-name", "pvc-plan", "name of the PV, which is also the name of the AMLFS cluster")
+	if err := planFlags.Parse(args); err != nil {
+		return err
+	}
+	if *storageClassFile == "" {
+		return errors.New("plan requires --storage-class")
+	}
+
+	manifest, err := os.ReadFile(*storageClassFile)
+	if err != nil {
+		return err
+	}
+	var storageClass storagev1.StorageClass
+	if err := yaml.Unmarshal(manifest, &storageClass); err != nil {
+		return fmt.Errorf("failed to parse StorageClass %s: %w", *storageClassFile, err)
+	}
+	if storageClass.Provisioner != *driverName {
+		klog.Warningf("StorageClass %s has provisioner %s instead of %s", storageClass.Name, storageClass.Provisioner, *driverName)
+	}
+
+	capacityInBytes := int64(0)
+	if *capacity != "" {
+		quantity, err := resource.ParseQuantity(*capacity)
+		if err != nil {
+			return fmt.Errorf("invalid capacity %s: %w", *capacity, err)
+		}
+		capacityInBytes = quantity.Value()
+	}
+
+	driver := azurelustre.NewDriver(&azurelustre.DriverOptions{
 		NodeID:                       *nodeID,
 		DriverName:                   *driverName,
-		EnableAzureLustreMockMount:   *enableAzureLustreMockMount,
-		EnableAzureLustreMockDynProv: *enableAzureLustreMockDynProv,
-		WorkingMountDir:              *workingMountDir,
-		RemoveNotReadyTaint:          *removeNotReadyTaint,
+		EnableAzureLustreMockDynProv: false,
+		SkuCacheTTL:                  *skuCacheTTL,
+	})
+	if driver == nil {
+		return errors.New("failed to initialize Azure Lustre CSI driver")
+	}
+	volumePlan, err := driver.PlanVolume(context.Background(), *volumeName, capacityInBytes, storageClass.Parameters)
+	if err != nil {
+		return err
+	}
+
+	output, err := yaml.Marshal(volumePlan)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Print(string(output)) //nolint:forbidigo // Print the plan to stdout for access through kubectl exec
+	return err
+}
+
+func exportMetrics() {
+	if *metricsAddress == "" {
+		return
+	}
+	l, err := net.Listen("tcp", *metricsAddress)
+	if err != nil {
+		klog.Warningf("failed to get listener for metrics endpoint: %v", err)
+		return
+	}
+	klog.V(2).Infof("serving metrics on %s/metrics", l.Addr().String())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		defer l.Close()
+		if err := server.Serve(l); err != nil {
+			klog.Fatalf("failed to serve metrics on %s: %v", l.Addr().String(), err)
+		}
+	}()
+}
+
+func serveWebhook() {
+	if *webhookAddress == "" {
+		return
+	}
+	if *webhookTLSCertFile == "" || *webhookTLSKeyFile == "" {
+		klog.Fatalln("webhook-tls-cert-file and webhook-tls-key-file must be provided to serve the validating admission webhook")
+	}
+	l, err := net.Listen("tcp", *webhookAddress)
+	if err != nil {
+		klog.Fatalf("failed to get listener for validating admission webhook: %v", err)
+	}
+	klog.V(2).Infof("serving validating admission webhook on %s%s", l.Addr().String(), azurelustre.ValidatingWebhookPath)
+	server := &http.Server{Handler: azurelustre.NewValidatingWebhook(*driverName), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		defer l.Close()
+		if err := server.ServeTLS(l, *webhookTLSCertFile, *webhookTLSKeyFile); err != nil {
+			klog.Fatalf("failed to serve validating admission webhook on %s: %v", l.Addr().String(), err)
+		}
+	}()
+}
+
+func handle() {
+	driverOptions := azurelustre.DriverOptions{
+		NodeID:                               *nodeID,
+		DriverName:                           *driverName,
+		EnableAzureLustreMockMount:           *enableAzureLustreMockMount,
+		EnableAzureLustreMockDynProv:         *enableAzureLustreMockDynProv,
+		WorkingMountDir:                      *workingMountDir,
+		RemoveNotReadyTaint:                  *removeNotReadyTaint,
+		OperationNamespace:                   *operationNamespace,
+		CreateOperationResumeInterval:        *resumeAmlfsCreationsInterval,
+		OrphanedAmlFilesystemCheckInterval:   *orphanedAmlfsCheckInterval,
+		DeleteOrphanedAmlFilesystems:         *deleteOrphanedAmlfs,
+		OrphanedAmlFilesystemGracePeriod:     *orphanedAmlfsGracePeriod,
+		BlobImportPopulatorInterval:          *blobImportPopulatorInterval,
+		SkuCacheTTL:                          *skuCacheTTL,
+		MaxConcurrentAmlFilesystemOperations: *maxConcurrentAmlfsOperations,
+		SubDirCloneParallelism:               *subDirCloneParallelism,
 	}
 	driver := azurelustre.NewDriver(&driverOptions)
 	if driver == nil {